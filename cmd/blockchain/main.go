@@ -1,27 +1,109 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 
 	"github.com/antontuzov/coubcore/internal/blockchain/api"
+	"github.com/antontuzov/coubcore/internal/blockchain/consensus"
 	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/mempool"
+	"github.com/antontuzov/coubcore/internal/blockchain/mining"
 	"github.com/antontuzov/coubcore/internal/blockchain/network"
+	"github.com/antontuzov/coubcore/internal/metrics"
 )
 
+// listPageSize is the number of blocks fetched per page by the list command
+const listPageSize = 100
+
 func main() {
+	opts := core.DefaultOptions()
+	networkName := flag.String("network", core.NetworkMain, "network whose consensus rules to follow ("+core.NetworkMain+" or "+core.NetworkRegtest+")")
+	flag.StringVar(&opts.Backend, "backend", opts.Backend, "storage backend (bbolt or memory)")
+	flag.StringVar(&opts.DataDir, "datadir", opts.DataDir, "directory holding the blockchain database")
+	flag.DurationVar(&opts.Timeout, "db-timeout", opts.Timeout, "how long to wait for the database lock")
+	flag.BoolVar(&opts.AddressIndex, "addrindex", opts.AddressIndex, "maintain the per-address transaction history index")
+	flag.StringVar(&opts.Consensus, "consensus", opts.Consensus, "consensus engine ("+strings.Join(core.Engines(), ", ")+")")
+	consensusConfig := flag.String("consensus-config", "", "JSON file configuring the consensus engine")
+	miners := flag.Int("miners", runtime.NumCPU(), "number of proof of work mining threads")
+	payout := flag.String("payout", "", "address paid by generated blocks and block templates that name none")
+	flag.Parse()
+	args := flag.Args()
+
+	params, err := core.NetworkParams(*networkName)
+	if err != nil {
+		fmt.Printf("Error selecting network: %v\n", err)
+		os.Exit(1)
+	}
+	opts.Params = params
+
+	// Keep regtest chains apart from main network data
+	if *networkName == core.NetworkRegtest {
+		opts.DataDir = filepath.Join(opts.DataDir, core.NetworkRegtest)
+	}
+
+	if *consensusConfig != "" {
+		config, err := os.ReadFile(*consensusConfig)
+		if err != nil {
+			fmt.Printf("Error reading consensus config: %v\n", err)
+			os.Exit(1)
+		}
+		opts.ConsensusConfig = config
+	}
+
+	// Proof of work mines on the requested threads and reports its hashrate on /metrics
+	if opts.Consensus == consensus.EnginePoW {
+		miner := consensus.DefaultMinerOptions()
+		miner.Workers = *miners
+		miner.Metrics = metrics.NewMetrics()
+		opts.Engine = consensus.NewPoWEngineWithOptions(miner)
+	}
+
+	// Judge block timestamps by the time agreed with peers
+	clock := core.NewNetworkClock(core.SystemClock{}, core.DefaultMaxTimeOffset)
+	opts.Clock = clock
+
 	fmt.Println("Coubcore Blockchain Node")
 	fmt.Println("========================")
 
 	// Create a new blockchain
-	blockchain := core.NewBlockchain()
+	blockchain, err := core.NewBlockchain(opts)
+	if err != nil {
+		fmt.Printf("Error opening blockchain: %v\n", err)
+		os.Exit(1)
+	}
 	defer blockchain.Close()
 
+	// Create the transaction memory pool
+	pool := mempool.NewMempool(blockchain, mempool.DefaultOptions())
+
 	// Create a new network server
-	networkServer := network.NewServer("localhost", 8000, blockchain)
+	networkServer := network.NewServer("localhost", 8000, blockchain, pool)
+	networkServer.SetClock(clock)
+	magic, _ := network.NetworkMagic(*networkName) // the name was checked with the params
+	networkServer.SetMagic(magic)
 
 	// Create a new API server
-	apiServer := api.NewServer(blockchain, networkServer, 8080)
+	apiServer := api.NewServer(blockchain, networkServer, pool, 8080)
+
+	// Serve block templates to external proof of work miners
+	var templates *mining.Manager
+	if opts.Consensus == consensus.EnginePoW {
+		templateOpts := mining.DefaultOptions()
+		templateOpts.PayoutAddress = *payout
+		templates = mining.NewManager(blockchain, pool, templateOpts)
+		apiServer.SetMining(templates)
+	}
+
+	// Mining blocks on demand is only for regtest
+	regtest := *networkName == core.NetworkRegtest
+	apiServer.SetGenerate(regtest)
 
 	// Start the network server in a separate goroutine
 	go func() {
@@ -38,31 +120,67 @@ func main() {
 	}()
 
 	// If we have command line arguments, process them
-	if len(os.Args) > 1 {
-		switch os.Args[1] {
+	if len(args) > 0 {
+		switch args[0] {
 		case "add":
-			if len(os.Args) > 2 {
-				data := os.Args[2]
-				block := blockchain.AddBlock(data)
+			if len(args) > 1 {
+				data := args[1]
+				block, err := blockchain.AddBlockContext(context.Background(), data)
+				if err != nil {
+					fmt.Printf("Error adding block: %v\n", err)
+					break
+				}
 				fmt.Printf("Added block #%d with hash %s\n", block.Index, block.Hash)
 			} else {
 				fmt.Println("Usage: blockchain add <data>")
 			}
-		case "list":
-			blocks := blockchain.GetBlocks()
-			fmt.Printf("Blockchain length: %d\n", len(blocks))
+		case "generate":
+			if len(args) < 2 || len(args) > 3 {
+				fmt.Println("Usage: blockchain generate <n> [address]")
+				break
+			}
+			if !regtest {
+				fmt.Println("Blocks can only be generated on " + core.NetworkRegtest)
+				break
+			}
+			if templates == nil {
+				fmt.Println("Blocks can only be generated with proof of work")
+				break
+			}
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				fmt.Printf("Invalid number of blocks: %s\n", args[1])
+				break
+			}
+			address := ""
+			if len(args) == 3 {
+				address = args[2]
+			}
+
+			blocks, err := templates.Generate(context.Background(), n, address)
 			for _, block := range blocks {
-				fmt.Printf("Block #%d: %s\n", block.Index, block.Hash)
+				networkServer.BroadcastBlock(block)
+				fmt.Printf("Generated block #%d with hash %s\n", block.Index, block.Hash)
+			}
+			if err != nil {
+				fmt.Printf("Error generating blocks: %v\n", err)
+			}
+		case "list":
+			fmt.Printf("Blockchain length: %d\n", blockchain.Length())
+			for start := uint64(0); start < uint64(blockchain.Length()); start += listPageSize {
+				for _, block := range blockchain.GetBlocks(start, listPageSize) {
+					fmt.Printf("Block #%d: %s\n", block.Index, block.Hash)
+				}
 			}
 		case "validate":
-			if blockchain.IsChainValid() {
-				fmt.Println("Blockchain is valid")
+			if err := blockchain.VerifyChain(); err != nil {
+				fmt.Printf("Blockchain is invalid: %v\n", err)
 			} else {
-				fmt.Println("Blockchain is invalid")
+				fmt.Println("Blockchain is valid")
 			}
 		case "connect":
-			if len(os.Args) > 2 {
-				address := os.Args[2]
+			if len(args) > 1 {
+				address := args[1]
 				if err := networkServer.ConnectToPeer(address); err != nil {
 					fmt.Printf("Error connecting to peer %s: %v\n", address, err)
 				} else {
@@ -72,8 +190,8 @@ func main() {
 				fmt.Println("Usage: blockchain connect <address>")
 			}
 		default:
-			fmt.Printf("Unknown command: %s\n", os.Args[1])
-			fmt.Println("Available commands: add, list, validate, connect")
+			fmt.Printf("Unknown command: %s\n", args[0])
+			fmt.Println("Available commands: add, generate, list, validate, connect")
 		}
 	} else {
 		// Print basic info
@@ -81,7 +199,7 @@ func main() {
 		if latest := blockchain.GetLatestBlock(); latest != nil {
 			fmt.Printf("Latest block hash: %s\n", latest.Hash)
 		}
-		fmt.Println("Available commands: add, list, validate, connect")
+		fmt.Println("Available commands: add, generate, list, validate, connect")
 		fmt.Println("Network server running on port 8000")
 		fmt.Println("API server running on port 8080")
 	}
@@ -0,0 +1,126 @@
+// Command miner searches for proof of work on block templates served by a
+// node's API and submits the nonces it finds
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/consensus"
+	"github.com/antontuzov/coubcore/internal/blockchain/mining"
+)
+
+// retryDelay is how long the miner waits after the node fails a request
+const retryDelay = 5 * time.Second
+
+func main() {
+	node := flag.String("node", "http://localhost:8080", "API address of the node")
+	address := flag.String("address", "", "address paid by mined blocks (default: the node's payout address)")
+	workers := flag.Int("workers", runtime.NumCPU(), "number of mining threads")
+	flag.Parse()
+
+	for {
+		template, err := fetchTemplate(context.Background(), *node, *address, "")
+		if err != nil {
+			log.Printf("Error fetching block template: %v", err)
+			time.Sleep(retryDelay)
+			continue
+		}
+		log.Printf("Mining block #%d on %s with bits %08x", template.Height, template.PreviousHash, template.Bits)
+
+		// Give up on the template as soon as the node says it is stale
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			defer cancel()
+			fetchTemplate(ctx, *node, *address, template.ID)
+		}()
+
+		nonce, _, err := consensus.NewProofOfWork(template.Block(0)).Run(ctx, consensus.MinerOptions{Workers: *workers})
+		cancel()
+		if err != nil {
+			log.Printf("Block template %s is stale", template.ID)
+			continue
+		}
+
+		hash, err := submit(*node, mining.Solution{TemplateID: template.ID, Nonce: nonce})
+		if err != nil {
+			log.Printf("Solution rejected: %v", err)
+			continue
+		}
+		log.Printf("Mined block #%d with hash %s", template.Height, hash)
+	}
+}
+
+// fetchTemplate requests a block template from the node. With longpoll set
+// to a template ID the request returns once that template is stale.
+func fetchTemplate(ctx context.Context, node, address, longpoll string) (*mining.Template, error) {
+	query := url.Values{}
+	if address != "" {
+		query.Set("address", address)
+	}
+	if longpoll != "" {
+		query.Set("longpoll", longpoll)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, node+"/api/v1/mining/template?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	var template mining.Template
+	if err := json.NewDecoder(resp.Body).Decode(&template); err != nil {
+		return nil, fmt.Errorf("decode template: %w", err)
+	}
+	return &template, nil
+}
+
+// submit sends a solution to the node and returns the hash of the new block
+func submit(node string, solution mining.Solution) (string, error) {
+	body, err := json.Marshal(solution)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.Post(node+"/api/v1/mining/submit", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp)
+	}
+
+	var result struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decode response: %w", err)
+	}
+	return result.Hash, nil
+}
+
+// responseError describes a failed API response
+func responseError(resp *http.Response) error {
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return errors.New(resp.Status + ": " + string(bytes.TrimSpace(message)))
+}
@@ -42,10 +42,8 @@ func HealthCheckHandler(blockchain *core.Blockchain) http.HandlerFunc {
 func ReadyCheckHandler(blockchain *core.Blockchain) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Check if blockchain is valid
-		isValid := blockchain.IsChainValid()
-
-		if !isValid {
-			http.Error(w, "Blockchain is not valid", http.StatusServiceUnavailable)
+		if err := blockchain.VerifyChain(); err != nil {
+			http.Error(w, "Blockchain is not valid: "+err.Error(), http.StatusServiceUnavailable)
 			return
 		}
 
@@ -2,11 +2,15 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/mempool"
+	"github.com/antontuzov/coubcore/internal/blockchain/mining"
 	"github.com/antontuzov/coubcore/internal/blockchain/network"
 )
 
@@ -14,18 +18,35 @@ import (
 type Server struct {
 	blockchain *core.Blockchain
 	network    *network.Server
+	mempool    *mempool.Mempool
+	mining     *mining.Manager
+	generate   bool
 	port       int
 }
 
 // NewServer creates a new API server
-func NewServer(blockchain *core.Blockchain, network *network.Server, port int) *Server {
+func NewServer(blockchain *core.Blockchain, network *network.Server, pool *mempool.Mempool, port int) *Server {
 	return &Server{
 		blockchain: blockchain,
 		network:    network,
+		mempool:    pool,
 		port:       port,
 	}
 }
 
+// SetMining serves block templates from a template manager to external
+// miners. Without one the mining endpoints report that mining is disabled.
+func (s *Server) SetMining(m *mining.Manager) {
+	s.mining = m
+}
+
+// SetGenerate enables the endpoint that mines blocks on demand. It is meant
+// for regtest only: the endpoint is unauthenticated and on other networks
+// would let any caller keep the node mining.
+func (s *Server) SetGenerate(enabled bool) {
+	s.generate = enabled
+}
+
 // Start starts the JSON-RPC API server
 func (s *Server) Start() error {
 	// Register API endpoints
@@ -33,8 +54,14 @@ func (s *Server) Start() error {
 	http.HandleFunc("/api/v1/block", s.GetBlock)
 	http.HandleFunc("/api/v1/transaction", s.GetTransaction)
 	http.HandleFunc("/api/v1/balance", s.GetBalance)
+	http.HandleFunc("/api/v1/history", s.GetAddressHistory)
 	http.HandleFunc("/api/v1/peers", s.GetPeers)
 	http.HandleFunc("/api/v1/send", s.SendTransaction)
+	http.HandleFunc("/api/v1/mining/template", s.GetBlockTemplate)
+	http.HandleFunc("/api/v1/mining/submit", s.SubmitBlock)
+	if s.generate {
+		http.HandleFunc("/api/v1/generate", s.Generate)
+	}
 
 	// Health check endpoints
 	http.HandleFunc("/health", HealthCheckHandler(s.blockchain))
@@ -67,21 +94,31 @@ func (s *Server) GetBlock(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
 
-	// Get the block index from query parameters
-	index := r.URL.Query().Get("index")
-	if index == "" {
-		http.Error(w, "Missing index parameter", http.StatusBadRequest)
-		return
+	// Look the block up by hash or by index
+	var block *core.Block
+	if hash := r.URL.Query().Get("hash"); hash != "" {
+		block = s.blockchain.GetBlockByHash(hash)
+	} else {
+		index := r.URL.Query().Get("index")
+		if index == "" {
+			http.Error(w, "Missing index or hash parameter", http.StatusBadRequest)
+			return
+		}
+
+		height, err := strconv.ParseUint(index, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid index parameter", http.StatusBadRequest)
+			return
+		}
+		block = s.blockchain.GetBlockByIndex(height)
 	}
 
-	// TODO: Parse index and retrieve block
-	// For now, we'll return a placeholder response
-	response := map[string]interface{}{
-		"index": index,
-		"data":  "block data",
+	if block == nil {
+		http.Error(w, "Block not found", http.StatusNotFound)
+		return
 	}
 
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(block)
 }
 
 // GetTransaction returns transaction information
@@ -97,11 +134,17 @@ func (s *Server) GetTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: Retrieve transaction by ID
-	// For now, we'll return a placeholder response
+	tx, block := s.blockchain.GetTransaction(txid)
+	if tx == nil {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+
 	response := map[string]interface{}{
-		"txid": txid,
-		"data": "transaction data",
+		"txid":        tx.ID,
+		"transaction": tx,
+		"blockHash":   block.Hash,
+		"blockIndex":  block.Index,
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -120,11 +163,69 @@ func (s *Server) GetBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: Calculate balance for the address
-	// For now, we'll return a placeholder response
+	balance, err := s.blockchain.GetBalance(address)
+	if errors.Is(err, core.ErrInvalidAddress) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"address": address,
+		"balance": balance,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetAddressHistory returns the transactions that touched an address, newest first
+func (s *Server) GetAddressHistory(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get the address and paging parameters from query parameters
+	query := r.URL.Query()
+	address := query.Get("address")
+	if address == "" {
+		http.Error(w, "Missing address parameter", http.StatusBadRequest)
+		return
+	}
+
+	offset, err := intParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid offset parameter", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := intParam(query.Get("limit"), 50)
+	if err != nil || limit <= 0 {
+		http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+		return
+	}
+
+	history, err := s.blockchain.GetAddressHistory(address, offset, limit)
+	if errors.Is(err, core.ErrAddressIndexDisabled) {
+		http.Error(w, "Address index is disabled", http.StatusNotImplemented)
+		return
+	}
+	if errors.Is(err, core.ErrInvalidAddress) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	response := map[string]interface{}{
 		"address": address,
-		"balance": 0,
+		"offset":  offset,
+		"limit":   limit,
+		"history": history,
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -145,22 +246,203 @@ func (s *Server) GetPeers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// SendTransaction handles sending a transaction
+// SendTransaction accepts a signed transaction into the mempool and relays it to peers
 func (s *Server) SendTransaction(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
 
-	// TODO: Handle transaction submission
-	// For now, we'll return a placeholder response
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var tx core.Transaction
+	if err := json.NewDecoder(r.Body).Decode(&tx); err != nil {
+		http.Error(w, "Invalid transaction: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.mempool.Add(&tx); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, mempool.ErrAlreadyExists) {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if s.network != nil {
+		s.network.BroadcastTransaction(&tx)
+	}
+
 	response := map[string]interface{}{
 		"status": "success",
-		"txid":   "transaction_id",
+		"txid":   tx.ID,
 	}
 
 	json.NewEncoder(w).Encode(response)
 }
 
+// GetBlockTemplate returns a block template for an external miner. With a
+// longpoll parameter naming a template, the response waits until that
+// template is stale and then returns a fresh one.
+func (s *Server) GetBlockTemplate(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.mining == nil {
+		http.Error(w, "Mining is disabled", http.StatusNotImplemented)
+		return
+	}
+
+	query := r.URL.Query()
+	if id := query.Get("longpoll"); id != "" {
+		if err := s.mining.Wait(r.Context(), id); err != nil {
+			return
+		}
+	}
+
+	template, err := s.mining.Template(query.Get("address"))
+	if errors.Is(err, mining.ErrInvalidPayout) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(template)
+}
+
+// SubmitBlock accepts a miner's solution to a block template, adds the
+// block to the chain and relays it to peers
+func (s *Server) SubmitBlock(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.mining == nil {
+		http.Error(w, "Mining is disabled", http.StatusNotImplemented)
+		return
+	}
+
+	var solution mining.Solution
+	if err := json.NewDecoder(r.Body).Decode(&solution); err != nil {
+		http.Error(w, "Invalid solution: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	block, err := s.mining.Submit(solution)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, mining.ErrUnknownTemplate):
+			status = http.StatusNotFound
+		case errors.Is(err, mining.ErrStaleTemplate), errors.Is(err, core.ErrDuplicateBlock):
+			status = http.StatusConflict
+		case !errors.Is(err, core.ErrInvalidBlock):
+			status = http.StatusInternalServerError
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if s.network != nil {
+		s.network.BroadcastBlock(block)
+	}
+
+	response := map[string]interface{}{
+		"status": "success",
+		"hash":   block.Hash,
+		"height": block.Index,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// maxGenerate bounds the number of blocks one generate request may mine
+const maxGenerate = 10000
+
+// GenerateRequest asks the node to mine blocks right away
+type GenerateRequest struct {
+	Blocks  int    `json:"blocks"`
+	Address string `json:"address"`
+}
+
+// Generate mines the requested number of blocks on the chain tip, paying
+// their coinbases to the given address, and relays them to peers. It only
+// serves requests once enabled with SetGenerate.
+func (s *Server) Generate(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.generate {
+		http.Error(w, "Block generation is only available on regtest", http.StatusNotImplemented)
+		return
+	}
+	if s.mining == nil {
+		http.Error(w, "Mining is disabled", http.StatusNotImplemented)
+		return
+	}
+
+	var req GenerateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Blocks <= 0 || req.Blocks > maxGenerate {
+		http.Error(w, fmt.Sprintf("Blocks must be between 1 and %d", maxGenerate), http.StatusBadRequest)
+		return
+	}
+
+	blocks, err := s.mining.Generate(r.Context(), req.Blocks, req.Address)
+	if s.network != nil {
+		for _, block := range blocks {
+			s.network.BroadcastBlock(block)
+		}
+	}
+	if errors.Is(err, mining.ErrInvalidPayout) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	hashes := make([]string, len(blocks))
+	for i, block := range blocks {
+		hashes[i] = block.Hash
+	}
+
+	response := map[string]interface{}{
+		"status": "success",
+		"blocks": hashes,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// intParam parses an optional integer query parameter
+func intParam(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
 // sendJSONResponse sends a JSON response
 func sendJSONResponse(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
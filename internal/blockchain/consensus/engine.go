@@ -0,0 +1,102 @@
+package consensus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+)
+
+// Engine is a consensus algorithm the chain seals and verifies blocks with.
+// It is declared in core, which calls it, and implemented in this package.
+type Engine = core.ConsensusEngine
+
+// ChainReader is the view of the chain an Engine works with
+type ChainReader = core.ChainReader
+
+// EnginePoW is the name the proof of work engine is registered under
+const EnginePoW = "pow"
+
+func init() {
+	core.RegisterEngine(EnginePoW, func(json.RawMessage) (core.ConsensusEngine, error) {
+		return NewPoWEngine(), nil
+	})
+}
+
+// PoWEngine is the proof of work consensus engine. A block is sealed by
+// finding a nonce whose header hash falls below the target in its bits, and
+// its coinbase may claim the block subsidy plus the fees of its
+// transactions. The registered engine takes no configuration and mines with
+// DefaultMinerOptions.
+type PoWEngine struct {
+	miner MinerOptions
+}
+
+// NewPoWEngine creates a proof of work engine that mines with DefaultMinerOptions
+func NewPoWEngine() *PoWEngine {
+	return NewPoWEngineWithOptions(DefaultMinerOptions())
+}
+
+// NewPoWEngineWithOptions creates a proof of work engine that mines with the given options
+func NewPoWEngineWithOptions(miner MinerOptions) *PoWEngine {
+	return &PoWEngine{miner: miner}
+}
+
+// Prepare sets the target of a new block from the time the blocks before it took to mine
+func (e *PoWEngine) Prepare(chain ChainReader, block *core.Block) error {
+	parent := chain.Header(block.PreviousHash)
+	if parent == nil {
+		return fmt.Errorf("parent block %s not found", block.PreviousHash)
+	}
+
+	bits, err := NextBits(chain, parent)
+	if err != nil {
+		return err
+	}
+	block.Bits = bits
+
+	block.Hash = block.CalculateHash()
+	return nil
+}
+
+// Seal searches for a nonce that meets the block's difficulty and sets the
+// block's nonce and hash. It does not use the chain, which may be nil.
+func (e *PoWEngine) Seal(ctx context.Context, chain ChainReader, block *core.Block) error {
+	_, _, err := NewProofOfWork(block).Run(ctx, e.miner)
+	return err
+}
+
+// VerifyHeader checks that the block's bits are the target retargeting
+// expects after its parent
+func (e *PoWEngine) VerifyHeader(chain ChainReader, block *core.Block, parent *core.ChainHeader) error {
+	bits, err := NextBits(chain, parent)
+	if err != nil {
+		return err
+	}
+	if block.Bits != bits {
+		return fmt.Errorf("%w: bits %08x, expected %08x", core.ErrUnexpectedBits, block.Bits, bits)
+	}
+	return nil
+}
+
+// VerifySeal checks that the block's target is within the proof of work
+// limit and that its hash meets the target
+func (e *PoWEngine) VerifySeal(chain ChainReader, block *core.Block) error {
+	return block.CheckProofOfWork(chain.Params().PowLimitBits)
+}
+
+// Finalize checks that the coinbase claims at most the block subsidy plus the fees
+func (e *PoWEngine) Finalize(chain ChainReader, block *core.Block, fees int) error {
+	return checkReward(chain, block, fees)
+}
+
+// checkReward checks that a block's coinbase claims at most the block
+// subsidy plus the fees of its other transactions
+func checkReward(chain ChainReader, block *core.Block, fees int) error {
+	reward := chain.Params().Subsidy(block.Index) + fees
+	if claimed := block.Transactions[0].OutputValue(); claimed > reward {
+		return fmt.Errorf("coinbase claims %d but subsidy and fees only allow %d", claimed, reward)
+	}
+	return nil
+}
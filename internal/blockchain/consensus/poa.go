@@ -0,0 +1,485 @@
+package consensus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+// EnginePoA is the name the proof of authority engine is registered under
+const EnginePoA = "poa"
+
+var (
+	// ErrNotValidator is returned when a block is sealed by an address
+	// outside the validator set
+	ErrNotValidator = errors.New("not a validator")
+
+	// ErrOutOfTurn is returned when a validator seals a block in a slot
+	// that belongs to another validator
+	ErrOutOfTurn = errors.New("validator out of turn")
+
+	// ErrNoSigner is returned when a proof of authority engine without a
+	// signing key is asked to produce a block
+	ErrNoSigner = errors.New("no validator key configured")
+)
+
+// votePrefix marks a coinbase data output carrying validator votes
+var votePrefix = []byte("poa-vote:")
+
+// snapshotWindow is how many heights below the highest known snapshot the
+// proof of authority and proof of stake engines keep snapshots for. Older
+// ones are replayed from genesis on the rare occasions they are needed again.
+const snapshotWindow = 128
+
+// PoAConfig configures the proof of authority engine
+type PoAConfig struct {
+	// Validators are the addresses of the initial validators, in turn order
+	Validators []string
+
+	// SlotTime is the time between block slots. Time since the genesis
+	// block is divided into slots and each slot belongs to one validator.
+	SlotTime time.Duration
+}
+
+// poaConfigJSON is the configuration accepted by the registered factory
+type poaConfigJSON struct {
+	Validators []string `json:"validators"`
+	SlotTime   string   `json:"slotTime"`
+
+	// SignerKey is the hex private key of this node's validator wallet.
+	// Nodes that only verify blocks leave it empty.
+	SignerKey string `json:"signerKey,omitempty"`
+}
+
+func init() {
+	core.RegisterEngine(EnginePoA, func(data json.RawMessage) (core.ConsensusEngine, error) {
+		var config poaConfigJSON
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("decode poa config: %w", err)
+		}
+
+		slotTime, err := time.ParseDuration(config.SlotTime)
+		if err != nil {
+			return nil, fmt.Errorf("decode poa config: slot time: %w", err)
+		}
+
+		signer, err := decodeSigner(config.SignerKey)
+		if err != nil {
+			return nil, fmt.Errorf("decode poa config: %w", err)
+		}
+
+		return NewPoAEngine(PoAConfig{Validators: config.Validators, SlotTime: slotTime}, signer)
+	})
+}
+
+// Vote proposes adding a validator to the set or removing one from it.
+// Validators cast votes in the coinbase of the blocks they seal, and a
+// proposal takes effect once more than half of the validators agree.
+type Vote struct {
+	Address string `json:"address"`
+	Add     bool   `json:"add"`
+}
+
+// PoAEngine is the proof of authority consensus engine. Validators take
+// turns: each slot of SlotTime belongs to the validator at the slot number
+// modulo the size of the set, and only that validator may seal a block with
+// a timestamp in the slot. Blocks are signed with the validator's wallet
+// key, use the proof of work limit as their target so the longest chain
+// wins, and pay the same rewards as proof of work.
+type PoAEngine struct {
+	config PoAConfig
+	signer *wallet.Wallet
+	clock  slotClock
+
+	mu        sync.Mutex
+	proposals map[string]bool
+	snapshots map[string]snapshot
+	highest   uint64
+}
+
+// snapshot is the validator set after a block at some height
+type snapshot struct {
+	set    *validatorSet
+	height uint64
+}
+
+// NewPoAEngine creates a proof of authority engine. signer is this node's
+// validator wallet, or nil for a node that only verifies blocks.
+func NewPoAEngine(config PoAConfig, signer *wallet.Wallet) (*PoAEngine, error) {
+	if len(config.Validators) == 0 {
+		return nil, errors.New("proof of authority needs at least one validator")
+	}
+	if config.SlotTime <= 0 {
+		return nil, fmt.Errorf("invalid slot time %s", config.SlotTime)
+	}
+
+	seen := make(map[string]bool, len(config.Validators))
+	for _, address := range config.Validators {
+		if !wallet.ValidateAddress(address) {
+			return nil, fmt.Errorf("invalid validator address %q", address)
+		}
+		if seen[address] {
+			return nil, fmt.Errorf("validator %s listed twice", address)
+		}
+		seen[address] = true
+	}
+
+	return &PoAEngine{
+		config:    config,
+		signer:    signer,
+		clock:     slotClock(config.SlotTime),
+		proposals: make(map[string]bool),
+		snapshots: make(map[string]snapshot),
+	}, nil
+}
+
+// Propose makes the engine vote to add or remove a validator in every
+// block it seals until the proposal takes effect or is discarded
+func (e *PoAEngine) Propose(address string, add bool) error {
+	if !wallet.ValidateAddress(address) {
+		return fmt.Errorf("invalid validator address %q", address)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.proposals[address] = add
+	return nil
+}
+
+// Discard withdraws a proposal made with Propose
+func (e *PoAEngine) Discard(address string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.proposals, address)
+}
+
+// Validators returns the validator set, in turn order, that seals the
+// blocks after the block with the given hash
+func (e *PoAEngine) Validators(chain ChainReader, hash string) ([]string, error) {
+	set, err := e.snapshot(chain, hash)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), set.validators...), nil
+}
+
+// Prepare schedules a new block in the signer's next slot after its parent,
+// marks the signer as its validator and adds the signer's pending votes
+func (e *PoAEngine) Prepare(chain ChainReader, block *core.Block) error {
+	if e.signer == nil {
+		return ErrNoSigner
+	}
+
+	parent := chain.Header(block.PreviousHash)
+	if parent == nil {
+		return fmt.Errorf("parent block %s not found", block.PreviousHash)
+	}
+	set, err := e.snapshot(chain, parent.Hash)
+	if err != nil {
+		return err
+	}
+	if !set.contains(e.signer.Address) {
+		return fmt.Errorf("%w: %s", ErrNotValidator, e.signer.Address)
+	}
+
+	// Take the first slot of ours that is after the parent and not yet over
+	now := chain.Now()
+	slot := e.clock.slot(parent.Timestamp) + 1
+	if current := e.clock.slot(now); current > slot {
+		slot = current
+	}
+	for set.inTurn(slot) != e.signer.Address {
+		slot++
+	}
+
+	e.clock.schedule(block, slot, now)
+	block.Validator = e.signer.Address
+	block.Bits = chain.Params().PowLimitBits
+	block.Nonce = 0
+
+	if votes := e.pendingVotes(set); len(votes) > 0 {
+		payload, err := json.Marshal(votes)
+		if err != nil {
+			return err
+		}
+		addCoinbaseData(block, append(append([]byte(nil), votePrefix...), payload...))
+	}
+
+	block.Hash = block.CalculateHash()
+	return nil
+}
+
+// pendingVotes returns the proposals that would change the validator set
+func (e *PoAEngine) pendingVotes(set *validatorSet) []Vote {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var votes []Vote
+	for address, add := range e.proposals {
+		if set.contains(address) != add {
+			votes = append(votes, Vote{Address: address, Add: add})
+		}
+	}
+	return votes
+}
+
+// Seal waits until the chain's clock reaches the block's slot and signs the
+// block with the signer's key. The signature is followed by the signer's
+// public key.
+func (e *PoAEngine) Seal(ctx context.Context, chain ChainReader, block *core.Block) error {
+	if e.signer == nil {
+		return ErrNoSigner
+	}
+	if block.Validator != e.signer.Address {
+		return fmt.Errorf("block is prepared for validator %s, not %s", block.Validator, e.signer.Address)
+	}
+
+	if err := waitUntil(ctx, chain, block.Timestamp); err != nil {
+		return err
+	}
+	return signBlock(e.signer, block)
+}
+
+// VerifyHeader checks that the block was sealed by the validator whose turn
+// it is in the block's slot and that its votes are well formed
+func (e *PoAEngine) VerifyHeader(chain ChainReader, block *core.Block, parent *core.ChainHeader) error {
+	if limit := chain.Params().PowLimitBits; block.Bits != limit {
+		return fmt.Errorf("bits %08x, proof of authority blocks use the proof of work limit %08x", block.Bits, limit)
+	}
+
+	set, err := e.snapshot(chain, parent.Hash)
+	if err != nil {
+		return err
+	}
+	if !set.contains(block.Validator) {
+		return fmt.Errorf("%w: %s", ErrNotValidator, block.Validator)
+	}
+
+	slot := e.clock.slot(block.Timestamp)
+	if slot <= e.clock.slot(parent.Timestamp) {
+		return fmt.Errorf("block shares slot %d with its parent", slot)
+	}
+	if inTurn := set.inTurn(slot); inTurn != block.Validator {
+		return fmt.Errorf("%w: slot %d belongs to %s, not %s", ErrOutOfTurn, slot, inTurn, block.Validator)
+	}
+
+	_, err = blockVotes(block)
+	return err
+}
+
+// VerifySeal checks that the block is signed by the key of its validator
+func (e *PoAEngine) VerifySeal(chain ChainReader, block *core.Block) error {
+	return verifyValidatorSignature(block)
+}
+
+// Finalize checks that the coinbase claims at most the block subsidy plus the fees
+func (e *PoAEngine) Finalize(chain ChainReader, block *core.Block, fees int) error {
+	return checkReward(chain, block, fees)
+}
+
+// snapshot returns the validator set after the block with the given hash,
+// replaying the votes of the blocks since the last known snapshot. e.mu is
+// only held to use the snapshot map, never while reading the chain: a
+// locking chain may be calling VerifyHeader under its own lock.
+func (e *PoAEngine) snapshot(chain ChainReader, hash string) (*validatorSet, error) {
+	// Walk back to a block whose snapshot is known
+	var pending []string
+	var set *validatorSet
+	var height uint64
+	for set == nil {
+		if known, ok := e.lookup(hash); ok {
+			set, height = known.set, known.height
+			break
+		}
+
+		header := chain.Header(hash)
+		if header == nil {
+			return nil, fmt.Errorf("block %s not found", hash)
+		}
+		if header.Height == 0 {
+			set = newValidatorSet(e.config.Validators)
+			e.remember(map[string]snapshot{hash: {set: set}})
+			break
+		}
+
+		pending = append(pending, hash)
+		hash = header.PreviousHash
+	}
+
+	// Replay the votes from there forward
+	replayed := make(map[string]snapshot, len(pending))
+	for i := len(pending) - 1; i >= 0; i-- {
+		block := chain.Block(pending[i])
+		if block == nil {
+			return nil, fmt.Errorf("block %s not found", pending[i])
+		}
+		votes, err := blockVotes(block)
+		if err != nil {
+			return nil, err
+		}
+		set = set.apply(block.Validator, votes)
+		height++
+		replayed[pending[i]] = snapshot{set: set, height: height}
+	}
+
+	if len(replayed) > 0 {
+		e.remember(replayed)
+	}
+	return set, nil
+}
+
+// lookup returns the known snapshot of the block with the given hash
+func (e *PoAEngine) lookup(hash string) (snapshot, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	known, ok := e.snapshots[hash]
+	return known, ok
+}
+
+// remember stores snapshots and forgets the ones more than snapshotWindow
+// below the highest once there are twice as many as the window holds
+func (e *PoAEngine) remember(snapshots map[string]snapshot) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for hash, known := range snapshots {
+		e.snapshots[hash] = known
+		if known.height > e.highest {
+			e.highest = known.height
+		}
+	}
+
+	if len(e.snapshots) <= 2*snapshotWindow || e.highest < snapshotWindow {
+		return
+	}
+	for hash, known := range e.snapshots {
+		if known.height < e.highest-snapshotWindow {
+			delete(e.snapshots, hash)
+		}
+	}
+}
+
+// blockVotes returns the votes carried by a block's coinbase
+func blockVotes(block *core.Block) ([]Vote, error) {
+	if len(block.Transactions) == 0 {
+		return nil, nil
+	}
+
+	var votes []Vote
+	for _, out := range block.Transactions[0].Outputs {
+		data := out.Data()
+		if !bytes.HasPrefix(data, votePrefix) {
+			continue
+		}
+
+		var cast []Vote
+		if err := json.Unmarshal(data[len(votePrefix):], &cast); err != nil {
+			return nil, fmt.Errorf("malformed validator votes: %w", err)
+		}
+		for _, vote := range cast {
+			if !wallet.ValidateAddress(vote.Address) {
+				return nil, fmt.Errorf("vote for invalid address %q", vote.Address)
+			}
+		}
+		votes = append(votes, cast...)
+	}
+	return votes, nil
+}
+
+// validatorSet is the validator set after some block together with the
+// votes cast so far for proposals that have not taken effect
+type validatorSet struct {
+	validators []string
+	votes      map[string]map[string]bool // proposed address -> voter -> add
+}
+
+// newValidatorSet creates a set of validators with no votes
+func newValidatorSet(validators []string) *validatorSet {
+	return &validatorSet{
+		validators: append([]string(nil), validators...),
+		votes:      make(map[string]map[string]bool),
+	}
+}
+
+// contains reports whether an address is a validator
+func (s *validatorSet) contains(address string) bool {
+	for _, validator := range s.validators {
+		if validator == address {
+			return true
+		}
+	}
+	return false
+}
+
+// inTurn returns the validator a slot belongs to
+func (s *validatorSet) inTurn(slot uint64) string {
+	return s.validators[slot%uint64(len(s.validators))]
+}
+
+// apply returns the set after voter cast votes. A proposal takes effect as
+// soon as more than half of the validators have voted for it; its votes are
+// then cleared, as are the votes of a removed validator. The last validator
+// is never removed.
+func (s *validatorSet) apply(voter string, votes []Vote) *validatorSet {
+	if len(votes) == 0 {
+		return s
+	}
+
+	next := newValidatorSet(s.validators)
+	for address, voters := range s.votes {
+		next.votes[address] = make(map[string]bool, len(voters))
+		for v, add := range voters {
+			next.votes[address][v] = add
+		}
+	}
+
+	for _, vote := range votes {
+		if next.contains(vote.Address) == vote.Add {
+			continue
+		}
+		if next.votes[vote.Address] == nil {
+			next.votes[vote.Address] = make(map[string]bool)
+		}
+		next.votes[vote.Address][voter] = vote.Add
+
+		tally := 0
+		for _, add := range next.votes[vote.Address] {
+			if add == vote.Add {
+				tally++
+			}
+		}
+		if 2*tally <= len(next.validators) {
+			continue
+		}
+
+		delete(next.votes, vote.Address)
+		if vote.Add {
+			next.validators = append(next.validators, vote.Address)
+			continue
+		}
+		if len(next.validators) == 1 {
+			continue
+		}
+		for i, validator := range next.validators {
+			if validator == vote.Address {
+				next.validators = append(next.validators[:i], next.validators[i+1:]...)
+				break
+			}
+		}
+		for _, voters := range next.votes {
+			delete(voters, vote.Address)
+		}
+	}
+
+	return next
+}
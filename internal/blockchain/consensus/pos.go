@@ -0,0 +1,664 @@
+package consensus
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+// EnginePoS is the name the proof of stake engine is registered under
+const EnginePoS = "pos"
+
+// ErrSlashed is returned when a slashed validator proposes a block or a
+// block spends the stake it forfeited
+var ErrSlashed = errors.New("validator is slashed")
+
+// slashPrefix marks a coinbase data output carrying double sign evidence
+var slashPrefix = []byte("pos-slash:")
+
+const (
+	// maxSlotSearch bounds how many slots Prepare looks ahead for one the
+	// signer is chosen to propose in
+	maxSlotSearch = 1 << 16
+
+	// evidenceWindow is how many blocks back the engine remembers which
+	// block each validator signed, to notice double signing, and keeps the
+	// evidence it gathered but has not yet put in a block
+	evidenceWindow = 1000
+
+	// epochLength is the number of blocks in an epoch. Proposers are drawn
+	// from a seed that is fixed an epoch before it is used.
+	epochLength = 32
+)
+
+// PoSConfig configures the proof of stake engine
+type PoSConfig struct {
+	// Stakes are the stakes of the first validators by address. They are
+	// not backed by deposits, so they can be slashed but not withdrawn.
+	Stakes map[string]int
+
+	// SlotTime is the time between block slots. Each slot has one proposer.
+	SlotTime time.Duration
+
+	// Unbonding is the least number of blocks a deposit must stay bonded
+	// for. Deposits with a shorter lock do not count as stake.
+	Unbonding uint16
+}
+
+// posConfigJSON is the configuration accepted by the registered factory
+type posConfigJSON struct {
+	Stakes    map[string]int `json:"stakes"`
+	SlotTime  string         `json:"slotTime"`
+	Unbonding uint16         `json:"unbonding"`
+
+	// SignerKey is the hex private key of this node's validator wallet.
+	// Nodes that only verify blocks leave it empty.
+	SignerKey string `json:"signerKey,omitempty"`
+}
+
+func init() {
+	core.RegisterEngine(EnginePoS, func(data json.RawMessage) (core.ConsensusEngine, error) {
+		var config posConfigJSON
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("decode pos config: %w", err)
+		}
+
+		slotTime, err := time.ParseDuration(config.SlotTime)
+		if err != nil {
+			return nil, fmt.Errorf("decode pos config: slot time: %w", err)
+		}
+
+		signer, err := decodeSigner(config.SignerKey)
+		if err != nil {
+			return nil, fmt.Errorf("decode pos config: %w", err)
+		}
+
+		return NewPoSEngine(PoSConfig{
+			Stakes:    config.Stakes,
+			SlotTime:  slotTime,
+			Unbonding: config.Unbonding,
+		}, signer)
+	})
+}
+
+// DoubleSign is evidence that a validator signed two different blocks at
+// the same height: the binary headers of both blocks and the signatures
+// the validator sealed them with
+type DoubleSign struct {
+	Headers    [2][]byte `json:"headers"`
+	Signatures [2][]byte `json:"signatures"`
+}
+
+// verify checks the evidence against the chain, which must know the parents
+// of both blocks, and returns the address of the validator it convicts
+func (d DoubleSign) verify(chain ChainReader) (string, error) {
+	var hashes, signers [2]string
+	var heights [2]uint64
+	for i := range d.Headers {
+		header, err := core.DeserializeHeader(d.Headers[i])
+		if err != nil {
+			return "", err
+		}
+		hash := header.Hash()
+		hashes[i] = hex.EncodeToString(hash[:])
+
+		if signers[i], err = blockSigner(hashes[i], d.Signatures[i]); err != nil {
+			return "", err
+		}
+
+		parent := chain.Header(hex.EncodeToString(header.PreviousHash[:]))
+		if parent == nil {
+			return "", fmt.Errorf("parent of block %s not found", hashes[i])
+		}
+		heights[i] = parent.Height + 1
+	}
+
+	switch {
+	case hashes[0] == hashes[1]:
+		return "", errors.New("evidence names the same block twice")
+	case signers[0] != signers[1]:
+		return "", errors.New("blocks are signed by different validators")
+	case heights[0] != heights[1]:
+		return "", fmt.Errorf("blocks are at heights %d and %d", heights[0], heights[1])
+	}
+	return signers[0], nil
+}
+
+// PoSEngine is the proof of stake consensus engine. Validators bond coins
+// in stake deposits, outputs locked by core.StakeScript, and withdraw them
+// by spending the deposits once unbonded. Each slot of SlotTime has one
+// proposer, drawn with probability proportional to stake from the slot
+// number and the epoch's seed, and only that proposer may seal a block in
+// the slot. Blocks are signed with the proposer's wallet key and use the
+// proof of work limit as their target so the longest chain wins.
+//
+// The seed of an epoch is the hash of the last block two epochs back, or
+// of the genesis block in the first two epochs. A proposer cannot try
+// different versions of its block to be drawn again for the next slots:
+// the draw for them was fixed an epoch earlier. The proposer of the last
+// block of an epoch can still try versions of that block to bias the epoch
+// after next, without knowing how the stakes will have moved by then.
+//
+// A validator that signs two blocks at the same height is slashed once a
+// block carries the evidence: it may no longer propose and its deposits
+// can never be withdrawn. The engine gathers such evidence from the blocks
+// it verifies and includes it in the next block it prepares.
+type PoSEngine struct {
+	config PoSConfig
+	signer *wallet.Wallet
+	clock  slotClock
+
+	mu        sync.Mutex
+	snapshots map[string]stakeSnapshot
+	highest   uint64
+	signed    map[uint64]map[string]signedHeader // height -> validator -> first block seen
+	evidence  []gatheredEvidence
+}
+
+// stakeSnapshot is the stake set after a block at some height
+type stakeSnapshot struct {
+	set    *stakeSet
+	height uint64
+}
+
+// signedHeader is the header and signature of a block a validator signed
+type signedHeader struct {
+	hash      string
+	header    []byte
+	signature []byte
+	reported  bool // evidence of a second block has been gathered
+}
+
+// gatheredEvidence is double sign evidence for blocks at a height
+type gatheredEvidence struct {
+	DoubleSign
+	height uint64
+}
+
+// NewPoSEngine creates a proof of stake engine. signer is this node's
+// validator wallet, or nil for a node that only verifies blocks.
+func NewPoSEngine(config PoSConfig, signer *wallet.Wallet) (*PoSEngine, error) {
+	if len(config.Stakes) == 0 {
+		return nil, errors.New("proof of stake needs at least one staked validator")
+	}
+	if config.SlotTime <= 0 {
+		return nil, fmt.Errorf("invalid slot time %s", config.SlotTime)
+	}
+	for address, stake := range config.Stakes {
+		if !wallet.ValidateAddress(address) {
+			return nil, fmt.Errorf("invalid validator address %q", address)
+		}
+		if stake <= 0 {
+			return nil, fmt.Errorf("invalid stake %d for %s", stake, address)
+		}
+	}
+
+	return &PoSEngine{
+		config:    config,
+		signer:    signer,
+		clock:     slotClock(config.SlotTime),
+		snapshots: make(map[string]stakeSnapshot),
+		signed:    make(map[uint64]map[string]signedHeader),
+	}, nil
+}
+
+// Stakes returns the stake of every validator that may propose the blocks
+// after the block with the given hash
+func (e *PoSEngine) Stakes(chain ChainReader, hash string) (map[string]int, error) {
+	set, err := e.snapshot(chain, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	stakes := make(map[string]int, len(set.stakes))
+	for address, stake := range set.stakes {
+		stakes[address] = stake
+	}
+	return stakes, nil
+}
+
+// Proposer returns the validator chosen to propose the child of the block
+// with the given hash in a slot
+func (e *PoSEngine) Proposer(chain ChainReader, parentHash string, slot uint64) (string, error) {
+	set, err := e.snapshot(chain, parentHash)
+	if err != nil {
+		return "", err
+	}
+	return set.proposer(slot), nil
+}
+
+// Prepare schedules a new block in the next slot after its parent that the
+// signer is chosen to propose, marks the signer as its validator and adds
+// any double sign evidence the engine has gathered
+func (e *PoSEngine) Prepare(chain ChainReader, block *core.Block) error {
+	if e.signer == nil {
+		return ErrNoSigner
+	}
+
+	parent := chain.Header(block.PreviousHash)
+	if parent == nil {
+		return fmt.Errorf("parent block %s not found", block.PreviousHash)
+	}
+	set, err := e.snapshot(chain, parent.Hash)
+	if err != nil {
+		return err
+	}
+	if set.slashed[e.signer.Address] {
+		return fmt.Errorf("%w: %s", ErrSlashed, e.signer.Address)
+	}
+	if set.stakes[e.signer.Address] == 0 {
+		return fmt.Errorf("%w: %s has no stake", ErrNotValidator, e.signer.Address)
+	}
+
+	now := chain.Now()
+	slot := e.clock.slot(parent.Timestamp) + 1
+	if current := e.clock.slot(now); current > slot {
+		slot = current
+	}
+	for end := slot + maxSlotSearch; set.proposer(slot) != e.signer.Address; slot++ {
+		if slot == end {
+			return fmt.Errorf("%s is not chosen to propose in the next %d slots", e.signer.Address, maxSlotSearch)
+		}
+	}
+
+	e.clock.schedule(block, slot, now)
+	block.Validator = e.signer.Address
+	block.Bits = chain.Params().PowLimitBits
+	block.Nonce = 0
+
+	if evidence := e.pendingEvidence(chain, set); len(evidence) > 0 {
+		payload, err := json.Marshal(evidence)
+		if err != nil {
+			return err
+		}
+		addCoinbaseData(block, append(append([]byte(nil), slashPrefix...), payload...))
+	}
+
+	block.Hash = block.CalculateHash()
+	return nil
+}
+
+// pendingEvidence returns the gathered evidence that convicts validators
+// not yet slashed, dropping the rest. The evidence is verified against the
+// chain without holding e.mu.
+func (e *PoSEngine) pendingEvidence(chain ChainReader, set *stakeSet) []DoubleSign {
+	e.mu.Lock()
+	gathered := e.evidence
+	e.evidence = nil
+	e.mu.Unlock()
+
+	var kept []gatheredEvidence
+	var pending []DoubleSign
+	convicted := make(map[string]bool)
+	for _, evidence := range gathered {
+		offender, err := evidence.verify(chain)
+		if err != nil || set.slashed[offender] {
+			continue
+		}
+		if !convicted[offender] {
+			convicted[offender] = true
+			kept = append(kept, evidence)
+			pending = append(pending, evidence.DoubleSign)
+		}
+	}
+
+	// Keep what was kept together with evidence gathered in the meantime
+	e.mu.Lock()
+	e.evidence = append(kept, e.evidence...)
+	e.mu.Unlock()
+	return pending
+}
+
+// Seal waits until the chain's clock reaches the block's slot and signs the
+// block with the signer's key
+func (e *PoSEngine) Seal(ctx context.Context, chain ChainReader, block *core.Block) error {
+	if e.signer == nil {
+		return ErrNoSigner
+	}
+	if block.Validator != e.signer.Address {
+		return fmt.Errorf("block is prepared for validator %s, not %s", block.Validator, e.signer.Address)
+	}
+
+	if err := waitUntil(ctx, chain, block.Timestamp); err != nil {
+		return err
+	}
+	return signBlock(e.signer, block)
+}
+
+// VerifyHeader checks that the block was sealed by the proposer of its slot
+// and that its transactions and evidence respect the stake rules
+func (e *PoSEngine) VerifyHeader(chain ChainReader, block *core.Block, parent *core.ChainHeader) error {
+	if limit := chain.Params().PowLimitBits; block.Bits != limit {
+		return fmt.Errorf("bits %08x, proof of stake blocks use the proof of work limit %08x", block.Bits, limit)
+	}
+
+	set, err := e.snapshot(chain, parent.Hash)
+	if err != nil {
+		return err
+	}
+	if set.slashed[block.Validator] {
+		return fmt.Errorf("%w: %s", ErrSlashed, block.Validator)
+	}
+
+	slot := e.clock.slot(block.Timestamp)
+	if slot <= e.clock.slot(parent.Timestamp) {
+		return fmt.Errorf("block shares slot %d with its parent", slot)
+	}
+	if proposer := set.proposer(slot); proposer != block.Validator {
+		return fmt.Errorf("%w: slot %d belongs to %s, not %s", ErrOutOfTurn, slot, proposer, block.Validator)
+	}
+
+	if _, err := set.apply(chain, block, parent.Height+1, e.config.Unbonding); err != nil {
+		return err
+	}
+
+	e.observe(block, parent.Height+1)
+	return nil
+}
+
+// observe remembers the block a validator signed at a height and records
+// evidence the first time it is seen to sign a different one. Blocks and
+// evidence more than evidenceWindow below the height are forgotten.
+func (e *PoSEngine) observe(block *core.Block, height uint64) {
+	header, err := block.Header()
+	if err != nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	signed, ok := e.signed[height]
+	if !ok {
+		signed = make(map[string]signedHeader)
+		e.signed[height] = signed
+		for h := range e.signed {
+			if h+evidenceWindow < height {
+				delete(e.signed, h)
+			}
+		}
+
+		recent := e.evidence[:0]
+		for _, evidence := range e.evidence {
+			if evidence.height+evidenceWindow >= height {
+				recent = append(recent, evidence)
+			}
+		}
+		e.evidence = recent
+	}
+
+	first, ok := signed[block.Validator]
+	if !ok {
+		signed[block.Validator] = signedHeader{hash: block.Hash, header: header.Serialize(), signature: block.Signature}
+		return
+	}
+	if first.hash != block.Hash && !first.reported {
+		first.reported = true
+		signed[block.Validator] = first
+		e.evidence = append(e.evidence, gatheredEvidence{
+			DoubleSign: DoubleSign{
+				Headers:    [2][]byte{first.header, header.Serialize()},
+				Signatures: [2][]byte{first.signature, block.Signature},
+			},
+			height: height,
+		})
+	}
+}
+
+// VerifySeal checks that the block is signed by the key of its validator
+func (e *PoSEngine) VerifySeal(chain ChainReader, block *core.Block) error {
+	return verifyValidatorSignature(block)
+}
+
+// Finalize checks that the coinbase claims at most the block subsidy plus the fees
+func (e *PoSEngine) Finalize(chain ChainReader, block *core.Block, fees int) error {
+	return checkReward(chain, block, fees)
+}
+
+// snapshot returns the stakes after the block with the given hash,
+// replaying the blocks since the last known snapshot. Like the proof of
+// authority engine, it only holds e.mu to use the snapshot map.
+func (e *PoSEngine) snapshot(chain ChainReader, hash string) (*stakeSet, error) {
+	// Walk back to a block whose snapshot is known
+	var pending []string
+	var set *stakeSet
+	var height uint64
+	for set == nil {
+		header := chain.Header(hash)
+		if header == nil {
+			return nil, fmt.Errorf("block %s not found", hash)
+		}
+		if known, ok := e.lookup(hash); ok {
+			set, height = known, header.Height
+			break
+		}
+		if header.Height == 0 {
+			set = newStakeSet(e.config.Stakes, hash)
+			e.remember(map[string]stakeSnapshot{hash: {set: set}})
+			break
+		}
+
+		pending = append(pending, hash)
+		hash = header.PreviousHash
+	}
+
+	// Replay the blocks from there forward
+	replayed := make(map[string]stakeSnapshot, len(pending))
+	for i := len(pending) - 1; i >= 0; i-- {
+		block := chain.Block(pending[i])
+		if block == nil {
+			return nil, fmt.Errorf("block %s not found", pending[i])
+		}
+
+		var err error
+		height++
+		if set, err = set.apply(chain, block, height, e.config.Unbonding); err != nil {
+			return nil, err
+		}
+		replayed[pending[i]] = stakeSnapshot{set: set, height: height}
+	}
+
+	if len(replayed) > 0 {
+		e.remember(replayed)
+	}
+	return set, nil
+}
+
+// lookup returns the known snapshot of the block with the given hash
+func (e *PoSEngine) lookup(hash string) (*stakeSet, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	known, ok := e.snapshots[hash]
+	return known.set, ok
+}
+
+// remember stores snapshots and forgets the ones more than snapshotWindow
+// below the highest once there are twice as many as the window holds
+func (e *PoSEngine) remember(snapshots map[string]stakeSnapshot) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for hash, known := range snapshots {
+		e.snapshots[hash] = known
+		if known.height > e.highest {
+			e.highest = known.height
+		}
+	}
+
+	if len(e.snapshots) <= 2*snapshotWindow || e.highest < snapshotWindow {
+		return
+	}
+	for hash, known := range e.snapshots {
+		if known.height < e.highest-snapshotWindow {
+			delete(e.snapshots, hash)
+		}
+	}
+}
+
+// blockEvidence returns the double sign evidence carried by a block's coinbase
+func blockEvidence(block *core.Block) ([]DoubleSign, error) {
+	if len(block.Transactions) == 0 {
+		return nil, nil
+	}
+
+	var evidence []DoubleSign
+	for _, out := range block.Transactions[0].Outputs {
+		data := out.Data()
+		if !bytes.HasPrefix(data, slashPrefix) {
+			continue
+		}
+
+		var carried []DoubleSign
+		if err := json.Unmarshal(data[len(slashPrefix):], &carried); err != nil {
+			return nil, fmt.Errorf("malformed double sign evidence: %w", err)
+		}
+		evidence = append(evidence, carried...)
+	}
+	return evidence, nil
+}
+
+// stakeSet is the stake of every validator after some block, together
+// with the seeds that draw the proposers of its children
+type stakeSet struct {
+	stakes   map[string]int     // validator -> stake that counts for proposing
+	deposits map[string]deposit // outpoint -> bonded deposit
+	slashed  map[string]bool
+
+	seed string // hash that seeds the draw in the current epoch
+	next string // hash that seeds the draw in the next epoch
+}
+
+// deposit is an unspent stake deposit
+type deposit struct {
+	owner string
+	value int
+}
+
+// newStakeSet creates a set holding the stakes of the first validators,
+// seeded by the genesis block's hash
+func newStakeSet(stakes map[string]int, genesis string) *stakeSet {
+	set := &stakeSet{
+		stakes:   make(map[string]int, len(stakes)),
+		deposits: make(map[string]deposit),
+		slashed:  make(map[string]bool),
+		seed:     genesis,
+		next:     genesis,
+	}
+	for address, stake := range stakes {
+		set.stakes[address] = stake
+	}
+	return set
+}
+
+// copy returns a copy of the set that can be changed independently
+func (s *stakeSet) copy() *stakeSet {
+	next := newStakeSet(s.stakes, s.seed)
+	next.next = s.next
+	for outpoint, d := range s.deposits {
+		next.deposits[outpoint] = d
+	}
+	for address := range s.slashed {
+		next.slashed[address] = true
+	}
+	return next
+}
+
+// proposer draws the proposer of a child of the set's block in a slot.
+// Each validator is chosen with probability proportional to its stake.
+func (s *stakeSet) proposer(slot uint64) string {
+	validators := make([]string, 0, len(s.stakes))
+	total := int64(0)
+	for address, stake := range s.stakes {
+		validators = append(validators, address)
+		total += int64(stake)
+	}
+	if total == 0 {
+		return ""
+	}
+	sort.Strings(validators)
+
+	seed := sha256.Sum256(binary.BigEndian.AppendUint64([]byte(s.seed), slot))
+	draw := new(big.Int).Mod(new(big.Int).SetBytes(seed[:]), big.NewInt(total)).Int64()
+	for _, address := range validators {
+		if draw < int64(s.stakes[address]) {
+			return address
+		}
+		draw -= int64(s.stakes[address])
+	}
+	return validators[len(validators)-1]
+}
+
+// apply returns the set after the block at a height. Evidence in the block
+// slashes its offenders first, so the block cannot also withdraw their
+// stake. Deposits count once confirmed and stop counting when withdrawn.
+// The last block of an epoch becomes the seed of the epoch after next.
+func (s *stakeSet) apply(chain ChainReader, block *core.Block, height uint64, unbonding uint16) (*stakeSet, error) {
+	next := s.copy()
+	if (height+1)%epochLength == 0 {
+		next.seed, next.next = s.next, block.Hash
+	}
+
+	evidence, err := blockEvidence(block)
+	if err != nil {
+		return nil, err
+	}
+	for _, ev := range evidence {
+		offender, err := ev.verify(chain)
+		if err != nil {
+			return nil, fmt.Errorf("invalid double sign evidence: %w", err)
+		}
+		if next.slashed[offender] {
+			return nil, fmt.Errorf("validator %s is already slashed", offender)
+		}
+		next.slashed[offender] = true
+		delete(next.stakes, offender)
+	}
+
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Inputs {
+				outpoint := fmt.Sprintf("%s:%d", in.TXID, in.Vout)
+				d, ok := next.deposits[outpoint]
+				if !ok {
+					continue
+				}
+				if next.slashed[d.owner] {
+					return nil, fmt.Errorf("%w: stake of %s is forfeit", ErrSlashed, d.owner)
+				}
+
+				delete(next.deposits, outpoint)
+				if next.stakes[d.owner] -= d.value; next.stakes[d.owner] <= 0 {
+					delete(next.stakes, d.owner)
+				}
+			}
+		}
+
+		for i, out := range tx.Outputs {
+			lock, pubKeyHash, ok := out.Script.Stake()
+			if !ok || lock < unbonding || out.Value <= 0 {
+				continue
+			}
+
+			owner := core.PubKeyHashToAddress(pubKeyHash)
+			next.deposits[fmt.Sprintf("%s:%d", tx.ID, i)] = deposit{owner: owner, value: out.Value}
+			if !next.slashed[owner] {
+				next.stakes[owner] += out.Value
+			}
+		}
+	}
+
+	return next, nil
+}
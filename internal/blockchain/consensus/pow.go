@@ -1,14 +1,20 @@
 package consensus
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/metrics"
 )
 
 // ProofOfWork represents a proof of work consensus mechanism
@@ -17,67 +23,179 @@ type ProofOfWork struct {
 	Target *big.Int
 }
 
-// NewProofOfWork creates a new ProofOfWork
+// NewProofOfWork creates a new ProofOfWork for the target in the block's bits
 func NewProofOfWork(block *core.Block) *ProofOfWork {
-	// Limit difficulty to prevent overflow
-	difficulty := block.Difficulty
-	if difficulty > 128 {
-		difficulty = 128
+	return &ProofOfWork{block, core.CompactToBig(block.Bits)}
+}
+
+// InitData returns the encoded block header with the given nonce, which is
+// what the proof of work hashes
+func (pow *ProofOfWork) InitData(nonce uint64) []byte {
+	header, err := pow.Block.Header()
+	if err != nil {
+		return nil
 	}
 
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-int(difficulty)))
+	header.Nonce = nonce
+	return header.Serialize()
+}
+
+// MinerOptions configures the proof of work search
+type MinerOptions struct {
+	// Workers is the number of goroutines that search the nonce space in
+	// parallel. Zero means one per CPU.
+	Workers int
 
-	pow := &ProofOfWork{block, target}
+	// MaxNonce is the last nonce tried before the block's extra nonce is
+	// rolled and the search starts over. Zero means the whole nonce space.
+	MaxNonce uint64
 
-	return pow
+	// Metrics receives the hashrate of every search when set
+	Metrics *metrics.Metrics
 }
 
-// InitData initializes the data for proof of work
-func (pow *ProofOfWork) InitData(nonce uint64) []byte {
-	data := bytes.Join(
-		[][]byte{
-			[]byte(pow.Block.PreviousHash),
-			[]byte(fmt.Sprintf("%v", pow.Block.Data)),
-			IntToHex(int64(nonce)),
-			IntToHex(int64(pow.Block.Difficulty)),
-		},
-		[]byte{},
-	)
-
-	return data
+// DefaultMinerOptions returns the options the proof of work engine mines
+// with unless given others: one worker per CPU over the whole nonce space
+func DefaultMinerOptions() MinerOptions {
+	return MinerOptions{Workers: runtime.NumCPU()}
 }
 
-// Run performs the proof of work
-func (pow *ProofOfWork) Run() (uint64, []byte) {
-	var hashInt big.Int
-	var hash [32]byte
-	nonce := uint64(0)
-
-	fmt.Printf("Mining a new block with difficulty %d\n", pow.Block.Difficulty)
-	for nonce < math.MaxUint64 {
-		data := pow.InitData(nonce)
-		hash = sha256.Sum256(data)
-		fmt.Printf("\r%x", hash)
-		hashInt.SetBytes(hash[:])
-
-		if hashInt.Cmp(pow.Target) == -1 {
-			break
-		} else {
-			nonce++
+// cancelCheckInterval is how many hashes a worker tries between checks for
+// cancellation
+const cancelCheckInterval = 1 << 12
+
+// Run searches for a nonce whose header hash meets the target, with
+// opts.Workers goroutines each trying every Workers-th nonce. Once every
+// nonce up to opts.MaxNonce has been tried the extra nonce is rolled, which
+// changes the header, and the search starts over. On success the block's
+// nonce and hash are set and returned. Run gives up with the cause of ctx's
+// cancellation, such as a new tip making the block stale.
+func (pow *ProofOfWork) Run(ctx context.Context, opts MinerOptions) (uint64, []byte, error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	maxNonce := opts.MaxNonce
+	if maxNonce == 0 {
+		maxNonce = math.MaxUint64
+	}
+
+	var hashes atomic.Uint64
+	if opts.Metrics != nil {
+		start := time.Now()
+		defer func() {
+			if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+				opts.Metrics.SetHashrate(float64(hashes.Load()) / elapsed)
+			}
+		}()
+	}
+
+	var extraNonce uint64
+	for {
+		header, err := pow.Block.Header()
+		if err != nil {
+			return 0, nil, fmt.Errorf("block %d has no valid header: %w", pow.Block.Index, err)
+		}
+
+		nonce, hash, found := pow.search(ctx, header, workers, maxNonce, &hashes)
+		if found {
+			pow.Block.Nonce = nonce
+			pow.Block.Hash = hex.EncodeToString(hash[:])
+			return nonce, hash[:], nil
 		}
+		if ctx.Err() != nil {
+			return 0, nil, context.Cause(ctx)
+		}
+
+		extraNonce++
+		pow.rollExtraNonce(extraNonce)
+	}
+}
+
+// search tries the nonces up to maxNonce with the given number of workers
+// and reports whether one of them meets the target
+func (pow *ProofOfWork) search(ctx context.Context, header *core.BlockHeader, workers int, maxNonce uint64, hashes *atomic.Uint64) (uint64, [32]byte, bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		nonce uint64
+		hash  [32]byte
 	}
-	fmt.Print("\n\n")
+	found := make(chan result, workers)
+
+	var wg sync.WaitGroup
+	for first := uint64(0); first < uint64(workers) && first <= maxNonce; first++ {
+		wg.Add(1)
+		go func(header core.BlockHeader) {
+			defer wg.Done()
+
+			var hashInt big.Int
+			var tried uint64
+			defer func() { hashes.Add(tried) }()
+
+			for header.Nonce = first; ; header.Nonce += uint64(workers) {
+				if tried%cancelCheckInterval == 0 && ctx.Err() != nil {
+					return
+				}
+
+				hash := header.Hash()
+				tried++
+				if hashInt.SetBytes(hash[:]).Cmp(pow.Target) < 0 {
+					found <- result{header.Nonce, hash}
+					cancel()
+					return
+				}
+
+				if maxNonce-header.Nonce < uint64(workers) {
+					return
+				}
+			}
+		}(*header)
+	}
+	wg.Wait()
 
-	return nonce, hash[:]
+	select {
+	case r := <-found:
+		return r.nonce, r.hash, true
+	default:
+		return 0, [32]byte{}, false
+	}
 }
 
-// Validate validates the proof of work
+// rollExtraNonce changes the block once its nonce space is exhausted. The
+// extra nonce is appended to the coinbase's input data, which moves the
+// merkle root; a block without a coinbase moves its timestamp on instead.
+func (pow *ProofOfWork) rollExtraNonce(extraNonce uint64) {
+	block := pow.Block
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		block.Timestamp = block.Timestamp.Add(time.Nanosecond)
+		return
+	}
+
+	input := &block.Transactions[0].Inputs[0]
+	if extraNonce > 1 {
+		input.ScriptSig = input.ScriptSig[:len(input.ScriptSig)-8]
+	}
+	input.ScriptSig = binary.BigEndian.AppendUint64(append(core.Script(nil), input.ScriptSig...), extraNonce)
+	block.Transactions[0].SetID()
+	block.MerkleRoot = block.ComputeMerkleRoot()
+}
+
+// Validate validates the proof of work. The block's hash must be the hash of
+// its header and fall below the target.
 func (pow *ProofOfWork) Validate() bool {
 	var hashInt big.Int
 
 	data := pow.InitData(pow.Block.Nonce)
+	if data == nil {
+		return false
+	}
+
 	hash := sha256.Sum256(data)
+	if hex.EncodeToString(hash[:]) != pow.Block.Hash {
+		return false
+	}
 	hashInt.SetBytes(hash[:])
 
 	return hashInt.Cmp(pow.Target) == -1
@@ -88,73 +206,68 @@ func IntToHex(num int64) []byte {
 	return []byte(fmt.Sprintf("%x", num))
 }
 
-// CalculateDifficulty calculates the new difficulty based on the time it took to mine the last blocks
-func CalculateDifficulty(lastBlock *core.Block, previousBlock *core.Block) uint8 {
-	// Target time between blocks (in seconds)
-	targetTime := 10
-
-	// If this is the genesis block or there's no previous block, return default difficulty
-	if previousBlock == nil {
-		return 1
+// NextBits returns the compact target a child of parent must meet. Until
+// the chain is longer than the retarget window every block uses the proof
+// of work limit. After that the target is the average target of the last
+// RetargetWindow blocks scaled by how long they took against TargetSpacing,
+// with the timespan clamped to within a factor of MaxRetarget of the
+// intended one, so difficulty moves smoothly and by fractions. Median time
+// past lets a window take no time or less than none, so the timespan is
+// never taken as shorter than a second and the target never reaches zero.
+func NextBits(chain ChainReader, parent *core.ChainHeader) (uint32, error) {
+	params := chain.Params()
+	window := params.RetargetWindow
+	if window == 0 || parent.Height < window {
+		return params.PowLimitBits, nil
 	}
 
-	// Calculate the time difference between the last two blocks
-	timeDiff := lastBlock.Timestamp.Sub(previousBlock.Timestamp).Seconds()
-
-	// Calculate the new difficulty
-	// If blocks are being mined too quickly, increase difficulty
-	// If blocks are being mined too slowly, decrease difficulty
-	newDifficulty := lastBlock.Difficulty
-
-	if timeDiff < float64(targetTime) {
-		// Blocks are being mined too quickly, increase difficulty
-		if newDifficulty < 128 {
-			newDifficulty++
-		}
-	} else if timeDiff > float64(targetTime) {
-		// Blocks are being mined too slowly, decrease difficulty (but not below 1)
-		if newDifficulty > 1 {
-			newDifficulty--
+	// Sum the targets of the window, walking back to the block before it
+	sum := new(big.Int)
+	first := parent
+	for i := uint64(0); i < window; i++ {
+		sum.Add(sum, core.CompactToBig(first.Bits))
+		if first = chain.Header(first.PreviousHash); first == nil {
+			return 0, fmt.Errorf("retarget window of block %s is incomplete", parent.Hash)
 		}
 	}
 
-	return newDifficulty
-}
-
-// MineBlock mines a new block using proof of work
-func MineBlock(blockchain *core.Blockchain, data interface{}) *core.Block {
-	// Get the previous block
-	previousBlock := blockchain.GetLatestBlock()
-
-	// Calculate the new difficulty
-	var newDifficulty uint8 = 1
-	if previousBlock.Index > 0 {
-		prevPrevBlock := blockchain.GetBlockByIndex(previousBlock.Index - 1)
-		newDifficulty = CalculateDifficulty(previousBlock, prevPrevBlock)
+	expected := time.Duration(window) * params.TargetSpacing
+	timespan := parent.Timestamp.Sub(first.Timestamp)
+	if params.MaxRetarget > 0 {
+		timespan = max(timespan, expected/time.Duration(params.MaxRetarget))
+		timespan = min(timespan, expected*time.Duration(params.MaxRetarget))
 	}
+	timespan = max(timespan, time.Second)
 
-	// Create a new block with the calculated difficulty
-	newBlock := core.NewBlock(previousBlock.Index+1, previousBlock.Hash, data)
-	newBlock.Difficulty = newDifficulty
-	newBlock.Timestamp = time.Now()
-
-	// Create proof of work for the new block
-	pow := NewProofOfWork(newBlock)
-
-	// Run the proof of work
-	nonce, hash := pow.Run()
+	target := sum.Div(sum, new(big.Int).SetUint64(window))
+	target.Mul(target, big.NewInt(int64(timespan)))
+	target.Div(target, big.NewInt(int64(expected)))
+	if target.Sign() == 0 {
+		target.SetInt64(1)
+	}
+	if limit := core.CompactToBig(params.PowLimitBits); target.Cmp(limit) > 0 {
+		return params.PowLimitBits, nil
+	}
+	return core.BigToCompact(target), nil
+}
 
-	// Set the nonce and hash for the block
-	newBlock.Nonce = nonce
-	newBlock.Hash = fmt.Sprintf("%x", hash)
+// ErrStaleBlock is the cause reported when mining stops because the chain
+// tip moved
+var ErrStaleBlock = core.ErrStaleBlock
 
-	// Validate the proof of work
-	if !pow.Validate() {
+// MineBlock mines a new block with the chain's consensus engine
+func MineBlock(blockchain *core.Blockchain, data interface{}) *core.Block {
+	block, err := MineBlockContext(context.Background(), blockchain, data)
+	if err != nil {
 		return nil
 	}
+	return block
+}
 
-	// Add the block to the blockchain
-	blockchain.AddBlockManually(newBlock)
-
-	return newBlock
+// MineBlockContext creates a new block on the chain tip, has the chain's
+// consensus engine seal it and adds it to the chain, as
+// Blockchain.AddBlockContext does. A proof of work engine mines with the
+// options it was created with.
+func MineBlockContext(ctx context.Context, blockchain *core.Blockchain, data interface{}) (*core.Block, error) {
+	return blockchain.AddBlockContext(ctx, data)
 }
@@ -0,0 +1,135 @@
+package consensus
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+// slotClock divides the time since the genesis block into slots of a fixed
+// length. The engines where validators take turns give each slot to one
+// validator.
+type slotClock time.Duration
+
+// slot returns the number of the slot a time falls in
+func (c slotClock) slot(t time.Time) uint64 {
+	elapsed := t.Sub(core.GenesisTimestamp)
+	if elapsed < 0 {
+		return 0
+	}
+	return uint64(elapsed / time.Duration(c))
+}
+
+// start returns the time a slot begins
+func (c slotClock) start(slot uint64) time.Time {
+	return core.GenesisTimestamp.Add(time.Duration(slot) * time.Duration(c))
+}
+
+// schedule sets the timestamp of a block sealed in slot: the start of the
+// slot, or now if the slot has already begun
+func (c slotClock) schedule(block *core.Block, slot uint64, now time.Time) {
+	block.Timestamp = c.start(slot)
+	if now.After(block.Timestamp) {
+		block.Timestamp = now
+	}
+}
+
+// clockPollInterval bounds how long waitUntil sleeps before reading the
+// chain's clock again. The clock may be adjusted by peers or set by hand,
+// so the wall clock only estimates when it reaches a time.
+const clockPollInterval = 10 * time.Millisecond
+
+// waitUntil blocks until the chain's clock reaches t, giving up with the
+// cause of ctx's cancellation
+func waitUntil(ctx context.Context, chain ChainReader, t time.Time) error {
+	for {
+		remaining := t.Sub(chain.Now())
+		if remaining <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(min(remaining, clockPollInterval))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return context.Cause(ctx)
+		case <-timer.C:
+		}
+	}
+}
+
+// decodeSigner returns the validator wallet for a hex private key from an
+// engine's configuration, or nil if the key is empty
+func decodeSigner(key string) (*wallet.Wallet, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	raw, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("signer key: %w", err)
+	}
+	signer, err := wallet.NewWalletFromKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("signer key: %w", err)
+	}
+	return signer, nil
+}
+
+// signBlock signs a block's hash with a validator's wallet key. The
+// signature is followed by the wallet's public key.
+func signBlock(signer *wallet.Wallet, block *core.Block) error {
+	block.Hash = block.CalculateHash()
+	hash, err := hex.DecodeString(block.Hash)
+	if err != nil {
+		return err
+	}
+
+	signature, err := core.SignHash(signer.PrivateKey, hash)
+	if err != nil {
+		return err
+	}
+	block.Signature = append(signature, signer.PublicKey...)
+	return nil
+}
+
+// blockSigner checks a signature made by signBlock over a block hash and
+// returns the address of the key that made it
+func blockSigner(hash string, signature []byte) (string, error) {
+	if len(signature) != core.SignatureLen+core.PubKeyLen {
+		return "", errors.New("block is not signed by a validator")
+	}
+
+	pubKey := signature[core.SignatureLen:]
+	raw, err := hex.DecodeString(hash)
+	if err != nil || !core.VerifyHash(pubKey, raw, signature[:core.SignatureLen]) {
+		return "", errors.New("invalid validator signature")
+	}
+	return core.PubKeyHashToAddress(core.HashPubKey(pubKey)), nil
+}
+
+// verifyValidatorSignature checks that a block is signed by the key of its validator
+func verifyValidatorSignature(block *core.Block) error {
+	signer, err := blockSigner(block.Hash, block.Signature)
+	if err != nil {
+		return err
+	}
+	if signer != block.Validator {
+		return fmt.Errorf("block is not signed by its validator %s", block.Validator)
+	}
+	return nil
+}
+
+// addCoinbaseData adds a data output to a block's coinbase and updates the
+// block's merkle root to commit to it
+func addCoinbaseData(block *core.Block, payload []byte) {
+	coinbase := &block.Transactions[0]
+	coinbase.Outputs = append(coinbase.Outputs, core.NewDataOutput(payload))
+	coinbase.SetID()
+	block.MerkleRoot = block.ComputeMerkleRoot()
+}
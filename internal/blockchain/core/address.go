@@ -0,0 +1,40 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// PubKeyHashLen is the length of a public key hash in bytes
+const PubKeyHashLen = 20
+
+// ErrInvalidAddress is returned (wrapped with the address) for strings that
+// are not the hex encoding of a public key hash
+var ErrInvalidAddress = errors.New("invalid address")
+
+// HashPubKey returns the hash that outputs are locked to for a public key.
+// It matches the derivation used by wallet addresses.
+func HashPubKey(pubKey []byte) []byte {
+	hash := sha256.Sum256(pubKey)
+	return hash[:PubKeyHashLen]
+}
+
+// PubKeyHashToAddress returns the address for a public key hash
+func PubKeyHashToAddress(pubKeyHash []byte) string {
+	return hex.EncodeToString(pubKeyHash)
+}
+
+// addressPubKeyHash returns the public key hash for an address. Anything
+// else is an error, since an output locked to it could never be spent.
+func addressPubKeyHash(address string) ([]byte, error) {
+	if len(address) != 2*PubKeyHashLen {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAddress, address)
+	}
+	pubKeyHash, err := hex.DecodeString(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAddress, address)
+	}
+	return pubKeyHash, nil
+}
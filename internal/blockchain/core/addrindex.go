@@ -0,0 +1,244 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/storage"
+)
+
+// addrIndexBucket maps address, height and position to a transaction ID
+var addrIndexBucket = []byte("addrindex")
+
+// ErrAddressIndexDisabled is returned by address queries when the node runs without the address index
+var ErrAddressIndexDisabled = errors.New("address index disabled")
+
+// AddressTx is one entry of an address's transaction history
+type AddressTx struct {
+	TxID        string       `json:"txid"`
+	Height      uint64       `json:"height"`
+	Position    uint32       `json:"position"`
+	BlockHash   string       `json:"blockHash"`
+	Timestamp   time.Time    `json:"timestamp"`
+	Transaction *Transaction `json:"transaction"`
+}
+
+// addrPrefix returns the key prefix shared by every entry of a public key hash.
+// The hash is length-prefixed so that no address is a prefix of another.
+func addrPrefix(pubKeyHash []byte) []byte {
+	prefix := make([]byte, 0, 1+len(pubKeyHash))
+	prefix = append(prefix, byte(len(pubKeyHash)))
+	return append(prefix, pubKeyHash...)
+}
+
+// addrKey returns the address index key of a transaction. Keys sort by
+// address, then height, then position, so an address's history is in chain order.
+func addrKey(pubKeyHash []byte, loc TxLocation) []byte {
+	key := addrPrefix(pubKeyHash)
+	key = binary.BigEndian.AppendUint64(key, loc.Height)
+	return binary.BigEndian.AppendUint32(key, loc.Position)
+}
+
+// touchedPubKeyHashes returns the public key hashes a transaction pays to or spends from
+func touchedPubKeyHashes(t *Transaction) [][]byte {
+	var hashes [][]byte
+	seen := make(map[string]bool)
+
+	add := func(pubKeyHash []byte) {
+		if len(pubKeyHash) == 0 || seen[string(pubKeyHash)] {
+			return
+		}
+		seen[string(pubKeyHash)] = true
+		hashes = append(hashes, pubKeyHash)
+	}
+
+	if !t.IsCoinbase() {
+		for _, in := range t.Inputs {
+			if pubKey := in.ScriptSig.p2pkhPubKey(); pubKey != nil {
+				add(HashPubKey(pubKey))
+			}
+		}
+	}
+
+	for _, out := range t.Outputs {
+		add(out.Script.signerPubKeyHash())
+	}
+
+	return hashes
+}
+
+// indexAddresses records every address touched by a block's transactions
+func indexAddresses(tx storage.Tx, block *Block) error {
+	bucket := tx.Bucket(addrIndexBucket)
+	if bucket == nil {
+		return fmt.Errorf("address index bucket not found")
+	}
+
+	for i := range block.Transactions {
+		t := &block.Transactions[i]
+		loc := TxLocation{Height: block.Index, Position: uint32(i)}
+		for _, pubKeyHash := range touchedPubKeyHashes(t) {
+			if err := bucket.Put(addrKey(pubKeyHash, loc), []byte(t.ID)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// unindexAddresses removes a block that is leaving the main chain from the address index
+func unindexAddresses(tx storage.Tx, block *Block) error {
+	bucket := tx.Bucket(addrIndexBucket)
+	if bucket == nil {
+		return fmt.Errorf("address index bucket not found")
+	}
+
+	for i := range block.Transactions {
+		t := &block.Transactions[i]
+		loc := TxLocation{Height: block.Index, Position: uint32(i)}
+		for _, pubKeyHash := range touchedPubKeyHashes(t) {
+			if err := bucket.Delete(addrKey(pubKeyHash, loc)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// rebuildAddressIndex recreates the address index from the blocks bucket
+func rebuildAddressIndex(tx storage.Tx) error {
+	if err := tx.DeleteBucket(addrIndexBucket); err != nil && err != storage.ErrBucketNotFound {
+		return err
+	}
+	if _, err := tx.CreateBucketIfNotExists(addrIndexBucket); err != nil {
+		return err
+	}
+
+	blocks := tx.Bucket(blocksBucket)
+	if blocks == nil {
+		return nil
+	}
+
+	return blocks.ForEach(func(k, v []byte) error {
+		block, err := Deserialize(v)
+		if err != nil {
+			return fmt.Errorf("decode block %s: %w", k, err)
+		}
+		return indexAddresses(tx, block)
+	})
+}
+
+// RebuildAddressIndex recreates the address index from the main chain
+func (bc *Blockchain) RebuildAddressIndex() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if !bc.addrIndex {
+		return ErrAddressIndexDisabled
+	}
+
+	return bc.store.Update(rebuildAddressIndex)
+}
+
+// GetAddressHistory returns the transactions that pay to or spend from an
+// address, newest first. offset skips that many entries and limit caps the
+// page size; a limit of zero or less returns every remaining entry.
+func (bc *Blockchain) GetAddressHistory(address string, offset, limit int) ([]AddressTx, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if !bc.addrIndex {
+		return nil, ErrAddressIndexDisabled
+	}
+	pubKeyHash, err := addressPubKeyHash(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var locs []TxLocation
+	var txids []string
+
+	err = bc.store.View(func(tx storage.Tx) error {
+		bucket := tx.Bucket(addrIndexBucket)
+		if bucket == nil {
+			return fmt.Errorf("address index bucket not found")
+		}
+
+		prefix := addrPrefix(pubKeyHash)
+
+		// Position the cursor on the last key with the prefix and walk backwards
+		cursor := bucket.Cursor()
+		k, v := cursor.Last()
+		if end := prefixEnd(prefix); end != nil {
+			if k, _ = cursor.Seek(end); k == nil {
+				k, v = cursor.Last()
+			} else {
+				k, v = cursor.Prev()
+			}
+		}
+
+		skipped := 0
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Prev() {
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			if limit > 0 && len(txids) >= limit {
+				break
+			}
+
+			rest := k[len(prefix):]
+			locs = append(locs, TxLocation{
+				Height:   binary.BigEndian.Uint64(rest[:8]),
+				Position: binary.BigEndian.Uint32(rest[8:12]),
+			})
+			txids = append(txids, string(v))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]AddressTx, 0, len(txids))
+	for i, loc := range locs {
+		block := bc.blockAt(loc.Height)
+		if block == nil {
+			return nil, fmt.Errorf("block %d missing from store", loc.Height)
+		}
+
+		txs := block.Transactions
+		if int(loc.Position) >= len(txs) || txs[loc.Position].ID != txids[i] {
+			return nil, fmt.Errorf("address index out of sync at block %d", loc.Height)
+		}
+
+		history = append(history, AddressTx{
+			TxID:        txids[i],
+			Height:      loc.Height,
+			Position:    loc.Position,
+			BlockHash:   block.Hash,
+			Timestamp:   block.Timestamp,
+			Transaction: &txs[loc.Position],
+		})
+	}
+
+	return history, nil
+}
+
+// prefixEnd returns the smallest key greater than every key starting with prefix
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -1,7 +1,7 @@
 package core
 
 import (
-	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -9,57 +9,80 @@ import (
 
 // Block represents a block in the blockchain
 type Block struct {
-	Index        uint64      `json:"index"`
-	Timestamp    time.Time   `json:"timestamp"`
-	PreviousHash string      `json:"previousHash"`
-	Hash         string      `json:"hash"`
-	Data         interface{} `json:"data"`
-	Nonce        uint64      `json:"nonce"`
-	Difficulty   uint8       `json:"difficulty"`
-	Validator    string      `json:"validator"`
+	Version      uint32        `json:"version"`
+	Index        uint64        `json:"index"`
+	Timestamp    time.Time     `json:"timestamp"`
+	PreviousHash string        `json:"previousHash"`
+	Hash         string        `json:"hash"`
+	MerkleRoot   string        `json:"merkleRoot"`
+	Transactions []Transaction `json:"transactions"`
+	Nonce        uint64        `json:"nonce"`
+	Bits         uint32        `json:"bits"`
+	Validator    string        `json:"validator"`
+
+	// Signature seals the block for consensus engines where a validator
+	// signs blocks. Its format is up to the engine.
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// GenesisTimestamp is the fixed timestamp of the genesis block. Every node
+// derives the same genesis block from it so independently started nodes share
+// the root of the block tree.
+var GenesisTimestamp = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// NewGenesisBlock creates the genesis block
+func NewGenesisBlock() *Block {
+	coinbase := newPayloadCoinbase(0, []byte("Genesis Block"))
+	coinbase.Time = GenesisTimestamp
+	coinbase.SetID()
+
+	block := &Block{
+		Version:      BlockVersion,
+		Index:        0,
+		Timestamp:    GenesisTimestamp,
+		Transactions: []Transaction{*coinbase},
+		Bits:         PowLimitBits,
+	}
+
+	block.MerkleRoot = block.ComputeMerkleRoot()
+	block.Hash = block.CalculateHash()
+
+	return block
 }
 
-// NewBlock creates a new block
+// NewBlock creates a new block. data is either the block's transactions
+// ([]Transaction, []*Transaction or *Transaction) or an arbitrary payload that
+// is stored in a data output of the coinbase. A coinbase is added in front of
+// transactions that do not start with one.
 func NewBlock(index uint64, previousHash string, data interface{}) *Block {
 	block := &Block{
+		Version:      BlockVersion,
 		Index:        index,
 		Timestamp:    time.Now(),
 		PreviousHash: previousHash,
-		Data:         data,
+		Transactions: newBlockBody(index, data),
 		Nonce:        0,
-		Difficulty:   1,
+		Bits:         PowLimitBits,
 		Validator:    "",
 	}
 
-	// Calculate the hash for the new block
+	// Commit to the transactions and calculate the hash for the new block
+	block.MerkleRoot = block.ComputeMerkleRoot()
 	block.Hash = block.CalculateHash()
 
 	return block
 }
 
-// CalculateHash calculates the SHA256 hash of the block
+// CalculateHash calculates the block ID, the hex SHA-256 hash of the binary
+// header. It returns an empty string if the header cannot be encoded.
 func (b *Block) CalculateHash() string {
-	// Create a copy of the block without the hash field to avoid circular dependency
-	blockCopy := Block{
-		Index:        b.Index,
-		Timestamp:    b.Timestamp,
-		PreviousHash: b.PreviousHash,
-		Data:         b.Data,
-		Nonce:        b.Nonce,
-		Difficulty:   b.Difficulty,
-		Validator:    b.Validator,
-		Hash:         "", // Set to empty to avoid circular dependency
-	}
-
-	// Convert the block copy to JSON for hashing
-	blockData, err := json.Marshal(blockCopy)
+	header, err := b.Header()
 	if err != nil {
 		return ""
 	}
 
-	// Calculate SHA256 hash
-	hash := sha256.Sum256(blockData)
-	return fmt.Sprintf("%x", hash)
+	hash := header.Hash()
+	return hex.EncodeToString(hash[:])
 }
 
 // Serialize converts the block to a JSON byte array
@@ -77,27 +100,42 @@ func Deserialize(data []byte) (*Block, error) {
 	return &block, nil
 }
 
-// Validate checks the integrity of the block
+// Validate checks the integrity of the block. See Check for the reason a
+// block is invalid.
 func (b *Block) Validate(previousBlock *Block) bool {
+	return b.Check(previousBlock) == nil
+}
+
+// Check checks the integrity of the block and, if previousBlock is given,
+// that the block follows it. The seal, a proof of work or a validator's
+// signature, depends on the chain's consensus engine and parameters, and
+// the timestamp rules need the blocks before previousBlock, so both are
+// checked by the chain. The reason a block is invalid is returned wrapped
+// in ErrInvalidBlock.
+func (b *Block) Check(previousBlock *Block) error {
 	// Check if the hash is correct
-	if b.CalculateHash() != b.Hash {
-		return false
+	if b.Hash == "" || b.CalculateHash() != b.Hash {
+		return fmt.Errorf("%w: hash does not match header", ErrInvalidBlock)
 	}
 
-	// Check if the previous hash matches the previous block's hash
-	if previousBlock != nil && b.PreviousHash != previousBlock.Hash {
-		return false
+	// Check if the body is well formed and matches the header's merkle root
+	if err := b.CheckBody(); err != nil {
+		return err
 	}
 
-	// Check if the index is correct
-	if previousBlock != nil && b.Index != previousBlock.Index+1 {
-		return false
+	if previousBlock == nil {
+		return nil
 	}
 
-	// Check if the timestamp is after the previous block's timestamp
-	if previousBlock != nil && !b.Timestamp.After(previousBlock.Timestamp) {
-		return false
+	// Check if the previous hash matches the previous block's hash
+	if b.PreviousHash != previousBlock.Hash {
+		return fmt.Errorf("%w: previous hash does not match block %d", ErrInvalidBlock, previousBlock.Index)
+	}
+
+	// Check if the index is correct
+	if b.Index != previousBlock.Index+1 {
+		return fmt.Errorf("%w: height %d does not follow parent height %d", ErrInvalidBlock, b.Index, previousBlock.Index)
 	}
 
-	return true
+	return nil
 }
@@ -1,120 +1,769 @@
 package core
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
+	"os"
 	"sync"
+	"time"
 
-	"go.etcd.io/bbolt"
+	"github.com/antontuzov/coubcore/internal/blockchain/storage"
 )
 
-// Blockchain represents the entire blockchain
+// Storage backends understood by NewBlockchain
+const (
+	BackendBolt   = "bbolt"
+	BackendMemory = "memory"
+)
+
+var blocksBucket = []byte("blocks")
+
+// ErrUnknownBackend is returned when Options names a backend that does not exist
+var ErrUnknownBackend = errors.New("unknown storage backend")
+
+// Options configures how a Blockchain stores its data
+type Options struct {
+	// Backend selects the storage implementation (BackendBolt or BackendMemory)
+	Backend string `json:"backend"`
+
+	// DataDir is the directory holding the database file
+	DataDir string `json:"dataDir"`
+
+	// FileMode is used when creating the database file
+	FileMode os.FileMode `json:"fileMode"`
+
+	// Timeout bounds how long opening waits for another process holding the database lock
+	Timeout time.Duration `json:"timeout"`
+
+	// CacheSize is the number of recently used blocks kept in memory
+	CacheSize int `json:"cacheSize"`
+
+	// AddressIndex enables the per-address transaction history index
+	AddressIndex bool `json:"addressIndex"`
+
+	// Params are the consensus rules. The zero value selects DefaultParams.
+	Params Params `json:"params"`
+
+	// Consensus names the registered engine that seals and verifies blocks
+	Consensus string `json:"consensus"`
+
+	// ConsensusConfig is passed to the factory of the engine named by Consensus
+	ConsensusConfig json.RawMessage `json:"consensusConfig,omitempty"`
+
+	// Engine, when set, is used instead of the engine named by Consensus
+	Engine ConsensusEngine `json:"-"`
+
+	// Clock sets the timestamps of new blocks. Nil selects SystemClock.
+	Clock Clock `json:"-"`
+}
+
+// DefaultOptions returns the options used by a standalone node
+func DefaultOptions() Options {
+	return Options{
+		Backend:   BackendBolt,
+		DataDir:   ".",
+		FileMode:  0600,
+		Timeout:   time.Second,
+		CacheSize: DefaultCacheSize,
+		Params:    DefaultParams(),
+		Consensus: DefaultConsensus,
+	}
+}
+
+// ErrDuplicateBlock is returned when a block that is already known is processed again
+var ErrDuplicateBlock = errors.New("block already known")
+
+// ErrInvalidBlock is returned (wrapped with the reason) when a block fails validation
+var ErrInvalidBlock = errors.New("invalid block")
+
+// BlockStatus describes where ProcessBlock put a block
+type BlockStatus int
+
+const (
+	// BlockMainChain means the block is part of the main chain, possibly after a reorganization
+	BlockMainChain BlockStatus = iota
+
+	// BlockSideChain means the block was stored on a branch with less work than the main chain
+	BlockSideChain
+
+	// BlockOrphan means the block's parent is unknown and the block waits in the orphan pool
+	BlockOrphan
+)
+
+// Blockchain represents the entire blockchain. Blocks live in the store and
+// only the chain tip, a bounded cache of recently used blocks and the tree of
+// known headers are kept in memory. The main chain is the branch of the
+// header tree with the most cumulative work.
 type Blockchain struct {
-	blocks []*Block
-	db     *bbolt.DB
-	mu     sync.RWMutex
+	store     storage.Store
+	cache     *blockCache
+	tip       *Block
+	tipNode   *blockNode
+	nodes     map[string]*blockNode
+	orphans   *orphanPool
+	addrIndex bool
+	params    Params
+	engine    ConsensusEngine
+	clock     Clock
+	listeners []ChainListener
+	events    []chainEvent
+	mu        sync.RWMutex
+}
+
+// NewBlockchain opens the storage backend described by opts and loads the
+// blockchain from it, creating the genesis block if the store is empty
+func NewBlockchain(opts Options) (*Blockchain, error) {
+	var store storage.Store
+
+	switch opts.Backend {
+	case "", BackendBolt:
+		bolt, err := storage.OpenBolt(storage.BoltOptions{
+			DataDir:  opts.DataDir,
+			FileMode: opts.FileMode,
+			Timeout:  opts.Timeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+		store = bolt
+	case BackendMemory:
+		store = storage.NewMemory()
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBackend, opts.Backend)
+	}
+
+	bc, err := NewBlockchainWithStore(store, opts)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	return bc, nil
 }
 
-// NewBlockchain creates a new blockchain with a genesis block
-func NewBlockchain() *Blockchain {
-	// Open the database
-	db, err := bbolt.Open("blockchain.db", 0600, nil)
+// NewBlockchainWithStore creates a blockchain on top of an already opened store.
+// The storage related fields of opts are ignored. The blockchain takes
+// ownership of the store and closes it in Close.
+func NewBlockchainWithStore(store storage.Store, opts Options) (*Blockchain, error) {
+	engine, err := newEngine(opts)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	bc := &Blockchain{
-		blocks: make([]*Block, 0),
-		db:     db,
+		store:     store,
+		cache:     newBlockCache(opts.CacheSize),
+		nodes:     make(map[string]*blockNode),
+		orphans:   newOrphanPool(),
+		addrIndex: opts.AddressIndex,
+		params:    opts.Params,
+		engine:    engine,
+		clock:     opts.Clock,
+	}
+	if bc.clock == nil {
+		bc.clock = SystemClock{}
+	}
+	if bc.params == (Params{}) {
+		bc.params = DefaultParams()
+	}
+	// No hash meets a zero target, so params without one use the default
+	if bc.params.PowLimitBits == 0 {
+		bc.params.PowLimitBits = PowLimitBits
 	}
 
-	// Initialize the database buckets
-	bc.db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte("blocks"))
-		return err
+	// Initialize the database buckets, rebuilding the indexes for
+	// databases created before they existed
+	err = bc.store.Update(func(tx storage.Tx) error {
+		for _, name := range [][]byte{blocksBucket, sideBlocksBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(headersBucket) == nil {
+			if err := rebuildHeaders(tx); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(hashIndexBucket) == nil || tx.Bucket(txIndexBucket) == nil {
+			if err := rebuildIndexes(tx); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(utxoBucket) == nil {
+			if err := rebuildChainstate(tx, bc.params); err != nil {
+				return err
+			}
+		}
+
+		// The address index is optional: build it when enabled and drop it
+		// when disabled so it never goes stale
+		if bc.addrIndex && tx.Bucket(addrIndexBucket) == nil {
+			return rebuildAddressIndex(tx)
+		}
+		if !bc.addrIndex {
+			if err := tx.DeleteBucket(addrIndexBucket); err != nil && err != storage.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
 	})
+	if err != nil {
+		return nil, fmt.Errorf("initialize buckets: %w", err)
+	}
 
-	// Load existing blockchain from database or create genesis block
-	bc.loadFromDB()
+	// Load the chain tip and header tree from the database
+	if err := bc.loadTip(); err != nil {
+		return nil, fmt.Errorf("load blockchain: %w", err)
+	}
 
 	// If blockchain is empty, create genesis block
-	if len(bc.blocks) == 0 {
-		genesisBlock := NewBlock(0, "", "Genesis Block")
-		bc.blocks = append(bc.blocks, genesisBlock)
-		bc.persistBlock(genesisBlock)
+	if bc.tip == nil {
+		if err := bc.initGenesis(NewGenesisBlock()); err != nil {
+			return nil, fmt.Errorf("persist genesis block: %w", err)
+		}
+	}
+
+	return bc, nil
+}
+
+// initGenesis stores the genesis block of an empty database
+func (bc *Blockchain) initGenesis(genesis *Block) error {
+	node := newBlockNode(genesis, nil)
+
+	err := bc.store.Update(func(tx storage.Tx) error {
+		if err := putHeader(tx, node); err != nil {
+			return err
+		}
+		return bc.connectBlock(tx, genesis)
+	})
+	if err != nil {
+		return err
 	}
 
-	return bc
+	bc.nodes[node.hash] = node
+	bc.tipNode = node
+	bc.setTip(genesis)
+
+	return nil
 }
 
-// loadFromDB loads the blockchain from the database
-func (bc *Blockchain) loadFromDB() {
-	bc.db.View(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket([]byte("blocks"))
+// blockKey returns the database key of the block at a height
+func blockKey(index uint64) []byte {
+	return []byte(fmt.Sprintf("%020d", index))
+}
+
+// loadTip reads the highest main chain block and the header tree from the database
+func (bc *Blockchain) loadTip() error {
+	return bc.store.View(func(tx storage.Tx) error {
+		nodes, err := loadHeaders(tx)
+		if err != nil {
+			return err
+		}
+		bc.nodes = nodes
+
+		bucket := tx.Bucket(blocksBucket)
 		if bucket == nil {
 			return nil
 		}
 
-		cursor := bucket.Cursor()
-		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-			block, err := Deserialize(v)
-			if err != nil {
-				continue
-			}
-			bc.blocks = append(bc.blocks, block)
+		k, v := bucket.Cursor().Last()
+		if k == nil {
+			return nil
 		}
 
+		block, err := Deserialize(v)
+		if err != nil {
+			return fmt.Errorf("decode tip block %s: %w", k, err)
+		}
+
+		node, ok := bc.nodes[block.Hash]
+		if !ok {
+			return fmt.Errorf("tip block %s missing from header tree", block.Hash)
+		}
+
+		bc.tipNode = node
+		bc.setTip(block)
 		return nil
 	})
 }
 
-// persistBlock saves a block to the database
-func (bc *Blockchain) persistBlock(block *Block) error {
-	return bc.db.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket([]byte("blocks"))
+// setTip makes block the head of the chain
+func (bc *Blockchain) setTip(block *Block) {
+	bc.tip = block
+	bc.cache.add(block)
+}
+
+// connectBlock makes a block the new top of the main chain inside a store
+// transaction, moving it out of the side blocks and updating the UTXO set
+// and the indexes
+func (bc *Blockchain) connectBlock(tx storage.Tx, block *Block) error {
+	bucket := tx.Bucket(blocksBucket)
+	side := tx.Bucket(sideBlocksBucket)
+	if bucket == nil || side == nil {
+		return fmt.Errorf("blocks bucket not found")
+	}
+
+	// Genesis has no parent and spends nothing, so its own time stands in
+	medianTime := block.Timestamp
+	if parent, ok := bc.nodes[block.PreviousHash]; ok {
+		medianTime = medianTimePast(parent, bc.params.MedianTimeBlocks)
+	}
+	fees, err := connectUTXOs(tx, block, medianTime, bc.params)
+	if err != nil {
+		return err
+	}
+	if err := bc.engine.Finalize(chainView{bc, tx}, block, fees); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+	}
+
+	data, err := block.Serialize()
+	if err != nil {
+		return err
+	}
+
+	if err := bucket.Put(blockKey(block.Index), data); err != nil {
+		return err
+	}
+	if err := side.Delete([]byte(block.Hash)); err != nil {
+		return err
+	}
+
+	if err := indexBlock(tx, block); err != nil {
+		return err
+	}
+
+	if bc.addrIndex {
+		return indexAddresses(tx, block)
+	}
+	return nil
+}
+
+// disconnectBlock removes the top block of the main chain inside a store
+// transaction, keeping it as a side block so a later reorganization can
+// reconnect it
+func (bc *Blockchain) disconnectBlock(tx storage.Tx, block *Block) error {
+	bucket := tx.Bucket(blocksBucket)
+	side := tx.Bucket(sideBlocksBucket)
+	if bucket == nil || side == nil {
+		return fmt.Errorf("blocks bucket not found")
+	}
+
+	if err := disconnectUTXOs(tx, block); err != nil {
+		return err
+	}
+
+	if err := unindexBlock(tx, block); err != nil {
+		return err
+	}
+	if bc.addrIndex {
+		if err := unindexAddresses(tx, block); err != nil {
+			return err
+		}
+	}
+
+	data, err := block.Serialize()
+	if err != nil {
+		return err
+	}
+
+	if err := side.Put([]byte(block.Hash), data); err != nil {
+		return err
+	}
+	return bucket.Delete(blockKey(block.Index))
+}
+
+// storedBlock reads a block by hash from the main chain or the side blocks
+func (bc *Blockchain) storedBlock(tx storage.Tx, node *blockNode) (*Block, error) {
+	if data := tx.Bucket(sideBlocksBucket).Get([]byte(node.hash)); data != nil {
+		return Deserialize(data)
+	}
+
+	if data := tx.Bucket(blocksBucket).Get(blockKey(node.height)); data != nil {
+		block, err := Deserialize(data)
+		if err != nil {
+			return nil, err
+		}
+		if block.Hash == node.hash {
+			return block, nil
+		}
+	}
+
+	return nil, fmt.Errorf("block %s missing from store", node.hash)
+}
+
+// readBlock loads the block at a height from the database
+func (bc *Blockchain) readBlock(index uint64) (*Block, error) {
+	var block *Block
+
+	err := bc.store.View(func(tx storage.Tx) error {
+		bucket := tx.Bucket(blocksBucket)
 		if bucket == nil {
-			return fmt.Errorf("blocks bucket not found")
+			return nil
+		}
+
+		data := bucket.Get(blockKey(index))
+		if data == nil {
+			return nil
+		}
+
+		var err error
+		block, err = Deserialize(data)
+		return err
+	})
+
+	return block, err
+}
+
+// blockAt returns the block at a height, consulting the cache first.
+// The caller must hold bc.mu.
+func (bc *Blockchain) blockAt(index uint64) *Block {
+	if bc.tip == nil || index > bc.tip.Index {
+		return nil
+	}
+
+	if block, ok := bc.cache.get(index); ok {
+		return block
+	}
+
+	block, err := bc.readBlock(index)
+	if err != nil || block == nil {
+		return nil
+	}
+
+	bc.cache.add(block)
+	return block
+}
+
+// AddBlock adds a new block to the blockchain. It returns nil if the block
+// could not be added; AddBlockContext reports why.
+func (bc *Blockchain) AddBlock(data interface{}) *Block {
+	block, err := bc.AddBlockContext(context.Background(), data)
+	if err != nil {
+		return nil
+	}
+	return block
+}
+
+// ErrStaleBlock is the cause reported when sealing a new block stops
+// because the chain tip moved
+var ErrStaleBlock = errors.New("chain tip changed while sealing")
+
+// AddBlockContext creates a block on the chain tip, has the consensus engine
+// seal it and adds it to the chain. Sealing may mine or wait for a
+// validator's slot, so it runs without the chain lock and stops with
+// ErrStaleBlock when another block changes the tip first, or with the cause
+// of ctx's cancellation.
+func (bc *Blockchain) AddBlockContext(ctx context.Context, data interface{}) (*Block, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	watcher := &tipWatcher{cancel: cancel}
+	bc.Subscribe(watcher)
+	defer bc.Unsubscribe(watcher)
+
+	// Create the block on the tip and fill in its consensus fields
+	bc.mu.RLock()
+	previousBlock := bc.tip
+	newBlock := NewBlock(previousBlock.Index+1, previousBlock.Hash, data)
+	newBlock.Timestamp = bc.nextTimestamp(previousBlock)
+	err := bc.engine.Prepare(chainView{bc: bc}, newBlock)
+	bc.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Seal it while the chain keeps serving readers and other blocks
+	if err := bc.engine.Seal(ctx, bc, newBlock); err != nil {
+		return nil, err
+	}
+
+	if _, err := bc.ProcessBlock(newBlock); err != nil {
+		return nil, err
+	}
+	return newBlock, nil
+}
+
+// tipWatcher cancels the sealing of a block when the main chain changes
+type tipWatcher struct {
+	cancel context.CancelCauseFunc
+}
+
+// BlockConnected cancels the sealing
+func (w *tipWatcher) BlockConnected(block *Block) {
+	w.cancel(ErrStaleBlock)
+}
+
+// BlockDisconnected cancels the sealing
+func (w *tipWatcher) BlockDisconnected(block *Block) {
+	w.cancel(ErrStaleBlock)
+}
+
+// AddBlockManually adds a pre-created block, such as a mined block or one
+// received from a peer, to the block tree
+func (bc *Blockchain) AddBlockManually(block *Block) error {
+	_, err := bc.ProcessBlock(block)
+	return err
+}
+
+// ProcessBlock adds a block to the block tree. A block whose parent is
+// unknown is kept in the orphan pool until the parent arrives. If the block
+// makes some branch heavier than the main chain, the chain reorganizes onto
+// that branch by disconnecting and connecting only the blocks that differ.
+func (bc *Blockchain) ProcessBlock(block *Block) (status BlockStatus, err error) {
+	bc.update(func() {
+		status, err = bc.processBlock(block)
+	})
+	return status, err
+}
+
+// processBlock implements ProcessBlock. The caller must hold bc.mu.
+func (bc *Blockchain) processBlock(block *Block) (BlockStatus, error) {
+	status, err := bc.acceptBlock(block)
+	if err != nil || status == BlockOrphan {
+		return status, err
+	}
+
+	// The new block may be the missing parent of some orphans
+	pending := []string{block.Hash}
+	for len(pending) > 0 {
+		parent := pending[0]
+		pending = pending[1:]
+
+		for _, orphan := range bc.orphans.takeChildren(parent) {
+			if _, err := bc.acceptBlock(orphan); err == nil {
+				pending = append(pending, orphan.Hash)
+			}
+		}
+	}
+
+	// Connecting orphans may have moved the tip past the block
+	if bc.isMainChain(bc.nodes[block.Hash]) {
+		return BlockMainChain, nil
+	}
+	return BlockSideChain, nil
+}
+
+// acceptBlock validates a block against its parent, stores it in the block
+// tree and switches the main chain if the block's branch is now the heaviest
+func (bc *Blockchain) acceptBlock(block *Block) (BlockStatus, error) {
+	if known, ok := bc.nodes[block.Hash]; ok {
+		if known.invalid {
+			return 0, fmt.Errorf("%w: block is known to be invalid", ErrInvalidBlock)
+		}
+		return 0, ErrDuplicateBlock
+	}
+	if bc.orphans.has(block.Hash) {
+		return 0, ErrDuplicateBlock
+	}
+
+	if block.Hash == "" || block.CalculateHash() != block.Hash {
+		return 0, fmt.Errorf("%w: hash does not match header", ErrInvalidBlock)
+	}
+	if err := block.CheckBody(); err != nil {
+		return 0, err
+	}
+	if err := bc.engine.VerifySeal(chainView{bc: bc}, block); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+	}
+
+	parent, ok := bc.nodes[block.PreviousHash]
+	if !ok {
+		if block.Index == 0 {
+			return 0, fmt.Errorf("%w: unknown genesis block", ErrInvalidBlock)
+		}
+		bc.orphans.add(block)
+		return BlockOrphan, nil
+	}
+
+	if parent.invalid {
+		return 0, fmt.Errorf("%w: parent block is invalid", ErrInvalidBlock)
+	}
+
+	if block.Index != parent.height+1 {
+		return 0, fmt.Errorf("%w: height %d does not follow parent height %d", ErrInvalidBlock, block.Index, parent.height)
+	}
+	if err := bc.checkTimestamp(block, parent); err != nil {
+		return 0, err
+	}
+	if err := bc.engine.VerifyHeader(chainView{bc: bc}, block, parent.header()); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+	}
+
+	node := newBlockNode(block, parent)
+	heavier := node.work.Cmp(bc.tipNode.work) > 0
+
+	var fork *blockNode
+	var disconnected, connected []*Block
+	err := bc.store.Update(func(tx storage.Tx) error {
+		if err := putHeader(tx, node); err != nil {
+			return err
 		}
 
 		data, err := block.Serialize()
 		if err != nil {
 			return err
 		}
+		if err := tx.Bucket(sideBlocksBucket).Put([]byte(block.Hash), data); err != nil {
+			return err
+		}
 
-		key := fmt.Sprintf("%020d", block.Index)
-		return bucket.Put([]byte(key), data)
+		if heavier {
+			fork, disconnected, connected, err = bc.reorganize(tx, node, block)
+			return err
+		}
+		return nil
 	})
+	if err != nil {
+		// The store transaction rolled back, so the block is forgotten and
+		// can be offered again. Only a block that broke the consensus rules
+		// is remembered as invalid: CheckBody ties its body to its hash, so
+		// no other copy of it can be valid, and neither can any block built
+		// on it up to the new tip.
+		var failed *connectError
+		if errors.As(err, &failed) && errors.Is(err, ErrInvalidBlock) {
+			for n := node; n != failed.node.parent; n = n.parent {
+				n.invalid = true
+			}
+			bc.nodes[node.hash] = node
+		}
+		return 0, err
+	}
+
+	bc.nodes[node.hash] = node
+
+	if !heavier {
+		return BlockSideChain, nil
+	}
+
+	bc.cache.purgeFrom(fork.height + 1)
+	bc.tipNode = node
+	bc.setTip(block)
+	bc.recordReorg(disconnected, connected)
+
+	return BlockMainChain, nil
 }
 
-// AddBlock adds a new block to the blockchain
-func (bc *Blockchain) AddBlock(data interface{}) *Block {
-	bc.mu.Lock()
-	defer bc.mu.Unlock()
+// reorganize switches the main chain to end at newTip inside a store
+// transaction. It returns the fork point shared by the old and new chains,
+// the blocks disconnected from the old tip downwards and the blocks
+// connected from the fork point upwards.
+func (bc *Blockchain) reorganize(tx storage.Tx, newTip *blockNode, newBlock *Block) (*blockNode, []*Block, []*Block, error) {
+	fork := findFork(bc.tipNode, newTip)
+	if fork == nil {
+		return nil, nil, nil, fmt.Errorf("%w: branch does not share the genesis block", ErrInvalidBlock)
+	}
+
+	// Disconnect main chain blocks from the tip down to the fork point
+	var disconnected []*Block
+	for node := bc.tipNode; node != fork; node = node.parent {
+		block, err := bc.storedBlock(tx, node)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err := bc.disconnectBlock(tx, block); err != nil {
+			return nil, nil, nil, err
+		}
+		disconnected = append(disconnected, block)
+	}
 
-	// Get the previous block
-	previousBlock := bc.blocks[len(bc.blocks)-1]
+	// Connect the new branch from just above the fork point up to the new tip
+	var attach []*blockNode
+	for node := newTip; node != fork; node = node.parent {
+		attach = append(attach, node)
+	}
 
-	// Create a new block
-	newBlock := NewBlock(previousBlock.Index+1, previousBlock.Hash, data)
+	var connected []*Block
+	for i := len(attach) - 1; i >= 0; i-- {
+		block := newBlock
+		if attach[i] != newTip {
+			var err error
+			if block, err = bc.storedBlock(tx, attach[i]); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		if err := bc.connectBlock(tx, block); err != nil {
+			return nil, nil, nil, &connectError{node: attach[i], err: err}
+		}
+		connected = append(connected, block)
+	}
+
+	return fork, disconnected, connected, nil
+}
+
+// connectError is returned by reorganize when a block of the new branch
+// fails to connect
+type connectError struct {
+	node *blockNode
+	err  error
+}
+
+func (e *connectError) Error() string {
+	return e.err.Error()
+}
+
+func (e *connectError) Unwrap() error {
+	return e.err
+}
+
+// isMainChain reports whether a node is on the main chain
+func (bc *Blockchain) isMainChain(node *blockNode) bool {
+	return bc.tipNode.ancestor(node.height) == node
+}
+
+// Params returns the consensus rules the blockchain validates blocks with
+func (bc *Blockchain) Params() Params {
+	return bc.params
+}
 
-	// Add the new block to the chain
-	bc.blocks = append(bc.blocks, newBlock)
+// Now returns the current time of the blockchain's clock
+func (bc *Blockchain) Now() time.Time {
+	return bc.clock.Now()
+}
 
-	// Persist the new block
-	bc.persistBlock(newBlock)
+// NextTimestamp returns the timestamp of a new block on parent: the
+// current time, moved past the parent's timestamp and median time past if
+// the clock is behind them
+func (bc *Blockchain) NextTimestamp(parent *Block) time.Time {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
 
-	return newBlock
+	return bc.nextTimestamp(parent)
 }
 
-// AddBlockManually adds a pre-created block to the blockchain
-func (bc *Blockchain) AddBlockManually(block *Block) {
-	bc.mu.Lock()
-	defer bc.mu.Unlock()
+// nextTimestamp implements NextTimestamp. The caller must hold bc.mu.
+func (bc *Blockchain) nextTimestamp(parent *Block) time.Time {
+	earliest := parent.Timestamp
+	if node, ok := bc.nodes[parent.Hash]; ok {
+		if median := medianTimePast(node, bc.params.MedianTimeBlocks); median.After(earliest) {
+			earliest = median
+		}
+	}
 
-	// Add the block to the chain
-	bc.blocks = append(bc.blocks, block)
+	now := bc.clock.Now()
+	if !now.After(earliest) {
+		now = earliest.Add(time.Nanosecond)
+	}
+	return now
+}
 
-	// Persist the new block
-	bc.persistBlock(block)
+// OrphanCount returns the number of blocks waiting for their parent
+func (bc *Blockchain) OrphanCount() int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.orphans.len()
+}
+
+// TotalWork returns the cumulative work of the main chain
+func (bc *Blockchain) TotalWork() *big.Int {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return new(big.Int).Set(bc.tipNode.work)
 }
 
 // GetLatestBlock returns the latest block in the blockchain
@@ -122,58 +771,203 @@ func (bc *Blockchain) GetLatestBlock() *Block {
 	bc.mu.RLock()
 	defer bc.mu.RUnlock()
 
-	if len(bc.blocks) == 0 {
+	return bc.tip
+}
+
+// GetBlockByIndex returns a block by its index
+func (bc *Blockchain) GetBlockByIndex(index uint64) *Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.blockAt(index)
+}
+
+// GetBlockByHash returns the main chain block with the given hash
+func (bc *Blockchain) GetBlockByHash(hash string) *Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	var height uint64
+	found := false
+
+	bc.store.View(func(tx storage.Tx) error {
+		bucket := tx.Bucket(hashIndexBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		data := bucket.Get([]byte(hash))
+		if data == nil {
+			return nil
+		}
+
+		var err error
+		height, err = decodeHeight(data)
+		found = err == nil
+		return nil
+	})
+
+	if !found {
 		return nil
 	}
 
-	return bc.blocks[len(bc.blocks)-1]
+	return bc.blockAt(height)
 }
 
-// GetBlockByIndex returns a block by its index
-func (bc *Blockchain) GetBlockByIndex(index uint64) *Block {
+// GetTransaction returns a main chain transaction by ID together with the
+// block that contains it
+func (bc *Blockchain) GetTransaction(txid string) (*Transaction, *Block) {
 	bc.mu.RLock()
 	defer bc.mu.RUnlock()
 
-	if index >= uint64(len(bc.blocks)) {
+	var loc TxLocation
+	found := false
+
+	bc.store.View(func(tx storage.Tx) error {
+		bucket := tx.Bucket(txIndexBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		data := bucket.Get([]byte(txid))
+		if data == nil {
+			return nil
+		}
+
+		var err error
+		loc, err = decodeTxLocation(data)
+		found = err == nil
 		return nil
+	})
+
+	if !found {
+		return nil, nil
 	}
 
-	return bc.blocks[index]
+	block := bc.blockAt(loc.Height)
+	if block == nil {
+		return nil, nil
+	}
+
+	txs := block.Transactions
+	if int(loc.Position) >= len(txs) || txs[loc.Position].ID != txid {
+		return nil, nil
+	}
+
+	return &txs[loc.Position], block
 }
 
-// IsChainValid validates the entire blockchain
+// FindPrevTransactions returns the main chain transactions spent by the
+// inputs of tx, keyed by ID, as needed by Transaction.Sign and Transaction.Verify
+func (bc *Blockchain) FindPrevTransactions(tx *Transaction) (map[string]Transaction, error) {
+	prevTXs := make(map[string]Transaction)
+	for _, in := range tx.Inputs {
+		prev, _ := bc.GetTransaction(in.TXID)
+		if prev == nil {
+			return nil, fmt.Errorf("transaction %s not found", in.TXID)
+		}
+		prevTXs[prev.ID] = *prev
+	}
+	return prevTXs, nil
+}
+
+// SignTransaction signs the inputs of tx, which must spend main chain outputs
+func (bc *Blockchain) SignTransaction(tx *Transaction, privKey *ecdsa.PrivateKey) error {
+	prevTXs, err := bc.FindPrevTransactions(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Sign(privKey, prevTXs)
+}
+
+// VerifyTransaction verifies the input signatures of tx against the main chain
+func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
+	if tx.IsCoinbase() {
+		return true
+	}
+
+	prevTXs, err := bc.FindPrevTransactions(tx)
+	if err != nil {
+		return false
+	}
+	return tx.Verify(prevTXs)
+}
+
+// IsChainValid validates the entire blockchain. VerifyChain returns why
+// the chain is invalid.
 func (bc *Blockchain) IsChainValid() bool {
+	return bc.VerifyChain() == nil
+}
+
+// VerifyChain checks every block of the main chain: its integrity, its link
+// to the previous block, its median time past and the consensus engine's
+// header and seal rules.
+// It returns the reason the first invalid block was rejected.
+func (bc *Blockchain) VerifyChain() error {
 	bc.mu.RLock()
 	defer bc.mu.RUnlock()
 
 	// Check if the blockchain is empty
-	if len(bc.blocks) == 0 {
-		return false
+	if bc.tip == nil {
+		return errors.New("blockchain is empty")
 	}
 
 	// Validate each block in the chain
-	for i := 1; i < len(bc.blocks); i++ {
-		currentBlock := bc.blocks[i]
-		previousBlock := bc.blocks[i-1]
+	previousBlock := bc.blockAt(0)
+	if previousBlock == nil {
+		return errors.New("missing block at index 0")
+	}
+
+	view := chainView{bc: bc}
+	for i := uint64(1); i <= bc.tip.Index; i++ {
+		currentBlock := bc.blockAt(i)
+		if currentBlock == nil {
+			return fmt.Errorf("missing block at index %d", i)
+		}
 
 		// Validate the current block
-		if !currentBlock.Validate(previousBlock) {
-			fmt.Printf("Invalid block at index %d\n", currentBlock.Index)
-			return false
+		if err := currentBlock.Check(previousBlock); err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+		if median := medianTimePast(bc.tipNode.ancestor(i-1), bc.params.MedianTimeBlocks); !currentBlock.Timestamp.After(median) {
+			return fmt.Errorf("block %d: %w: %w", i, ErrInvalidBlock, ErrTimeTooOld)
+		}
+		if err := bc.engine.VerifySeal(view, currentBlock); err != nil {
+			return fmt.Errorf("block %d: %w: %w", i, ErrInvalidBlock, err)
+		}
+		if err := bc.engine.VerifyHeader(view, currentBlock, bc.header(previousBlock.Hash)); err != nil {
+			return fmt.Errorf("block %d: %w: %w", i, ErrInvalidBlock, err)
 		}
+
+		previousBlock = currentBlock
 	}
 
-	return true
+	return nil
 }
 
-// GetBlocks returns all blocks in the blockchain
-func (bc *Blockchain) GetBlocks() []*Block {
+// GetBlocks returns up to limit blocks starting at height start.
+// A limit of zero or less returns every block from start to the tip.
+func (bc *Blockchain) GetBlocks(start uint64, limit int) []*Block {
 	bc.mu.RLock()
 	defer bc.mu.RUnlock()
 
-	// Return a copy of the blocks slice
-	blocks := make([]*Block, len(bc.blocks))
-	copy(blocks, bc.blocks)
+	if bc.tip == nil || start > bc.tip.Index {
+		return []*Block{}
+	}
+
+	end := bc.tip.Index
+	if limit > 0 && start+uint64(limit)-1 < end {
+		end = start + uint64(limit) - 1
+	}
+
+	blocks := make([]*Block, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		block := bc.blockAt(i)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
 
 	return blocks
 }
@@ -183,63 +977,89 @@ func (bc *Blockchain) Length() int {
 	bc.mu.RLock()
 	defer bc.mu.RUnlock()
 
-	return len(bc.blocks)
+	if bc.tip == nil {
+		return 0
+	}
+
+	return int(bc.tip.Index + 1)
 }
 
-// ReplaceChain replaces the current chain with a longer valid chain
-func (bc *Blockchain) ReplaceChain(newChain []*Block) bool {
-	bc.mu.Lock()
-	defer bc.mu.Unlock()
+// CachedBlocks returns the number of blocks currently held in the block cache
+func (bc *Blockchain) CachedBlocks() int {
+	return bc.cache.len()
+}
 
-	// Check if the new chain is longer than the current chain
-	if len(newChain) <= len(bc.blocks) {
-		return false
+// checkBlocks checks that a sequence of blocks forms a valid chain and
+// that every block after the first carries the seal the chain's engine
+// expects, before any of them is offered to the block tree
+func (bc *Blockchain) checkBlocks(blocks []*Block) error {
+	if len(blocks) == 0 {
+		return errors.New("chain is empty")
 	}
 
-	// Validate the new chain
-	// Create a temporary blockchain with the new chain
-	tempBC := &Blockchain{
-		blocks: newChain,
-		db:     bc.db, // Use the same database connection
-	}
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
 
-	if !tempBC.IsChainValid() {
-		return false
+	view := chainView{bc: bc}
+	for i := 1; i < len(blocks); i++ {
+		if err := blocks[i].Check(blocks[i-1]); err != nil {
+			return fmt.Errorf("block %d: %w", blocks[i].Index, err)
+		}
+		if err := bc.engine.VerifySeal(view, blocks[i]); err != nil {
+			return fmt.Errorf("block %d: %w: %w", blocks[i].Index, ErrInvalidBlock, err)
+		}
 	}
 
-	// Replace the current chain with the new chain
-	bc.blocks = newChain
+	return nil
+}
 
-	// Persist the new chain
-	bc.db.Update(func(tx *bbolt.Tx) error {
-		// Clear the blocks bucket
-		tx.DeleteBucket([]byte("blocks"))
-		bucket, err := tx.CreateBucket([]byte("blocks"))
-		if err != nil {
-			return err
-		}
+// ReplaceChain offers a complete chain, starting at the genesis block, to the
+// block tree. It returns true if the chain's branch ends up as the main chain,
+// which only happens when it carries more cumulative work than the current one.
+// OfferChain returns why a chain was not.
+func (bc *Blockchain) ReplaceChain(newChain []*Block) bool {
+	return bc.OfferChain(newChain) == nil
+}
 
-		// Add all blocks to the bucket
-		for _, block := range newChain {
-			data, err := block.Serialize()
-			if err != nil {
-				continue
-			}
+// ErrLighterChain is returned by OfferChain for a valid chain that does not
+// carry more work than the main chain
+var ErrLighterChain = errors.New("chain does not carry more work than the main chain")
 
-			key := fmt.Sprintf("%020d", block.Index)
-			err = bucket.Put([]byte(key), data)
-			if err != nil {
-				return err
+// OfferChain implements ReplaceChain, returning why a chain was not made
+// the main chain. Every block goes through the same checks as ProcessBlock.
+func (bc *Blockchain) OfferChain(newChain []*Block) error {
+	// Validate the new chain, which must start at our genesis block
+	if err := bc.checkBlocks(newChain); err != nil {
+		return err
+	}
+	if newChain[0].Index != 0 {
+		return fmt.Errorf("%w: chain does not start at the genesis block", ErrInvalidBlock)
+	}
+
+	var err error
+	bc.update(func() {
+		if genesis := bc.tipNode.ancestor(0); genesis.hash != newChain[0].Hash {
+			err = fmt.Errorf("%w: unknown genesis block", ErrInvalidBlock)
+			return
+		}
+
+		for _, block := range newChain[1:] {
+			if _, err = bc.processBlock(block); err != nil && err != ErrDuplicateBlock {
+				err = fmt.Errorf("block %d: %w", block.Index, err)
+				return
 			}
 		}
 
-		return nil
+		err = nil
+		if bc.tip.Hash != newChain[len(newChain)-1].Hash {
+			err = ErrLighterChain
+		}
 	})
 
-	return true
+	return err
 }
 
-// Close closes the database connection
+// Close closes the underlying store
 func (bc *Blockchain) Close() error {
-	return bc.db.Close()
+	return bc.store.Close()
 }
@@ -0,0 +1,258 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/storage"
+)
+
+var (
+	// headersBucket maps the hash of every known block to its header record
+	headersBucket = []byte("headers")
+
+	// sideBlocksBucket maps a block hash to the full block for blocks off the main chain
+	sideBlocksBucket = []byte("sideblocks")
+)
+
+// MaxOrphanBlocks is the number of blocks with unknown parents kept in memory
+const MaxOrphanBlocks = 100
+
+// blockNode is an entry of the in-memory block tree. It holds only header
+// fields so the whole tree stays small even for long chains.
+type blockNode struct {
+	hash         string
+	previousHash string
+	parent       *blockNode
+	height       uint64
+	timestamp    time.Time
+	bits         uint32
+	work         *big.Int // cumulative work from genesis up to and including this block
+	invalid      bool
+}
+
+// headerRecord is the persisted form of a blockNode
+type headerRecord struct {
+	PreviousHash string    `json:"previousHash"`
+	Index        uint64    `json:"index"`
+	Timestamp    time.Time `json:"timestamp"`
+	Bits         uint32    `json:"bits"`
+	Work         string    `json:"work"`
+}
+
+// newBlockNode creates a tree node for block on top of parent (nil for genesis)
+func newBlockNode(block *Block, parent *blockNode) *blockNode {
+	node := &blockNode{
+		hash:         block.Hash,
+		previousHash: block.PreviousHash,
+		parent:       parent,
+		height:       block.Index,
+		timestamp:    block.Timestamp,
+		bits:         block.Bits,
+		work:         Work(block.Bits),
+	}
+
+	if parent != nil {
+		node.work.Add(node.work, parent.work)
+	}
+
+	return node
+}
+
+// ancestor returns the node's ancestor at a height
+func (n *blockNode) ancestor(height uint64) *blockNode {
+	if height > n.height {
+		return nil
+	}
+
+	node := n
+	for node != nil && node.height > height {
+		node = node.parent
+	}
+	return node
+}
+
+// findFork returns the most recent common ancestor of two nodes
+func findFork(a, b *blockNode) *blockNode {
+	if a.height > b.height {
+		a = a.ancestor(b.height)
+	} else {
+		b = b.ancestor(a.height)
+	}
+
+	for a != nil && b != nil && a != b {
+		a = a.parent
+		b = b.parent
+	}
+
+	return a
+}
+
+// putHeader persists the header record of a node
+func putHeader(tx storage.Tx, node *blockNode) error {
+	bucket := tx.Bucket(headersBucket)
+	if bucket == nil {
+		return fmt.Errorf("headers bucket not found")
+	}
+
+	data, err := json.Marshal(headerRecord{
+		PreviousHash: node.previousHash,
+		Index:        node.height,
+		Timestamp:    node.timestamp,
+		Bits:         node.bits,
+		Work:         node.work.Text(16),
+	})
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(node.hash), data)
+}
+
+// loadHeaders reads every header record and links the nodes into a tree
+func loadHeaders(tx storage.Tx) (map[string]*blockNode, error) {
+	nodes := make(map[string]*blockNode)
+
+	bucket := tx.Bucket(headersBucket)
+	if bucket == nil {
+		return nodes, nil
+	}
+
+	err := bucket.ForEach(func(k, v []byte) error {
+		var record headerRecord
+		if err := json.Unmarshal(v, &record); err != nil {
+			return fmt.Errorf("decode header %s: %w", k, err)
+		}
+
+		work, ok := new(big.Int).SetString(record.Work, 16)
+		if !ok {
+			return fmt.Errorf("decode header %s: invalid work", k)
+		}
+
+		nodes[string(k)] = &blockNode{
+			hash:         string(k),
+			previousHash: record.PreviousHash,
+			height:       record.Index,
+			timestamp:    record.Timestamp,
+			bits:         record.Bits,
+			work:         work,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes {
+		node.parent = nodes[node.previousHash]
+	}
+
+	return nodes, nil
+}
+
+// rebuildHeaders creates header records for the main chain of a database
+// written before the block tree existed
+func rebuildHeaders(tx storage.Tx) error {
+	if _, err := tx.CreateBucketIfNotExists(headersBucket); err != nil {
+		return err
+	}
+
+	blocks := tx.Bucket(blocksBucket)
+	if blocks == nil {
+		return nil
+	}
+
+	var parent *blockNode
+	return blocks.ForEach(func(k, v []byte) error {
+		block, err := Deserialize(v)
+		if err != nil {
+			return fmt.Errorf("decode block %s: %w", k, err)
+		}
+
+		node := newBlockNode(block, parent)
+		parent = node
+		return putHeader(tx, node)
+	})
+}
+
+// orphanPool holds blocks whose parent has not arrived yet
+type orphanPool struct {
+	blocks   map[string]*Block
+	byParent map[string][]string
+	order    []string
+}
+
+// newOrphanPool creates an empty orphan pool
+func newOrphanPool() *orphanPool {
+	return &orphanPool{
+		blocks:   make(map[string]*Block),
+		byParent: make(map[string][]string),
+	}
+}
+
+// has reports whether a block is in the pool
+func (p *orphanPool) has(hash string) bool {
+	_, ok := p.blocks[hash]
+	return ok
+}
+
+// add stores an orphan, evicting the oldest one when the pool is full
+func (p *orphanPool) add(block *Block) {
+	if p.has(block.Hash) {
+		return
+	}
+
+	for len(p.order) >= MaxOrphanBlocks {
+		p.remove(p.order[0])
+	}
+
+	p.blocks[block.Hash] = block
+	p.byParent[block.PreviousHash] = append(p.byParent[block.PreviousHash], block.Hash)
+	p.order = append(p.order, block.Hash)
+}
+
+// remove drops an orphan from the pool
+func (p *orphanPool) remove(hash string) {
+	block, ok := p.blocks[hash]
+	if !ok {
+		return
+	}
+	delete(p.blocks, hash)
+
+	siblings := p.byParent[block.PreviousHash]
+	for i, h := range siblings {
+		if h == hash {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(p.byParent, block.PreviousHash)
+	} else {
+		p.byParent[block.PreviousHash] = siblings
+	}
+
+	for i, h := range p.order {
+		if h == hash {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// takeChildren removes and returns the orphans whose parent is hash
+func (p *orphanPool) takeChildren(hash string) []*Block {
+	var children []*Block
+	for _, h := range append([]string{}, p.byParent[hash]...) {
+		children = append(children, p.blocks[h])
+		p.remove(h)
+	}
+	return children
+}
+
+// len returns the number of orphans in the pool
+func (p *orphanPool) len() int {
+	return len(p.blocks)
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// newBlockBody turns the data passed to NewBlock into a transaction list
+// that starts with a coinbase
+func newBlockBody(height uint64, data interface{}) []Transaction {
+	var txs []Transaction
+	var payload []byte
+
+	switch d := data.(type) {
+	case nil:
+	case []Transaction:
+		txs = append(txs, d...)
+	case []*Transaction:
+		for _, tx := range d {
+			txs = append(txs, *tx)
+		}
+	case *Transaction:
+		txs = append(txs, *d)
+	case string:
+		payload = []byte(d)
+	case []byte:
+		payload = d
+	default:
+		payload, _ = json.Marshal(d)
+	}
+
+	if len(txs) == 0 || !txs[0].IsCoinbase() {
+		txs = append([]Transaction{*newPayloadCoinbase(height, payload)}, txs...)
+	}
+
+	return txs
+}
+
+// newPayloadCoinbase creates a coinbase that mints nothing and carries an
+// optional payload in a data output. The block height goes into the input so
+// coinbases of different blocks never share an ID.
+func newPayloadCoinbase(height uint64, payload []byte) *Transaction {
+	tx := Transaction{
+		Inputs: []TXInput{{
+			TXID:      "",
+			Vout:      -1,
+			ScriptSig: binary.BigEndian.AppendUint64(nil, height),
+		}},
+		Time: time.Now(),
+	}
+
+	if len(payload) > 0 {
+		tx.Outputs = append(tx.Outputs, NewDataOutput(payload))
+	}
+
+	tx.SetID()
+
+	return &tx
+}
+
+// Payload returns the data of the first data output in the block, or nil if
+// the block carries no data
+func (b *Block) Payload() []byte {
+	for _, tx := range b.Transactions {
+		for _, out := range tx.Outputs {
+			if out.IsData() {
+				return out.Data()
+			}
+		}
+	}
+	return nil
+}
+
+// CheckBody checks that the block holds exactly one coinbase as its first
+// transaction, that every transaction ID is unique, matches its contents and
+// passes CheckTransaction and that the header's merkle root commits to the
+// transactions
+func (b *Block) CheckBody() error {
+	if len(b.Transactions) == 0 {
+		return fmt.Errorf("%w: block has no transactions", ErrInvalidBlock)
+	}
+
+	// merkleRoot pairs the last leaf of an odd level with itself, so
+	// repeating trailing transactions keeps the root. Rejecting repeated IDs
+	// stops such a copy from sharing the hash of the honest block.
+	seen := make(map[string]bool, len(b.Transactions))
+	for i := range b.Transactions {
+		tx := &b.Transactions[i]
+
+		if tx.IsCoinbase() != (i == 0) {
+			return fmt.Errorf("%w: coinbase must be the first and only coinbase transaction", ErrInvalidBlock)
+		}
+		if tx.ID != hex.EncodeToString(tx.Hash()) {
+			return fmt.Errorf("%w: transaction %d ID does not match its contents", ErrInvalidBlock, i)
+		}
+		if seen[tx.ID] {
+			return fmt.Errorf("%w: transaction %s appears more than once", ErrInvalidBlock, tx.ID)
+		}
+		seen[tx.ID] = true
+		if err := CheckTransaction(tx); err != nil {
+			return fmt.Errorf("%w: transaction %d: %w", ErrInvalidBlock, i, err)
+		}
+	}
+
+	if b.ComputeMerkleRoot() != b.MerkleRoot {
+		return fmt.Errorf("%w: merkle root does not match transactions", ErrInvalidBlock)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultCacheSize is the number of recently used blocks kept in memory
+const DefaultCacheSize = 1024
+
+// blockCache is a fixed-size LRU cache of blocks keyed by height
+type blockCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[uint64]*list.Element
+}
+
+// newBlockCache creates a cache holding at most size blocks
+func newBlockCache(size int) *blockCache {
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+
+	return &blockCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[uint64]*list.Element),
+	}
+}
+
+// get returns the cached block at a height and marks it as recently used
+func (c *blockCache) get(index uint64) (*Block, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[index]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	return elem.Value.(*Block), true
+}
+
+// add inserts a block, evicting the least recently used one if the cache is full
+func (c *blockCache) add(block *Block) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[block.Index]; ok {
+		elem.Value = block
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[block.Index] = c.order.PushFront(block)
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*Block).Index)
+	}
+}
+
+// purgeFrom drops every cached block at or above a height
+func (c *blockCache) purgeFrom(index uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for height, elem := range c.entries {
+		if height >= index {
+			c.order.Remove(elem)
+			delete(c.entries, height)
+		}
+	}
+}
+
+// len returns the number of cached blocks
+func (c *blockCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
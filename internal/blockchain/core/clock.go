@@ -0,0 +1,136 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the chain the current time, which sets the timestamps of the
+// blocks it builds. Tests inject a ManualClock to make block times
+// deterministic.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock
+type SystemClock struct{}
+
+// Now returns time.Now()
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ManualClock is a Clock that only moves when it is set or advanced
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManualClock creates a clock stopped at now
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now returns the time the clock was last set to
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Set moves the clock to now
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = now
+}
+
+// Advance moves the clock forward by d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// Network-adjusted time defaults
+const (
+	// DefaultMaxTimeOffset is the largest offset from peer clocks a
+	// NetworkClock applies; a larger median offset suggests our own clock
+	// is wrong and is ignored
+	DefaultMaxTimeOffset = time.Minute
+
+	// minTimeSamples is the number of peer samples needed before a
+	// NetworkClock adjusts the time
+	minTimeSamples = 5
+
+	// maxTimeSamples bounds the number of peers a NetworkClock remembers
+	maxTimeSamples = 200
+)
+
+// NetworkClock is a Clock adjusted by the median offset between a base clock
+// and the clocks of peers, as reported in their handshakes. Each peer
+// contributes one sample, so a single peer cannot move the time by
+// reconnecting, and the offset is capped so that a majority of lying peers
+// can only move it by maxOffset.
+type NetworkClock struct {
+	base      Clock
+	maxOffset time.Duration
+
+	mu      sync.Mutex
+	samples map[string]time.Duration
+	offset  time.Duration
+}
+
+// NewNetworkClock creates a network clock on top of base that applies
+// offsets of at most maxOffset
+func NewNetworkClock(base Clock, maxOffset time.Duration) *NetworkClock {
+	return &NetworkClock{
+		base:      base,
+		maxOffset: maxOffset,
+		samples:   make(map[string]time.Duration),
+	}
+}
+
+// Now returns the base clock's time moved by the median peer offset
+func (c *NetworkClock) Now() time.Time {
+	return c.base.Now().Add(c.Offset())
+}
+
+// Offset returns the offset Now applies to the base clock
+func (c *NetworkClock) Offset() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.offset
+}
+
+// AddSample records the time a peer reported and updates the offset. Only
+// the first sample of each peer counts.
+func (c *NetworkClock) AddSample(peer string, peerTime time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.samples[peer]; ok || len(c.samples) >= maxTimeSamples {
+		return
+	}
+	c.samples[peer] = peerTime.Sub(c.base.Now())
+
+	if len(c.samples) < minTimeSamples {
+		return
+	}
+
+	offsets := make([]time.Duration, 0, len(c.samples))
+	for _, offset := range c.samples {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	c.offset = offsets[len(offsets)/2]
+	if c.offset > c.maxOffset || c.offset < -c.maxOffset {
+		c.offset = 0
+	}
+}
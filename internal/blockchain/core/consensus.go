@@ -0,0 +1,224 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/storage"
+)
+
+// DefaultConsensus is the engine selected when Options.Consensus is empty:
+// the proof of work engine registered by the consensus package
+const DefaultConsensus = "pow"
+
+// ErrUnknownEngine is returned when Options names a consensus engine that is not registered
+var ErrUnknownEngine = errors.New("unknown consensus engine")
+
+// ConsensusEngine decides how blocks are sealed and which blocks the chain
+// accepts. The chain verifies the header and seal of every block before
+// storing it and finalizes every block it connects. Engine errors are
+// reported wrapped in ErrInvalidBlock.
+type ConsensusEngine interface {
+	// Prepare fills in the consensus fields of a new block, such as its
+	// target bits, for the position after its PreviousHash
+	Prepare(chain ChainReader, block *Block) error
+
+	// Seal completes a prepared block so that VerifySeal accepts it,
+	// updating its hash. It runs without the chain lock and gives up with
+	// the cause of ctx's cancellation.
+	Seal(ctx context.Context, chain ChainReader, block *Block) error
+
+	// VerifyHeader checks the consensus fields of a block's header against its parent
+	VerifyHeader(chain ChainReader, block *Block, parent *ChainHeader) error
+
+	// VerifySeal checks that a block is sealed. It runs before the parent
+	// is known, so orphans are checked too.
+	VerifySeal(chain ChainReader, block *Block) error
+
+	// Finalize checks the rewards paid by a block's coinbase once the fees
+	// of its other transactions are known
+	Finalize(chain ChainReader, block *Block, fees int) error
+}
+
+// ChainReader is the view of the chain a consensus engine works with
+type ChainReader interface {
+	// Params returns the consensus rules of the chain
+	Params() Params
+
+	// Now returns the current time of the chain's clock
+	Now() time.Time
+
+	// Header returns a known block on any branch, or nil
+	Header(hash string) *ChainHeader
+
+	// Block returns the full block for a known header, or nil
+	Block(hash string) *Block
+}
+
+// ChainHeader describes a block of the block tree
+type ChainHeader struct {
+	Hash         string
+	PreviousHash string
+	Height       uint64
+	Timestamp    time.Time
+	Bits         uint32
+}
+
+// EngineFactory creates a consensus engine from its JSON configuration,
+// which is empty when none was given
+type EngineFactory func(config json.RawMessage) (ConsensusEngine, error)
+
+var (
+	enginesMu sync.RWMutex
+	engines   = make(map[string]EngineFactory)
+)
+
+// RegisterEngine makes a consensus engine selectable by name through
+// Options.Consensus. It panics if the name is already registered.
+func RegisterEngine(name string, factory EngineFactory) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+
+	if _, ok := engines[name]; ok {
+		panic("consensus engine registered twice: " + name)
+	}
+	engines[name] = factory
+}
+
+// Engines returns the names of the registered consensus engines
+func Engines() []string {
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newEngine returns the engine configured by opts
+func newEngine(opts Options) (ConsensusEngine, error) {
+	if opts.Engine != nil {
+		return opts.Engine, nil
+	}
+
+	name := opts.Consensus
+	if name == "" {
+		name = DefaultConsensus
+	}
+
+	enginesMu.RLock()
+	factory, ok := engines[name]
+	enginesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEngine, name)
+	}
+
+	return factory(opts.ConsensusConfig)
+}
+
+// chainView is the ChainReader handed to the engine while bc.mu is held.
+// Inside a store transaction tx is set so blocks are read through it.
+type chainView struct {
+	bc *Blockchain
+	tx storage.Tx
+}
+
+// Params returns the consensus rules of the chain
+func (v chainView) Params() Params {
+	return v.bc.params
+}
+
+// Now returns the current time of the chain's clock
+func (v chainView) Now() time.Time {
+	return v.bc.clock.Now()
+}
+
+// Header returns a known block on any branch, or nil
+func (v chainView) Header(hash string) *ChainHeader {
+	return v.bc.header(hash)
+}
+
+// Block returns the full block for a known header, or nil
+func (v chainView) Block(hash string) *Block {
+	if v.tx == nil {
+		return v.bc.block(hash)
+	}
+
+	node, ok := v.bc.nodes[hash]
+	if !ok {
+		return nil
+	}
+	block, err := v.bc.storedBlock(v.tx, node)
+	if err != nil {
+		return nil
+	}
+	return block
+}
+
+// Engine returns the consensus engine the blockchain seals and verifies blocks with
+func (bc *Blockchain) Engine() ConsensusEngine {
+	return bc.engine
+}
+
+// Header returns a known block on any branch, or nil
+func (bc *Blockchain) Header(hash string) *ChainHeader {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.header(hash)
+}
+
+// header implements Header. The caller must hold bc.mu.
+func (bc *Blockchain) header(hash string) *ChainHeader {
+	node, ok := bc.nodes[hash]
+	if !ok {
+		return nil
+	}
+	return node.header()
+}
+
+// Block returns a known block on any branch, or nil
+func (bc *Blockchain) Block(hash string) *Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.block(hash)
+}
+
+// block implements Block. The caller must hold bc.mu.
+func (bc *Blockchain) block(hash string) *Block {
+	node, ok := bc.nodes[hash]
+	if !ok {
+		return nil
+	}
+	if bc.isMainChain(node) {
+		return bc.blockAt(node.height)
+	}
+
+	var block *Block
+	bc.store.View(func(tx storage.Tx) error {
+		var err error
+		block, err = bc.storedBlock(tx, node)
+		return err
+	})
+	return block
+}
+
+// header returns the ChainHeader describing the node
+func (n *blockNode) header() *ChainHeader {
+	return &ChainHeader{
+		Hash:         n.hash,
+		PreviousHash: n.previousHash,
+		Height:       n.height,
+		Timestamp:    n.timestamp,
+		Bits:         n.bits,
+	}
+}
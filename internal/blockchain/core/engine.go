@@ -0,0 +1,442 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Script engine limits
+const (
+	// MaxScriptSize is the largest script the engine runs
+	MaxScriptSize = 10000
+
+	// MaxScriptElementSize is the largest element that can be pushed onto the stack
+	MaxScriptElementSize = 520
+
+	// MaxStackSize is the largest number of elements the stack may hold
+	MaxStackSize = 1000
+
+	// MaxOpsPerScript is the largest number of non-push opcodes in a script
+	MaxOpsPerScript = 201
+)
+
+// ErrScriptFailed is returned (wrapped with the reason) when an unlocking
+// script does not satisfy the locking script of the output it spends
+var ErrScriptFailed = errors.New("script failed")
+
+// engine evaluates the scripts of one transaction input
+type engine struct {
+	tx      *Transaction
+	index   int
+	prevOut TXOutput
+	stack   [][]byte
+}
+
+// VerifyScript runs the unlocking script of input index followed by the
+// locking script of the output it spends. The input is valid if both run
+// without error and leave a true value on top of the stack.
+func (tx *Transaction) VerifyScript(index int, prevOut TXOutput) error {
+	if index < 0 || index >= len(tx.Inputs) {
+		return fmt.Errorf("%w: input %d does not exist", ErrScriptFailed, index)
+	}
+
+	scriptSig := tx.Inputs[index].ScriptSig
+	if !scriptSig.IsPushOnly() {
+		return fmt.Errorf("%w: unlocking script is not push-only", ErrScriptFailed)
+	}
+
+	e := &engine{tx: tx, index: index, prevOut: prevOut}
+	if err := e.execute(scriptSig); err != nil {
+		return err
+	}
+	if err := e.execute(prevOut.Script); err != nil {
+		return err
+	}
+
+	if len(e.stack) == 0 || !asBool(e.stack[len(e.stack)-1]) {
+		return fmt.Errorf("%w: script evaluated to false", ErrScriptFailed)
+	}
+	return nil
+}
+
+// execute runs a script on the engine's stack
+func (e *engine) execute(script Script) error {
+	if len(script) > MaxScriptSize {
+		return fmt.Errorf("%w: script is %d bytes, the maximum is %d", ErrScriptFailed, len(script), MaxScriptSize)
+	}
+
+	instructions, err := script.parse()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrScriptFailed, err)
+	}
+
+	// conditions holds one entry per open OP_IF; an instruction runs only
+	// when every entry is true
+	var conditions []bool
+	executing := func() bool {
+		for _, c := range conditions {
+			if !c {
+				return false
+			}
+		}
+		return true
+	}
+
+	ops := 0
+	for _, in := range instructions {
+		if !in.isPush() {
+			ops++
+			if ops > MaxOpsPerScript {
+				return fmt.Errorf("%w: more than %d opcodes", ErrScriptFailed, MaxOpsPerScript)
+			}
+		}
+
+		// Conditionals are tracked even inside branches that are skipped
+		switch in.op {
+		case OP_IF, OP_NOTIF:
+			branch := false
+			if executing() {
+				value, err := e.pop()
+				if err != nil {
+					return err
+				}
+				branch = asBool(value) == (in.op == OP_IF)
+			}
+			conditions = append(conditions, branch)
+			continue
+		case OP_ELSE:
+			if len(conditions) == 0 {
+				return fmt.Errorf("%w: OP_ELSE without OP_IF", ErrScriptFailed)
+			}
+			conditions[len(conditions)-1] = !conditions[len(conditions)-1]
+			continue
+		case OP_ENDIF:
+			if len(conditions) == 0 {
+				return fmt.Errorf("%w: OP_ENDIF without OP_IF", ErrScriptFailed)
+			}
+			conditions = conditions[:len(conditions)-1]
+			continue
+		}
+
+		if !executing() {
+			continue
+		}
+		if err := e.step(in); err != nil {
+			return err
+		}
+		if len(e.stack) > MaxStackSize {
+			return fmt.Errorf("%w: stack holds more than %d elements", ErrScriptFailed, MaxStackSize)
+		}
+	}
+
+	if len(conditions) != 0 {
+		return fmt.Errorf("%w: unbalanced conditional", ErrScriptFailed)
+	}
+	return nil
+}
+
+// step executes a single instruction
+func (e *engine) step(in instruction) error {
+	switch {
+	case in.op <= OP_PUSHDATA4:
+		if len(in.data) > MaxScriptElementSize {
+			return fmt.Errorf("%w: pushed element is larger than %d bytes", ErrScriptFailed, MaxScriptElementSize)
+		}
+		e.push(in.data)
+		return nil
+	case in.op >= OP_1 && in.op <= OP_16:
+		e.push([]byte{byte(in.op - OP_1 + 1)})
+		return nil
+	}
+
+	switch in.op {
+	case OP_NOP, OP_STAKE:
+
+	case OP_VERIFY:
+		return e.verify("OP_VERIFY")
+
+	case OP_RETURN:
+		return fmt.Errorf("%w: OP_RETURN output is unspendable", ErrScriptFailed)
+
+	case OP_DROP:
+		if _, err := e.pop(); err != nil {
+			return err
+		}
+
+	case OP_DUP:
+		top, err := e.peek()
+		if err != nil {
+			return err
+		}
+		e.push(top)
+
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.push(fromBool(bytes.Equal(a, b)))
+		if in.op == OP_EQUALVERIFY {
+			return e.verify("OP_EQUALVERIFY")
+		}
+
+	case OP_SHA256:
+		value, err := e.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(value)
+		e.push(hash[:])
+
+	case OP_PUBKEYHASH:
+		value, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.push(HashPubKey(value))
+
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		pubKey, err := e.pop()
+		if err != nil {
+			return err
+		}
+		signature, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.push(fromBool(VerifyHash(pubKey, e.tx.SigHash(e.index, e.prevOut), signature)))
+		if in.op == OP_CHECKSIGVERIFY {
+			return e.verify("OP_CHECKSIGVERIFY")
+		}
+
+	case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+		valid, err := e.checkMultiSig()
+		if err != nil {
+			return err
+		}
+		e.push(fromBool(valid))
+		if in.op == OP_CHECKMULTISIGVERIFY {
+			return e.verify("OP_CHECKMULTISIGVERIFY")
+		}
+
+	case OP_CHECKLOCKTIMEVERIFY:
+		return e.checkLockTime()
+
+	case OP_CHECKSEQUENCEVERIFY:
+		return e.checkSequence()
+
+	default:
+		return fmt.Errorf("%w: unknown opcode %s", ErrScriptFailed, in.op)
+	}
+
+	return nil
+}
+
+// checkMultiSig pops <sig 1> ... <sig m> m <key 1> ... <key n> n and reports
+// whether every signature is valid for a distinct key. Signatures must be in
+// the same order as their keys.
+func (e *engine) checkMultiSig() (bool, error) {
+	n, err := e.popInt(0, MaxMultiSigKeys)
+	if err != nil {
+		return false, err
+	}
+	pubKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		if pubKeys[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	m, err := e.popInt(0, n)
+	if err != nil {
+		return false, err
+	}
+	signatures := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		if signatures[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	// Walk the keys once, matching each signature to the next key it verifies with
+	hash := e.tx.SigHash(e.index, e.prevOut)
+	key := 0
+	for _, signature := range signatures {
+		for key < len(pubKeys) && !VerifyHash(pubKeys[key], hash, signature) {
+			key++
+		}
+		if key == len(pubKeys) {
+			return false, nil
+		}
+		key++
+	}
+	return true, nil
+}
+
+// checkLockTime fails unless the transaction's LockTime is of the same kind,
+// height or time, as the number on top of the stack and has reached it. The
+// number is left on the stack. Since LockTime is enforced by consensus, the
+// output cannot be spent before that point.
+func (e *engine) checkLockTime() error {
+	n, err := e.peekLock()
+	if err != nil {
+		return err
+	}
+
+	lockTime := int64(e.tx.LockTime)
+	if (n < LockTimeThreshold) != (lockTime < LockTimeThreshold) {
+		return fmt.Errorf("%w: lock time %d and transaction lock time %d differ in kind", ErrScriptFailed, n, lockTime)
+	}
+	if n > lockTime {
+		return fmt.Errorf("%w: transaction lock time %d is before %d", ErrScriptFailed, lockTime, n)
+	}
+	return nil
+}
+
+// checkSequence fails unless the input's Sequence carries a relative lock of
+// the same kind as the number on top of the stack and at least as long. The
+// number is left on the stack, and a number with SequenceLockDisabled set
+// imposes nothing.
+func (e *engine) checkSequence() error {
+	n, err := e.peekLock()
+	if err != nil {
+		return err
+	}
+
+	lock := uint32(n)
+	if lock&SequenceLockDisabled != 0 {
+		return nil
+	}
+
+	sequence := e.tx.Inputs[e.index].Sequence
+	if sequence&SequenceLockDisabled != 0 {
+		return fmt.Errorf("%w: input has no relative lock", ErrScriptFailed)
+	}
+	if lock&SequenceLockTimeFlag != sequence&SequenceLockTimeFlag {
+		return fmt.Errorf("%w: relative lock %#x and input sequence %#x differ in kind", ErrScriptFailed, lock, sequence)
+	}
+	if lock&SequenceLockMask > sequence&SequenceLockMask {
+		return fmt.Errorf("%w: input sequence %#x is shorter than relative lock %#x", ErrScriptFailed, sequence, lock)
+	}
+	return nil
+}
+
+// peekLock reads the non-negative number of at most 5 bytes on top of the
+// stack that OP_CHECKLOCKTIMEVERIFY and OP_CHECKSEQUENCEVERIFY compare against
+func (e *engine) peekLock() (int64, error) {
+	value, err := e.peek()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := asInt(value, 5)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > math.MaxUint32 {
+		return 0, fmt.Errorf("%w: lock %d out of range", ErrScriptFailed, n)
+	}
+	return n, nil
+}
+
+// popInt pops a number and checks that it lies in [lo, hi]
+func (e *engine) popInt(lo, hi int) (int, error) {
+	value, err := e.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := asInt(value, 4)
+	if err != nil {
+		return 0, err
+	}
+	if n < int64(lo) || n > int64(hi) {
+		return 0, fmt.Errorf("%w: number %d out of range [%d, %d]", ErrScriptFailed, n, lo, hi)
+	}
+	return int(n), nil
+}
+
+// push adds an element to the top of the stack
+func (e *engine) push(value []byte) {
+	e.stack = append(e.stack, value)
+}
+
+// pop removes and returns the top element of the stack
+func (e *engine) pop() ([]byte, error) {
+	value, err := e.peek()
+	if err != nil {
+		return nil, err
+	}
+	e.stack = e.stack[:len(e.stack)-1]
+	return value, nil
+}
+
+// peek returns the top element of the stack
+func (e *engine) peek() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, fmt.Errorf("%w: stack is empty", ErrScriptFailed)
+	}
+	return e.stack[len(e.stack)-1], nil
+}
+
+// verify pops the top element and fails unless it is true
+func (e *engine) verify(op string) error {
+	value, err := e.pop()
+	if err != nil {
+		return err
+	}
+	if !asBool(value) {
+		return fmt.Errorf("%w: %s failed", ErrScriptFailed, op)
+	}
+	return nil
+}
+
+// asBool interprets a stack element as a boolean: it is false if every byte
+// is zero, allowing a negative zero sign bit in the last byte
+func asBool(value []byte) bool {
+	for i, b := range value {
+		if b != 0 {
+			return !(i == len(value)-1 && b == 0x80)
+		}
+	}
+	return false
+}
+
+// asInt decodes a stack element holding a little-endian sign-magnitude
+// number of at most maxLen bytes
+func asInt(value []byte, maxLen int) (int64, error) {
+	if len(value) > maxLen {
+		return 0, fmt.Errorf("%w: number is longer than %d bytes", ErrScriptFailed, maxLen)
+	}
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	var n int64
+	for i, b := range value {
+		n |= int64(b) << (8 * i)
+	}
+
+	// The high bit of the last byte is the sign
+	last := len(value) - 1
+	if value[last]&0x80 != 0 {
+		n &^= int64(0x80) << (8 * last)
+		return -n, nil
+	}
+	return n, nil
+}
+
+// fromBool encodes a boolean as a stack element
+func fromBool(value bool) []byte {
+	if value {
+		return []byte{1}
+	}
+	return nil
+}
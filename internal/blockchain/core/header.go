@@ -0,0 +1,153 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// BlockVersion is the header version written by this implementation
+const BlockVersion uint32 = 1
+
+// HeaderSize is the length of an encoded block header in bytes
+const HeaderSize = 4 + 32 + 32 + 8 + 4 + 8
+
+// ErrInvalidHeader is returned when a header cannot be encoded or decoded
+var ErrInvalidHeader = errors.New("invalid block header")
+
+// BlockHeader is the fixed-layout part of a block. Its SHA-256 hash is both
+// the block ID and the value checked by proof of work. All integers are
+// encoded big-endian in the order below:
+//
+//	version       uint32   4 bytes
+//	previous hash          32 bytes (all zero for the genesis block)
+//	merkle root            32 bytes
+//	timestamp     int64    8 bytes, Unix time in nanoseconds
+//	bits          uint32   4 bytes
+//	nonce         uint64   8 bytes
+type BlockHeader struct {
+	Version      uint32
+	PreviousHash [32]byte
+	MerkleRoot   [32]byte
+	Timestamp    int64
+	Bits         uint32
+	Nonce        uint64
+}
+
+// Serialize encodes the header in its canonical binary form
+func (h *BlockHeader) Serialize() []byte {
+	buf := make([]byte, 0, HeaderSize)
+	buf = binary.BigEndian.AppendUint32(buf, h.Version)
+	buf = append(buf, h.PreviousHash[:]...)
+	buf = append(buf, h.MerkleRoot[:]...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(h.Timestamp))
+	buf = binary.BigEndian.AppendUint32(buf, h.Bits)
+	return binary.BigEndian.AppendUint64(buf, h.Nonce)
+}
+
+// DeserializeHeader decodes a header produced by Serialize
+func DeserializeHeader(data []byte) (*BlockHeader, error) {
+	if len(data) != HeaderSize {
+		return nil, fmt.Errorf("%w: length %d", ErrInvalidHeader, len(data))
+	}
+
+	h := &BlockHeader{}
+	h.Version = binary.BigEndian.Uint32(data[0:4])
+	copy(h.PreviousHash[:], data[4:36])
+	copy(h.MerkleRoot[:], data[36:68])
+	h.Timestamp = int64(binary.BigEndian.Uint64(data[68:76]))
+	h.Bits = binary.BigEndian.Uint32(data[76:80])
+	h.Nonce = binary.BigEndian.Uint64(data[80:88])
+
+	return h, nil
+}
+
+// Hash returns the SHA-256 hash of the encoded header
+func (h *BlockHeader) Hash() [32]byte {
+	return sha256.Sum256(h.Serialize())
+}
+
+// decodeHash converts a hex block or merkle hash into its 32 raw bytes.
+// The empty string stands for the all-zero hash.
+func decodeHash(s string) ([32]byte, error) {
+	var hash [32]byte
+	if s == "" {
+		return hash, nil
+	}
+
+	raw, err := hex.DecodeString(s)
+	if err != nil || len(raw) != len(hash) {
+		return hash, fmt.Errorf("%w: malformed hash %q", ErrInvalidHeader, s)
+	}
+
+	copy(hash[:], raw)
+	return hash, nil
+}
+
+// Header returns the block's header
+func (b *Block) Header() (*BlockHeader, error) {
+	previousHash, err := decodeHash(b.PreviousHash)
+	if err != nil {
+		return nil, err
+	}
+
+	merkleRoot, err := decodeHash(b.MerkleRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlockHeader{
+		Version:      b.Version,
+		PreviousHash: previousHash,
+		MerkleRoot:   merkleRoot,
+		Timestamp:    b.Timestamp.UnixNano(),
+		Bits:         b.Bits,
+		Nonce:        b.Nonce,
+	}, nil
+}
+
+// ComputeMerkleRoot returns the merkle root of the hashes of the block's
+// transactions. The hashes are recomputed from the transaction contents, so
+// any change to the body changes the root.
+func (b *Block) ComputeMerkleRoot() string {
+	leaves := make([][32]byte, 0, len(b.Transactions))
+	for i := range b.Transactions {
+		var leaf [32]byte
+		copy(leaf[:], b.Transactions[i].Hash())
+		leaves = append(leaves, leaf)
+	}
+
+	root := merkleRoot(leaves)
+	return hex.EncodeToString(root[:])
+}
+
+// merkleRoot folds leaves pairwise into a single root, pairing the last
+// leaf of an odd level with itself. A list ending in a repeated leaf thus has
+// the same root as the list without it, which is why CheckBody rejects
+// repeated transactions.
+func merkleRoot(leaves [][32]byte) [32]byte {
+	if len(leaves) == 0 {
+		return [32]byte{}
+	}
+
+	level := leaves
+	for len(level) > 1 {
+		next := make([][32]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			right := level[i]
+			if i+1 < len(level) {
+				right = level[i+1]
+			}
+
+			var pair [64]byte
+			copy(pair[:32], level[i][:])
+			copy(pair[32:], right[:])
+			next = append(next, sha256.Sum256(pair[:]))
+		}
+		level = next
+	}
+
+	return level[0]
+}
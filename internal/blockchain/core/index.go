@@ -0,0 +1,136 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/storage"
+)
+
+var (
+	// hashIndexBucket maps a block hash to the height of the block
+	hashIndexBucket = []byte("blockhashes")
+
+	// txIndexBucket maps a transaction ID to its block height and position
+	txIndexBucket = []byte("txindex")
+)
+
+// TxLocation is the position of a transaction in the main chain
+type TxLocation struct {
+	Height   uint64 `json:"height"`
+	Position uint32 `json:"position"`
+}
+
+// encodeHeight encodes a block height as an 8-byte big-endian value
+func encodeHeight(height uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, height)
+	return buf
+}
+
+// decodeHeight is the inverse of encodeHeight
+func decodeHeight(data []byte) (uint64, error) {
+	if len(data) != 8 {
+		return 0, fmt.Errorf("invalid height length %d", len(data))
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// encodeTxLocation encodes a transaction location as height followed by position
+func encodeTxLocation(loc TxLocation) []byte {
+	buf := make([]byte, 12)
+	binary.BigEndian.PutUint64(buf[:8], loc.Height)
+	binary.BigEndian.PutUint32(buf[8:], loc.Position)
+	return buf
+}
+
+// decodeTxLocation is the inverse of encodeTxLocation
+func decodeTxLocation(data []byte) (TxLocation, error) {
+	if len(data) != 12 {
+		return TxLocation{}, fmt.Errorf("invalid tx location length %d", len(data))
+	}
+
+	return TxLocation{
+		Height:   binary.BigEndian.Uint64(data[:8]),
+		Position: binary.BigEndian.Uint32(data[8:]),
+	}, nil
+}
+
+// createIndexBuckets creates the index buckets if they do not exist
+func createIndexBuckets(tx storage.Tx) error {
+	for _, name := range [][]byte{hashIndexBucket, txIndexBucket} {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexBlock records a main chain block in the hash and transaction indexes
+func indexBlock(tx storage.Tx, block *Block) error {
+	hashes := tx.Bucket(hashIndexBucket)
+	txids := tx.Bucket(txIndexBucket)
+	if hashes == nil || txids == nil {
+		return fmt.Errorf("index buckets not found")
+	}
+
+	if err := hashes.Put([]byte(block.Hash), encodeHeight(block.Index)); err != nil {
+		return err
+	}
+
+	for i, t := range block.Transactions {
+		loc := TxLocation{Height: block.Index, Position: uint32(i)}
+		if err := txids.Put([]byte(t.ID), encodeTxLocation(loc)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexBlock removes a block that is leaving the main chain from the indexes
+func unindexBlock(tx storage.Tx, block *Block) error {
+	hashes := tx.Bucket(hashIndexBucket)
+	txids := tx.Bucket(txIndexBucket)
+	if hashes == nil || txids == nil {
+		return fmt.Errorf("index buckets not found")
+	}
+
+	if err := hashes.Delete([]byte(block.Hash)); err != nil {
+		return err
+	}
+
+	for _, t := range block.Transactions {
+		if err := txids.Delete([]byte(t.ID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rebuildIndexes recreates the hash and transaction indexes from the blocks
+// bucket. It is used when opening a database written before the indexes existed.
+func rebuildIndexes(tx storage.Tx) error {
+	for _, name := range [][]byte{hashIndexBucket, txIndexBucket} {
+		if err := tx.DeleteBucket(name); err != nil && err != storage.ErrBucketNotFound {
+			return err
+		}
+	}
+	if err := createIndexBuckets(tx); err != nil {
+		return err
+	}
+
+	blocks := tx.Bucket(blocksBucket)
+	if blocks == nil {
+		return nil
+	}
+
+	return blocks.ForEach(func(k, v []byte) error {
+		block, err := Deserialize(v)
+		if err != nil {
+			return fmt.Errorf("decode block %s: %w", k, err)
+		}
+		return indexBlock(tx, block)
+	})
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"math/big"
+)
+
+// PubKeyLen is the length of an encoded public key: the X and Y
+// coordinates, each left-padded to 32 bytes
+const PubKeyLen = 64
+
+// SignatureLen is the length of an encoded signature: r and s, each
+// left-padded to 32 bytes
+const SignatureLen = 64
+
+// EncodePubKey encodes a P-256 public key in the fixed-size form stored in inputs
+func EncodePubKey(pub *ecdsa.PublicKey) []byte {
+	buf := make([]byte, PubKeyLen)
+	pub.X.FillBytes(buf[:32])
+	pub.Y.FillBytes(buf[32:])
+	return buf
+}
+
+// decodePubKey is the inverse of EncodePubKey
+func decodePubKey(data []byte) (*ecdsa.PublicKey, error) {
+	if len(data) != PubKeyLen {
+		return nil, errors.New("invalid public key length")
+	}
+
+	pub := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(data[:32]),
+		Y:     new(big.Int).SetBytes(data[32:]),
+	}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("public key is not on the curve")
+	}
+
+	return pub, nil
+}
+
+// SignHash signs a hash and encodes the signature in its fixed-size form
+func SignHash(privKey *ecdsa.PrivateKey, hash []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, privKey, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	signature := make([]byte, SignatureLen)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signature, nil
+}
+
+// VerifyHash checks a fixed-size signature of a hash against an encoded public key
+func VerifyHash(pubKey, hash, signature []byte) bool {
+	if len(signature) != SignatureLen {
+		return false
+	}
+
+	pub, err := decodePubKey(pubKey)
+	if err != nil {
+		return false
+	}
+
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	return ecdsa.Verify(pub, hash, r, s)
+}
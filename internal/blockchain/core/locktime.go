@@ -0,0 +1,96 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LockTimeThreshold separates the two meanings of Transaction.LockTime:
+// values below it are block heights, values at or above it Unix timestamps
+const LockTimeThreshold = 500_000_000
+
+// Relative lock encoding of TXInput.Sequence. The low 16 bits hold the lock
+// and SequenceLockTimeFlag selects whether it counts blocks or units of
+// 512 seconds since the spent output was confirmed. A zero sequence imposes
+// no lock.
+//
+// Time locks are measured in median time past rather than block timestamps,
+// which a miner may set hours ahead to mine a locked transaction early: a
+// transaction in a block is checked against the median time past of the
+// block's parent, and an output counts as confirmed at the median time past
+// of the parent of the block that created it.
+const (
+	// SequenceLockDisabled turns off the relative lock of an input
+	SequenceLockDisabled uint32 = 1 << 31
+
+	// SequenceLockTimeFlag makes the lock count time instead of blocks
+	SequenceLockTimeFlag uint32 = 1 << 22
+
+	// SequenceLockMask extracts the lock value
+	SequenceLockMask uint32 = 0x0000ffff
+
+	// SequenceLockGranularity is the log2 of the seconds per time-based lock unit
+	SequenceLockGranularity = 9
+)
+
+// ErrTimeLocked is returned when a transaction is included or spends an
+// output before its absolute or relative time lock expires
+var ErrTimeLocked = errors.New("transaction is time locked")
+
+// RelativeLockBlocks returns the sequence that locks an input until the
+// spent output has the given number of confirmations
+func RelativeLockBlocks(blocks uint16) uint32 {
+	return uint32(blocks)
+}
+
+// RelativeLockDuration returns the sequence that locks an input until d has
+// passed since the spent output was confirmed. d is rounded up to a multiple
+// of 512 seconds.
+func RelativeLockDuration(d time.Duration) uint32 {
+	units := (int64(d/time.Second) + 1<<SequenceLockGranularity - 1) >> SequenceLockGranularity
+	if units > int64(SequenceLockMask) {
+		units = int64(SequenceLockMask)
+	}
+	return SequenceLockTimeFlag | uint32(units)
+}
+
+// IsFinal reports whether the transaction's LockTime allows it in a block at
+// height whose parent has the given median time past. LockTime is the first
+// height, or the earliest Unix time, at which the transaction is valid; zero
+// means no lock.
+func (tx *Transaction) IsFinal(height uint64, medianTime time.Time) bool {
+	switch {
+	case tx.LockTime == 0:
+		return true
+	case tx.LockTime < LockTimeThreshold:
+		return height >= uint64(tx.LockTime)
+	default:
+		return medianTime.Unix() >= int64(tx.LockTime)
+	}
+}
+
+// checkSequenceLocks checks the relative lock of every input against the
+// output it spends for a block at height whose parent has the given median
+// time past
+func (tx *Transaction) checkSequenceLocks(spent []UTXO, height uint64, medianTime time.Time) error {
+	for i, in := range tx.Inputs {
+		if in.Sequence&SequenceLockDisabled != 0 {
+			continue
+		}
+
+		lock := in.Sequence & SequenceLockMask
+		if in.Sequence&SequenceLockTimeFlag != 0 {
+			unlocks := spent[i].Time.Add(time.Duration(lock) << SequenceLockGranularity * time.Second)
+			if medianTime.Before(unlocks) {
+				return fmt.Errorf("%w: input %d is locked until %s", ErrTimeLocked, i, unlocks.UTC().Format(time.RFC3339))
+			}
+			continue
+		}
+
+		if height < spent[i].Height+uint64(lock) {
+			return fmt.Errorf("%w: input %d is locked until height %d", ErrTimeLocked, i, spent[i].Height+uint64(lock))
+		}
+	}
+	return nil
+}
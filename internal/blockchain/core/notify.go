@@ -0,0 +1,74 @@
+package core
+
+// ChainListener is notified when blocks join or leave the main chain.
+// Listeners are called after the chain lock is released, so they may query
+// the blockchain, but they must not block for long.
+type ChainListener interface {
+	// BlockConnected is called for every block added to the main chain
+	BlockConnected(block *Block)
+
+	// BlockDisconnected is called for every block removed from the main chain
+	// by a reorganization. The blocks of one reorganization are reported
+	// oldest first, before the blocks of the new branch are connected.
+	BlockDisconnected(block *Block)
+}
+
+// chainEvent is a main chain change waiting to be reported to listeners
+type chainEvent struct {
+	block     *Block
+	connected bool
+}
+
+// Subscribe registers a listener for main chain changes
+func (bc *Blockchain) Subscribe(listener ChainListener) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.listeners = append(bc.listeners, listener)
+}
+
+// Unsubscribe removes a listener registered with Subscribe
+func (bc *Blockchain) Unsubscribe(listener ChainListener) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	for i, l := range bc.listeners {
+		if l == listener {
+			bc.listeners = append(bc.listeners[:i:i], bc.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// update runs fn with the write lock held and then reports the main chain
+// changes fn made to the listeners once the lock is released
+func (bc *Blockchain) update(fn func()) {
+	bc.mu.Lock()
+	fn()
+	events := bc.events
+	bc.events = nil
+	listeners := bc.listeners
+	bc.mu.Unlock()
+
+	for _, event := range events {
+		for _, listener := range listeners {
+			if event.connected {
+				listener.BlockConnected(event.block)
+			} else {
+				listener.BlockDisconnected(event.block)
+			}
+		}
+	}
+}
+
+// recordReorg queues the events of a successful chain switch: the
+// disconnected blocks, given tip first, are reported oldest first and are
+// followed by the connected blocks in chain order
+func (bc *Blockchain) recordReorg(disconnected, connected []*Block) {
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		bc.events = append(bc.events, chainEvent{block: disconnected[i]})
+	}
+	for _, block := range connected {
+		bc.events = append(bc.events, chainEvent{block: block, connected: true})
+	}
+}
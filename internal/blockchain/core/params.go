@@ -0,0 +1,108 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Params holds the consensus rules that differ between networks
+type Params struct {
+	// InitialSubsidy is the number of coins a coinbase may mint at height 0
+	InitialSubsidy int `json:"initialSubsidy"`
+
+	// HalvingInterval is the number of blocks after which the subsidy
+	// halves. Zero keeps the subsidy constant.
+	HalvingInterval uint64 `json:"halvingInterval"`
+
+	// CoinbaseMaturity is the number of blocks a coinbase output waits
+	// before it can be spent: a coinbase at height h is spendable from
+	// height h + CoinbaseMaturity on
+	CoinbaseMaturity uint64 `json:"coinbaseMaturity"`
+
+	// PowLimitBits is the compact encoding of the easiest proof of work target
+	PowLimitBits uint32 `json:"powLimitBits"`
+
+	// TargetSpacing is the intended time between proof of work blocks
+	TargetSpacing time.Duration `json:"targetSpacing"`
+
+	// RetargetWindow is the number of recent blocks whose timespan sets the
+	// target of the next proof of work block. Zero keeps the target at the
+	// limit.
+	RetargetWindow uint64 `json:"retargetWindow"`
+
+	// MaxRetarget bounds the timespan a retarget uses to within this factor
+	// of the intended one, so one retarget moves the target by at most that
+	// factor in either direction
+	MaxRetarget int64 `json:"maxRetarget"`
+
+	// MedianTimeBlocks is the number of blocks whose median timestamp a new
+	// block's timestamp must exceed. Zero compares with the parent only.
+	MedianTimeBlocks uint64 `json:"medianTimeBlocks"`
+
+	// MaxFutureDrift is how far a block's timestamp may run ahead of the
+	// network-adjusted time. Zero allows any timestamp.
+	MaxFutureDrift time.Duration `json:"maxFutureDrift"`
+}
+
+// DefaultParams returns the consensus rules of the main network
+func DefaultParams() Params {
+	return Params{
+		InitialSubsidy:   100,
+		HalvingInterval:  210000,
+		CoinbaseMaturity: 100,
+		PowLimitBits:     PowLimitBits,
+		TargetSpacing:    10 * time.Second,
+		RetargetWindow:   24,
+		MaxRetarget:      4,
+		MedianTimeBlocks: 11,
+		MaxFutureDrift:   2 * time.Minute,
+	}
+}
+
+// RegtestParams returns the consensus rules of the regression test
+// network, where blocks are generated on demand: the target stays at the
+// proof of work limit, so a block takes about two hashes, and the subsidy
+// halves every 150 blocks
+func RegtestParams() Params {
+	params := DefaultParams()
+	params.HalvingInterval = 150
+	params.RetargetWindow = 0
+	return params
+}
+
+// Network names accepted by NetworkParams
+const (
+	NetworkMain    = "main"
+	NetworkRegtest = "regtest"
+)
+
+// ErrUnknownNetwork is returned by NetworkParams for a network it does not know
+var ErrUnknownNetwork = errors.New("unknown network")
+
+// NetworkParams returns the consensus rules of a named network
+func NetworkParams(network string) (Params, error) {
+	switch network {
+	case "", NetworkMain:
+		return DefaultParams(), nil
+	case NetworkRegtest:
+		return RegtestParams(), nil
+	default:
+		return Params{}, fmt.Errorf("%w: %s", ErrUnknownNetwork, network)
+	}
+}
+
+// Subsidy returns the number of new coins a block at a height may mint. The
+// subsidy halves every HalvingInterval blocks until it reaches zero, which
+// caps the total supply at roughly twice InitialSubsidy * HalvingInterval.
+func (p Params) Subsidy(height uint64) int {
+	if p.HalvingInterval == 0 {
+		return p.InitialSubsidy
+	}
+
+	halvings := height / p.HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return p.InitialSubsidy >> halvings
+}
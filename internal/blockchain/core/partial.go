@@ -0,0 +1,188 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrIncompleteTransaction is returned when a partially signed transaction
+// is finalized before every input has enough signatures
+var ErrIncompleteTransaction = errors.New("transaction is not fully signed")
+
+// PartialTransaction is an unsigned transaction passed between signers
+// until its inputs have enough signatures. It carries the outputs the inputs
+// spend, so a signer needs no access to the chain, and the signatures
+// collected so far for each input, keyed by hex-encoded public key.
+type PartialTransaction struct {
+	Tx          Transaction         `json:"tx"`
+	PrevOutputs []TXOutput          `json:"prevOutputs"`
+	Signatures  []map[string][]byte `json:"signatures"`
+}
+
+// NewPartialTransaction starts collecting signatures for tx. prevOuts holds
+// the output spent by each input, in input order.
+func NewPartialTransaction(tx *Transaction, prevOuts []TXOutput) (*PartialTransaction, error) {
+	if tx.IsCoinbase() {
+		return nil, errors.New("coinbase transactions are not signed")
+	}
+	if len(prevOuts) != len(tx.Inputs) {
+		return nil, fmt.Errorf("expected %d previous outputs, got %d", len(tx.Inputs), len(prevOuts))
+	}
+
+	p := &PartialTransaction{
+		Tx:          tx.TrimmedCopy(),
+		PrevOutputs: append([]TXOutput(nil), prevOuts...),
+		Signatures:  make([]map[string][]byte, len(tx.Inputs)),
+	}
+	for i := range p.Signatures {
+		p.Signatures[i] = make(map[string][]byte)
+	}
+	p.Tx.SetID()
+
+	return p, nil
+}
+
+// DeserializePartialTransaction decodes a partially signed transaction
+// produced by Serialize
+func DeserializePartialTransaction(data []byte) (*PartialTransaction, error) {
+	var p PartialTransaction
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	if len(p.PrevOutputs) != len(p.Tx.Inputs) || len(p.Signatures) != len(p.Tx.Inputs) {
+		return nil, errors.New("partial transaction does not cover every input")
+	}
+	for i := range p.Signatures {
+		if p.Signatures[i] == nil {
+			p.Signatures[i] = make(map[string][]byte)
+		}
+	}
+	return &p, nil
+}
+
+// Serialize encodes the partially signed transaction for the next signer
+func (p *PartialTransaction) Serialize() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// canSign reports whether the locking script spent by input index names
+// pubKey, either as one of its multisig keys or by its hash
+func (p *PartialTransaction) canSign(index int, pubKey []byte) bool {
+	script := p.PrevOutputs[index].Script
+
+	if _, pubKeys, ok := script.MultiSig(); ok {
+		for _, key := range pubKeys {
+			if bytes.Equal(key, pubKey) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return bytes.Equal(HashPubKey(pubKey), script.signerPubKeyHash())
+}
+
+// Sign adds a signature by privKey to every input whose locking script
+// names its key and returns the number of inputs signed
+func (p *PartialTransaction) Sign(privKey *ecdsa.PrivateKey) (int, error) {
+	pubKey := EncodePubKey(&privKey.PublicKey)
+
+	signed := 0
+	for i := range p.Tx.Inputs {
+		if !p.canSign(i, pubKey) {
+			continue
+		}
+
+		signature, err := SignHash(privKey, p.Tx.SigHash(i, p.PrevOutputs[i]))
+		if err != nil {
+			return signed, err
+		}
+		p.Signatures[i][hex.EncodeToString(pubKey)] = signature
+		signed++
+	}
+
+	return signed, nil
+}
+
+// Combine merges the signatures collected by another signer for the same transaction
+func (p *PartialTransaction) Combine(other *PartialTransaction) error {
+	if other.Tx.ID != p.Tx.ID || len(other.Signatures) != len(p.Signatures) {
+		return errors.New("partial transactions are for different transactions")
+	}
+
+	for i, signatures := range other.Signatures {
+		for pubKey, signature := range signatures {
+			p.Signatures[i][pubKey] = signature
+		}
+	}
+	return nil
+}
+
+// IsComplete reports whether every input has enough signatures to be finalized
+func (p *PartialTransaction) IsComplete() bool {
+	for i := range p.Tx.Inputs {
+		if _, err := p.unlockingScript(i); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// unlockingScript builds the unlocking script of input index from the collected signatures
+func (p *PartialTransaction) unlockingScript(index int) (Script, error) {
+	script := p.PrevOutputs[index].Script
+	signatures := p.Signatures[index]
+
+	if m, pubKeys, ok := script.MultiSig(); ok {
+		// Signatures go in the same order as their keys
+		unlock := NewScript()
+		count := 0
+		for _, pubKey := range pubKeys {
+			signature, ok := signatures[hex.EncodeToString(pubKey)]
+			if !ok || count == m {
+				continue
+			}
+			unlock = unlock.AddData(signature)
+			count++
+		}
+		if count < m {
+			return nil, fmt.Errorf("%w: input %d has %d of %d signatures", ErrIncompleteTransaction, index, count, m)
+		}
+		return unlock, nil
+	}
+
+	if pubKeyHash := script.signerPubKeyHash(); pubKeyHash != nil {
+		for pubKey, signature := range signatures {
+			key, err := hex.DecodeString(pubKey)
+			if err == nil && bytes.Equal(HashPubKey(key), pubKeyHash) {
+				return NewScript().AddData(signature).AddData(key), nil
+			}
+		}
+		return nil, fmt.Errorf("%w: input %d is not signed", ErrIncompleteTransaction, index)
+	}
+
+	return nil, fmt.Errorf("input %d spends a script that cannot be signed", index)
+}
+
+// Finalize builds the unlocking scripts from the collected signatures and
+// returns the signed transaction after checking every input
+func (p *PartialTransaction) Finalize() (*Transaction, error) {
+	tx := p.Tx.TrimmedCopy()
+	for i := range tx.Inputs {
+		unlock, err := p.unlockingScript(i)
+		if err != nil {
+			return nil, err
+		}
+		tx.Inputs[i].ScriptSig = unlock
+	}
+	tx.SetID()
+
+	if err := tx.VerifyInputs(p.PrevOutputs); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
@@ -0,0 +1,500 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Opcode is a single script instruction
+type Opcode byte
+
+// Script opcodes. Values follow the Bitcoin opcode table where an
+// equivalent exists.
+const (
+	OP_0              Opcode = 0x00 // push an empty element
+	OP_PUSHDATA1      Opcode = 0x4c // push the next N bytes, N given by a 1 byte length
+	OP_PUSHDATA2      Opcode = 0x4d // push the next N bytes, N given by a 2 byte length
+	OP_PUSHDATA4      Opcode = 0x4e // push the next N bytes, N given by a 4 byte length
+	OP_1              Opcode = 0x51 // push the number 1; OP_2 to OP_16 follow
+	OP_16             Opcode = 0x60 // push the number 16
+	OP_NOP            Opcode = 0x61
+	OP_IF             Opcode = 0x63
+	OP_NOTIF          Opcode = 0x64
+	OP_ELSE           Opcode = 0x67
+	OP_ENDIF          Opcode = 0x68
+	OP_VERIFY         Opcode = 0x69
+	OP_RETURN         Opcode = 0x6a
+	OP_DROP           Opcode = 0x75
+	OP_DUP            Opcode = 0x76
+	OP_EQUAL          Opcode = 0x87
+	OP_EQUALVERIFY    Opcode = 0x88
+	OP_SHA256         Opcode = 0xa8
+	OP_PUBKEYHASH     Opcode = 0xa9 // replace the top element with HashPubKey of it
+	OP_CHECKSIG       Opcode = 0xac
+	OP_CHECKSIGVERIFY Opcode = 0xad
+
+	OP_CHECKMULTISIG       Opcode = 0xae
+	OP_CHECKMULTISIGVERIFY Opcode = 0xaf
+
+	OP_CHECKLOCKTIMEVERIFY Opcode = 0xb1 // fail unless the transaction's LockTime has reached the top element
+	OP_CHECKSEQUENCEVERIFY Opcode = 0xb2 // fail unless the input's Sequence has reached the top element
+	OP_STAKE               Opcode = 0xb3 // mark an output as bonded stake; does nothing when run
+
+	// OP_FALSE and OP_TRUE are aliases used when a script pushes a boolean
+	OP_FALSE = OP_0
+	OP_TRUE  = OP_1
+)
+
+// opcodeNames maps the opcodes without data to their names
+var opcodeNames = map[Opcode]string{
+	OP_0:              "OP_0",
+	OP_NOP:            "OP_NOP",
+	OP_IF:             "OP_IF",
+	OP_NOTIF:          "OP_NOTIF",
+	OP_ELSE:           "OP_ELSE",
+	OP_ENDIF:          "OP_ENDIF",
+	OP_VERIFY:         "OP_VERIFY",
+	OP_RETURN:         "OP_RETURN",
+	OP_DROP:           "OP_DROP",
+	OP_DUP:            "OP_DUP",
+	OP_EQUAL:          "OP_EQUAL",
+	OP_EQUALVERIFY:    "OP_EQUALVERIFY",
+	OP_SHA256:         "OP_SHA256",
+	OP_PUBKEYHASH:     "OP_PUBKEYHASH",
+	OP_CHECKSIG:       "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY: "OP_CHECKSIGVERIFY",
+
+	OP_CHECKMULTISIG:       "OP_CHECKMULTISIG",
+	OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+	OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY",
+	OP_CHECKSEQUENCEVERIFY: "OP_CHECKSEQUENCEVERIFY",
+	OP_STAKE:               "OP_STAKE",
+}
+
+// String returns the name of the opcode
+func (op Opcode) String() string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	if op >= OP_1 && op <= OP_16 {
+		return fmt.Sprintf("OP_%d", op-OP_1+1)
+	}
+	return fmt.Sprintf("OP_UNKNOWN_%#02x", byte(op))
+}
+
+// ErrMalformedScript is returned when a script cannot be parsed into instructions
+var ErrMalformedScript = errors.New("malformed script")
+
+// Script is a sequence of opcodes and data pushes. Outputs carry a locking
+// script and inputs an unlocking script that satisfies it.
+type Script []byte
+
+// NewScript returns an empty script to build on with AddOp and AddData
+func NewScript() Script {
+	return Script{}
+}
+
+// AddOp appends an opcode to the script
+func (s Script) AddOp(op Opcode) Script {
+	return append(s, byte(op))
+}
+
+// AddData appends a push of data to the script using the smallest encoding
+func (s Script) AddData(data []byte) Script {
+	n := len(data)
+	switch {
+	case n == 0:
+		return append(s, byte(OP_0))
+	case n < int(OP_PUSHDATA1):
+		s = append(s, byte(n))
+	case n <= 0xff:
+		s = append(s, byte(OP_PUSHDATA1), byte(n))
+	case n <= 0xffff:
+		s = append(s, byte(OP_PUSHDATA2))
+		s = binary.LittleEndian.AppendUint16(s, uint16(n))
+	default:
+		s = append(s, byte(OP_PUSHDATA4))
+		s = binary.LittleEndian.AppendUint32(s, uint32(n))
+	}
+	return append(s, data...)
+}
+
+// AddInt appends a push of a number, using OP_0 to OP_16 for small values
+func (s Script) AddInt(n int64) Script {
+	switch {
+	case n == 0:
+		return s.AddOp(OP_0)
+	case n >= 1 && n <= 16:
+		return s.AddOp(smallInt(int(n)))
+	}
+	return s.AddData(encodeInt(n))
+}
+
+// encodeInt encodes a number as the engine reads it: little-endian
+// sign-magnitude with the sign in the high bit of the last byte
+func encodeInt(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+
+	var value []byte
+	for ; n > 0; n >>= 8 {
+		value = append(value, byte(n))
+	}
+
+	// Add a byte for the sign if the high bit is already taken
+	last := len(value) - 1
+	switch {
+	case value[last]&0x80 != 0 && negative:
+		value = append(value, 0x80)
+	case value[last]&0x80 != 0:
+		value = append(value, 0)
+	case negative:
+		value[last] |= 0x80
+	}
+	return value
+}
+
+// instruction is a parsed script element: an opcode and, for pushes, its data
+type instruction struct {
+	op   Opcode
+	data []byte
+}
+
+// isPush reports whether the instruction only pushes data or a small number
+func (in instruction) isPush() bool {
+	return in.op <= OP_PUSHDATA4 || (in.op >= OP_1 && in.op <= OP_16)
+}
+
+// number returns the number pushed by the instruction
+func (in instruction) number() (int64, error) {
+	switch {
+	case in.op >= OP_1 && in.op <= OP_16:
+		return int64(in.op-OP_1) + 1, nil
+	case in.op <= OP_PUSHDATA4:
+		return asInt(in.data, 5)
+	}
+	return 0, fmt.Errorf("%w: %s does not push a number", ErrMalformedScript, in.op)
+}
+
+// parse splits a script into instructions
+func (s Script) parse() ([]instruction, error) {
+	var instructions []instruction
+
+	for i := 0; i < len(s); {
+		op := Opcode(s[i])
+		i++
+
+		var n int
+		switch {
+		case op > OP_0 && op < OP_PUSHDATA1:
+			n = int(op)
+		case op == OP_PUSHDATA1:
+			if i+1 > len(s) {
+				return nil, fmt.Errorf("%w: truncated OP_PUSHDATA1", ErrMalformedScript)
+			}
+			n = int(s[i])
+			i++
+		case op == OP_PUSHDATA2:
+			if i+2 > len(s) {
+				return nil, fmt.Errorf("%w: truncated OP_PUSHDATA2", ErrMalformedScript)
+			}
+			n = int(binary.LittleEndian.Uint16(s[i:]))
+			i += 2
+		case op == OP_PUSHDATA4:
+			if i+4 > len(s) {
+				return nil, fmt.Errorf("%w: truncated OP_PUSHDATA4", ErrMalformedScript)
+			}
+			n = int(binary.LittleEndian.Uint32(s[i:]))
+			i += 4
+		default:
+			instructions = append(instructions, instruction{op: op})
+			continue
+		}
+
+		if n < 0 || i+n > len(s) {
+			return nil, fmt.Errorf("%w: push of %d bytes runs past the end", ErrMalformedScript, n)
+		}
+		instructions = append(instructions, instruction{op: op, data: s[i : i+n]})
+		i += n
+	}
+
+	return instructions, nil
+}
+
+// String disassembles the script, showing pushed data in hex
+func (s Script) String() string {
+	instructions, err := s.parse()
+	if err != nil {
+		return "[" + err.Error() + "]"
+	}
+
+	parts := make([]string, len(instructions))
+	for i, in := range instructions {
+		if in.op > OP_0 && in.op <= OP_PUSHDATA4 {
+			parts[i] = hex.EncodeToString(in.data)
+		} else {
+			parts[i] = in.op.String()
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// PayToPubKeyHashScript returns a script that locks an output to the owner
+// of a public key: OP_DUP OP_PUBKEYHASH <hash> OP_EQUALVERIFY OP_CHECKSIG.
+// It is unlocked by <signature> <public key>.
+func PayToPubKeyHashScript(pubKeyHash []byte) Script {
+	return NewScript().
+		AddOp(OP_DUP).
+		AddOp(OP_PUBKEYHASH).
+		AddData(pubKeyHash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_CHECKSIG)
+}
+
+// DataScript returns an unspendable script carrying a payload: OP_RETURN <payload>
+func DataScript(payload []byte) Script {
+	return NewScript().AddOp(OP_RETURN).AddData(payload)
+}
+
+// HashLockScript returns a script that anyone revealing the SHA-256
+// preimage of hash can unlock: OP_SHA256 <hash> OP_EQUAL. On its own it is
+// a puzzle rather than a payment, since the preimage becomes public when it
+// is first spent; combine it with OP_CHECKSIG to also require a key.
+func HashLockScript(hash []byte) Script {
+	return NewScript().AddOp(OP_SHA256).AddData(hash).AddOp(OP_EQUAL)
+}
+
+// TimeLockScript returns a pay-to-pubkey-hash script that can only be spent
+// by a transaction whose LockTime has reached lockTime, a block height or a
+// Unix time as for Transaction.LockTime:
+// <lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP followed by the
+// pay-to-pubkey-hash script. It suits vesting payouts.
+func TimeLockScript(lockTime uint32, pubKeyHash []byte) Script {
+	return append(NewScript().
+		AddInt(int64(lockTime)).
+		AddOp(OP_CHECKLOCKTIMEVERIFY).
+		AddOp(OP_DROP), PayToPubKeyHashScript(pubKeyHash)...)
+}
+
+// SequenceLockScript returns a pay-to-pubkey-hash script that can only be
+// spent by an input whose Sequence carries at least the relative lock in
+// sequence: <sequence> OP_CHECKSEQUENCEVERIFY OP_DROP followed by the
+// pay-to-pubkey-hash script. It suits refund paths that open some time
+// after an output is confirmed.
+func SequenceLockScript(sequence uint32, pubKeyHash []byte) Script {
+	return append(NewScript().
+		AddInt(int64(sequence)).
+		AddOp(OP_CHECKSEQUENCEVERIFY).
+		AddOp(OP_DROP), PayToPubKeyHashScript(pubKeyHash)...)
+}
+
+// TimeLock returns the lock time and public key hash of a script built by
+// TimeLockScript. ok is false for any other script.
+func (s Script) TimeLock() (lockTime uint32, pubKeyHash []byte, ok bool) {
+	return s.lockedPubKeyHash(OP_CHECKLOCKTIMEVERIFY)
+}
+
+// SequenceLock returns the relative lock and public key hash of a script
+// built by SequenceLockScript. ok is false for any other script.
+func (s Script) SequenceLock() (sequence uint32, pubKeyHash []byte, ok bool) {
+	return s.lockedPubKeyHash(OP_CHECKSEQUENCEVERIFY)
+}
+
+// lockedPubKeyHash splits a <n> op OP_DROP prefix off a pay-to-pubkey-hash script
+func (s Script) lockedPubKeyHash(op Opcode) (uint32, []byte, bool) {
+	instructions, err := s.parse()
+	if err != nil || len(instructions) < 3 || instructions[1].op != op || instructions[2].op != OP_DROP {
+		return 0, nil, false
+	}
+
+	n, err := instructions[0].number()
+	if err != nil || n < 0 || n > math.MaxUint32 {
+		return 0, nil, false
+	}
+
+	// Only the encoding the builders produce is recognised
+	prefix := NewScript().AddInt(n).AddOp(op).AddOp(OP_DROP)
+	if !bytes.HasPrefix(s, prefix) {
+		return 0, nil, false
+	}
+	pubKeyHash := s[len(prefix):].PubKeyHash()
+	if pubKeyHash == nil {
+		return 0, nil, false
+	}
+	return uint32(n), pubKeyHash, true
+}
+
+// signerPubKeyHash returns the hash of the key that signs for a
+// pay-to-pubkey-hash script, with or without a time lock, or for a stake
+// script, or nil for any other script
+func (s Script) signerPubKeyHash() []byte {
+	if pubKeyHash := s.PubKeyHash(); pubKeyHash != nil {
+		return pubKeyHash
+	}
+	if _, pubKeyHash, ok := s.TimeLock(); ok {
+		return pubKeyHash
+	}
+	if _, pubKeyHash, ok := s.SequenceLock(); ok {
+		return pubKeyHash
+	}
+	if _, pubKeyHash, ok := s.Stake(); ok {
+		return pubKeyHash
+	}
+	return nil
+}
+
+// locked reports whether s pays a key that can only sign for it once a time
+// lock or unbonding period is over
+func (s Script) locked() bool {
+	return s.PubKeyHash() == nil && s.signerPubKeyHash() != nil
+}
+
+// MaxMultiSigKeys is the largest number of keys a multisig script may list
+const MaxMultiSigKeys = 16
+
+// MultiSigScript returns a script that locks an output to any m of the given
+// public keys: OP_m <key 1> ... <key n> OP_n OP_CHECKMULTISIG. It is
+// unlocked by m signatures in the same order as their keys.
+func MultiSigScript(m int, pubKeys [][]byte) (Script, error) {
+	n := len(pubKeys)
+	if n == 0 || n > MaxMultiSigKeys {
+		return nil, fmt.Errorf("multisig needs 1 to %d keys, got %d", MaxMultiSigKeys, n)
+	}
+	if m < 1 || m > n {
+		return nil, fmt.Errorf("multisig threshold %d out of range for %d keys", m, n)
+	}
+
+	script := NewScript().AddOp(smallInt(m))
+	for _, pubKey := range pubKeys {
+		if len(pubKey) != PubKeyLen {
+			return nil, fmt.Errorf("invalid public key length %d", len(pubKey))
+		}
+		script = script.AddData(pubKey)
+	}
+	return script.AddOp(smallInt(n)).AddOp(OP_CHECKMULTISIG), nil
+}
+
+// MultiSig returns the threshold and keys of a multisig script. ok is false
+// for any other script.
+func (s Script) MultiSig() (m int, pubKeys [][]byte, ok bool) {
+	instructions, err := s.parse()
+	if err != nil || len(instructions) < 4 {
+		return 0, nil, false
+	}
+
+	last := len(instructions) - 1
+	first, count := instructions[0].op, instructions[last-1].op
+	if instructions[last].op != OP_CHECKMULTISIG ||
+		first < OP_1 || first > OP_16 || count < OP_1 || count > OP_16 {
+		return 0, nil, false
+	}
+
+	m, n := int(first-OP_1)+1, int(count-OP_1)+1
+	if m > n || n != last-2 {
+		return 0, nil, false
+	}
+
+	for _, in := range instructions[1 : last-1] {
+		if in.op == OP_0 || in.op > OP_PUSHDATA4 || len(in.data) != PubKeyLen {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, in.data)
+	}
+	return m, pubKeys, true
+}
+
+// smallInt returns the opcode pushing a number from 1 to 16
+func smallInt(n int) Opcode {
+	return OP_1 + Opcode(n-1)
+}
+
+// PubKeyHash returns the hash a pay-to-pubkey-hash script locks to, or nil
+// for any other script
+func (s Script) PubKeyHash() []byte {
+	instructions, err := s.parse()
+	if err != nil || len(instructions) != 5 {
+		return nil
+	}
+
+	if instructions[0].op != OP_DUP ||
+		instructions[1].op != OP_PUBKEYHASH ||
+		!instructions[2].isPush() || len(instructions[2].data) == 0 ||
+		instructions[3].op != OP_EQUALVERIFY ||
+		instructions[4].op != OP_CHECKSIG {
+		return nil
+	}
+	return instructions[2].data
+}
+
+// IsData reports whether the script is an OP_RETURN data carrier
+func (s Script) IsData() bool {
+	return len(s) > 0 && Opcode(s[0]) == OP_RETURN
+}
+
+// Data returns the payload of a data carrier script, or nil for any other script
+func (s Script) Data() []byte {
+	if !s.IsData() {
+		return nil
+	}
+
+	instructions, err := s[1:].parse()
+	if err != nil || len(instructions) == 0 {
+		return nil
+	}
+	return instructions[0].data
+}
+
+// IsPushOnly reports whether the script only pushes data. Unlocking scripts
+// must be push-only so they cannot alter how the locking script runs.
+func (s Script) IsPushOnly() bool {
+	instructions, err := s.parse()
+	if err != nil {
+		return false
+	}
+
+	for _, in := range instructions {
+		if !in.isPush() {
+			return false
+		}
+	}
+	return true
+}
+
+// pushes returns the data pushed by a push-only script, or nil otherwise
+func (s Script) pushes() [][]byte {
+	instructions, err := s.parse()
+	if err != nil {
+		return nil
+	}
+
+	data := make([][]byte, 0, len(instructions))
+	for _, in := range instructions {
+		if !in.isPush() {
+			return nil
+		}
+		data = append(data, in.data)
+	}
+	return data
+}
+
+// p2pkhPubKey returns the public key of an unlocking script in the
+// <signature> <public key> form of a pay-to-pubkey-hash spend, or nil for
+// any other script
+func (s Script) p2pkhPubKey() []byte {
+	data := s.pushes()
+	if len(data) != 2 || len(data[1]) != PubKeyLen {
+		return nil
+	}
+	return data[1]
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// StakeScript returns the script of a stake deposit: OP_STAKE followed by a
+// SequenceLockScript that keeps the deposit bonded for unbonding blocks
+// after it is confirmed. The stake belongs to the owner of pubKeyHash, who
+// withdraws it by spending the output once the lock has passed.
+func StakeScript(unbonding uint16, pubKeyHash []byte) Script {
+	return append(NewScript().AddOp(OP_STAKE), SequenceLockScript(RelativeLockBlocks(unbonding), pubKeyHash)...)
+}
+
+// Stake returns the unbonding period and owner of a script built by
+// StakeScript. ok is false for any other script.
+func (s Script) Stake() (unbonding uint16, pubKeyHash []byte, ok bool) {
+	if len(s) == 0 || Opcode(s[0]) != OP_STAKE {
+		return 0, nil, false
+	}
+
+	sequence, pubKeyHash, ok := s[1:].SequenceLock()
+	if !ok || sequence&^SequenceLockMask != 0 {
+		return 0, nil, false
+	}
+	return uint16(sequence), pubKeyHash, true
+}
+
+// NewStakeOutput creates a stake deposit of value owned by an address
+func NewStakeOutput(value int, address string, unbonding uint16) (TXOutput, error) {
+	pubKeyHash, err := addressPubKeyHash(address)
+	if err != nil {
+		return TXOutput{}, err
+	}
+	return TXOutput{
+		Value:  value,
+		Script: StakeScript(unbonding, pubKeyHash),
+	}, nil
+}
+
+// NewStakeTransaction creates a transaction that deposits amount from an
+// address as its stake. Inputs are chosen as for NewTransaction and left
+// unsigned.
+func NewStakeTransaction(from string, amount int, unbonding uint16, utxoSet map[string][]UTXO) (*Transaction, error) {
+	tx, err := NewTransaction(from, from, amount, utxoSet)
+	if err != nil {
+		return nil, err
+	}
+
+	if tx.Outputs[0], err = NewStakeOutput(amount, from, unbonding); err != nil {
+		return nil, err
+	}
+	tx.SetID()
+
+	return tx, nil
+}
+
+// NewWithdrawalTransaction creates a transaction that withdraws stake
+// deposits and pays them to an address. Each input carries the relative
+// lock of its deposit, so the transaction is valid once every deposit has
+// been bonded for its unbonding period. The inputs are left unsigned.
+func NewWithdrawalTransaction(stake []UTXO, to string) (*Transaction, error) {
+	if len(stake) == 0 {
+		return nil, errors.New("no stake to withdraw")
+	}
+	output, err := NewTXOutput(0, to)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := Transaction{Time: time.Now()}
+	total := 0
+	var owner []byte
+	for _, utxo := range stake {
+		unbonding, pubKeyHash, ok := utxo.Output.Script.Stake()
+		if !ok {
+			return nil, fmt.Errorf("output %s:%d is not a stake deposit", utxo.TXID, utxo.Index)
+		}
+		if owner != nil && !bytes.Equal(owner, pubKeyHash) {
+			return nil, errors.New("stake deposits have different owners")
+		}
+		owner = pubKeyHash
+
+		tx.Inputs = append(tx.Inputs, TXInput{
+			TXID:     utxo.TXID,
+			Vout:     utxo.Index,
+			Sequence: RelativeLockBlocks(unbonding),
+		})
+		total += utxo.Output.Value
+	}
+
+	output.Value = total
+	tx.Outputs = []TXOutput{output}
+	tx.SetID()
+
+	return &tx, nil
+}
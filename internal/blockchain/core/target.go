@@ -0,0 +1,111 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// PowLimitBits is the compact encoding of the easiest proof of work
+// target, which about half of all block hashes meet. The genesis block and
+// the first blocks of a chain use it.
+const PowLimitBits uint32 = 0x207fffff
+
+// Errors explaining why a block's proof of work is rejected. They are
+// reported wrapped in ErrInvalidBlock.
+var (
+	// ErrBadTarget is returned for bits that do not encode a target or
+	// encode one easier than the proof of work limit
+	ErrBadTarget = errors.New("bad proof of work target")
+
+	// ErrUnexpectedBits is returned for bits other than the ones
+	// retargeting expects after the block's parent
+	ErrUnexpectedBits = errors.New("unexpected proof of work target")
+
+	// ErrInsufficientWork is returned for a block whose hash does not meet
+	// its target
+	ErrInsufficientWork = errors.New("hash does not meet the proof of work target")
+)
+
+// Compact target encoding. A proof of work target is a 256-bit number that
+// a block hash must fall below. It is stored in 32 bits like a floating
+// point number: the high byte is the length of the target in bytes and the
+// low 23 bits are its leading digits, so target = mantissa * 256^(length-3).
+// Bit 23 is a sign bit; targets with it set are invalid.
+const (
+	compactSignBit      uint32 = 0x00800000
+	compactMantissaMask uint32 = 0x007fffff
+)
+
+// CompactToBig decodes a compact target. Invalid targets decode to zero,
+// which no hash meets.
+func CompactToBig(bits uint32) *big.Int {
+	if bits&compactSignBit != 0 {
+		return new(big.Int)
+	}
+
+	length := uint(bits >> 24)
+	mantissa := big.NewInt(int64(bits & compactMantissaMask))
+	if length <= 3 {
+		return mantissa.Rsh(mantissa, 8*(3-length))
+	}
+	return mantissa.Lsh(mantissa, 8*(length-3))
+}
+
+// BigToCompact encodes a target in compact form, truncating it to its
+// leading 23 bits
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+
+	length := uint32(len(target.Bytes()))
+	var mantissa uint32
+	if length <= 3 {
+		mantissa = uint32(target.Uint64()) << (8 * (3 - length))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, uint(8*(length-3))).Uint64())
+	}
+
+	// Keep the sign bit clear by moving a digit into the length
+	if mantissa&compactSignBit != 0 {
+		mantissa >>= 8
+		length++
+	}
+	return length<<24 | mantissa
+}
+
+// BitsFromLeadingZeros returns the compact target met by hashes starting
+// with the given number of zero bits, 2^(256-zeros)
+func BitsFromLeadingZeros(zeros uint) uint32 {
+	return BigToCompact(new(big.Int).Lsh(big.NewInt(1), 256-zeros))
+}
+
+// Work returns the expected number of hashes needed to meet a compact
+// target, 2^256 / target. An invalid target takes no work.
+func Work(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() == 0 {
+		return new(big.Int)
+	}
+	return target.Div(new(big.Int).Lsh(big.NewInt(1), 256), target)
+}
+
+// CheckProofOfWork checks that a block's bits encode a target no easier
+// than limit and that the hash of its header meets that target
+func (b *Block) CheckProofOfWork(limit uint32) error {
+	target := CompactToBig(b.Bits)
+	if target.Sign() == 0 || target.Cmp(CompactToBig(limit)) > 0 {
+		return fmt.Errorf("%w: bits %08x, limit %08x", ErrBadTarget, b.Bits, limit)
+	}
+
+	header, err := b.Header()
+	if err != nil {
+		return err
+	}
+	hash := header.Hash()
+	if new(big.Int).SetBytes(hash[:]).Cmp(target) >= 0 {
+		return fmt.Errorf("%w: hash %x, bits %08x", ErrInsufficientWork, hash, b.Bits)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Errors explaining why a block's timestamp is rejected. They are reported
+// wrapped in ErrInvalidBlock.
+var (
+	// ErrTimeTooOld is returned for a block whose timestamp is not after
+	// the median time of the blocks before it
+	ErrTimeTooOld = errors.New("block timestamp not after median time past")
+
+	// ErrTimeTooNew is returned for a block whose timestamp is further
+	// ahead of the network-adjusted time than MaxFutureDrift. Such a block
+	// may become valid later, so it is not marked invalid.
+	ErrTimeTooNew = errors.New("block timestamp too far in the future")
+)
+
+// medianTimePast returns the median timestamp of the last count blocks
+// ending at node, or of every block back to genesis if there are fewer.
+// A count of zero or one gives the node's own timestamp.
+func medianTimePast(node *blockNode, count uint64) time.Time {
+	if count == 0 {
+		count = 1
+	}
+
+	times := make([]time.Time, 0, count)
+	for n := node; n != nil && uint64(len(times)) < count; n = n.parent {
+		times = append(times, n.timestamp)
+	}
+	return median(times)
+}
+
+// median returns the median of a non-empty list of times, reordering it
+func median(times []time.Time) time.Time {
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times[len(times)/2]
+}
+
+// MedianTimePast returns the median timestamp of the MedianTimeBlocks
+// blocks ending at a known block, which a child of that block must exceed.
+// It returns the zero time for an unknown block.
+func (bc *Blockchain) MedianTimePast(hash string) time.Time {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	node, ok := bc.nodes[hash]
+	if !ok {
+		return time.Time{}
+	}
+	return medianTimePast(node, bc.params.MedianTimeBlocks)
+}
+
+// checkTimestamp checks a block's timestamp against the median time past of
+// its parent and, unless MaxFutureDrift is zero, against the chain's clock.
+// A timestamp may be before its parent's as long as it is after the median.
+func (bc *Blockchain) checkTimestamp(block *Block, parent *blockNode) error {
+	if median := medianTimePast(parent, bc.params.MedianTimeBlocks); !block.Timestamp.After(median) {
+		return fmt.Errorf("%w: %w: %s is not after %s", ErrInvalidBlock, ErrTimeTooOld,
+			block.Timestamp.Format(time.RFC3339Nano), median.Format(time.RFC3339Nano))
+	}
+
+	drift := bc.params.MaxFutureDrift
+	if limit := bc.clock.Now().Add(drift); drift > 0 && block.Timestamp.After(limit) {
+		return fmt.Errorf("%w: %w: %s is after %s", ErrInvalidBlock, ErrTimeTooNew,
+			block.Timestamp.Format(time.RFC3339Nano), limit.Format(time.RFC3339Nano))
+	}
+
+	return nil
+}
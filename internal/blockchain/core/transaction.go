@@ -1,8 +1,13 @@
 package core
 
 import (
+	"crypto/ecdsa"
 	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 )
 
@@ -12,47 +17,131 @@ type Transaction struct {
 	Inputs  []TXInput  `json:"inputs"`
 	Outputs []TXOutput `json:"outputs"`
 	Time    time.Time  `json:"time"`
+
+	// LockTime is the first block height, or the earliest Unix time when
+	// at least LockTimeThreshold, at which the transaction may be mined
+	LockTime uint32 `json:"lockTime,omitempty"`
 }
 
-// TXInput represents a transaction input
+// TXInput represents a transaction input. ScriptSig is the unlocking script
+// that satisfies the locking script of the spent output; a coinbase input
+// carries arbitrary data there instead. Sequence holds an optional relative
+// lock on the spent output.
 type TXInput struct {
 	TXID      string `json:"txid"`
 	Vout      int    `json:"vout"`
-	Signature []byte `json:"signature"`
-	PubKey    []byte `json:"pubKey"`
+	ScriptSig Script `json:"scriptSig"`
+	Sequence  uint32 `json:"sequence,omitempty"`
 }
 
-// TXOutput represents a transaction output
+// TXOutput represents a transaction output locked by a script
 type TXOutput struct {
-	Value      int    `json:"value"`
-	PubKeyHash []byte `json:"pubKeyHash"`
+	Value  int    `json:"value"`
+	Script Script `json:"script"`
+}
+
+// NewTXOutput creates an output paying value to an address
+func NewTXOutput(value int, address string) (TXOutput, error) {
+	pubKeyHash, err := addressPubKeyHash(address)
+	if err != nil {
+		return TXOutput{}, err
+	}
+	return TXOutput{
+		Value:  value,
+		Script: PayToPubKeyHashScript(pubKeyHash),
+	}, nil
 }
 
+// NewTimeLockedOutput creates an output paying value to an address that
+// cannot be spent by a transaction whose LockTime is before lockTime
+func NewTimeLockedOutput(value int, address string, lockTime uint32) (TXOutput, error) {
+	pubKeyHash, err := addressPubKeyHash(address)
+	if err != nil {
+		return TXOutput{}, err
+	}
+	return TXOutput{
+		Value:  value,
+		Script: TimeLockScript(lockTime, pubKeyHash),
+	}, nil
+}
+
+// NewDataOutput creates a data carrier output holding payload. It has no
+// value and can never be spent.
+func NewDataOutput(payload []byte) TXOutput {
+	return TXOutput{Script: DataScript(payload)}
+}
+
+// IsData checks if the output is a data carrier
+func (out TXOutput) IsData() bool {
+	return out.Script.IsData()
+}
+
+// Data returns the payload of a data carrier output, or nil for any other output
+func (out TXOutput) Data() []byte {
+	return out.Script.Data()
+}
+
+// ErrInsufficientFunds is returned when the spendable outputs do not cover a payment
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
 // UTXO represents an unspent transaction output
 type UTXO struct {
-	TXID   string
-	Index  int
-	Output TXOutput
+	TXID     string    `json:"txid"`
+	Index    int       `json:"index"`
+	Output   TXOutput  `json:"output"`
+	Height   uint64    `json:"height"`
+	Time     time.Time `json:"time"` // median time past of the confirming block's parent
+	Coinbase bool      `json:"coinbase"`
 }
 
-// NewTransaction creates a new transaction
+// NewTransaction creates a transaction paying amount to an address. Inputs
+// are taken in order from utxoSet[from] until they cover the amount and any
+// excess is paid back to from as change. Time-locked outputs and stake
+// deposits are skipped, since spending them needs a lock time or sequence
+// a plain transfer does not carry. The inputs are left unsigned.
 func NewTransaction(from, to string, amount int, utxoSet map[string][]UTXO) (*Transaction, error) {
-	// Create a regular transaction with proper inputs and outputs
-	txin := TXInput{
-		TXID:      "previous_tx_id", // In a real implementation, this would be a real TXID
-		Vout:      0,                // In a real implementation, this would be the output index
-		Signature: nil,
-		PubKey:    []byte(from),
+	if amount <= 0 {
+		return nil, fmt.Errorf("invalid amount %d", amount)
+	}
+	payment, err := NewTXOutput(amount, to)
+	if err != nil {
+		return nil, err
+	}
+	change, err := NewTXOutput(0, from)
+	if err != nil {
+		return nil, err
+	}
+
+	var inputs []TXInput
+	accumulated := 0
+
+	for _, utxo := range utxoSet[from] {
+		if accumulated >= amount {
+			break
+		}
+		if utxo.Output.Script.locked() {
+			continue
+		}
+		inputs = append(inputs, TXInput{
+			TXID: utxo.TXID,
+			Vout: utxo.Index,
+		})
+		accumulated += utxo.Output.Value
 	}
 
-	txout := TXOutput{
-		Value:      amount,
-		PubKeyHash: []byte(to),
+	if accumulated < amount {
+		return nil, fmt.Errorf("%w: %s has %d, needs %d", ErrInsufficientFunds, from, accumulated, amount)
+	}
+
+	outputs := []TXOutput{payment}
+	if accumulated > amount {
+		change.Value = accumulated - amount
+		outputs = append(outputs, change)
 	}
 
 	tx := Transaction{
-		Inputs:  []TXInput{txin},
-		Outputs: []TXOutput{txout},
+		Inputs:  inputs,
+		Outputs: outputs,
 		Time:    time.Now(),
 	}
 
@@ -61,8 +150,14 @@ func NewTransaction(from, to string, amount int, utxoSet map[string][]UTXO) (*Tr
 	return &tx, nil
 }
 
-// NewCoinbaseTransaction creates a coinbase transaction
-func NewCoinbaseTransaction(to, data string) *Transaction {
+// NewCoinbaseTransaction creates a coinbase transaction paying value to an
+// address. A block accepts it only if value does not exceed the block's
+// subsidy plus the fees of its other transactions.
+func NewCoinbaseTransaction(to, data string, value int) (*Transaction, error) {
+	output, err := NewTXOutput(value, to)
+	if err != nil {
+		return nil, err
+	}
 	if data == "" {
 		data = "Coinbase Transaction"
 	}
@@ -70,35 +165,23 @@ func NewCoinbaseTransaction(to, data string) *Transaction {
 	txin := TXInput{
 		TXID:      "",
 		Vout:      -1,
-		Signature: []byte(data),
-		PubKey:    []byte(data),
-	}
-
-	txout := TXOutput{
-		Value:      100, // Mining reward
-		PubKeyHash: []byte(to),
+		ScriptSig: Script(data),
 	}
 
 	tx := Transaction{
 		Inputs:  []TXInput{txin},
-		Outputs: []TXOutput{txout},
+		Outputs: []TXOutput{output},
 		Time:    time.Now(),
 	}
 
 	tx.SetID()
 
-	return &tx
+	return &tx, nil
 }
 
-// SetID sets the ID of the transaction
+// SetID sets the ID of the transaction to the hex encoded hash of its contents
 func (tx *Transaction) SetID() {
-	txBytes, err := json.Marshal(tx)
-	if err != nil {
-		return
-	}
-
-	hash := sha256.Sum256(txBytes)
-	tx.ID = string(hash[:])
+	tx.ID = hex.EncodeToString(tx.Hash())
 }
 
 // IsCoinbase checks if the transaction is a coinbase transaction
@@ -106,33 +189,124 @@ func (tx *Transaction) IsCoinbase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].TXID) == 0 && tx.Inputs[0].Vout == -1
 }
 
-// Sign signs each input of the transaction
-func (tx *Transaction) Sign(privKey interface{}, prevTXs map[string]Transaction) error {
+// SigHash returns the hash an input's signature commits to: the transaction
+// without unlocking scripts, followed by the output the input spends
+func (tx *Transaction) SigHash(index int, prevOut TXOutput) []byte {
+	txCopy := tx.TrimmedCopy()
+	txCopy.ID = ""
+
+	txBytes, err := json.Marshal(txCopy)
+	if err != nil {
+		return nil
+	}
+	outBytes, err := json.Marshal(prevOut)
+	if err != nil {
+		return nil
+	}
+
+	data := binary.BigEndian.AppendUint32(txBytes, uint32(index))
+	hash := sha256.Sum256(append(data, outBytes...))
+	return hash[:]
+}
+
+// Sign signs each input that spends a pay-to-pubkey-hash output, with or
+// without a time lock, or a stake deposit, with privKey, setting its unlocking script to
+// <signature> <public key>. LockTime and input sequences must be set first. prevTXs
+// maps the ID of every transaction spent by an input to that transaction.
+// Inputs spending other scripts are left for the caller to unlock. The ID is
+// recalculated afterwards because it covers the unlocking scripts.
+func (tx *Transaction) Sign(privKey *ecdsa.PrivateKey, prevTXs map[string]Transaction) error {
 	if tx.IsCoinbase() {
 		return nil
 	}
 
-	// TODO: Implement transaction signing
-	// This would involve:
-	// 1. Creating a copy of the transaction
-	// 2. Signing each input with the private key
-	// 3. Setting the signature in each input
+	prevOuts, err := tx.prevOutputs(prevTXs)
+	if err != nil {
+		return err
+	}
+
+	pubKey := EncodePubKey(&privKey.PublicKey)
+	for i := range tx.Inputs {
+		if prevOuts[i].Script.signerPubKeyHash() == nil {
+			continue
+		}
+
+		signature, err := SignHash(privKey, tx.SigHash(i, prevOuts[i]))
+		if err != nil {
+			return err
+		}
+		tx.Inputs[i].ScriptSig = NewScript().AddData(signature).AddData(pubKey)
+	}
+
+	tx.SetID()
 
 	return nil
 }
 
-// Verify verifies the signatures of the transaction
+// Verify verifies the unlocking scripts of the transaction. prevTXs maps the ID of
+// every transaction spent by an input to that transaction.
 func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 	if tx.IsCoinbase() {
 		return true
 	}
 
-	// TODO: Implement transaction verification
-	// This would involve:
-	// 1. Verifying each input's signature
-	// 2. Checking that the signatures match the public keys
+	prevOuts, err := tx.prevOutputs(prevTXs)
+	if err != nil {
+		return false
+	}
 
-	return true
+	return tx.VerifyInputs(prevOuts) == nil
+}
+
+// prevOutputs returns the output spent by each input
+func (tx *Transaction) prevOutputs(prevTXs map[string]Transaction) ([]TXOutput, error) {
+	prevOuts := make([]TXOutput, len(tx.Inputs))
+	for i, in := range tx.Inputs {
+		prev, ok := prevTXs[in.TXID]
+		if !ok || in.Vout < 0 || in.Vout >= len(prev.Outputs) {
+			return nil, fmt.Errorf("previous output %s:%d not found", in.TXID, in.Vout)
+		}
+		prevOuts[i] = prev.Outputs[in.Vout]
+	}
+	return prevOuts, nil
+}
+
+// VerifyInputs runs the unlocking script of every input against the
+// locking script of the output it spends, given in input order
+func (tx *Transaction) VerifyInputs(prevOuts []TXOutput) error {
+	if len(prevOuts) != len(tx.Inputs) {
+		return fmt.Errorf("%w: expected %d previous outputs, got %d", ErrScriptFailed, len(tx.Inputs), len(prevOuts))
+	}
+
+	for i := range tx.Inputs {
+		if err := tx.VerifyScript(i, prevOuts[i]); err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// OutputValue returns the sum of the transaction's output values
+func (tx *Transaction) OutputValue() int {
+	total := 0
+	for _, out := range tx.Outputs {
+		total += out.Value
+	}
+	return total
+}
+
+// Fee returns the inputs' value minus the outputs' value, given the output
+// spent by each input. A coinbase pays no fee.
+func (tx *Transaction) Fee(prevOuts []TXOutput) int {
+	if tx.IsCoinbase() {
+		return 0
+	}
+
+	fee := -tx.OutputValue()
+	for _, out := range prevOuts {
+		fee += out.Value
+	}
+	return fee
 }
 
 // Hash returns the hash of the transaction
@@ -149,19 +323,19 @@ func (tx *Transaction) Hash() []byte {
 	return hash[:]
 }
 
-// TrimmedCopy returns a copy of the transaction with empty signatures and pubkeys
+// TrimmedCopy returns a copy of the transaction without unlocking scripts
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TXInput
 	var outputs []TXOutput
 
 	for _, vin := range tx.Inputs {
-		inputs = append(inputs, TXInput{vin.TXID, vin.Vout, nil, nil})
+		inputs = append(inputs, TXInput{vin.TXID, vin.Vout, nil, vin.Sequence})
 	}
 
 	for _, vout := range tx.Outputs {
-		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash})
+		outputs = append(outputs, TXOutput{vout.Value, vout.Script})
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs, tx.Time}
+	txCopy := Transaction{tx.ID, inputs, outputs, tx.Time, tx.LockTime}
 	return txCopy
 }
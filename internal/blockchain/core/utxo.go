@@ -0,0 +1,297 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/storage"
+)
+
+var (
+	// utxoBucket maps an outpoint to the unspent output it refers to
+	utxoBucket = []byte("chainstate")
+
+	// utxoAddrBucket maps address and outpoint to nothing, so the unspent
+	// outputs of an address can be found with a prefix scan
+	utxoAddrBucket = []byte("chainstateaddr")
+
+	// undoBucket maps a block hash to the outputs the block spent, in spend order
+	undoBucket = []byte("undo")
+)
+
+// outpointKey encodes a transaction ID and output index
+func outpointKey(txid string, vout int) []byte {
+	key := make([]byte, 0, len(txid)+4)
+	key = append(key, txid...)
+	return binary.BigEndian.AppendUint32(key, uint32(vout))
+}
+
+// utxoAddrKey returns the address bucket key of an unspent output
+func utxoAddrKey(pubKeyHash []byte, txid string, vout int) []byte {
+	return append(addrPrefix(pubKeyHash), outpointKey(txid, vout)...)
+}
+
+// putUTXO adds an unspent output to the chainstate
+func putUTXO(tx storage.Tx, utxo UTXO) error {
+	data, err := json.Marshal(utxo)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Bucket(utxoBucket).Put(outpointKey(utxo.TXID, utxo.Index), data); err != nil {
+		return err
+	}
+
+	pubKeyHash := utxo.Output.Script.signerPubKeyHash()
+	if pubKeyHash == nil {
+		return nil
+	}
+	return tx.Bucket(utxoAddrBucket).Put(utxoAddrKey(pubKeyHash, utxo.TXID, utxo.Index), []byte{})
+}
+
+// deleteUTXO removes an unspent output from the chainstate
+func deleteUTXO(tx storage.Tx, utxo UTXO) error {
+	if err := tx.Bucket(utxoBucket).Delete(outpointKey(utxo.TXID, utxo.Index)); err != nil {
+		return err
+	}
+
+	pubKeyHash := utxo.Output.Script.signerPubKeyHash()
+	if pubKeyHash == nil {
+		return nil
+	}
+	return tx.Bucket(utxoAddrBucket).Delete(utxoAddrKey(pubKeyHash, utxo.TXID, utxo.Index))
+}
+
+// getUTXO reads an unspent output, returning nil if the outpoint is unknown or spent
+func getUTXO(tx storage.Tx, txid string, vout int) (*UTXO, error) {
+	data := tx.Bucket(utxoBucket).Get(outpointKey(txid, vout))
+	if data == nil {
+		return nil, nil
+	}
+
+	var utxo UTXO
+	if err := json.Unmarshal(data, &utxo); err != nil {
+		return nil, fmt.Errorf("decode utxo %s:%d: %w", txid, vout, err)
+	}
+	return &utxo, nil
+}
+
+// connectUTXOs spends the outputs a block's inputs refer to, adds the
+// block's new outputs and records undo data for the block. medianTime is the
+// median time past of the block's parent, which time locks are checked
+// against and the new outputs count as confirmed at. Every transaction must
+// pass ValidateTransaction, and none may repeat the ID of a transaction that
+// still has unspent outputs, since its outputs would overwrite them and
+// disconnecting the block would then lose both. It returns the fees of the block's transactions,
+// which the consensus engine checks the coinbase against.
+func connectUTXOs(tx storage.Tx, block *Block, medianTime time.Time, params Params) (int, error) {
+	var spent []UTXO
+	fees := 0
+
+	for _, t := range block.Transactions {
+		if !t.IsCoinbase() {
+			utxos := make([]UTXO, 0, len(t.Inputs))
+			for _, in := range t.Inputs {
+				utxo, err := getUTXO(tx, in.TXID, in.Vout)
+				if err != nil {
+					return 0, err
+				}
+				if utxo == nil {
+					return 0, fmt.Errorf("%w: transaction %s spends missing or spent output %s:%d", ErrInvalidBlock, t.ID, in.TXID, in.Vout)
+				}
+				utxos = append(utxos, *utxo)
+			}
+
+			fee, err := ValidateTransaction(&t, utxos, block.Index, medianTime, params)
+			if err != nil {
+				return 0, fmt.Errorf("%w: transaction %s: %w", ErrInvalidBlock, t.ID, err)
+			}
+			fees += fee
+
+			for _, utxo := range utxos {
+				if err := deleteUTXO(tx, utxo); err != nil {
+					return 0, err
+				}
+			}
+			spent = append(spent, utxos...)
+		}
+
+		for i, out := range t.Outputs {
+			if out.IsData() {
+				continue
+			}
+			existing, err := getUTXO(tx, t.ID, i)
+			if err != nil {
+				return 0, err
+			}
+			if existing != nil {
+				return 0, fmt.Errorf("%w: transaction %s would overwrite unspent output %s:%d from height %d", ErrInvalidBlock, t.ID, t.ID, i, existing.Height)
+			}
+			utxo := UTXO{
+				TXID:     t.ID,
+				Index:    i,
+				Output:   out,
+				Height:   block.Index,
+				Time:     medianTime,
+				Coinbase: t.IsCoinbase(),
+			}
+			if err := putUTXO(tx, utxo); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	data, err := json.Marshal(spent)
+	if err != nil {
+		return 0, err
+	}
+	return fees, tx.Bucket(undoBucket).Put([]byte(block.Hash), data)
+}
+
+// disconnectUTXOs reverses connectUTXOs, removing the block's outputs and
+// restoring the outputs it spent from the undo data
+func disconnectUTXOs(tx storage.Tx, block *Block) error {
+	data := tx.Bucket(undoBucket).Get([]byte(block.Hash))
+	if data == nil {
+		return fmt.Errorf("undo data for block %s not found", block.Hash)
+	}
+
+	var spent []UTXO
+	if err := json.Unmarshal(data, &spent); err != nil {
+		return fmt.Errorf("decode undo data for block %s: %w", block.Hash, err)
+	}
+
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		t := block.Transactions[i]
+		for vout, out := range t.Outputs {
+			if out.IsData() {
+				continue
+			}
+			if err := deleteUTXO(tx, UTXO{TXID: t.ID, Index: vout, Output: out}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := len(spent) - 1; i >= 0; i-- {
+		if err := putUTXO(tx, spent[i]); err != nil {
+			return err
+		}
+	}
+
+	return tx.Bucket(undoBucket).Delete([]byte(block.Hash))
+}
+
+// rebuildChainstate recreates the UTXO set and undo data by replaying the
+// main chain. It is used when opening a database written before the
+// chainstate existed.
+func rebuildChainstate(tx storage.Tx, params Params) error {
+	for _, name := range [][]byte{utxoBucket, utxoAddrBucket, undoBucket} {
+		if err := tx.DeleteBucket(name); err != nil && err != storage.ErrBucketNotFound {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+
+	blocks := tx.Bucket(blocksBucket)
+	if blocks == nil {
+		return nil
+	}
+
+	// The timestamps of the last MedianTimeBlocks blocks replayed, oldest first
+	var recent []time.Time
+	return blocks.ForEach(func(k, v []byte) error {
+		block, err := Deserialize(v)
+		if err != nil {
+			return fmt.Errorf("decode block %s: %w", k, err)
+		}
+
+		medianTime := block.Timestamp
+		if len(recent) > 0 {
+			medianTime = median(append([]time.Time(nil), recent...))
+		}
+		if _, err := connectUTXOs(tx, block, medianTime, params); err != nil {
+			return err
+		}
+
+		recent = append(recent, block.Timestamp)
+		if count := max(params.MedianTimeBlocks, 1); uint64(len(recent)) > count {
+			recent = recent[1:]
+		}
+		return nil
+	})
+}
+
+// GetUTXO returns the unspent output at an outpoint, or nil if the output
+// does not exist or has been spent
+func (bc *Blockchain) GetUTXO(txid string, vout int) (*UTXO, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	var utxo *UTXO
+	err := bc.store.View(func(tx storage.Tx) error {
+		var err error
+		utxo, err = getUTXO(tx, txid, vout)
+		return err
+	})
+	return utxo, err
+}
+
+// GetUTXOsByAddress returns the unspent outputs that pay to an address,
+// including time-locked outputs and stake deposits the address cannot
+// spend yet
+func (bc *Blockchain) GetUTXOsByAddress(address string) ([]UTXO, error) {
+	pubKeyHash, err := addressPubKeyHash(address)
+	if err != nil {
+		return nil, err
+	}
+
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	var utxos []UTXO
+	err = bc.store.View(func(tx storage.Tx) error {
+		prefix := addrPrefix(pubKeyHash)
+
+		cursor := tx.Bucket(utxoAddrBucket).Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			outpoint := k[len(prefix):]
+			txid := string(outpoint[:len(outpoint)-4])
+			vout := int(binary.BigEndian.Uint32(outpoint[len(outpoint)-4:]))
+
+			utxo, err := getUTXO(tx, txid, vout)
+			if err != nil {
+				return err
+			}
+			if utxo == nil {
+				return fmt.Errorf("chainstate out of sync at %s:%d", txid, vout)
+			}
+			utxos = append(utxos, *utxo)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return utxos, nil
+}
+
+// GetBalance returns the sum of the unspent outputs that pay to an address
+func (bc *Blockchain) GetBalance(address string) (int, error) {
+	utxos, err := bc.GetUTXOsByAddress(address)
+	if err != nil {
+		return 0, err
+	}
+
+	balance := 0
+	for _, utxo := range utxos {
+		balance += utxo.Output.Value
+	}
+	return balance, nil
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxMoney is the largest value an output, or the sum of the outputs of a
+// transaction, may hold. Keeping every total below it rules out overflow.
+const MaxMoney = 21_000_000 * 100_000_000
+
+var (
+	// ErrInvalidTransaction is returned (wrapped with the reason) when a
+	// transaction breaks a consensus rule
+	ErrInvalidTransaction = errors.New("invalid transaction")
+
+	// ErrImmatureCoinbase is returned when a transaction spends a coinbase
+	// output before it has CoinbaseMaturity confirmations
+	ErrImmatureCoinbase = errors.New("immature coinbase spend")
+)
+
+// CheckTransaction checks the rules that do not depend on the chain: a
+// transaction has inputs, spends no output twice and its output values are
+// in range. A coinbase has exactly one input and may have no outputs when it
+// mints nothing.
+func CheckTransaction(tx *Transaction) error {
+	if len(tx.Inputs) == 0 {
+		return fmt.Errorf("%w: no inputs", ErrInvalidTransaction)
+	}
+	if len(tx.Outputs) == 0 && !tx.IsCoinbase() {
+		return fmt.Errorf("%w: no outputs", ErrInvalidTransaction)
+	}
+
+	total := 0
+	for i, out := range tx.Outputs {
+		if out.Value < 0 {
+			return fmt.Errorf("%w: output %d has negative value", ErrInvalidTransaction, i)
+		}
+		if out.Value > MaxMoney {
+			return fmt.Errorf("%w: output %d value exceeds the maximum", ErrInvalidTransaction, i)
+		}
+		total += out.Value
+		if total > MaxMoney {
+			return fmt.Errorf("%w: total output value exceeds the maximum", ErrInvalidTransaction)
+		}
+	}
+
+	if tx.IsCoinbase() {
+		if len(tx.Inputs) != 1 {
+			return fmt.Errorf("%w: coinbase must have exactly one input", ErrInvalidTransaction)
+		}
+		return nil
+	}
+
+	seen := make(map[string]bool, len(tx.Inputs))
+	for i, in := range tx.Inputs {
+		if in.TXID == "" || in.Vout < 0 {
+			return fmt.Errorf("%w: input %d has a null outpoint", ErrInvalidTransaction, i)
+		}
+		key := string(outpointKey(in.TXID, in.Vout))
+		if seen[key] {
+			return fmt.Errorf("%w: input %d spends %s:%d twice", ErrInvalidTransaction, i, in.TXID, in.Vout)
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
+
+// ValidateTransaction checks a non-coinbase transaction for inclusion in a
+// block at height whose parent has median time past medianTime. spent holds the output each
+// input spends, in input order. Besides CheckTransaction and the input
+// scripts it requires the transaction's time locks to have expired, coinbase
+// outputs to be mature and the inputs to cover the outputs, and returns the
+// transaction's fee.
+func ValidateTransaction(tx *Transaction, spent []UTXO, height uint64, medianTime time.Time, params Params) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, fmt.Errorf("%w: coinbase is only valid as the first transaction of a block", ErrInvalidTransaction)
+	}
+	if err := CheckTransaction(tx); err != nil {
+		return 0, err
+	}
+	if len(spent) != len(tx.Inputs) {
+		return 0, fmt.Errorf("%w: expected %d spent outputs, got %d", ErrInvalidTransaction, len(tx.Inputs), len(spent))
+	}
+	if !tx.IsFinal(height, medianTime) {
+		return 0, fmt.Errorf("%w: lock time %d not reached", ErrTimeLocked, tx.LockTime)
+	}
+	if err := tx.checkSequenceLocks(spent, height, medianTime); err != nil {
+		return 0, err
+	}
+
+	prevOuts := make([]TXOutput, len(spent))
+	total := 0
+	for i, utxo := range spent {
+		if utxo.Coinbase && height < utxo.Height+params.CoinbaseMaturity {
+			return 0, fmt.Errorf("%w: input %d spends a coinbase from height %d at height %d", ErrImmatureCoinbase, i, utxo.Height, height)
+		}
+		if utxo.Output.Value < 0 || utxo.Output.Value > MaxMoney {
+			return 0, fmt.Errorf("%w: input %d value out of range", ErrInvalidTransaction, i)
+		}
+		total += utxo.Output.Value
+		if total > MaxMoney {
+			return 0, fmt.Errorf("%w: total input value exceeds the maximum", ErrInvalidTransaction)
+		}
+		prevOuts[i] = utxo.Output
+	}
+
+	if err := tx.VerifyInputs(prevOuts); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidTransaction, err)
+	}
+
+	fee := tx.Fee(prevOuts)
+	if fee < 0 {
+		return 0, fmt.Errorf("%w: outputs spend %d more than the inputs hold", ErrInvalidTransaction, -fee)
+	}
+	return fee, nil
+}
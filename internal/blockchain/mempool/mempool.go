@@ -0,0 +1,426 @@
+package mempool
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+)
+
+var (
+	// ErrAlreadyExists is returned when a transaction is already in the pool
+	ErrAlreadyExists = errors.New("transaction already in pool")
+
+	// ErrInvalidTransaction is returned (wrapped with the reason) for
+	// transactions that break a consensus rule
+	ErrInvalidTransaction = core.ErrInvalidTransaction
+
+	// ErrMissingInputs is returned when an input refers to an output that is
+	// neither unspent on the main chain nor created by a pooled transaction
+	ErrMissingInputs = errors.New("missing inputs")
+
+	// ErrDoubleSpend is returned when an input is already spent by a pooled transaction
+	ErrDoubleSpend = errors.New("double spend")
+
+	// ErrPoolFull is returned when the pool is full and the transaction pays
+	// no more than the cheapest entry
+	ErrPoolFull = errors.New("mempool full")
+)
+
+// Options configures a Mempool
+type Options struct {
+	// MaxTransactions is the number of transactions the pool holds before
+	// evicting the entries with the lowest fee rate
+	MaxTransactions int
+
+	// Expiry is how long a transaction may wait in the pool before it is dropped
+	Expiry time.Duration
+}
+
+// DefaultOptions returns the default mempool options
+func DefaultOptions() Options {
+	return Options{
+		MaxTransactions: 5000,
+		Expiry:          72 * time.Hour,
+	}
+}
+
+// Entry is a transaction waiting in the pool
+type Entry struct {
+	Tx      *core.Transaction
+	Fee     int
+	Size    int
+	Added   time.Time
+	FeeRate float64 // fee per byte of the serialized transaction
+}
+
+// outpoint identifies a transaction output
+type outpoint struct {
+	txid string
+	vout int
+}
+
+// Mempool holds validated transactions that are not in a block yet. It
+// follows the main chain: mined transactions are removed when their block is
+// connected and transactions of disconnected blocks are put back.
+type Mempool struct {
+	chain   *core.Blockchain
+	opts    Options
+	entries map[string]*Entry
+	spends  map[outpoint]string // outpoint -> ID of the pooled transaction spending it
+	mu      sync.RWMutex
+}
+
+// NewMempool creates an empty pool for a blockchain and subscribes it to
+// main chain changes
+func NewMempool(chain *core.Blockchain, opts Options) *Mempool {
+	if opts.MaxTransactions <= 0 {
+		opts.MaxTransactions = DefaultOptions().MaxTransactions
+	}
+	if opts.Expiry <= 0 {
+		opts.Expiry = DefaultOptions().Expiry
+	}
+
+	pool := &Mempool{
+		chain:   chain,
+		opts:    opts,
+		entries: make(map[string]*Entry),
+		spends:  make(map[outpoint]string),
+	}
+	chain.Subscribe(pool)
+
+	return pool
+}
+
+// Add validates a transaction against the UTXO set and the pool and adds it
+func (p *Mempool) Add(tx *core.Transaction) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.chain.Now()
+	p.expire(now)
+	return p.add(tx, now)
+}
+
+// add implements Add. The caller must hold p.mu.
+func (p *Mempool) add(tx *core.Transaction, now time.Time) error {
+	if _, ok := p.entries[tx.ID]; ok {
+		return ErrAlreadyExists
+	}
+
+	entry, err := p.validate(tx, now)
+	if err != nil {
+		return err
+	}
+
+	// Make room by evicting cheaper entries. The transaction's own
+	// ancestors are never evicted, since that would leave it spending
+	// outputs that no longer exist.
+	if len(p.entries) >= p.opts.MaxTransactions {
+		cheapest := p.cheapest(p.ancestors(tx))
+		if cheapest == nil || cheapest.FeeRate >= entry.FeeRate {
+			return ErrPoolFull
+		}
+		p.remove(cheapest.Tx.ID, true)
+	}
+
+	p.entries[tx.ID] = entry
+	for _, in := range tx.Inputs {
+		p.spends[outpoint{in.TXID, in.Vout}] = tx.ID
+	}
+
+	return nil
+}
+
+// validate checks a transaction with the consensus rules for inclusion in
+// the next block and computes its pool entry
+func (p *Mempool) validate(tx *core.Transaction, now time.Time) (*Entry, error) {
+	if tx.IsCoinbase() {
+		return nil, fmt.Errorf("%w: coinbase transactions are only valid in blocks", ErrInvalidTransaction)
+	}
+	if tx.ID != hex.EncodeToString(tx.Hash()) {
+		return nil, fmt.Errorf("%w: ID does not match contents", ErrInvalidTransaction)
+	}
+	if err := core.CheckTransaction(tx); err != nil {
+		return nil, err
+	}
+
+	// The transaction is checked for the block after the tip, whose time
+	// locks are measured against the tip's median time past
+	tip := p.chain.GetLatestBlock()
+	height := tip.Index + 1
+	medianTime := p.chain.MedianTimePast(tip.Hash)
+
+	// Find the output spent by every input
+	spent := make([]core.UTXO, 0, len(tx.Inputs))
+	for _, in := range tx.Inputs {
+		op := outpoint{in.TXID, in.Vout}
+		if spender, ok := p.spends[op]; ok && spender != tx.ID {
+			return nil, fmt.Errorf("%w: %s:%d already spent by %s", ErrDoubleSpend, in.TXID, in.Vout, spender)
+		}
+
+		utxo, err := p.findOutput(op, height, medianTime)
+		if err != nil {
+			return nil, err
+		}
+		spent = append(spent, *utxo)
+	}
+
+	fee, err := core.ValidateTransaction(tx, spent, height, medianTime, p.chain.Params())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Entry{
+		Tx:      tx,
+		Fee:     fee,
+		Size:    len(data),
+		Added:   now,
+		FeeRate: float64(fee) / float64(len(data)),
+	}, nil
+}
+
+// findOutput returns an output that is unspent on the main chain or created
+// by a pooled transaction. Outputs of pooled transactions are treated as if
+// they were confirmed in the next block, at height and median time past
+// medianTime.
+func (p *Mempool) findOutput(op outpoint, height uint64, medianTime time.Time) (*core.UTXO, error) {
+	if parent, ok := p.entries[op.txid]; ok {
+		if op.vout < 0 || op.vout >= len(parent.Tx.Outputs) {
+			return nil, fmt.Errorf("%w: %s:%d does not exist", ErrMissingInputs, op.txid, op.vout)
+		}
+		return &core.UTXO{
+			TXID:   op.txid,
+			Index:  op.vout,
+			Output: parent.Tx.Outputs[op.vout],
+			Height: height,
+			Time:   medianTime,
+		}, nil
+	}
+
+	utxo, err := p.chain.GetUTXO(op.txid, op.vout)
+	if err != nil {
+		return nil, err
+	}
+	if utxo == nil {
+		return nil, fmt.Errorf("%w: %s:%d is not unspent", ErrMissingInputs, op.txid, op.vout)
+	}
+	return utxo, nil
+}
+
+// remove drops a transaction. With descendants set, pooled transactions
+// spending its outputs are dropped too, since they can no longer be valid.
+// The caller must hold p.mu.
+func (p *Mempool) remove(txid string, descendants bool) {
+	entry, ok := p.entries[txid]
+	if !ok {
+		return
+	}
+
+	delete(p.entries, txid)
+	for _, in := range entry.Tx.Inputs {
+		op := outpoint{in.TXID, in.Vout}
+		if p.spends[op] == txid {
+			delete(p.spends, op)
+		}
+	}
+
+	if descendants {
+		for vout := range entry.Tx.Outputs {
+			if child, ok := p.spends[outpoint{txid, vout}]; ok {
+				p.remove(child, true)
+			}
+		}
+	}
+}
+
+// cheapest returns the entry with the lowest fee rate that is not in
+// exclude. The caller must hold p.mu.
+func (p *Mempool) cheapest(exclude map[string]bool) *Entry {
+	var cheapest *Entry
+	for txid, entry := range p.entries {
+		if exclude[txid] {
+			continue
+		}
+		if cheapest == nil || entry.FeeRate < cheapest.FeeRate {
+			cheapest = entry
+		}
+	}
+	return cheapest
+}
+
+// ancestors returns the IDs of the pooled transactions whose outputs tx
+// spends, directly or through other pooled transactions. The caller must
+// hold p.mu.
+func (p *Mempool) ancestors(tx *core.Transaction) map[string]bool {
+	ancestors := make(map[string]bool)
+	pending := []*core.Transaction{tx}
+	for len(pending) > 0 {
+		next := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		for _, in := range next.Inputs {
+			parent, ok := p.entries[in.TXID]
+			if !ok || ancestors[in.TXID] {
+				continue
+			}
+			ancestors[in.TXID] = true
+			pending = append(pending, parent.Tx)
+		}
+	}
+	return ancestors
+}
+
+// revalidate checks every pooled transaction against the current main chain
+// and drops the ones that are no longer valid together with their
+// descendants. The caller must hold p.mu.
+func (p *Mempool) revalidate(now time.Time) {
+	txids := make([]string, 0, len(p.entries))
+	for txid := range p.entries {
+		txids = append(txids, txid)
+	}
+
+	for _, txid := range txids {
+		// Dropped already as the descendant of an invalid transaction
+		entry, ok := p.entries[txid]
+		if !ok {
+			continue
+		}
+		if _, err := p.validate(entry.Tx, now); err != nil {
+			p.remove(txid, true)
+		}
+	}
+}
+
+// expire drops entries older than the expiry. The caller must hold p.mu.
+func (p *Mempool) expire(now time.Time) {
+	for txid, entry := range p.entries {
+		if now.Sub(entry.Added) > p.opts.Expiry {
+			p.remove(txid, true)
+		}
+	}
+}
+
+// Expire drops every transaction that has waited longer than the expiry
+func (p *Mempool) Expire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire(p.chain.Now())
+}
+
+// Has reports whether a transaction is in the pool
+func (p *Mempool) Has(txid string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	_, ok := p.entries[txid]
+	return ok
+}
+
+// Get returns a pooled transaction, or nil if it is not in the pool
+func (p *Mempool) Get(txid string) *core.Transaction {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if entry, ok := p.entries[txid]; ok {
+		return entry.Tx
+	}
+	return nil
+}
+
+// Count returns the number of pooled transactions
+func (p *Mempool) Count() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.entries)
+}
+
+// Entries returns the pooled entries ordered by fee rate, highest first.
+// Entries with equal fee rates keep their arrival order.
+func (p *Mempool) Entries() []Entry {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	entries := make([]Entry, 0, len(p.entries))
+	for _, entry := range p.entries {
+		entries = append(entries, *entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].FeeRate != entries[j].FeeRate {
+			return entries[i].FeeRate > entries[j].FeeRate
+		}
+		if !entries[i].Added.Equal(entries[j].Added) {
+			return entries[i].Added.Before(entries[j].Added)
+		}
+		return entries[i].Tx.ID < entries[j].Tx.ID
+	})
+
+	return entries
+}
+
+// Transactions returns the pooled transactions ordered by fee rate, highest first
+func (p *Mempool) Transactions() []*core.Transaction {
+	entries := p.Entries()
+
+	txs := make([]*core.Transaction, len(entries))
+	for i, entry := range entries {
+		txs[i] = entry.Tx
+	}
+	return txs
+}
+
+// BlockConnected removes the block's transactions and any pooled
+// transactions that conflict with them
+func (p *Mempool) BlockConnected(block *core.Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		// A mined transaction leaves the pool but its children stay valid
+		p.remove(tx.ID, false)
+
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			if spender, ok := p.spends[outpoint{in.TXID, in.Vout}]; ok {
+				p.remove(spender, true)
+			}
+		}
+	}
+
+	p.expire(p.chain.Now())
+}
+
+// BlockDisconnected puts the block's transactions back into the pool and
+// checks the whole pool against the new tip. Transactions that are no longer
+// valid, such as ones spending outputs of the disconnected block or whose
+// inputs the new branch spends, are dropped with their descendants.
+func (p *Mempool) BlockDisconnected(block *core.Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.chain.Now()
+	for i := range block.Transactions {
+		tx := block.Transactions[i]
+		if tx.IsCoinbase() {
+			continue
+		}
+		p.add(&tx, now)
+	}
+
+	p.revalidate(now)
+}
@@ -0,0 +1,281 @@
+// Package mining builds block templates for miners running outside the node
+// and turns the nonces they find into blocks.
+package mining
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/consensus"
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/mempool"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+var (
+	// ErrUnknownTemplate is returned for a solution to a template the node
+	// never handed out or has forgotten
+	ErrUnknownTemplate = errors.New("unknown block template")
+
+	// ErrStaleTemplate is returned for a solution to a template built on a
+	// block that is no longer the chain tip
+	ErrStaleTemplate = errors.New("block template is stale")
+
+	// ErrInvalidPayout is returned when a template is requested without a
+	// valid payout address
+	ErrInvalidPayout = errors.New("invalid payout address")
+)
+
+// Options configures a Manager
+type Options struct {
+	// PayoutAddress receives the coinbase of templates requested without
+	// an address of their own
+	PayoutAddress string
+
+	// MaxTransactions is the number of pooled transactions a template
+	// carries at most, besides its coinbase
+	MaxTransactions int
+
+	// MaxTemplates is the number of templates on the tip the manager
+	// remembers. Solutions to older templates are refused as unknown.
+	MaxTemplates int
+
+	// Miner configures the proof of work search of Generate
+	Miner consensus.MinerOptions
+}
+
+// DefaultOptions returns the default template options
+func DefaultOptions() Options {
+	return Options{
+		MaxTransactions: 1000,
+		MaxTemplates:    100,
+		Miner:           consensus.DefaultMinerOptions(),
+	}
+}
+
+// Manager hands out block templates on the chain tip and accepts solutions
+// to them. Templates go stale when the tip changes; solutions to stale
+// templates are refused, as are solutions to templates so old that the
+// manager no longer remembers them.
+type Manager struct {
+	chain *core.Blockchain
+	pool  *mempool.Mempool
+	opts  Options
+
+	mu        sync.Mutex
+	tip       string
+	seq       uint64
+	templates map[string]*Template // templates on the tip by ID
+	order     []string             // IDs of the templates on the tip, oldest first
+	stale     map[string]*Template // templates on the previous tip
+	changed   chan struct{}        // closed when the tip changes
+}
+
+// NewManager creates a template manager for a chain and subscribes it to
+// main chain changes. pool supplies the transactions of templates and may
+// be nil for templates with only a coinbase.
+func NewManager(chain *core.Blockchain, pool *mempool.Mempool, opts Options) *Manager {
+	if opts.MaxTransactions <= 0 {
+		opts.MaxTransactions = DefaultOptions().MaxTransactions
+	}
+	if opts.MaxTemplates <= 0 {
+		opts.MaxTemplates = DefaultOptions().MaxTemplates
+	}
+
+	m := &Manager{
+		chain:     chain,
+		pool:      pool,
+		opts:      opts,
+		tip:       chain.GetLatestBlock().Hash,
+		templates: make(map[string]*Template),
+		stale:     make(map[string]*Template),
+		changed:   make(chan struct{}),
+	}
+	chain.Subscribe(m)
+
+	return m
+}
+
+// Template builds a template on the chain tip whose coinbase pays the block
+// subsidy and the fees of its transactions to payout, or to the manager's
+// payout address if payout is empty
+func (m *Manager) Template(payout string) (*Template, error) {
+	if payout == "" {
+		payout = m.opts.PayoutAddress
+	}
+	if !wallet.ValidateAddress(payout) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPayout, payout)
+	}
+
+	for {
+		tip := m.chain.GetLatestBlock()
+
+		m.mu.Lock()
+		m.seq++
+		seq := m.seq
+		m.mu.Unlock()
+
+		block, value, err := m.newBlock(tip, payout, seq)
+		if err != nil {
+			return nil, err
+		}
+		template, err := newTemplate(fmt.Sprintf("%.16s-%d", tip.Hash, seq), block, value)
+		if err != nil {
+			return nil, err
+		}
+
+		// Keep the template unless the tip moved while it was built,
+		// forgetting the oldest one once MaxTemplates are kept
+		m.mu.Lock()
+		current := m.tip == tip.Hash
+		if current {
+			m.templates[template.ID] = template
+			m.order = append(m.order, template.ID)
+			for len(m.order) > m.opts.MaxTemplates {
+				delete(m.templates, m.order[0])
+				m.order = m.order[1:]
+			}
+		}
+		m.mu.Unlock()
+
+		if current {
+			return template, nil
+		}
+	}
+}
+
+// newBlock assembles an unsealed block on tip. The sequence number goes
+// into the coinbase so every template has its own header.
+func (m *Manager) newBlock(tip *core.Block, payout string, seq uint64) (*core.Block, int, error) {
+	parent := m.chain.Header(tip.Hash)
+	if parent == nil {
+		return nil, 0, fmt.Errorf("chain tip %s has no header", tip.Hash)
+	}
+	bits, err := consensus.NextBits(m.chain, parent)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var txs []core.Transaction
+	fees := 0
+	if m.pool != nil {
+		txs, fees = selectTransactions(m.pool.Entries(), m.opts.MaxTransactions)
+	}
+
+	height := tip.Index + 1
+	value := m.chain.Params().Subsidy(height) + fees
+	timestamp := m.chain.NextTimestamp(tip)
+	coinbase, err := core.NewCoinbaseTransaction(payout, "", value)
+	if err != nil {
+		return nil, 0, err
+	}
+	coinbase.Inputs[0].ScriptSig = binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, height), seq)
+	coinbase.Time = timestamp
+	coinbase.SetID()
+
+	block := &core.Block{
+		Version:      core.BlockVersion,
+		Index:        height,
+		Timestamp:    timestamp,
+		PreviousHash: tip.Hash,
+		Transactions: append([]core.Transaction{*coinbase}, txs...),
+		Bits:         bits,
+	}
+	block.MerkleRoot = block.ComputeMerkleRoot()
+	block.Hash = block.CalculateHash()
+
+	return block, value, nil
+}
+
+// Submit turns a solution into a block and adds it to the chain. It returns
+// the block so the caller can relay it to peers.
+func (m *Manager) Submit(solution Solution) (*core.Block, error) {
+	m.mu.Lock()
+	template, ok := m.templates[solution.TemplateID]
+	_, stale := m.stale[solution.TemplateID]
+	m.mu.Unlock()
+
+	if stale {
+		return nil, fmt.Errorf("%w: %s", ErrStaleTemplate, solution.TemplateID)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTemplate, solution.TemplateID)
+	}
+
+	block := template.Block(solution.Nonce)
+	if _, err := m.chain.ProcessBlock(block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// Generate mines n blocks in a row from templates paying payout and returns
+// them. It suits networks such as regtest whose target is met in a few
+// hashes; on other networks it takes as long as mining does.
+func (m *Manager) Generate(ctx context.Context, n int, payout string) ([]*core.Block, error) {
+	// Rolling the extra nonce would change the block the template describes
+	miner := m.opts.Miner
+	miner.MaxNonce = 0
+
+	blocks := make([]*core.Block, 0, n)
+	for len(blocks) < n {
+		template, err := m.Template(payout)
+		if err != nil {
+			return blocks, err
+		}
+
+		nonce, _, err := consensus.NewProofOfWork(template.Block(0)).Run(ctx, miner)
+		if err != nil {
+			return blocks, err
+		}
+		block, err := m.Submit(Solution{TemplateID: template.ID, Nonce: nonce})
+		if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// Wait blocks until the template with the given ID is stale or ctx is
+// done. It returns at once for templates that are not current.
+func (m *Manager) Wait(ctx context.Context, id string) error {
+	m.mu.Lock()
+	_, current := m.templates[id]
+	changed := m.changed
+	m.mu.Unlock()
+
+	if !current {
+		return nil
+	}
+
+	select {
+	case <-changed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// BlockConnected makes the templates on the old tip stale
+func (m *Manager) BlockConnected(block *core.Block) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// A reorganization connects several blocks in a row; remember the
+	// templates of the last tip that had any
+	m.tip = block.Hash
+	if len(m.templates) > 0 {
+		m.stale = m.templates
+		m.templates = make(map[string]*Template)
+		m.order = nil
+	}
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// BlockDisconnected does nothing; the blocks connected after it change the tip
+func (m *Manager) BlockDisconnected(block *core.Block) {}
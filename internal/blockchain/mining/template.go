@@ -0,0 +1,123 @@
+package mining
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/mempool"
+)
+
+// Template is a block waiting for its proof of work. External miners
+// receive it, search for a nonce that brings the header hash below the
+// target and submit the nonce as a Solution.
+type Template struct {
+	// ID names the template in solutions
+	ID string `json:"id"`
+
+	Version      uint32    `json:"version"`
+	Height       uint64    `json:"height"`
+	PreviousHash string    `json:"previousHash"`
+	MerkleRoot   string    `json:"merkleRoot"`
+	Timestamp    time.Time `json:"timestamp"`
+	Bits         uint32    `json:"bits"`
+
+	// Target is the hex encoded 256-bit target the header hash must fall below
+	Target string `json:"target"`
+
+	// Header is the hex encoded binary header with a zero nonce. The nonce
+	// is its last eight bytes, big-endian.
+	Header string `json:"header"`
+
+	// CoinbaseValue is what the coinbase pays: the subsidy plus the fees
+	CoinbaseValue int `json:"coinbaseValue"`
+
+	// Transactions is the block body, starting with the coinbase
+	Transactions []core.Transaction `json:"transactions"`
+}
+
+// Solution is a nonce a miner found for a template
+type Solution struct {
+	TemplateID string `json:"templateId"`
+	Nonce      uint64 `json:"nonce"`
+}
+
+// newTemplate describes a block as a template
+func newTemplate(id string, block *core.Block, coinbaseValue int) (*Template, error) {
+	header, err := block.Header()
+	if err != nil {
+		return nil, err
+	}
+	header.Nonce = 0
+
+	return &Template{
+		ID:            id,
+		Version:       block.Version,
+		Height:        block.Index,
+		PreviousHash:  block.PreviousHash,
+		MerkleRoot:    block.MerkleRoot,
+		Timestamp:     block.Timestamp,
+		Bits:          block.Bits,
+		Target:        fmt.Sprintf("%064x", core.CompactToBig(block.Bits)),
+		Header:        fmt.Sprintf("%x", header.Serialize()),
+		CoinbaseValue: coinbaseValue,
+		Transactions:  block.Transactions,
+	}, nil
+}
+
+// Block returns the block the template describes, with the given nonce
+func (t *Template) Block(nonce uint64) *core.Block {
+	block := &core.Block{
+		Version:      t.Version,
+		Index:        t.Height,
+		Timestamp:    t.Timestamp,
+		PreviousHash: t.PreviousHash,
+		MerkleRoot:   t.MerkleRoot,
+		Transactions: t.Transactions,
+		Nonce:        nonce,
+		Bits:         t.Bits,
+	}
+	block.Hash = block.CalculateHash()
+	return block
+}
+
+// selectTransactions picks pooled transactions by fee rate, highest first,
+// up to max. A transaction spending the output of another pooled
+// transaction is only picked after that transaction, so the block connects
+// in order. It returns the picked transactions and their fees.
+func selectTransactions(entries []mempool.Entry, max int) ([]core.Transaction, int) {
+	pooled := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		pooled[entry.Tx.ID] = true
+	}
+
+	var txs []core.Transaction
+	fees := 0
+	picked := make(map[string]bool)
+	for progress := true; progress && len(txs) < max; {
+		progress = false
+		for _, entry := range entries {
+			if picked[entry.Tx.ID] || len(txs) >= max {
+				continue
+			}
+
+			ready := true
+			for _, in := range entry.Tx.Inputs {
+				if pooled[in.TXID] && !picked[in.TXID] {
+					ready = false
+					break
+				}
+			}
+			if !ready {
+				continue
+			}
+
+			picked[entry.Tx.ID] = true
+			txs = append(txs, *entry.Tx)
+			fees += entry.Fee
+			progress = true
+		}
+	}
+
+	return txs, fees
+}
@@ -1,13 +1,15 @@
 package network
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/mempool"
 )
 
 // Server represents a P2P node in the blockchain network
@@ -16,26 +18,72 @@ type Server struct {
 	port         int
 	listener     net.Listener
 	blockchain   *core.Blockchain
+	mempool      *mempool.Mempool
 	peers        map[string]*Peer
 	peersMutex   sync.RWMutex
 	newPeerChan  chan *Peer
 	deadPeerChan chan *Peer
 	messageChan  chan Message
+	magic        uint32
+	clock        *core.NetworkClock
 }
 
 // NewServer creates a new P2P server
-func NewServer(host string, port int, blockchain *core.Blockchain) *Server {
+func NewServer(host string, port int, blockchain *core.Blockchain, pool *mempool.Mempool) *Server {
 	return &Server{
 		host:         host,
 		port:         port,
 		blockchain:   blockchain,
+		mempool:      pool,
 		peers:        make(map[string]*Peer),
 		newPeerChan:  make(chan *Peer),
 		deadPeerChan: make(chan *Peer),
 		messageChan:  make(chan Message),
+		magic:        MagicMain,
 	}
 }
 
+// SetMagic selects the network whose envelopes the server exchanges, see
+// NetworkMagic. It must be called before the server starts.
+func (s *Server) SetMagic(magic uint32) {
+	s.magic = magic
+}
+
+// SetClock makes the server report the clocks of peers from their
+// handshakes to a network clock
+func (s *Server) SetClock(clock *core.NetworkClock) {
+	s.clock = clock
+}
+
+// handshake returns the handshake the server sends to a peer at addrTo
+func (s *Server) handshake(addrTo string) Handshake {
+	return Handshake{
+		Version:    1,
+		AddrFrom:   fmt.Sprintf("%s:%d", s.host, s.port),
+		AddrTo:     addrTo,
+		ListenPort: s.port,
+		Timestamp:  time.Now().Unix(),
+	}
+}
+
+// receiveHandshake waits for a peer's handshake and samples its clock
+func (s *Server) receiveHandshake(peer *Peer) (Handshake, error) {
+	handshake, err := peer.ReceiveHandshake()
+	if err != nil {
+		return handshake, err
+	}
+
+	// Peers are sampled by host so reconnecting does not add samples
+	if s.clock != nil && handshake.Timestamp != 0 {
+		host, _, err := net.SplitHostPort(peer.Addr())
+		if err != nil {
+			host = peer.Addr()
+		}
+		s.clock.AddSample(host, time.Unix(handshake.Timestamp, 0))
+	}
+	return handshake, nil
+}
+
 // Start starts the P2P server
 func (s *Server) Start() error {
 	// Start listening for incoming connections
@@ -68,24 +116,17 @@ func (s *Server) Start() error {
 // handleConnection handles a new incoming connection
 func (s *Server) handleConnection(conn net.Conn) {
 	// Create a new peer
-	peer := NewPeer(conn, s.messageChan, s.deadPeerChan)
+	peer := NewPeer(conn, s.magic, s.messageChan, s.deadPeerChan)
 
 	// Send handshake
-	handshake := Handshake{
-		Version:    1,
-		AddrFrom:   fmt.Sprintf("%s:%d", s.host, s.port),
-		AddrTo:     conn.RemoteAddr().String(),
-		ListenPort: s.port,
-	}
-
-	if err := peer.SendHandshake(handshake); err != nil {
+	if err := peer.SendHandshake(s.handshake(conn.RemoteAddr().String())); err != nil {
 		log.Printf("Error sending handshake: %v", err)
 		conn.Close()
 		return
 	}
 
 	// Wait for handshake response
-	response, err := peer.ReceiveHandshake()
+	response, err := s.receiveHandshake(peer)
 	if err != nil {
 		log.Printf("Error receiving handshake: %v", err)
 		conn.Close()
@@ -106,12 +147,13 @@ func (s *Server) handleNewPeers() {
 		s.peersMutex.Unlock()
 
 		log.Printf("New peer connected: %s", peer.Addr())
+		go peer.ListenForMessages()
 
 		// Send latest block to the new peer
 		latestBlock := s.blockchain.GetLatestBlock()
 		if latestBlock != nil {
 			msg := Message{
-				Type:    "latest_block",
+				Type:    CmdLatestBlock,
 				Payload: latestBlock,
 			}
 			peer.SendMessage(msg)
@@ -133,59 +175,66 @@ func (s *Server) handleDeadPeers() {
 // handleMessages handles incoming messages from peers
 func (s *Server) handleMessages() {
 	for msg := range s.messageChan {
-		switch msg.Type {
-		case "block":
-			s.handleBlockMessage(msg)
-		case "transaction":
-			s.handleTransactionMessage(msg)
-		case "get_blocks":
-			s.handleGetBlocksMessage(msg)
-		case "inventory":
-			s.handleInventoryMessage(msg)
+		switch payload := msg.Payload.(type) {
+		case *core.Block:
+			s.handleBlockMessage(payload)
+		case *core.Transaction:
+			s.handleTransactionMessage(payload)
+		case *GetBlocks:
+			s.handleGetBlocksMessage(payload)
+		case *Inventory:
+			s.handleInventoryMessage(payload)
 		default:
-			log.Printf("Unknown message type: %s", msg.Type)
+			log.Printf("Unexpected %s message", msg.Type)
 		}
 	}
 }
 
-// handleBlockMessage handles incoming block messages
-func (s *Server) handleBlockMessage(msg Message) {
-	// Deserialize the block
-	blockData, err := json.Marshal(msg.Payload)
+// handleBlockMessage handles incoming block and latest block messages
+func (s *Server) handleBlockMessage(block *core.Block) {
+	// Add the block to the block tree; it may extend a side branch or wait
+	// for its parent as an orphan
+	status, err := s.blockchain.ProcessBlock(block)
 	if err != nil {
-		log.Printf("Error marshaling block: %v", err)
+		log.Printf("Rejected block #%d: %v", block.Index, err)
 		return
 	}
 
-	block, err := core.Deserialize(blockData)
-	if err != nil {
-		log.Printf("Error deserializing block: %v", err)
-		return
+	switch status {
+	case core.BlockOrphan:
+		log.Printf("Received orphan block #%d", block.Index)
+	case core.BlockSideChain:
+		log.Printf("Received side chain block #%d", block.Index)
+	default:
+		log.Printf("Received and added block #%d", block.Index)
 	}
-
-	// Add the block to the blockchain
-	// Note: In a real implementation, we would validate the block before adding it
-	s.blockchain.AddBlockManually(block)
-
-	log.Printf("Received and added block #%d", block.Index)
 }
 
 // handleTransactionMessage handles incoming transaction messages
-func (s *Server) handleTransactionMessage(msg Message) {
-	// In a real implementation, we would deserialize and validate the transaction
-	log.Printf("Received transaction message")
+func (s *Server) handleTransactionMessage(tx *core.Transaction) {
+	// Validate the transaction against the UTXO set and the mempool. Known
+	// transactions are not relayed again, which stops them from circulating.
+	if err := s.mempool.Add(tx); err != nil {
+		if !errors.Is(err, mempool.ErrAlreadyExists) {
+			log.Printf("Rejected transaction %s: %v", tx.ID, err)
+		}
+		return
+	}
+
+	log.Printf("Accepted transaction %s", tx.ID)
+	s.BroadcastTransaction(tx)
 }
 
 // handleGetBlocksMessage handles get_blocks messages
-func (s *Server) handleGetBlocksMessage(msg Message) {
+func (s *Server) handleGetBlocksMessage(req *GetBlocks) {
 	// In a real implementation, we would send the requested blocks to the peer
-	log.Printf("Received get_blocks message")
+	log.Printf("Received get_blocks message for %d blocks from #%d", req.Limit, req.From)
 }
 
 // handleInventoryMessage handles inventory messages
-func (s *Server) handleInventoryMessage(msg Message) {
+func (s *Server) handleInventoryMessage(inv *Inventory) {
 	// In a real implementation, we would request the items in the inventory
-	log.Printf("Received inventory message")
+	log.Printf("Received inventory message with %d items", len(inv.Items))
 }
 
 // ConnectToPeer connects to a remote peer
@@ -197,17 +246,14 @@ func (s *Server) ConnectToPeer(address string) error {
 	}
 
 	// Create a new peer
-	peer := NewPeer(conn, s.messageChan, s.deadPeerChan)
+	peer := NewPeer(conn, s.magic, s.messageChan, s.deadPeerChan)
 
-	// Send handshake
-	handshake := Handshake{
-		Version:    1,
-		AddrFrom:   fmt.Sprintf("%s:%d", s.host, s.port),
-		AddrTo:     address,
-		ListenPort: s.port,
+	// Exchange handshakes
+	if err := peer.SendHandshake(s.handshake(address)); err != nil {
+		conn.Close()
+		return err
 	}
-
-	if err := peer.SendHandshake(handshake); err != nil {
+	if _, err := s.receiveHandshake(peer); err != nil {
 		conn.Close()
 		return err
 	}
@@ -232,6 +278,16 @@ func (s *Server) BroadcastMessage(msg Message) {
 	}
 }
 
+// BroadcastBlock relays a block to all connected peers
+func (s *Server) BroadcastBlock(block *core.Block) {
+	s.BroadcastMessage(Message{Type: CmdBlock, Payload: block})
+}
+
+// BroadcastTransaction relays a transaction to all connected peers
+func (s *Server) BroadcastTransaction(tx *core.Transaction) {
+	s.BroadcastMessage(Message{Type: CmdTransaction, Payload: tx})
+}
+
 // Stop stops the P2P server
 func (s *Server) Stop() error {
 	if s.listener != nil {
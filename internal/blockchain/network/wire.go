@@ -0,0 +1,219 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+)
+
+// Every message travels in a binary envelope. All integers are encoded
+// big-endian in the order below:
+//
+//	magic     uint32   4 bytes, identifies the network
+//	version   uint32   4 bytes, ProtocolVersion
+//	command            12 bytes, ASCII padded with zero bytes
+//	length    uint32   4 bytes, length of the payload
+//	checksum           4 bytes, first bytes of SHA-256(SHA-256(payload))
+//	payload            length bytes, the JSON encoding of the command's payload
+const (
+	// ProtocolVersion is the envelope version written by this implementation
+	ProtocolVersion uint32 = 1
+
+	// EnvelopeSize is the length of an encoded envelope without its payload
+	EnvelopeSize = 4 + 4 + commandSize + 4 + 4
+
+	// MaxPayloadSize bounds the payload a peer may announce, so a forged
+	// length cannot make us allocate without limit
+	MaxPayloadSize = 32 << 20
+
+	commandSize = 12
+)
+
+// Magic values identifying the network of an envelope
+const (
+	MagicMain    uint32 = 0xc0bbc0de
+	MagicRegtest uint32 = 0xfabfb5da
+)
+
+// NetworkMagic returns the magic value of a named network
+func NetworkMagic(network string) (uint32, error) {
+	switch network {
+	case "", core.NetworkMain:
+		return MagicMain, nil
+	case core.NetworkRegtest:
+		return MagicRegtest, nil
+	default:
+		return 0, fmt.Errorf("%w: %s", core.ErrUnknownNetwork, network)
+	}
+}
+
+// Commands and the payloads they carry
+const (
+	CmdHandshake   = "handshake"    // *Handshake
+	CmdBlock       = "block"        // *core.Block
+	CmdLatestBlock = "latest_block" // *core.Block
+	CmdTransaction = "transaction"  // *core.Transaction
+	CmdGetBlocks   = "get_blocks"   // *GetBlocks
+	CmdInventory   = "inventory"    // *Inventory
+)
+
+var (
+	// ErrInvalidFrame is returned (wrapped with the reason) for an envelope
+	// that is malformed, belongs to another network or protocol version, or
+	// whose payload does not decode. Peers sending one are disconnected.
+	ErrInvalidFrame = errors.New("invalid message frame")
+
+	// ErrUnknownCommand is returned for a well formed envelope with a
+	// command this node does not know. The envelope has been read in full,
+	// so the next one can follow.
+	ErrUnknownCommand = errors.New("unknown command")
+)
+
+// GetBlocks asks a peer for up to Limit blocks of its main chain starting at height From
+type GetBlocks struct {
+	From  uint64 `json:"from"`
+	Limit int    `json:"limit"`
+}
+
+// InvItem names a block or transaction a peer has
+type InvItem struct {
+	Type string `json:"type"` // CmdBlock or CmdTransaction
+	Hash string `json:"hash"`
+}
+
+// Inventory announces blocks and transactions to a peer
+type Inventory struct {
+	Items []InvItem `json:"items"`
+}
+
+// newPayload returns an empty payload of the type a command carries
+func newPayload(command string) (interface{}, error) {
+	switch command {
+	case CmdHandshake:
+		return &Handshake{}, nil
+	case CmdBlock, CmdLatestBlock:
+		return &core.Block{}, nil
+	case CmdTransaction:
+		return &core.Transaction{}, nil
+	case CmdGetBlocks:
+		return &GetBlocks{}, nil
+	case CmdInventory:
+		return &Inventory{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCommand, command)
+	}
+}
+
+// checksum returns the first four bytes of the double SHA-256 of a payload
+func checksum(payload []byte) [4]byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+
+	var sum [4]byte
+	copy(sum[:], second[:4])
+	return sum
+}
+
+// EncodeMessage encodes a message in an envelope for the network with the
+// given magic. Only known commands are encoded.
+func EncodeMessage(magic uint32, msg Message) ([]byte, error) {
+	if _, err := newPayload(msg.Type); err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("encode %s payload: %w", msg.Type, err)
+	}
+	if len(payload) > MaxPayloadSize {
+		return nil, fmt.Errorf("%s payload of %d bytes exceeds %d", msg.Type, len(payload), MaxPayloadSize)
+	}
+
+	var command [commandSize]byte
+	copy(command[:], msg.Type)
+	sum := checksum(payload)
+
+	buf := make([]byte, 0, EnvelopeSize+len(payload))
+	buf = binary.BigEndian.AppendUint32(buf, magic)
+	buf = binary.BigEndian.AppendUint32(buf, ProtocolVersion)
+	buf = append(buf, command[:]...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = append(buf, sum[:]...)
+	return append(buf, payload...), nil
+}
+
+// WriteMessage encodes a message and writes it to w in a single write, so
+// messages sent from several goroutines do not interleave
+func WriteMessage(w io.Writer, magic uint32, msg Message) error {
+	data, err := EncodeMessage(magic, msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadMessage reads an envelope for the network with the given magic from r
+// and decodes its payload into the type its command carries; the returned
+// message's Payload is a pointer to that type. Read errors are returned as
+// they are and broken envelopes wrapped in ErrInvalidFrame.
+func ReadMessage(r io.Reader, magic uint32) (Message, error) {
+	var header [EnvelopeSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Message{}, err
+	}
+
+	if got := binary.BigEndian.Uint32(header[0:4]); got != magic {
+		return Message{}, fmt.Errorf("%w: magic %08x, expected %08x", ErrInvalidFrame, got, magic)
+	}
+	if version := binary.BigEndian.Uint32(header[4:8]); version != ProtocolVersion {
+		return Message{}, fmt.Errorf("%w: unsupported version %d", ErrInvalidFrame, version)
+	}
+	command, err := parseCommand(header[8 : 8+commandSize])
+	if err != nil {
+		return Message{}, err
+	}
+	length := binary.BigEndian.Uint32(header[8+commandSize : 12+commandSize])
+	if length > MaxPayloadSize {
+		return Message{}, fmt.Errorf("%w: payload of %d bytes exceeds %d", ErrInvalidFrame, length, MaxPayloadSize)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return Message{}, err
+	}
+	if sum := checksum(payload); !bytes.Equal(sum[:], header[12+commandSize:]) {
+		return Message{}, fmt.Errorf("%w: %s payload checksum mismatch", ErrInvalidFrame, command)
+	}
+
+	value, err := newPayload(command)
+	if err != nil {
+		return Message{}, err
+	}
+	if err := json.Unmarshal(payload, value); err != nil {
+		return Message{}, fmt.Errorf("%w: decode %s payload: %w", ErrInvalidFrame, command, err)
+	}
+
+	return Message{Type: command, Payload: value}, nil
+}
+
+// parseCommand decodes a zero-padded command. Commands are printable ASCII
+// and the padding holds nothing but zero bytes.
+func parseCommand(field []byte) (string, error) {
+	command := bytes.TrimRight(field, "\x00")
+	if len(command) == 0 {
+		return "", fmt.Errorf("%w: empty command", ErrInvalidFrame)
+	}
+	for _, c := range command {
+		if c <= ' ' || c > '~' {
+			return "", fmt.Errorf("%w: malformed command %q", ErrInvalidFrame, field)
+		}
+	}
+	return string(command), nil
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// DefaultFileName is the name of the database file inside the data directory
+const DefaultFileName = "blockchain.db"
+
+// BoltOptions configures a bbolt backed store
+type BoltOptions struct {
+	// DataDir is the directory holding the database file. It is created if missing.
+	DataDir string
+
+	// FileName overrides DefaultFileName
+	FileName string
+
+	// FileMode is used when creating the database file (default 0600)
+	FileMode os.FileMode
+
+	// Timeout is how long to wait for the file lock held by another process.
+	// Zero waits forever, which is what bbolt does by default.
+	Timeout time.Duration
+}
+
+// BoltStore is a Store backed by a bbolt database file
+type BoltStore struct {
+	db *bbolt.DB
+}
+
+// OpenBolt opens (or creates) a bbolt database according to opts
+func OpenBolt(opts BoltOptions) (*BoltStore, error) {
+	if opts.FileName == "" {
+		opts.FileName = DefaultFileName
+	}
+	if opts.FileMode == 0 {
+		opts.FileMode = 0600
+	}
+
+	if opts.DataDir != "" {
+		if err := os.MkdirAll(opts.DataDir, 0700); err != nil {
+			return nil, fmt.Errorf("create data dir: %w", err)
+		}
+	}
+
+	path := filepath.Join(opts.DataDir, opts.FileName)
+	db, err := bbolt.Open(path, opts.FileMode, &bbolt.Options{Timeout: opts.Timeout})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// Path returns the path of the underlying database file
+func (s *BoltStore) Path() string {
+	return s.db.Path()
+}
+
+// View runs fn inside a read-only bbolt transaction
+func (s *BoltStore) View(fn func(tx Tx) error) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+// Update runs fn inside a read-write bbolt transaction
+func (s *BoltStore) Update(fn func(tx Tx) error) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+// Close closes the database file
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// boltTx adapts a bbolt transaction to Tx
+type boltTx struct {
+	tx *bbolt.Tx
+}
+
+func (t boltTx) Bucket(name []byte) Bucket {
+	b := t.tx.Bucket(name)
+	if b == nil {
+		return nil
+	}
+	return boltBucket{b}
+}
+
+func (t boltTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	if !t.tx.Writable() {
+		return nil, ErrTxNotWritable
+	}
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+	return boltBucket{b}, nil
+}
+
+func (t boltTx) DeleteBucket(name []byte) error {
+	if !t.tx.Writable() {
+		return ErrTxNotWritable
+	}
+	err := t.tx.DeleteBucket(name)
+	if err == bbolt.ErrBucketNotFound {
+		return ErrBucketNotFound
+	}
+	return err
+}
+
+// boltBucket adapts a bbolt bucket to Bucket
+type boltBucket struct {
+	b *bbolt.Bucket
+}
+
+func (b boltBucket) Get(key []byte) []byte {
+	return b.b.Get(key)
+}
+
+func (b boltBucket) Put(key []byte, value []byte) error {
+	if !b.b.Tx().Writable() {
+		return ErrTxNotWritable
+	}
+	return b.b.Put(key, value)
+}
+
+func (b boltBucket) Delete(key []byte) error {
+	if !b.b.Tx().Writable() {
+		return ErrTxNotWritable
+	}
+	return b.b.Delete(key)
+}
+
+func (b boltBucket) ForEach(fn func(k, v []byte) error) error {
+	return b.b.ForEach(fn)
+}
+
+func (b boltBucket) Cursor() Cursor {
+	return b.b.Cursor()
+}
@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// MemoryStore is a Store that keeps everything in memory. It is meant for
+// tests and throwaway nodes; nothing survives Close.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+	closed  bool
+}
+
+// NewMemory creates an empty in-memory store
+func NewMemory() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]map[string][]byte),
+	}
+}
+
+// View runs fn against the current contents of the store
+func (s *MemoryStore) View(fn func(tx Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	return fn(&memTx{buckets: s.buckets})
+}
+
+// Update runs fn against a private copy of the store and publishes the copy
+// only if fn succeeds. Buckets are copied lazily on first write.
+func (s *MemoryStore) Update(fn func(tx Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	buckets := make(map[string]map[string][]byte, len(s.buckets))
+	for name, b := range s.buckets {
+		buckets[name] = b
+	}
+
+	tx := &memTx{
+		writable: true,
+		buckets:  buckets,
+		cloned:   make(map[string]bool),
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	s.buckets = tx.buckets
+	return nil
+}
+
+// Close drops the contents of the store
+func (s *MemoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.buckets = nil
+	return nil
+}
+
+// memTx is a transaction against a MemoryStore
+type memTx struct {
+	writable bool
+	buckets  map[string]map[string][]byte
+	cloned   map[string]bool
+}
+
+func (t *memTx) Bucket(name []byte) Bucket {
+	if _, ok := t.buckets[string(name)]; !ok {
+		return nil
+	}
+	return &memBucket{tx: t, name: string(name)}
+}
+
+func (t *memTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	if !t.writable {
+		return nil, ErrTxNotWritable
+	}
+	if _, ok := t.buckets[string(name)]; !ok {
+		t.buckets[string(name)] = make(map[string][]byte)
+		t.cloned[string(name)] = true
+	}
+	return &memBucket{tx: t, name: string(name)}, nil
+}
+
+func (t *memTx) DeleteBucket(name []byte) error {
+	if !t.writable {
+		return ErrTxNotWritable
+	}
+	if _, ok := t.buckets[string(name)]; !ok {
+		return ErrBucketNotFound
+	}
+	delete(t.buckets, string(name))
+	delete(t.cloned, string(name))
+	return nil
+}
+
+// writableBucket returns the transaction's own copy of a bucket
+func (t *memTx) writableBucket(name string) (map[string][]byte, error) {
+	if !t.writable {
+		return nil, ErrTxNotWritable
+	}
+	b, ok := t.buckets[name]
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+	if !t.cloned[name] {
+		clone := make(map[string][]byte, len(b))
+		for k, v := range b {
+			clone[k] = v
+		}
+		t.buckets[name] = clone
+		t.cloned[name] = true
+		b = clone
+	}
+	return b, nil
+}
+
+// memBucket is a bucket inside a memTx
+type memBucket struct {
+	tx   *memTx
+	name string
+}
+
+func (b *memBucket) Get(key []byte) []byte {
+	return b.tx.buckets[b.name][string(key)]
+}
+
+func (b *memBucket) Put(key []byte, value []byte) error {
+	bucket, err := b.tx.writableBucket(b.name)
+	if err != nil {
+		return err
+	}
+	bucket[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (b *memBucket) Delete(key []byte) error {
+	bucket, err := b.tx.writableBucket(b.name)
+	if err != nil {
+		return err
+	}
+	delete(bucket, string(key))
+	return nil
+}
+
+func (b *memBucket) ForEach(fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *memBucket) Cursor() Cursor {
+	bucket := b.tx.buckets[b.name]
+	keys := make([]string, 0, len(bucket))
+	for k := range bucket {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return &memCursor{bucket: b, keys: keys, pos: -1}
+}
+
+// memCursor walks a sorted snapshot of a bucket's keys
+type memCursor struct {
+	bucket *memBucket
+	keys   []string
+	pos    int
+}
+
+func (c *memCursor) at(pos int) ([]byte, []byte) {
+	if pos < 0 || pos >= len(c.keys) {
+		c.pos = len(c.keys)
+		return nil, nil
+	}
+	c.pos = pos
+	key := c.keys[pos]
+	return []byte(key), c.bucket.tx.buckets[c.bucket.name][key]
+}
+
+func (c *memCursor) First() ([]byte, []byte) {
+	return c.at(0)
+}
+
+func (c *memCursor) Last() ([]byte, []byte) {
+	return c.at(len(c.keys) - 1)
+}
+
+func (c *memCursor) Next() ([]byte, []byte) {
+	return c.at(c.pos + 1)
+}
+
+func (c *memCursor) Prev() ([]byte, []byte) {
+	if c.pos <= 0 {
+		c.pos = len(c.keys)
+		return nil, nil
+	}
+	return c.at(c.pos - 1)
+}
+
+func (c *memCursor) Seek(seek []byte) ([]byte, []byte) {
+	i := sort.Search(len(c.keys), func(i int) bool {
+		return bytes.Compare([]byte(c.keys[i]), seek) >= 0
+	})
+	return c.at(i)
+}
@@ -0,0 +1,69 @@
+package storage
+
+import "errors"
+
+// ErrBucketNotFound is returned when an operation targets a bucket that does not exist
+var ErrBucketNotFound = errors.New("bucket not found")
+
+// ErrTxNotWritable is returned when a write is attempted inside a read-only transaction
+var ErrTxNotWritable = errors.New("transaction not writable")
+
+// ErrStoreClosed is returned when a closed store is used
+var ErrStoreClosed = errors.New("store closed")
+
+// Store is a transactional key/value store organised in named buckets.
+// Every backend gives the same guarantees: View transactions see a consistent
+// snapshot and Update transactions are applied atomically, so a function that
+// returns an error leaves the store untouched.
+type Store interface {
+	// View runs fn inside a read-only transaction
+	View(fn func(tx Tx) error) error
+
+	// Update runs fn inside a read-write transaction
+	Update(fn func(tx Tx) error) error
+
+	// Close releases the resources held by the store
+	Close() error
+}
+
+// Tx is a transaction against a Store
+type Tx interface {
+	// Bucket returns the named bucket or nil if it does not exist
+	Bucket(name []byte) Bucket
+
+	// CreateBucketIfNotExists returns the named bucket, creating it if needed
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+
+	// DeleteBucket removes the named bucket and all of its keys
+	DeleteBucket(name []byte) error
+}
+
+// Bucket is a collection of key/value pairs ordered by key
+type Bucket interface {
+	// Get returns the value for a key or nil if the key does not exist.
+	// The returned slice is only valid for the life of the transaction.
+	Get(key []byte) []byte
+
+	// Put sets the value for a key
+	Put(key []byte, value []byte) error
+
+	// Delete removes a key
+	Delete(key []byte) error
+
+	// ForEach calls fn for every key/value pair in key order
+	ForEach(fn func(k, v []byte) error) error
+
+	// Cursor returns a cursor positioned before the first key
+	Cursor() Cursor
+}
+
+// Cursor iterates over the keys of a bucket in order
+type Cursor interface {
+	First() (key []byte, value []byte)
+	Last() (key []byte, value []byte)
+	Next() (key []byte, value []byte)
+	Prev() (key []byte, value []byte)
+
+	// Seek moves to the first key greater than or equal to seek
+	Seek(seek []byte) (key []byte, value []byte)
+}
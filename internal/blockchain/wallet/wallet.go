@@ -6,7 +6,10 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"math/big"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
 )
 
 // Wallet represents a cryptocurrency wallet
@@ -25,7 +28,7 @@ func NewWallet() (*Wallet, error) {
 	}
 
 	// Get the public key
-	publicKey := append(privateKey.PublicKey.X.Bytes(), privateKey.PublicKey.Y.Bytes()...)
+	publicKey := core.EncodePubKey(&privateKey.PublicKey)
 
 	// Generate the address
 	address := generateAddress(publicKey)
@@ -39,6 +42,33 @@ func NewWallet() (*Wallet, error) {
 	return wallet, nil
 }
 
+// NewWalletFromKey restores a wallet from a private key exported with PrivateKeyBytes
+func NewWalletFromKey(privateKey []byte) (*Wallet, error) {
+	curve := elliptic.P256()
+
+	d := new(big.Int).SetBytes(privateKey)
+	if len(privateKey) != 32 || d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("invalid private key")
+	}
+
+	key := &ecdsa.PrivateKey{D: d}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(privateKey)
+
+	publicKey := core.EncodePubKey(&key.PublicKey)
+
+	return &Wallet{
+		PrivateKey: key,
+		PublicKey:  publicKey,
+		Address:    generateAddress(publicKey),
+	}, nil
+}
+
+// PrivateKeyBytes returns the wallet's private key as 32 big-endian bytes
+func (w *Wallet) PrivateKeyBytes() []byte {
+	return w.PrivateKey.D.FillBytes(make([]byte, 32))
+}
+
 // generateAddress generates a wallet address from a public key
 func generateAddress(publicKey []byte) string {
 	// SHA256 hash of the public key
@@ -71,15 +101,7 @@ func (w *Wallet) Sign(data []byte) ([]byte, error) {
 	hash := sha256.Sum256(data)
 
 	// Sign the hash
-	r, s, err := ecdsa.Sign(rand.Reader, w.PrivateKey, hash[:])
-	if err != nil {
-		return nil, err
-	}
-
-	// Concatenate r and s
-	signature := append(r.Bytes(), s.Bytes()...)
-
-	return signature, nil
+	return core.SignHash(w.PrivateKey, hash[:])
 }
 
 // Verify verifies a signature with the wallet's public key
@@ -87,12 +109,31 @@ func (w *Wallet) Verify(data []byte, signature []byte) bool {
 	// Hash the data
 	hash := sha256.Sum256(data)
 
-	// Split signature into r and s
-	r := new(big.Int).SetBytes(signature[:len(signature)/2])
-	s := new(big.Int).SetBytes(signature[len(signature)/2:])
-
 	// Verify the signature
-	return ecdsa.Verify(&w.PrivateKey.PublicKey, hash[:], r, s)
+	return core.VerifyHash(w.PublicKey, hash[:], signature)
+}
+
+// SignTransaction signs every pay-to-pubkey-hash input of tx with the
+// wallet's key. prevTXs maps the ID of every transaction spent by an input to
+// that transaction.
+func (w *Wallet) SignTransaction(tx *core.Transaction, prevTXs map[string]core.Transaction) error {
+	return tx.Sign(w.PrivateKey, prevTXs)
+}
+
+// SignPartial adds the wallet's signature to every input of a partially
+// signed transaction that its key may sign and returns the number of inputs signed
+func (w *Wallet) SignPartial(p *core.PartialTransaction) (int, error) {
+	return p.Sign(w.PrivateKey)
+}
+
+// NewMultiSigOutput creates an output of value that any m of the given
+// public keys can spend together
+func NewMultiSigOutput(value, m int, pubKeys ...[]byte) (core.TXOutput, error) {
+	script, err := core.MultiSigScript(m, pubKeys)
+	if err != nil {
+		return core.TXOutput{}, err
+	}
+	return core.TXOutput{Value: value, Script: script}, nil
 }
 
 // GetBalance calculates the balance of the wallet (simplified version)
@@ -12,6 +12,7 @@ type Metrics struct {
 	TransactionsTotal         prometheus.Counter
 	BlockchainHeight          prometheus.Gauge
 	Difficulty                prometheus.Gauge
+	Hashrate                  prometheus.Gauge
 	BlockProcessingTime       prometheus.Histogram
 	TransactionProcessingTime prometheus.Histogram
 
@@ -52,6 +53,10 @@ func NewMetrics() *Metrics {
 			Name: "coubcore_difficulty",
 			Help: "Current mining difficulty",
 		}),
+		Hashrate: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "coubcore_hashrate",
+			Help: "Hashes per second of the last proof of work search",
+		}),
 		BlockProcessingTime: promauto.NewHistogram(prometheus.HistogramOpts{
 			Name:    "coubcore_block_processing_duration_seconds",
 			Help:    "Time spent processing blocks",
@@ -143,6 +148,11 @@ func (m *Metrics) SetDifficulty(difficulty float64) {
 	m.Difficulty.Set(difficulty)
 }
 
+// SetHashrate sets the hashes per second of the last proof of work search
+func (m *Metrics) SetHashrate(hashesPerSecond float64) {
+	m.Hashrate.Set(hashesPerSecond)
+}
+
 // SetPeersConnected sets the number of connected peers
 func (m *Metrics) SetPeersConnected(count float64) {
 	m.PeersConnected.Set(count)
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+// newAddressIndexBlockchain creates an in-memory blockchain with the address index enabled
+func newAddressIndexBlockchain(t *testing.T) *core.Blockchain {
+	t.Helper()
+
+	opts := core.DefaultOptions()
+	opts.Backend = core.BackendMemory
+	opts.AddressIndex = true
+	opts.Params.CoinbaseMaturity = 0
+
+	bc, err := core.NewBlockchain(opts)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	return bc
+}
+
+func TestAddressIndex(t *testing.T) {
+	alice, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+
+	t.Run("HistoryNewestFirst", func(t *testing.T) {
+		bc := newAddressIndexBlockchain(t)
+		defer bc.Close()
+
+		first := newCoinbase(t, alice.Address, "first", 100)
+		other := newCoinbase(t, bob.Address, "other", 100)
+		bc.AddBlock([]*core.Transaction{first})
+
+		// Bob pays Alice in the same block as his coinbase
+		second := &core.Transaction{
+			Inputs:  []core.TXInput{{TXID: other.ID, Vout: 0}},
+			Outputs: []core.TXOutput{newOutput(t, 100, alice.Address)},
+		}
+		if err := bob.SignTransaction(second, map[string]core.Transaction{other.ID: *other}); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		bc.AddBlock([]*core.Transaction{other, second})
+
+		// A spend signed by Alice's key also shows up in her history
+		spend := &core.Transaction{
+			Inputs:  []core.TXInput{{TXID: first.ID, Vout: 0}},
+			Outputs: []core.TXOutput{newOutput(t, 100, bob.Address)},
+		}
+		if err := bc.SignTransaction(spend, alice.PrivateKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		bc.AddBlock([]*core.Transaction{spend})
+
+		history, err := bc.GetAddressHistory(alice.Address, 0, 0)
+		if err != nil {
+			t.Fatalf("Failed to get history: %v", err)
+		}
+		if len(history) != 3 {
+			t.Fatalf("Expected 3 history entries, got %d", len(history))
+		}
+		if history[0].TxID != spend.ID || history[1].TxID != second.ID || history[2].TxID != first.ID {
+			t.Error("Expected history to be ordered newest first")
+		}
+
+		// Paging walks the same order
+		page, err := bc.GetAddressHistory(alice.Address, 1, 1)
+		if err != nil {
+			t.Fatalf("Failed to get history page: %v", err)
+		}
+		if len(page) != 1 || page[0].TxID != second.ID {
+			t.Error("Expected the second page to hold the second newest entry")
+		}
+
+		bobHistory, err := bc.GetAddressHistory(bob.Address, 0, 0)
+		if err != nil {
+			t.Fatalf("Failed to get history: %v", err)
+		}
+		if len(bobHistory) != 3 {
+			t.Errorf("Expected 3 history entries for bob, got %d", len(bobHistory))
+		}
+	})
+
+	t.Run("TimeLockedOutputs", func(t *testing.T) {
+		bc := newAddressIndexBlockchain(t)
+		defer bc.Close()
+
+		funding := newCoinbase(t, bob.Address, "time locks", 100)
+		bc.AddBlock([]*core.Transaction{funding})
+
+		// Outputs Alice can only spend later still belong to her
+		locked := &core.Transaction{
+			Inputs: []core.TXInput{{TXID: funding.ID, Vout: 0}},
+			Outputs: []core.TXOutput{
+				{Value: 30, Script: core.TimeLockScript(1000, core.HashPubKey(alice.PublicKey))},
+				{Value: 20, Script: core.SequenceLockScript(core.RelativeLockBlocks(10), core.HashPubKey(alice.PublicKey))},
+				newOutput(t, 50, bob.Address),
+			},
+		}
+		if err := bob.SignTransaction(locked, map[string]core.Transaction{funding.ID: *funding}); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		if bc.AddBlock([]*core.Transaction{locked}) == nil {
+			t.Fatal("Failed to add block")
+		}
+
+		utxos, err := bc.GetUTXOsByAddress(alice.Address)
+		if err != nil {
+			t.Fatalf("Failed to get UTXOs: %v", err)
+		}
+		if len(utxos) != 2 {
+			t.Errorf("Expected both time-locked outputs, got %d", len(utxos))
+		}
+		if balance, err := bc.GetBalance(alice.Address); err != nil || balance != 50 {
+			t.Errorf("Expected a balance of 50, got %d (%v)", balance, err)
+		}
+
+		history, err := bc.GetAddressHistory(alice.Address, 0, 0)
+		if err != nil {
+			t.Fatalf("Failed to get history: %v", err)
+		}
+		if len(history) != 1 || history[0].TxID != locked.ID {
+			t.Errorf("Expected the locking transaction in the history, got %d entries", len(history))
+		}
+	})
+
+	t.Run("ReorgRemovesEntries", func(t *testing.T) {
+		bc1 := newAddressIndexBlockchain(t)
+		bc2 := newTestBlockchain(t)
+		defer bc1.Close()
+		defer bc2.Close()
+
+		bc1.AddBlock([]*core.Transaction{newCoinbase(t, alice.Address, "orphaned", 100)})
+
+		bc2.AddBlock([]*core.Transaction{newCoinbase(t, bob.Address, "replacement", 100)})
+		bc2.AddBlock("Block 2")
+
+		if !bc1.ReplaceChain(bc2.GetBlocks(0, 0)) {
+			t.Fatal("Expected chain replacement to be successful")
+		}
+
+		history, err := bc1.GetAddressHistory(alice.Address, 0, 0)
+		if err != nil {
+			t.Fatalf("Failed to get history: %v", err)
+		}
+		if len(history) != 0 {
+			t.Errorf("Expected orphaned entries to be removed, got %d", len(history))
+		}
+
+		history, err = bc1.GetAddressHistory(bob.Address, 0, 0)
+		if err != nil {
+			t.Fatalf("Failed to get history: %v", err)
+		}
+		if len(history) != 1 {
+			t.Errorf("Expected 1 entry from the new chain, got %d", len(history))
+		}
+	})
+
+	t.Run("RebuildAfterEnabling", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		opts.DataDir = t.TempDir()
+
+		bc, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to create blockchain: %v", err)
+		}
+		bc.AddBlock([]*core.Transaction{newCoinbase(t, alice.Address, "before", 100)})
+
+		if _, err := bc.GetAddressHistory(alice.Address, 0, 0); err != core.ErrAddressIndexDisabled {
+			t.Errorf("Expected ErrAddressIndexDisabled, got %v", err)
+		}
+		bc.Close()
+
+		// Enabling the index on an existing database builds it from the chain
+		opts.AddressIndex = true
+		reopened, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to reopen blockchain: %v", err)
+		}
+		defer reopened.Close()
+
+		history, err := reopened.GetAddressHistory(alice.Address, 0, 0)
+		if err != nil {
+			t.Fatalf("Failed to get history: %v", err)
+		}
+		if len(history) != 1 {
+			t.Errorf("Expected 1 history entry after rebuild, got %d", len(history))
+		}
+
+		if err := reopened.RebuildAddressIndex(); err != nil {
+			t.Errorf("Failed to rebuild address index: %v", err)
+		}
+	})
+}
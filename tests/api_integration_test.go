@@ -1,29 +1,36 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"testing"
 
 	"github.com/antontuzov/coubcore/internal/blockchain/api"
 	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/mempool"
 	"github.com/antontuzov/coubcore/internal/blockchain/network"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
 )
 
 func TestAPIIntegration(t *testing.T) {
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
-
 	// Create a new blockchain
-	blockchain := core.NewBlockchain()
+	blockchain := newTestBlockchain(t)
 	defer blockchain.Close()
 
+	// Create a transaction pool
+	pool := mempool.NewMempool(blockchain, mempool.DefaultOptions())
+
 	// Create a new network server
-	networkServer := network.NewServer("localhost", 8000, blockchain)
+	networkServer := network.NewServer("localhost", 8000, blockchain, pool)
 
 	// Create a new API server
-	apiServer := api.NewServer(blockchain, networkServer, 8080)
+	apiServer := api.NewServer(blockchain, networkServer, pool, 8080)
+
+	// Add a block carrying a transaction so lookups have real data
+	coinbase := newCoinbase(t, testAddress("recipient"), "api test", 100)
+	txBlock := blockchain.AddBlock([]*core.Transaction{coinbase})
 
 	t.Run("GetBlockchainInfo", func(t *testing.T) {
 		// Create a test request
@@ -74,7 +81,7 @@ func TestAPIIntegration(t *testing.T) {
 
 	t.Run("GetTransaction", func(t *testing.T) {
 		// Create a test request with query parameter
-		req, err := http.NewRequest("GET", "/api/v1/transaction?txid=test", nil)
+		req, err := http.NewRequest("GET", "/api/v1/transaction?txid="+coinbase.ID, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -92,6 +99,36 @@ func TestAPIIntegration(t *testing.T) {
 		}
 	})
 
+	t.Run("GetBlockByHash", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/api/v1/block?hash="+txBlock.Hash, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(apiServer.GetBlock).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+	})
+
+	t.Run("UnknownTransaction", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/api/v1/transaction?txid=unknown", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(apiServer.GetTransaction).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusNotFound)
+		}
+	})
+
 	t.Run("GetBalance", func(t *testing.T) {
 		// Create a test request with query parameter
 		req, err := http.NewRequest("GET", "/api/v1/balance?address=test", nil)
@@ -105,11 +142,40 @@ func TestAPIIntegration(t *testing.T) {
 		// Call the handler directly
 		http.HandlerFunc(apiServer.GetBalance).ServeHTTP(rr, req)
 
-		// Check the status code
-		if status := rr.Code; status != http.StatusOK {
+		// A malformed address is refused rather than read as an empty balance
+		if status := rr.Code; status != http.StatusBadRequest {
 			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusBadRequest)
+		}
+
+		// A funded wallet reports the coins the chain holds for it
+		owner, err := wallet.NewWallet()
+		if err != nil {
+			t.Fatalf("Failed to create wallet: %v", err)
+		}
+		blockchain.AddBlock([]*core.Transaction{newCoinbase(t, owner.Address, "api balance", 70)})
+
+		req, err = http.NewRequest("GET", "/api/v1/balance?address="+owner.Address, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(apiServer.GetBalance).ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
 				status, http.StatusOK)
 		}
+
+		var response struct {
+			Address string `json:"address"`
+			Balance int    `json:"balance"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Address != owner.Address || response.Balance != 70 {
+			t.Errorf("Expected a balance of 70 for %s, got %+v", owner.Address, response)
+		}
 	})
 
 	t.Run("GetPeers", func(t *testing.T) {
@@ -133,22 +199,73 @@ func TestAPIIntegration(t *testing.T) {
 	})
 
 	t.Run("SendTransaction", func(t *testing.T) {
-		// Create a test request
+		// Only POST is accepted
 		req, err := http.NewRequest("GET", "/api/v1/send", nil)
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		// Create a response recorder
 		rr := httptest.NewRecorder()
-
-		// Call the handler directly
 		http.HandlerFunc(apiServer.SendTransaction).ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusMethodNotAllowed {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusMethodNotAllowed)
+		}
 
-		// Check the status code
+		// Fund a wallet and submit a signed spend
+		sender, err := wallet.NewWallet()
+		if err != nil {
+			t.Fatalf("Failed to create wallet: %v", err)
+		}
+		blockchain.AddBlock([]*core.Transaction{newCoinbase(t, sender.Address, "api send", 100)})
+
+		utxos, err := blockchain.GetUTXOsByAddress(sender.Address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, err := core.NewTransaction(sender.Address, testAddress("recipient"), 25, map[string][]core.UTXO{sender.Address: utxos})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := blockchain.SignTransaction(tx, sender.PrivateKey); err != nil {
+			t.Fatal(err)
+		}
+		body, err := json.Marshal(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err = http.NewRequest("POST", "/api/v1/send", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(apiServer.SendTransaction).ServeHTTP(rr, req)
 		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s",
+				status, http.StatusOK, rr.Body.String())
+		}
+
+		var response map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response["txid"] != tx.ID {
+			t.Errorf("Expected txid %s, got %v", tx.ID, response["txid"])
+		}
+		if !pool.Has(tx.ID) {
+			t.Error("Expected transaction to be in the mempool")
+		}
+
+		// Submitting the same spend again is rejected
+		req, err = http.NewRequest("POST", "/api/v1/send", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(apiServer.SendTransaction).ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusConflict {
 			t.Errorf("handler returned wrong status code: got %v want %v",
-				status, http.StatusOK)
+				status, http.StatusConflict)
 		}
 	})
 
@@ -1,27 +1,26 @@
 package main
 
 import (
-	"os"
 	"testing"
 
 	"github.com/antontuzov/coubcore/internal/blockchain/api"
-	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/mempool"
 	"github.com/antontuzov/coubcore/internal/blockchain/network"
 )
 
 func TestAPIServerCreation(t *testing.T) {
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
-
 	// Create a new blockchain
-	blockchain := core.NewBlockchain()
+	blockchain := newTestBlockchain(t)
 	defer blockchain.Close()
 
+	// Create a transaction pool
+	pool := mempool.NewMempool(blockchain, mempool.DefaultOptions())
+
 	// Create a new network server
-	networkServer := network.NewServer("localhost", 8000, blockchain)
+	networkServer := network.NewServer("localhost", 8000, blockchain, pool)
 
 	// Create a new API server
-	apiServer := api.NewServer(blockchain, networkServer, 8080)
+	apiServer := api.NewServer(blockchain, networkServer, pool, 8080)
 
 	// Check that the API server was created
 	if apiServer == nil {
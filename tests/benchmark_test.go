@@ -1,7 +1,6 @@
 package main
 
 import (
-	"os"
 	"testing"
 
 	"github.com/antontuzov/coubcore/internal/blockchain/consensus"
@@ -10,11 +9,8 @@ import (
 )
 
 func BenchmarkBlockMining(b *testing.B) {
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
-
 	// Create a new blockchain
-	blockchain := core.NewBlockchain()
+	blockchain := newTestBlockchain(b)
 	defer blockchain.Close()
 
 	// Reset the benchmark timer
@@ -31,11 +27,8 @@ func BenchmarkBlockMining(b *testing.B) {
 }
 
 func BenchmarkBlockValidation(b *testing.B) {
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
-
 	// Create a new blockchain
-	blockchain := core.NewBlockchain()
+	blockchain := newTestBlockchain(b)
 	defer blockchain.Close()
 
 	// Mine a block for testing
@@ -59,6 +52,7 @@ func BenchmarkBlockValidation(b *testing.B) {
 func BenchmarkTransactionCreation(b *testing.B) {
 	// Create a UTXO set
 	utxoSet := make(map[string][]core.UTXO)
+	utxoSet[testAddress("sender")] = []core.UTXO{{TXID: "funding", Index: 0, Output: core.TXOutput{Value: 100}}}
 
 	// Reset the benchmark timer
 	b.ResetTimer()
@@ -66,7 +60,7 @@ func BenchmarkTransactionCreation(b *testing.B) {
 	// Run the benchmark
 	for i := 0; i < b.N; i++ {
 		// Create a transaction
-		_, err := core.NewTransaction("sender", "recipient", 100, utxoSet)
+		_, err := core.NewTransaction(testAddress("sender"), testAddress("recipient"), 100, utxoSet)
 		if err != nil {
 			b.Fatal("Failed to create transaction")
 		}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+func TestBlockBody(t *testing.T) {
+	t.Run("PayloadGoesIntoDataOutput", func(t *testing.T) {
+		block := core.NewBlock(1, testPreviousHash, "test data")
+
+		if len(block.Transactions) != 1 || !block.Transactions[0].IsCoinbase() {
+			t.Fatal("Expected a single coinbase transaction")
+		}
+		out := block.Transactions[0].Outputs[0]
+		if !out.IsData() || out.Value != 0 || string(out.Data()) != "test data" {
+			t.Errorf("Expected a data output carrying the payload, got %+v", out)
+		}
+		if err := block.CheckBody(); err != nil {
+			t.Errorf("Expected body to be valid: %v", err)
+		}
+	})
+
+	t.Run("CoinbaseAddedInFront", func(t *testing.T) {
+		tx := &core.Transaction{
+			Inputs:  []core.TXInput{{TXID: "prev", Vout: 0}},
+			Outputs: []core.TXOutput{newOutput(t, 10, testAddress("recipient"))},
+		}
+		tx.SetID()
+
+		block := core.NewBlock(1, testPreviousHash, []*core.Transaction{tx})
+		if len(block.Transactions) != 2 || !block.Transactions[0].IsCoinbase() {
+			t.Fatal("Expected a coinbase to be added in front of the transaction")
+		}
+		if block.Transactions[1].ID != tx.ID {
+			t.Error("Expected the transaction to follow the coinbase")
+		}
+	})
+
+	t.Run("MerkleRootCoversEveryTransaction", func(t *testing.T) {
+		var txs []*core.Transaction
+		txs = append(txs, newCoinbase(t, testAddress("miner"), "", 100))
+		for _, value := range []int{1, 2} {
+			tx := &core.Transaction{
+				Inputs:  []core.TXInput{{TXID: "prev", Vout: value}},
+				Outputs: []core.TXOutput{newOutput(t, value, testAddress("recipient"))},
+			}
+			tx.SetID()
+			txs = append(txs, tx)
+		}
+
+		block := core.NewBlock(1, testPreviousHash, txs)
+		if err := block.CheckBody(); err != nil {
+			t.Fatalf("Expected body to be valid: %v", err)
+		}
+
+		// Changing the last transaction of an odd-sized tree changes the root
+		block.Transactions[2].Outputs[0].Value = 1000
+		block.Transactions[2].SetID()
+		if err := block.CheckBody(); !errors.Is(err, core.ErrInvalidBlock) {
+			t.Errorf("Expected merkle root mismatch, got %v", err)
+		}
+	})
+
+	t.Run("StaleTransactionID", func(t *testing.T) {
+		block := core.NewBlock(1, testPreviousHash, "test data")
+		block.Transactions[0].Outputs[0] = core.NewDataOutput([]byte("tampered data"))
+
+		if err := block.CheckBody(); !errors.Is(err, core.ErrInvalidBlock) {
+			t.Errorf("Expected stale transaction ID to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("RepeatedTransactionKeepsRoot", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		alice, err := wallet.NewWallet()
+		if err != nil {
+			t.Fatalf("Failed to create wallet: %v", err)
+		}
+
+		// spend pays part of a mined coinbase output back to alice
+		spend := func(coinbase *core.Transaction) *core.Transaction {
+			utxo, err := bc.GetUTXO(coinbase.ID, 0)
+			if err != nil || utxo == nil {
+				t.Fatalf("Expected coinbase output to be unspent, got %v, %v", utxo, err)
+			}
+			tx, err := core.NewTransaction(alice.Address, alice.Address, 10, map[string][]core.UTXO{alice.Address: {*utxo}})
+			if err != nil {
+				t.Fatalf("Failed to create transaction: %v", err)
+			}
+			if err := bc.SignTransaction(tx, alice.PrivateKey); err != nil {
+				t.Fatalf("Failed to sign transaction: %v", err)
+			}
+			return tx
+		}
+
+		var coinbases []*core.Transaction
+		for _, data := range []string{"first", "second"} {
+			coinbase := newCoinbase(t, alice.Address, data, 100)
+			if _, err := bc.ProcessBlock(childBlock(bc.GetLatestBlock(), 1, []*core.Transaction{coinbase})); err != nil {
+				t.Fatalf("Failed to add block: %v", err)
+			}
+			coinbases = append(coinbases, coinbase)
+		}
+
+		honest := childBlock(bc.GetLatestBlock(), 1, []*core.Transaction{spend(coinbases[0]), spend(coinbases[1])})
+
+		// Repeating the last transaction of the odd-sized body keeps the
+		// merkle root and so the block hash
+		mutated := *honest
+		mutated.Transactions = append(append([]core.Transaction{}, honest.Transactions...), honest.Transactions[2])
+		if mutated.ComputeMerkleRoot() != honest.MerkleRoot || mutated.CalculateHash() != honest.Hash {
+			t.Fatal("Expected the mutated block to share the honest block's hash")
+		}
+
+		if _, err := bc.ProcessBlock(&mutated); !errors.Is(err, core.ErrInvalidBlock) {
+			t.Fatalf("Expected repeated transaction to be rejected, got %v", err)
+		}
+		if status, err := bc.ProcessBlock(honest); err != nil || status != core.BlockMainChain {
+			t.Errorf("Expected honest block to be accepted after the mutated copy, got %v, %v", status, err)
+		}
+	})
+
+	t.Run("SingleCoinbase", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		first := newCoinbase(t, testAddress("miner"), "first", 100)
+		second := newCoinbase(t, testAddress("miner"), "second", 100)
+		if bc.AddBlock([]*core.Transaction{first, second}) != nil {
+			t.Error("Expected block with two coinbases to be rejected")
+		}
+
+		block := core.NewBlock(1, bc.GetLatestBlock().Hash, nil)
+		block.Transactions = nil
+		block.MerkleRoot = block.ComputeMerkleRoot()
+		block.Hash = block.CalculateHash()
+		if _, err := bc.ProcessBlock(block); !errors.Is(err, core.ErrInvalidBlock) {
+			t.Errorf("Expected empty block to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("BodySurvivesRestart", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		opts.DataDir = t.TempDir()
+
+		bc, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to create blockchain: %v", err)
+		}
+		coinbase := newCoinbase(t, testAddress("miner"), "stored", 100)
+		bc.AddBlock([]*core.Transaction{coinbase})
+		bc.Close()
+
+		reopened, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to reopen blockchain: %v", err)
+		}
+		defer reopened.Close()
+
+		block := reopened.GetBlockByIndex(1)
+		if block == nil {
+			t.Fatal("Expected to read the block back")
+		}
+		if err := block.CheckBody(); err != nil {
+			t.Errorf("Expected stored body to stay valid: %v", err)
+		}
+		if block.Transactions[0].ID != coinbase.ID {
+			t.Error("Expected stored coinbase to keep its ID")
+		}
+		if !reopened.IsChainValid() {
+			t.Error("Expected reopened chain to be valid")
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+)
+
+func TestBlocksReadFromStore(t *testing.T) {
+	// Use a tiny cache so most reads have to go to the store
+	opts := core.DefaultOptions()
+	opts.DataDir = t.TempDir()
+	opts.CacheSize = 2
+
+	bc, err := core.NewBlockchain(opts)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		bc.AddBlock("Block")
+	}
+
+	t.Run("BoundedCache", func(t *testing.T) {
+		if cached := bc.CachedBlocks(); cached > 2 {
+			t.Errorf("Expected at most 2 cached blocks, got %d", cached)
+		}
+	})
+
+	t.Run("GetBlockByIndex", func(t *testing.T) {
+		block := bc.GetBlockByIndex(3)
+		if block == nil || block.Index != 3 {
+			t.Fatalf("Expected block 3, got %v", block)
+		}
+
+		if bc.GetBlockByIndex(11) != nil {
+			t.Error("Expected nil for a height above the tip")
+		}
+	})
+
+	t.Run("PagedBlocks", func(t *testing.T) {
+		page := bc.GetBlocks(4, 3)
+		if len(page) != 3 {
+			t.Fatalf("Expected 3 blocks, got %d", len(page))
+		}
+		for i, block := range page {
+			if block.Index != uint64(4+i) {
+				t.Errorf("Expected block %d, got %d", 4+i, block.Index)
+			}
+		}
+
+		if tail := bc.GetBlocks(9, 5); len(tail) != 2 {
+			t.Errorf("Expected 2 blocks at the end of the chain, got %d", len(tail))
+		}
+
+		if past := bc.GetBlocks(20, 5); len(past) != 0 {
+			t.Errorf("Expected no blocks past the tip, got %d", len(past))
+		}
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		tip := bc.GetLatestBlock()
+		bc.Close()
+
+		reopened, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to reopen blockchain: %v", err)
+		}
+		defer reopened.Close()
+
+		if reopened.Length() != 11 {
+			t.Errorf("Expected length 11, got %d", reopened.Length())
+		}
+		if reopened.GetLatestBlock().Hash != tip.Hash {
+			t.Error("Expected the tip to survive a restart")
+		}
+		if reopened.CachedBlocks() != 1 {
+			t.Errorf("Expected only the tip to be cached after a restart, got %d", reopened.CachedBlocks())
+		}
+		if !reopened.IsChainValid() {
+			t.Error("Expected reopened blockchain to be valid")
+		}
+	})
+}
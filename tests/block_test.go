@@ -6,21 +6,24 @@ import (
 	"github.com/antontuzov/coubcore/internal/blockchain/core"
 )
 
+// testPreviousHash is a well-formed hash used as the parent of standalone blocks
+const testPreviousHash = "0000000000000000000000000000000000000000000000000000000000000001"
+
 func TestBlockCreation(t *testing.T) {
 	// Create a new block
-	block := core.NewBlock(1, "previousHash", "test data")
+	block := core.NewBlock(1, testPreviousHash, "test data")
 
 	// Check that the block was created correctly
 	if block.Index != 1 {
 		t.Errorf("Expected index 1, got %d", block.Index)
 	}
 
-	if block.PreviousHash != "previousHash" {
-		t.Errorf("Expected previousHash, got %s", block.PreviousHash)
+	if block.PreviousHash != testPreviousHash {
+		t.Errorf("Expected %s, got %s", testPreviousHash, block.PreviousHash)
 	}
 
-	if block.Data != "test data" {
-		t.Errorf("Expected test data, got %s", block.Data)
+	if string(block.Payload()) != "test data" {
+		t.Errorf("Expected test data, got %s", block.Payload())
 	}
 
 	// Check that the hash is not empty
@@ -31,7 +34,7 @@ func TestBlockCreation(t *testing.T) {
 
 func TestBlockHashCalculation(t *testing.T) {
 	// Create a new block
-	block := core.NewBlock(1, "previousHash", "test data")
+	block := core.NewBlock(1, testPreviousHash, "test data")
 
 	// Calculate the hash manually
 	calculatedHash := block.CalculateHash()
@@ -44,7 +47,7 @@ func TestBlockHashCalculation(t *testing.T) {
 
 func TestBlockSerialization(t *testing.T) {
 	// Create a new block
-	block := core.NewBlock(1, "previousHash", "test data")
+	block := core.NewBlock(1, testPreviousHash, "test data")
 
 	// Serialize the block
 	data, err := block.Serialize()
@@ -78,7 +81,7 @@ func TestBlockValidation(t *testing.T) {
 	prevBlock := core.NewBlock(0, "", "genesis data")
 
 	// Create a new block
-	block := core.NewBlock(1, prevBlock.Hash, "test data")
+	block := sealBlock(core.NewBlock(1, prevBlock.Hash, "test data"))
 
 	// Validate the block against the previous block
 	if !block.Validate(prevBlock) {
@@ -1,23 +1,20 @@
 package main
 
 import (
-	"os"
+	"errors"
 	"testing"
 
 	"github.com/antontuzov/coubcore/internal/blockchain/core"
 )
 
 func TestBlockValidationComprehensive(t *testing.T) {
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
-
 	// Test valid block
 	t.Run("ValidBlock", func(t *testing.T) {
 		// Create a previous block
 		prevBlock := core.NewBlock(0, "", "genesis data")
 
 		// Create a new block
-		block := core.NewBlock(1, prevBlock.Hash, "test data")
+		block := sealBlock(core.NewBlock(1, prevBlock.Hash, "test data"))
 
 		// Validate the block against the previous block
 		if !block.Validate(prevBlock) {
@@ -62,7 +59,7 @@ func TestBlockValidationComprehensive(t *testing.T) {
 		block := core.NewBlock(1, prevBlock.Hash, "test data")
 
 		// Tamper with the block data
-		block.Data = "tampered data"
+		block.Transactions[0].Outputs[0] = core.NewDataOutput([]byte("tampered data"))
 
 		// Validate the block (should fail because hash doesn't match)
 		if block.Validate(prevBlock) {
@@ -70,20 +67,25 @@ func TestBlockValidationComprehensive(t *testing.T) {
 		}
 	})
 
-	// Test invalid block (wrong timestamp)
+	// Test invalid block (wrong timestamp). Timestamps are checked against
+	// the median time past, which needs the chain.
 	t.Run("InvalidTimestamp", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
 		// Create a previous block
-		prevBlock := core.NewBlock(0, "", "genesis data")
+		prevBlock := bc.GetLatestBlock()
 
 		// Create a new block
 		block := core.NewBlock(1, prevBlock.Hash, "test data")
 
 		// Set the timestamp to be before the previous block
 		block.Timestamp = prevBlock.Timestamp.Add(-1 * 1000000000) // 1 second before
+		sealBlock(block)
 
-		// Validate the block against the previous block
-		if block.Validate(prevBlock) {
-			t.Error("Expected block to be invalid")
+		// Add the block on top of the previous block
+		if _, err := bc.ProcessBlock(block); !errors.Is(err, core.ErrTimeTooOld) {
+			t.Errorf("Expected ErrTimeTooOld, got %v", err)
 		}
 	})
 }
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"testing"
 
 	"github.com/antontuzov/coubcore/internal/blockchain/core"
@@ -11,11 +10,8 @@ import (
 func TestBlockchainFunctionality(t *testing.T) {
 	fmt.Println("Testing blockchain functionality...")
 
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
-
 	// Create a new blockchain
-	bc := core.NewBlockchain()
+	bc := newTestBlockchain(t)
 	defer bc.Close()
 
 	// Check that the blockchain was created
@@ -41,8 +37,8 @@ func TestBlockchainFunctionality(t *testing.T) {
 		t.Errorf("Expected new block index to be 1, got %d", newBlock.Index)
 	}
 
-	if newBlock.Data != "Test Data" {
-		t.Errorf("Expected new block data to be 'Test Data', got %s", newBlock.Data)
+	if string(newBlock.Payload()) != "Test Data" {
+		t.Errorf("Expected new block data to be 'Test Data', got %s", newBlock.Payload())
 	}
 
 	// Check that the chain is valid
@@ -54,11 +50,8 @@ func TestBlockchainFunctionality(t *testing.T) {
 }
 
 func TestBlockchainCreation(t *testing.T) {
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
-
 	// Create a new blockchain
-	bc := core.NewBlockchain()
+	bc := newTestBlockchain(t)
 	defer bc.Close()
 
 	// Check that the blockchain was created
@@ -81,17 +74,14 @@ func TestBlockchainCreation(t *testing.T) {
 		t.Errorf("Expected genesis block index to be 0, got %d", genesisBlock.Index)
 	}
 
-	if genesisBlock.Data != "Genesis Block" {
-		t.Errorf("Expected genesis block data to be 'Genesis Block', got %s", genesisBlock.Data)
+	if string(genesisBlock.Payload()) != "Genesis Block" {
+		t.Errorf("Expected genesis block data to be 'Genesis Block', got %s", genesisBlock.Payload())
 	}
 }
 
 func TestAddBlock(t *testing.T) {
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
-
 	// Create a new blockchain
-	bc := core.NewBlockchain()
+	bc := newTestBlockchain(t)
 	defer bc.Close()
 
 	// Add a new block
@@ -107,8 +97,8 @@ func TestAddBlock(t *testing.T) {
 		t.Errorf("Expected new block index to be 1, got %d", newBlock.Index)
 	}
 
-	if newBlock.Data != "Test Data" {
-		t.Errorf("Expected new block data to be 'Test Data', got %s", newBlock.Data)
+	if string(newBlock.Payload()) != "Test Data" {
+		t.Errorf("Expected new block data to be 'Test Data', got %s", newBlock.Payload())
 	}
 
 	if newBlock.PreviousHash != bc.GetBlockByIndex(0).Hash {
@@ -117,11 +107,8 @@ func TestAddBlock(t *testing.T) {
 }
 
 func TestGetLatestBlock(t *testing.T) {
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
-
 	// Create a new blockchain
-	bc := core.NewBlockchain()
+	bc := newTestBlockchain(t)
 	defer bc.Close()
 
 	// Add a new block
@@ -135,17 +122,14 @@ func TestGetLatestBlock(t *testing.T) {
 		t.Errorf("Expected latest block index to be 1, got %d", latestBlock.Index)
 	}
 
-	if latestBlock.Data != "Test Data" {
-		t.Errorf("Expected latest block data to be 'Test Data', got %s", latestBlock.Data)
+	if string(latestBlock.Payload()) != "Test Data" {
+		t.Errorf("Expected latest block data to be 'Test Data', got %s", latestBlock.Payload())
 	}
 }
 
 func TestIsChainValid(t *testing.T) {
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
-
 	// Create a new blockchain
-	bc := core.NewBlockchain()
+	bc := newTestBlockchain(t)
 	defer bc.Close()
 
 	// Add some blocks
@@ -160,7 +144,7 @@ func TestIsChainValid(t *testing.T) {
 
 	// Tamper with a block to make it invalid
 	block := bc.GetBlockByIndex(1)
-	block.Data = "Tampered Data"
+	block.Transactions[0].Outputs[0] = core.NewDataOutput([]byte("Tampered Data"))
 
 	// Check that the chain is now invalid
 	if bc.IsChainValid() {
@@ -169,12 +153,9 @@ func TestIsChainValid(t *testing.T) {
 }
 
 func TestReplaceChain(t *testing.T) {
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
-
 	// Create two blockchains
-	bc1 := core.NewBlockchain()
-	bc2 := core.NewBlockchain()
+	bc1 := newTestBlockchain(t)
+	bc2 := newTestBlockchain(t)
 	defer bc1.Close()
 	defer bc2.Close()
 
@@ -183,7 +164,7 @@ func TestReplaceChain(t *testing.T) {
 	bc2.AddBlock("Block 2")
 
 	// Replace bc1's chain with bc2's chain
-	blocks := bc2.GetBlocks()
+	blocks := bc2.GetBlocks(0, 0)
 	success := bc1.ReplaceChain(blocks)
 
 	// Check that the replacement was successful
@@ -197,7 +178,7 @@ func TestReplaceChain(t *testing.T) {
 	}
 
 	// Try to replace with a shorter chain (should fail)
-	success = bc2.ReplaceChain(bc1.GetBlocks()[:2])
+	success = bc2.ReplaceChain(bc1.GetBlocks(0, 0)[:2])
 	if success {
 		t.Error("Expected chain replacement to fail with shorter chain")
 	}
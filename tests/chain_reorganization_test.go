@@ -1,20 +1,16 @@
 package main
 
 import (
-	"os"
 	"testing"
 
 	"github.com/antontuzov/coubcore/internal/blockchain/core"
 )
 
 func TestChainReorganization(t *testing.T) {
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
-
 	t.Run("ForkResolution", func(t *testing.T) {
 		// Create two blockchains
-		bc1 := core.NewBlockchain()
-		bc2 := core.NewBlockchain()
+		bc1 := newTestBlockchain(t)
+		bc2 := newTestBlockchain(t)
 		defer bc1.Close()
 		defer bc2.Close()
 
@@ -27,7 +23,7 @@ func TestChainReorganization(t *testing.T) {
 		bc2.AddBlock("Block 3") // bc2 is longer
 
 		// Get blocks from bc2
-		blocks := bc2.GetBlocks()
+		blocks := bc2.GetBlocks(0, 0)
 
 		// Replace bc1's chain with bc2's chain
 		success := bc1.ReplaceChain(blocks)
@@ -52,8 +48,8 @@ func TestChainReorganization(t *testing.T) {
 
 	t.Run("InvalidChainRejection", func(t *testing.T) {
 		// Create two blockchains
-		bc1 := core.NewBlockchain()
-		bc2 := core.NewBlockchain()
+		bc1 := newTestBlockchain(t)
+		bc2 := newTestBlockchain(t)
 		defer bc1.Close()
 		defer bc2.Close()
 
@@ -63,10 +59,10 @@ func TestChainReorganization(t *testing.T) {
 
 		// Tamper with a block in bc2 to make it invalid
 		block := bc2.GetBlockByIndex(1)
-		block.Data = "Tampered Data"
+		block.Transactions[0].Outputs[0] = core.NewDataOutput([]byte("Tampered Data"))
 
 		// Get blocks from bc2
-		blocks := bc2.GetBlocks()
+		blocks := bc2.GetBlocks(0, 0)
 
 		// Try to replace bc1's chain with bc2's chain (should fail)
 		success := bc1.ReplaceChain(blocks)
@@ -84,8 +80,8 @@ func TestChainReorganization(t *testing.T) {
 
 	t.Run("ShorterChainRejection", func(t *testing.T) {
 		// Create two blockchains
-		bc1 := core.NewBlockchain()
-		bc2 := core.NewBlockchain()
+		bc1 := newTestBlockchain(t)
+		bc2 := newTestBlockchain(t)
 		defer bc1.Close()
 		defer bc2.Close()
 
@@ -95,7 +91,7 @@ func TestChainReorganization(t *testing.T) {
 		bc1.AddBlock("Block 3")
 
 		// Get blocks from bc2 (shorter chain)
-		blocks := bc2.GetBlocks()
+		blocks := bc2.GetBlocks(0, 0)
 
 		// Try to replace bc1's chain with bc2's chain (should fail)
 		success := bc1.ReplaceChain(blocks)
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/consensus"
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+)
+
+// recordingEngine accepts every block and counts how often the chain calls it
+type recordingEngine struct {
+	config json.RawMessage
+	calls  map[string]int
+}
+
+func (e *recordingEngine) Prepare(chain consensus.ChainReader, block *core.Block) error {
+	e.calls["Prepare"]++
+	block.Validator = "recorder"
+	return nil
+}
+
+func (e *recordingEngine) Seal(ctx context.Context, chain consensus.ChainReader, block *core.Block) error {
+	e.calls["Seal"]++
+	block.Hash = block.CalculateHash()
+	return nil
+}
+
+func (e *recordingEngine) VerifyHeader(chain consensus.ChainReader, block *core.Block, parent *core.ChainHeader) error {
+	e.calls["VerifyHeader"]++
+	if parent.Hash != block.PreviousHash || chain.Header(parent.Hash) == nil {
+		return errors.New("wrong parent")
+	}
+	return nil
+}
+
+func (e *recordingEngine) VerifySeal(chain consensus.ChainReader, block *core.Block) error {
+	e.calls["VerifySeal"]++
+	return nil
+}
+
+func (e *recordingEngine) Finalize(chain consensus.ChainReader, block *core.Block, fees int) error {
+	e.calls["Finalize"]++
+	if block.Transactions[0].OutputValue() > 0 {
+		return errors.New("this engine pays no rewards")
+	}
+	return nil
+}
+
+func init() {
+	core.RegisterEngine("recording", func(config json.RawMessage) (core.ConsensusEngine, error) {
+		return &recordingEngine{config: config, calls: make(map[string]int)}, nil
+	})
+}
+
+// waitingEngine is a recordingEngine whose Seal waits until it is cancelled
+type waitingEngine struct {
+	*recordingEngine
+	sealing chan struct{}
+}
+
+func (e *waitingEngine) Seal(ctx context.Context, chain consensus.ChainReader, block *core.Block) error {
+	close(e.sealing)
+	<-ctx.Done()
+	return context.Cause(ctx)
+}
+
+func TestConsensusEngine(t *testing.T) {
+	t.Run("ProofOfWorkIsTheDefault", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		if _, ok := bc.Engine().(*consensus.PoWEngine); !ok {
+			t.Fatalf("Expected the proof of work engine, got %T", bc.Engine())
+		}
+
+		block := bc.AddBlock("sealed by the engine")
+		if block == nil {
+			t.Fatal("Expected block to be added")
+		}
+		if !consensus.NewProofOfWork(block).Validate() {
+			t.Error("Expected AddBlock to seal the block with proof of work")
+		}
+	})
+
+	t.Run("UnsealedBlockIsRejected", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		// Pick a nonce that misses the target
+		block := core.NewBlock(1, bc.GetLatestBlock().Hash, "no work")
+		block.Hash = block.CalculateHash()
+		for consensus.NewProofOfWork(block).Validate() {
+			block.Nonce++
+			block.Hash = block.CalculateHash()
+		}
+		if _, err := bc.ProcessBlock(block); !errors.Is(err, core.ErrInvalidBlock) || !errors.Is(err, core.ErrInsufficientWork) {
+			t.Errorf("Expected ErrInvalidBlock and ErrInsufficientWork, got %v", err)
+		}
+
+		sealBlock(block)
+		if _, err := bc.ProcessBlock(block); err != nil {
+			t.Errorf("Expected the sealed block to be accepted, got %v", err)
+		}
+	})
+
+	t.Run("UnexpectedBits", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		// A harder target than retargeting asks for is still wrong
+		block := core.NewBlock(1, bc.GetLatestBlock().Hash, "wrong bits")
+		block.Bits = core.BitsFromLeadingZeros(4)
+		sealBlock(block)
+		if _, err := bc.ProcessBlock(block); !errors.Is(err, core.ErrInvalidBlock) || !errors.Is(err, core.ErrUnexpectedBits) {
+			t.Errorf("Expected ErrInvalidBlock and ErrUnexpectedBits, got %v", err)
+		}
+	})
+
+	t.Run("UnknownEngine", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		opts.Backend = core.BackendMemory
+		opts.Consensus = "no such engine"
+
+		if _, err := core.NewBlockchain(opts); !errors.Is(err, core.ErrUnknownEngine) {
+			t.Errorf("Expected ErrUnknownEngine, got %v", err)
+		}
+	})
+
+	t.Run("EngineSelectedByName", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		opts.Backend = core.BackendMemory
+		opts.Consensus = "recording"
+		opts.ConsensusConfig = json.RawMessage(`{"slot":"1s"}`)
+
+		bc, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to create blockchain: %v", err)
+		}
+		defer bc.Close()
+
+		engine, ok := bc.Engine().(*recordingEngine)
+		if !ok {
+			t.Fatalf("Expected the recording engine, got %T", bc.Engine())
+		}
+		if string(engine.config) != `{"slot":"1s"}` {
+			t.Errorf("Expected the engine to receive its config, got %s", engine.config)
+		}
+
+		block := bc.AddBlock("block 1")
+		if block == nil || block.Validator != "recorder" {
+			t.Fatalf("Expected the engine to prepare the block, got %+v", block)
+		}
+		for _, method := range []string{"Prepare", "Seal", "VerifyHeader", "VerifySeal"} {
+			if engine.calls[method] != 1 {
+				t.Errorf("Expected one call to %s, got %d", method, engine.calls[method])
+			}
+		}
+		// The genesis block is finalized too
+		if engine.calls["Finalize"] != 2 {
+			t.Errorf("Expected two calls to Finalize, got %d", engine.calls["Finalize"])
+		}
+
+		if bc.AddBlock([]*core.Transaction{newCoinbase(t, testAddress("miner"), "reward", 1)}) != nil {
+			t.Error("Expected the engine to reject a coinbase reward")
+		}
+	})
+
+	t.Run("SealingRunsWithoutTheChainLock", func(t *testing.T) {
+		engine := &waitingEngine{
+			recordingEngine: &recordingEngine{calls: make(map[string]int)},
+			sealing:         make(chan struct{}),
+		}
+		opts := core.DefaultOptions()
+		opts.Backend = core.BackendMemory
+		opts.Engine = engine
+
+		bc, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to create blockchain: %v", err)
+		}
+		defer bc.Close()
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := bc.AddBlockContext(context.Background(), "sealed slowly")
+			done <- err
+		}()
+		<-engine.sealing
+
+		// The chain keeps accepting blocks while the other one is sealed
+		tip := bc.GetLatestBlock()
+		block := core.NewBlock(tip.Index+1, tip.Hash, "arrived first")
+		block.Timestamp = bc.NextTimestamp(tip)
+		block.Hash = block.CalculateHash()
+		if _, err := bc.ProcessBlock(block); err != nil {
+			t.Fatalf("Expected block to be accepted while sealing, got %v", err)
+		}
+
+		select {
+		case err := <-done:
+			if !errors.Is(err, core.ErrStaleBlock) {
+				t.Errorf("Expected sealing to stop with ErrStaleBlock, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the new tip to cancel sealing")
+		}
+		if bc.Length() != 2 {
+			t.Errorf("Expected only the first block to be added, got length %d", bc.Length())
+		}
+	})
+}
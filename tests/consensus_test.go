@@ -1,7 +1,6 @@
 package main
 
 import (
-	"os"
 	"testing"
 
 	"github.com/antontuzov/coubcore/internal/blockchain/consensus"
@@ -9,12 +8,9 @@ import (
 )
 
 func TestBlockchainConsensus(t *testing.T) {
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
-
 	t.Run("ValidChain", func(t *testing.T) {
 		// Create a new blockchain
-		blockchain := core.NewBlockchain()
+		blockchain := newTestBlockchain(t)
 		defer blockchain.Close()
 
 		// Add some blocks
@@ -30,7 +26,7 @@ func TestBlockchainConsensus(t *testing.T) {
 
 	t.Run("InvalidChain", func(t *testing.T) {
 		// Create a new blockchain
-		blockchain := core.NewBlockchain()
+		blockchain := newTestBlockchain(t)
 		defer blockchain.Close()
 
 		// Add some blocks
@@ -39,7 +35,7 @@ func TestBlockchainConsensus(t *testing.T) {
 
 		// Tamper with a block to make it invalid
 		block := blockchain.GetBlockByIndex(1)
-		block.Data = "Tampered Data"
+		block.Transactions[0].Outputs[0] = core.NewDataOutput([]byte("Tampered Data"))
 
 		// Validate the chain (should fail)
 		if blockchain.IsChainValid() {
@@ -49,7 +45,7 @@ func TestBlockchainConsensus(t *testing.T) {
 
 	t.Run("ProofOfWorkValidation", func(t *testing.T) {
 		// Create a new blockchain
-		blockchain := core.NewBlockchain()
+		blockchain := newTestBlockchain(t)
 		defer blockchain.Close()
 
 		// Mine a new block
@@ -66,8 +62,8 @@ func TestBlockchainConsensus(t *testing.T) {
 
 	t.Run("ChainReplacement", func(t *testing.T) {
 		// Create two blockchains
-		bc1 := core.NewBlockchain()
-		bc2 := core.NewBlockchain()
+		bc1 := newTestBlockchain(t)
+		bc2 := newTestBlockchain(t)
 		defer bc1.Close()
 		defer bc2.Close()
 
@@ -76,7 +72,7 @@ func TestBlockchainConsensus(t *testing.T) {
 		bc2.AddBlock("Block 2")
 
 		// Get blocks from bc2
-		blocks := bc2.GetBlocks()
+		blocks := bc2.GetBlocks(0, 0)
 
 		// Replace bc1's chain with bc2's chain
 		success := bc1.ReplaceChain(blocks)
@@ -94,8 +90,8 @@ func TestBlockchainConsensus(t *testing.T) {
 
 	t.Run("ChainReplacementShorterChain", func(t *testing.T) {
 		// Create two blockchains
-		bc1 := core.NewBlockchain()
-		bc2 := core.NewBlockchain()
+		bc1 := newTestBlockchain(t)
+		bc2 := newTestBlockchain(t)
 		defer bc1.Close()
 		defer bc2.Close()
 
@@ -104,7 +100,7 @@ func TestBlockchainConsensus(t *testing.T) {
 		bc1.AddBlock("Block 2")
 
 		// Get blocks from bc2 (shorter chain)
-		blocks := bc2.GetBlocks()
+		blocks := bc2.GetBlocks(0, 0)
 
 		// Try to replace bc1's chain with bc2's chain (should fail)
 		success := bc1.ReplaceChain(blocks)
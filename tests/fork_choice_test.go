@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/consensus"
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+)
+
+func TestForkChoice(t *testing.T) {
+	t.Run("HeavierShorterBranchWins", func(t *testing.T) {
+		bc := newForkChoiceBlockchain(t)
+		defer bc.Close()
+
+		genesis := bc.GetLatestBlock()
+
+		// Two light blocks on the main chain
+		a1 := childBlock(genesis, 1, "a1")
+		a2 := childBlock(a1, 1, "a2")
+		for _, block := range []*core.Block{a1, a2} {
+			if status, err := bc.ProcessBlock(block); err != nil || status != core.BlockMainChain {
+				t.Fatalf("Expected block %d on the main chain, got %v, %v", block.Index, status, err)
+			}
+		}
+
+		// A single heavier block on a competing branch
+		b1 := childBlock(genesis, 4, "b1")
+		status, err := bc.ProcessBlock(b1)
+		if err != nil {
+			t.Fatalf("Failed to process heavier block: %v", err)
+		}
+		if status != core.BlockMainChain {
+			t.Errorf("Expected heavier block to become the main chain, got %v", status)
+		}
+		if bc.GetLatestBlock().Hash != b1.Hash || bc.Length() != 2 {
+			t.Errorf("Expected tip %s at length 2, got %s at length %d", b1.Hash, bc.GetLatestBlock().Hash, bc.Length())
+		}
+		if bc.GetBlockByIndex(1).Hash != b1.Hash {
+			t.Error("Expected height 1 to hold the heavier block")
+		}
+		if bc.GetBlockByHash(a2.Hash) != nil {
+			t.Error("Expected disconnected block to leave the hash index")
+		}
+
+		// Extending the old branch past the new one switches back
+		a3 := childBlock(a2, 4, "a3")
+		if status, err := bc.ProcessBlock(a3); err != nil || status != core.BlockMainChain {
+			t.Fatalf("Expected reorganization back to the first branch, got %v, %v", status, err)
+		}
+		if bc.GetBlockByIndex(1).Hash != a1.Hash || bc.GetLatestBlock().Hash != a3.Hash {
+			t.Error("Expected the first branch to be reconnected")
+		}
+		if !bc.IsChainValid() {
+			t.Error("Expected chain to be valid after reorganizations")
+		}
+	})
+
+	t.Run("LighterBranchIsKeptAside", func(t *testing.T) {
+		bc := newForkChoiceBlockchain(t)
+		defer bc.Close()
+
+		genesis := bc.GetLatestBlock()
+		mainBlock := childBlock(genesis, 3, "main")
+		side := childBlock(genesis, 2, "side")
+
+		if _, err := bc.ProcessBlock(mainBlock); err != nil {
+			t.Fatalf("Failed to process main block: %v", err)
+		}
+		status, err := bc.ProcessBlock(side)
+		if err != nil {
+			t.Fatalf("Failed to process side block: %v", err)
+		}
+		if status != core.BlockSideChain {
+			t.Errorf("Expected side chain status, got %v", status)
+		}
+		if bc.GetLatestBlock().Hash != mainBlock.Hash {
+			t.Error("Expected tip to stay on the heavier block")
+		}
+
+		if _, err := bc.ProcessBlock(side); !errors.Is(err, core.ErrDuplicateBlock) {
+			t.Errorf("Expected ErrDuplicateBlock, got %v", err)
+		}
+	})
+
+	t.Run("OrphansConnectWhenParentArrives", func(t *testing.T) {
+		bc := newForkChoiceBlockchain(t)
+		defer bc.Close()
+
+		genesis := bc.GetLatestBlock()
+		b1 := childBlock(genesis, 1, "b1")
+		b2 := childBlock(b1, 1, "b2")
+		b3 := childBlock(b2, 1, "b3")
+
+		for _, block := range []*core.Block{b3, b2} {
+			if status, err := bc.ProcessBlock(block); err != nil || status != core.BlockOrphan {
+				t.Fatalf("Expected block %d to be an orphan, got %v, %v", block.Index, status, err)
+			}
+		}
+		if bc.OrphanCount() != 2 {
+			t.Errorf("Expected 2 orphans, got %d", bc.OrphanCount())
+		}
+
+		if _, err := bc.ProcessBlock(b1); err != nil {
+			t.Fatalf("Failed to process parent block: %v", err)
+		}
+		if bc.OrphanCount() != 0 {
+			t.Errorf("Expected orphan pool to be empty, got %d", bc.OrphanCount())
+		}
+		if bc.GetLatestBlock().Hash != b3.Hash {
+			t.Errorf("Expected orphans to be connected up to block 3, tip is %d", bc.GetLatestBlock().Index)
+		}
+	})
+
+	t.Run("InvalidBlocksAreRejected", func(t *testing.T) {
+		bc := newForkChoiceBlockchain(t)
+		defer bc.Close()
+
+		genesis := bc.GetLatestBlock()
+		block := childBlock(genesis, 1, "bad height")
+		block.Index = 5
+		sealBlock(block)
+
+		if _, err := bc.ProcessBlock(block); !errors.Is(err, core.ErrInvalidBlock) {
+			t.Errorf("Expected ErrInvalidBlock, got %v", err)
+		}
+	})
+
+	t.Run("FailedBranchMarksTheBlockAtFault", func(t *testing.T) {
+		bc := newForkChoiceBlockchain(t)
+		defer bc.Close()
+
+		genesis := bc.GetLatestBlock()
+		mainBlock := childBlock(genesis, 2, "main")
+		if _, err := bc.ProcessBlock(mainBlock); err != nil {
+			t.Fatalf("Failed to process main block: %v", err)
+		}
+
+		// A lighter side block spending an output that does not exist is
+		// stored without being connected
+		spend := &core.Transaction{
+			Inputs:  []core.TXInput{{TXID: "missing", Vout: 0}},
+			Outputs: []core.TXOutput{newOutput(t, 10, testAddress("recipient"))},
+		}
+		spend.SetID()
+		bad := childBlock(genesis, 1, []*core.Transaction{spend})
+		if status, err := bc.ProcessBlock(bad); err != nil || status != core.BlockSideChain {
+			t.Fatalf("Expected bad block to be kept aside, got %v, %v", status, err)
+		}
+
+		// Its heavier child makes the chain try to connect it
+		child := childBlock(bad, 3, "child")
+		if _, err := bc.ProcessBlock(child); !errors.Is(err, core.ErrInvalidBlock) {
+			t.Fatalf("Expected reorganization onto the bad block to fail, got %v", err)
+		}
+		if bc.GetLatestBlock().Hash != mainBlock.Hash {
+			t.Error("Expected tip to stay on the main block")
+		}
+
+		// The block that failed is remembered as invalid, not merely known
+		if _, err := bc.ProcessBlock(bad); !errors.Is(err, core.ErrInvalidBlock) {
+			t.Errorf("Expected failed block to be known as invalid, got %v", err)
+		}
+		if _, err := bc.ProcessBlock(childBlock(bad, 3, "sibling")); !errors.Is(err, core.ErrInvalidBlock) {
+			t.Errorf("Expected block on the failed block to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("SideBranchSurvivesRestart", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		opts.DataDir = t.TempDir()
+		opts.Engine = anyTargetEngine{consensus.NewPoWEngine()}
+
+		bc, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to create blockchain: %v", err)
+		}
+		genesis := bc.GetLatestBlock()
+		mainBlock := childBlock(genesis, 2, "main")
+		side := childBlock(genesis, 1, "side")
+		bc.ProcessBlock(mainBlock)
+		bc.ProcessBlock(side)
+		bc.Close()
+
+		reopened, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to reopen blockchain: %v", err)
+		}
+		defer reopened.Close()
+
+		// The stored side block can still win once its branch gets heavier
+		sideChild := childBlock(side, 3, "side child")
+		if status, err := reopened.ProcessBlock(sideChild); err != nil || status != core.BlockMainChain {
+			t.Fatalf("Expected stored side branch to take over, got %v, %v", status, err)
+		}
+		if reopened.GetBlockByIndex(1).Hash != side.Hash {
+			t.Error("Expected side block to be reconnected from the store")
+		}
+	})
+}
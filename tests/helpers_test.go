@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/consensus"
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+// newTestBlockchain creates a blockchain backed by an in-memory store so
+// tests never share or delete each other's database files. Coinbase outputs
+// are spendable right away so tests need not mine a maturity's worth of blocks.
+func newTestBlockchain(tb testing.TB) *core.Blockchain {
+	tb.Helper()
+
+	opts := core.DefaultOptions()
+	opts.Backend = core.BackendMemory
+	opts.Params.CoinbaseMaturity = 0
+
+	bc, err := core.NewBlockchain(opts)
+	if err != nil {
+		tb.Fatalf("Failed to create blockchain: %v", err)
+	}
+
+	return bc
+}
+
+// newWallet creates a wallet with a fresh key
+func newWallet(tb testing.TB) *wallet.Wallet {
+	tb.Helper()
+
+	w, err := wallet.NewWallet()
+	if err != nil {
+		tb.Fatalf("Failed to create wallet: %v", err)
+	}
+	return w
+}
+
+// testAddress returns a well-formed address standing in for a label, for
+// parties a test pays but never spends as
+func testAddress(label string) string {
+	return core.PubKeyHashToAddress(core.HashPubKey([]byte(label)))
+}
+
+// newCoinbase creates a coinbase transaction paying value to an address
+func newCoinbase(tb testing.TB, to, data string, value int) *core.Transaction {
+	tb.Helper()
+
+	tx, err := core.NewCoinbaseTransaction(to, data, value)
+	if err != nil {
+		tb.Fatalf("Failed to create coinbase transaction: %v", err)
+	}
+	return tx
+}
+
+// newOutput creates an output paying value to an address
+func newOutput(tb testing.TB, value int, address string) core.TXOutput {
+	tb.Helper()
+
+	output, err := core.NewTXOutput(value, address)
+	if err != nil {
+		tb.Fatalf("Failed to create output: %v", err)
+	}
+	return output
+}
+
+// sealBlock solves the proof of work of a block built by hand, so the chain
+// checks the rules a test is about rather than rejecting an unsealed block
+func sealBlock(block *core.Block) *core.Block {
+	consensus.NewPoWEngine().Seal(context.Background(), nil, block)
+	return block
+}
+
+// childBlock builds a sealed block on top of parent whose target takes the
+// given number of leading zero bits. One zero bit is the proof of work limit.
+func childBlock(parent *core.Block, difficulty uint, data interface{}) *core.Block {
+	block := core.NewBlock(parent.Index+1, parent.Hash, data)
+	block.Timestamp = parent.Timestamp.Add(time.Second)
+	if difficulty > 1 {
+		block.Bits = core.BitsFromLeadingZeros(difficulty)
+	}
+	return sealBlock(block)
+}
+
+// anyTargetEngine is proof of work without retargeting, so fork choice
+// tests can build branches of any weight
+type anyTargetEngine struct {
+	*consensus.PoWEngine
+}
+
+// VerifyHeader accepts any target
+func (anyTargetEngine) VerifyHeader(chain consensus.ChainReader, block *core.Block, parent *core.ChainHeader) error {
+	return nil
+}
+
+// newForkChoiceBlockchain creates a blockchain like newTestBlockchain that
+// accepts blocks of any target
+func newForkChoiceBlockchain(t *testing.T) *core.Blockchain {
+	t.Helper()
+
+	opts := core.DefaultOptions()
+	opts.Backend = core.BackendMemory
+	opts.Params.CoinbaseMaturity = 0
+	opts.Engine = anyTargetEngine{consensus.NewPoWEngine()}
+
+	bc, err := core.NewBlockchain(opts)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	return bc
+}
+
+// prepareBlock builds a block holding data on the tip, prepared and sealed
+// by an engine
+func prepareBlock(t *testing.T, bc *core.Blockchain, engine core.ConsensusEngine, data interface{}) *core.Block {
+	t.Helper()
+
+	tip := bc.GetLatestBlock()
+	block := core.NewBlock(tip.Index+1, tip.Hash, data)
+	if err := engine.Prepare(bc, block); err != nil {
+		t.Fatalf("Failed to prepare block: %v", err)
+	}
+	if err := engine.Seal(context.Background(), bc, block); err != nil {
+		t.Fatalf("Failed to seal block: %v", err)
+	}
+	return block
+}
+
+// produceBlock adds a block built by prepareBlock to the chain
+func produceBlock(t *testing.T, bc *core.Blockchain, engine core.ConsensusEngine, data interface{}) *core.Block {
+	t.Helper()
+
+	block := prepareBlock(t, bc, engine, data)
+	if _, err := bc.ProcessBlock(block); err != nil {
+		t.Fatalf("Expected block to be accepted, got %v", err)
+	}
+	return block
+}
+
+// pausingReader reads a chain but signals its first header lookup and waits
+// before making it, so a test can start a block import in between
+type pausingReader struct {
+	*core.Blockchain
+	once    sync.Once
+	reading chan struct{}
+}
+
+// Header signals the first lookup, waits for the import to take the chain's
+// lock and then reads the header
+func (r *pausingReader) Header(hash string) *core.ChainHeader {
+	r.once.Do(func() {
+		close(r.reading)
+		time.Sleep(100 * time.Millisecond)
+	})
+	return r.Blockchain.Header(hash)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+)
+
+func TestBlockchainIndexes(t *testing.T) {
+	t.Run("HashAndTransactionLookup", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		tx := newCoinbase(t, testAddress("recipient"), "indexed", 100)
+		block := bc.AddBlock([]*core.Transaction{tx})
+
+		found := bc.GetBlockByHash(block.Hash)
+		if found == nil || found.Index != block.Index {
+			t.Fatalf("Expected to find block %d by hash", block.Index)
+		}
+
+		got, containing := bc.GetTransaction(tx.ID)
+		if got == nil {
+			t.Fatal("Expected to find transaction by ID")
+		}
+		if got.ID != tx.ID || containing.Hash != block.Hash {
+			t.Error("Expected transaction to be returned with its block")
+		}
+
+		if bc.GetBlockByHash("unknown") != nil {
+			t.Error("Expected unknown hash to return nil")
+		}
+		if unknown, _ := bc.GetTransaction("unknown"); unknown != nil {
+			t.Error("Expected unknown txid to return nil")
+		}
+	})
+
+	t.Run("ReplaceChainUpdatesIndexes", func(t *testing.T) {
+		bc1 := newTestBlockchain(t)
+		bc2 := newTestBlockchain(t)
+		defer bc1.Close()
+		defer bc2.Close()
+
+		// bc1 holds a transaction that the longer bc2 chain does not
+		orphanedTx := newCoinbase(t, testAddress("recipient"), "orphaned", 100)
+		orphanedBlock := bc1.AddBlock([]*core.Transaction{orphanedTx})
+
+		replacementTx := newCoinbase(t, testAddress("recipient"), "replacement", 100)
+		bc2.AddBlock([]*core.Transaction{replacementTx})
+		bc2.AddBlock("Block 2")
+
+		if !bc1.ReplaceChain(bc2.GetBlocks(0, 0)) {
+			t.Fatal("Expected chain replacement to be successful")
+		}
+
+		if bc1.GetBlockByHash(orphanedBlock.Hash) != nil {
+			t.Error("Expected replaced block to be removed from the hash index")
+		}
+		if tx, _ := bc1.GetTransaction(orphanedTx.ID); tx != nil {
+			t.Error("Expected replaced transaction to be removed from the tx index")
+		}
+		if tx, _ := bc1.GetTransaction(replacementTx.ID); tx == nil {
+			t.Error("Expected transaction from the new chain to be indexed")
+		}
+	})
+
+	t.Run("IndexesSurviveRestart", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		opts.DataDir = t.TempDir()
+
+		bc, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to create blockchain: %v", err)
+		}
+		tx := newCoinbase(t, testAddress("recipient"), "persisted", 100)
+		block := bc.AddBlock([]*core.Transaction{tx})
+		bc.Close()
+
+		reopened, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to reopen blockchain: %v", err)
+		}
+		defer reopened.Close()
+
+		if reopened.GetBlockByHash(block.Hash) == nil {
+			t.Error("Expected block to be found by hash after restart")
+		}
+		if got, _ := reopened.GetTransaction(tx.ID); got == nil {
+			t.Error("Expected transaction to be found after restart")
+		}
+	})
+}
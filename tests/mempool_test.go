@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/mempool"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+// fundWallets mines a block paying a coinbase reward of 100 to each wallet
+func fundWallets(t *testing.T, bc *core.Blockchain, wallets ...*wallet.Wallet) *core.Block {
+	t.Helper()
+
+	var txs []*core.Transaction
+	for _, w := range wallets {
+		txs = append(txs, newCoinbase(t, w.Address, "fund "+w.Address, 100))
+	}
+	// Only the first transaction of a block may be a coinbase, so the
+	// rest are mined one block each
+	var block *core.Block
+	for _, tx := range txs {
+		if block = bc.AddBlock([]*core.Transaction{tx}); block == nil {
+			t.Fatal("Failed to add funding block")
+		}
+	}
+	return block
+}
+
+// signedSpend builds and signs a transaction that pays amount to an address
+// and leaves fee to the miner
+func signedSpend(t *testing.T, bc *core.Blockchain, from *wallet.Wallet, to string, amount, fee int) *core.Transaction {
+	t.Helper()
+
+	utxos, err := bc.GetUTXOsByAddress(from.Address)
+	if err != nil {
+		t.Fatalf("Failed to get UTXOs: %v", err)
+	}
+	tx, err := core.NewTransaction(from.Address, to, amount, map[string][]core.UTXO{from.Address: utxos})
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	tx.Outputs[len(tx.Outputs)-1].Value -= fee
+	if err := bc.SignTransaction(tx, from.PrivateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	return tx
+}
+
+func TestMempoolAcceptAndReject(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+	pool := mempool.NewMempool(bc, mempool.DefaultOptions())
+
+	alice, bob := newWallet(t), newWallet(t)
+	fundWallets(t, bc, alice)
+
+	tx := signedSpend(t, bc, alice, bob.Address, 40, 2)
+	if err := pool.Add(tx); err != nil {
+		t.Fatalf("Expected transaction to be accepted, got %v", err)
+	}
+	if !pool.Has(tx.ID) || pool.Count() != 1 {
+		t.Fatal("Expected transaction to be in the pool")
+	}
+	if entry := pool.Entries()[0]; entry.Fee != 2 {
+		t.Errorf("Expected fee 2, got %d", entry.Fee)
+	}
+
+	if err := pool.Add(tx); !errors.Is(err, mempool.ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists, got %v", err)
+	}
+
+	conflict := signedSpend(t, bc, alice, bob.Address, 50, 2)
+	if err := pool.Add(conflict); !errors.Is(err, mempool.ErrDoubleSpend) {
+		t.Errorf("Expected ErrDoubleSpend, got %v", err)
+	}
+
+	missing := signedSpend(t, bc, alice, bob.Address, 10, 0)
+	missing.Inputs[0].TXID = "unknown"
+	missing.SetID()
+	if err := pool.Add(missing); !errors.Is(err, mempool.ErrMissingInputs) {
+		t.Errorf("Expected ErrMissingInputs, got %v", err)
+	}
+
+	coinbase := newCoinbase(t, bob.Address, "pool", 100)
+	if err := pool.Add(coinbase); !errors.Is(err, mempool.ErrInvalidTransaction) {
+		t.Errorf("Expected coinbase to be rejected, got %v", err)
+	}
+}
+
+func TestMempoolRejectsBadSignature(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+	pool := mempool.NewMempool(bc, mempool.DefaultOptions())
+
+	alice, mallory := newWallet(t), newWallet(t)
+	fundWallets(t, bc, alice)
+
+	// Mallory tries to spend alice's output
+	tx := signedSpend(t, bc, alice, mallory.Address, 40, 0)
+	if err := bc.SignTransaction(tx, mallory.PrivateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	if err := pool.Add(tx); !errors.Is(err, mempool.ErrInvalidTransaction) {
+		t.Errorf("Expected ErrInvalidTransaction, got %v", err)
+	}
+
+	overspend := signedSpend(t, bc, alice, mallory.Address, 40, 0)
+	overspend.Outputs[0].Value = 500
+	if err := bc.SignTransaction(overspend, alice.PrivateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	if err := pool.Add(overspend); !errors.Is(err, mempool.ErrInvalidTransaction) {
+		t.Errorf("Expected overspend to be rejected, got %v", err)
+	}
+}
+
+func TestMempoolOrderedByFeeRate(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+	pool := mempool.NewMempool(bc, mempool.DefaultOptions())
+
+	alice, bob, carol := newWallet(t), newWallet(t), newWallet(t)
+	fundWallets(t, bc, alice, bob, carol)
+
+	low := signedSpend(t, bc, alice, carol.Address, 10, 1)
+	high := signedSpend(t, bc, bob, carol.Address, 10, 9)
+	mid := signedSpend(t, bc, carol, alice.Address, 10, 5)
+	for _, tx := range []*core.Transaction{low, high, mid} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatalf("Failed to add transaction: %v", err)
+		}
+	}
+
+	txs := pool.Transactions()
+	if len(txs) != 3 || txs[0].ID != high.ID || txs[1].ID != mid.ID || txs[2].ID != low.ID {
+		t.Error("Expected transactions ordered by fee rate, highest first")
+	}
+}
+
+func TestMempoolFullPoolEvictsCheapest(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+
+	opts := mempool.DefaultOptions()
+	opts.MaxTransactions = 1
+	pool := mempool.NewMempool(bc, opts)
+
+	alice, bob, carol := newWallet(t), newWallet(t), newWallet(t)
+	fundWallets(t, bc, alice, bob, carol)
+
+	low := signedSpend(t, bc, alice, carol.Address, 10, 1)
+	high := signedSpend(t, bc, bob, carol.Address, 10, 9)
+	if err := pool.Add(low); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+	if err := pool.Add(high); err != nil {
+		t.Fatalf("Expected higher fee transaction to evict, got %v", err)
+	}
+	if pool.Has(low.ID) || !pool.Has(high.ID) {
+		t.Error("Expected the cheaper transaction to be evicted")
+	}
+
+	if err := pool.Add(signedSpend(t, bc, carol, alice.Address, 10, 0)); !errors.Is(err, mempool.ErrPoolFull) {
+		t.Errorf("Expected ErrPoolFull, got %v", err)
+	}
+}
+
+func TestMempoolFullPoolKeepsParents(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+
+	opts := mempool.DefaultOptions()
+	opts.MaxTransactions = 1
+	pool := mempool.NewMempool(bc, opts)
+
+	alice, carol := newWallet(t), newWallet(t)
+	fundWallets(t, bc, alice)
+
+	parent := signedSpend(t, bc, alice, carol.Address, 10, 1)
+	if err := pool.Add(parent); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	// The child pays a higher fee rate, but the only entry it could
+	// evict is the parent it spends from
+	utxo := core.UTXO{TXID: parent.ID, Index: 0, Output: parent.Outputs[0]}
+	child, err := core.NewTransaction(carol.Address, alice.Address, 5, map[string][]core.UTXO{carol.Address: {utxo}})
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	child.Outputs[len(child.Outputs)-1].Value -= 4
+	if err := child.Sign(carol.PrivateKey, map[string]core.Transaction{parent.ID: *parent}); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+
+	if err := pool.Add(child); !errors.Is(err, mempool.ErrPoolFull) {
+		t.Errorf("Expected ErrPoolFull, got %v", err)
+	}
+	if !pool.Has(parent.ID) || pool.Has(child.ID) {
+		t.Error("Expected the parent to stay and the child to be refused")
+	}
+}
+
+func TestMempoolMinedTransactionsEvicted(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+	pool := mempool.NewMempool(bc, mempool.DefaultOptions())
+
+	alice, bob := newWallet(t), newWallet(t)
+	fundWallets(t, bc, alice)
+
+	tx := signedSpend(t, bc, alice, bob.Address, 40, 1)
+	if err := pool.Add(tx); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	if bc.AddBlock([]*core.Transaction{tx}) == nil {
+		t.Fatal("Failed to mine transaction")
+	}
+	if pool.Count() != 0 {
+		t.Errorf("Expected mined transaction to leave the pool, got %d entries", pool.Count())
+	}
+}
+
+func TestMempoolConflictingBlockEvicts(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+	pool := mempool.NewMempool(bc, mempool.DefaultOptions())
+
+	alice, bob := newWallet(t), newWallet(t)
+	fundWallets(t, bc, alice)
+
+	pooled := signedSpend(t, bc, alice, bob.Address, 40, 1)
+	mined := signedSpend(t, bc, alice, bob.Address, 60, 1)
+	if err := pool.Add(pooled); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+	if bc.AddBlock([]*core.Transaction{mined}) == nil {
+		t.Fatal("Failed to mine transaction")
+	}
+	if pool.Has(pooled.ID) {
+		t.Error("Expected transaction conflicting with the block to leave the pool")
+	}
+}
+
+func TestMempoolExpiry(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+
+	opts := mempool.DefaultOptions()
+	opts.Expiry = 10 * time.Millisecond
+	pool := mempool.NewMempool(bc, opts)
+
+	alice, bob := newWallet(t), newWallet(t)
+	fundWallets(t, bc, alice)
+
+	if err := pool.Add(signedSpend(t, bc, alice, bob.Address, 40, 1)); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	pool.Expire()
+	if pool.Count() != 0 {
+		t.Errorf("Expected expired transaction to be dropped, got %d entries", pool.Count())
+	}
+}
+
+func TestMempoolReorgReturnsTransactions(t *testing.T) {
+	bc := newForkChoiceBlockchain(t)
+	defer bc.Close()
+	pool := mempool.NewMempool(bc, mempool.DefaultOptions())
+
+	alice, bob := newWallet(t), newWallet(t)
+	funded := fundWallets(t, bc, alice)
+
+	tx := signedSpend(t, bc, alice, bob.Address, 40, 1)
+	bc.AddBlock([]*core.Transaction{tx})
+	if pool.Count() != 0 {
+		t.Fatal("Expected the pool to be empty")
+	}
+
+	// A heavier branch from the funding block without the spend
+	fork := childBlock(funded, 4, "fork")
+	if status, err := bc.ProcessBlock(fork); err != nil || status != core.BlockMainChain {
+		t.Fatalf("Expected fork to become the main chain, got %v, %v", status, err)
+	}
+
+	if !pool.Has(tx.ID) {
+		t.Error("Expected the disconnected transaction to return to the pool")
+	}
+}
+
+func TestMempoolReorgEvictsOrphanedSpends(t *testing.T) {
+	bc := newForkChoiceBlockchain(t)
+	defer bc.Close()
+	pool := mempool.NewMempool(bc, mempool.DefaultOptions())
+
+	alice, bob := newWallet(t), newWallet(t)
+	funded := fundWallets(t, bc, alice)
+	fundWallets(t, bc, bob)
+
+	tx := signedSpend(t, bc, bob, alice.Address, 40, 1)
+	if err := pool.Add(tx); err != nil {
+		t.Fatalf("Failed to add transaction: %v", err)
+	}
+
+	// A heavier branch from alice's funding block drops bob's coinbase
+	fork := childBlock(funded, 4, "fork")
+	if status, err := bc.ProcessBlock(fork); err != nil || status != core.BlockMainChain {
+		t.Fatalf("Expected fork to become the main chain, got %v, %v", status, err)
+	}
+
+	if pool.Has(tx.ID) {
+		t.Error("Expected the spend of a disconnected coinbase to leave the pool")
+	}
+}
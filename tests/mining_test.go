@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/api"
+	"github.com/antontuzov/coubcore/internal/blockchain/consensus"
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/mempool"
+	"github.com/antontuzov/coubcore/internal/blockchain/mining"
+)
+
+// solveTemplate finds a nonce for a template the way an external miner does
+func solveTemplate(t *testing.T, template *mining.Template) mining.Solution {
+	t.Helper()
+
+	nonce, _, err := consensus.NewProofOfWork(template.Block(0)).Run(context.Background(), consensus.DefaultMinerOptions())
+	if err != nil {
+		t.Fatalf("Failed to solve template: %v", err)
+	}
+	return mining.Solution{TemplateID: template.ID, Nonce: nonce}
+}
+
+func TestMiningTemplateOnTip(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+	miner := newWallet(t)
+	manager := mining.NewManager(bc, nil, mining.DefaultOptions())
+
+	if _, err := manager.Template("not an address"); !errors.Is(err, mining.ErrInvalidPayout) {
+		t.Errorf("Expected ErrInvalidPayout, got %v", err)
+	}
+
+	template, err := manager.Template(miner.Address)
+	if err != nil {
+		t.Fatalf("Failed to build template: %v", err)
+	}
+	tip := bc.GetLatestBlock()
+	if template.Height != tip.Index+1 || template.PreviousHash != tip.Hash {
+		t.Errorf("Expected a template on the tip, got height %d on %s", template.Height, template.PreviousHash)
+	}
+	if bits, _ := consensus.NextBits(bc, bc.Header(tip.Hash)); template.Bits != bits {
+		t.Errorf("Expected bits %08x, got %08x", bits, template.Bits)
+	}
+	if template.CoinbaseValue != bc.Params().Subsidy(template.Height) {
+		t.Errorf("Expected the coinbase to claim the subsidy, got %d", template.CoinbaseValue)
+	}
+
+	// The header miners hash is the header of the block the template describes
+	header, err := template.Block(0).Header()
+	if err != nil {
+		t.Fatalf("Failed to encode header: %v", err)
+	}
+	if template.Header != hex.EncodeToString(header.Serialize()) {
+		t.Error("Expected the template header to match its block")
+	}
+
+	if again, _ := manager.Template(miner.Address); again.ID == template.ID || again.Header == template.Header {
+		t.Error("Expected every template to have its own ID and header")
+	}
+}
+
+func TestMiningSubmitSolution(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+	miner := newWallet(t)
+	opts := mining.DefaultOptions()
+	opts.PayoutAddress = miner.Address
+	manager := mining.NewManager(bc, nil, opts)
+
+	template, err := manager.Template("")
+	if err != nil {
+		t.Fatalf("Failed to build template: %v", err)
+	}
+	block, err := manager.Submit(solveTemplate(t, template))
+	if err != nil {
+		t.Fatalf("Expected the solution to be accepted, got %v", err)
+	}
+	if bc.GetLatestBlock().Hash != block.Hash {
+		t.Error("Expected the solved block to become the tip")
+	}
+	if balance, _ := bc.GetBalance(miner.Address); balance != template.CoinbaseValue {
+		t.Errorf("Expected the payout address to receive %d, got %d", template.CoinbaseValue, balance)
+	}
+}
+
+func TestMiningBadSolutions(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+	manager := mining.NewManager(bc, nil, mining.DefaultOptions())
+
+	template, err := manager.Template(newWallet(t).Address)
+	if err != nil {
+		t.Fatalf("Failed to build template: %v", err)
+	}
+
+	// A nonce whose hash misses the target
+	nonce := uint64(0)
+	for template.Block(nonce).CheckProofOfWork(core.PowLimitBits) == nil {
+		nonce++
+	}
+	_, err = manager.Submit(mining.Solution{TemplateID: template.ID, Nonce: nonce})
+	if !errors.Is(err, core.ErrInsufficientWork) {
+		t.Errorf("Expected ErrInsufficientWork, got %v", err)
+	}
+
+	if _, err := manager.Submit(mining.Solution{TemplateID: "no such template"}); !errors.Is(err, mining.ErrUnknownTemplate) {
+		t.Errorf("Expected ErrUnknownTemplate, got %v", err)
+	}
+}
+
+func TestMiningOldTemplatesAreForgotten(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+	opts := mining.DefaultOptions()
+	opts.MaxTemplates = 2
+	manager := mining.NewManager(bc, nil, opts)
+	miner := newWallet(t)
+
+	var templates []*mining.Template
+	for i := 0; i < 3; i++ {
+		template, err := manager.Template(miner.Address)
+		if err != nil {
+			t.Fatalf("Failed to build template: %v", err)
+		}
+		templates = append(templates, template)
+	}
+
+	if _, err := manager.Submit(solveTemplate(t, templates[0])); !errors.Is(err, mining.ErrUnknownTemplate) {
+		t.Errorf("Expected the oldest template to be forgotten, got %v", err)
+	}
+	if _, err := manager.Submit(solveTemplate(t, templates[2])); err != nil {
+		t.Errorf("Expected the newest template to be accepted, got %v", err)
+	}
+}
+
+func TestMiningStaleWhenTipChanges(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+	manager := mining.NewManager(bc, nil, mining.DefaultOptions())
+
+	template, err := manager.Template(newWallet(t).Address)
+	if err != nil {
+		t.Fatalf("Failed to build template: %v", err)
+	}
+	solution := solveTemplate(t, template)
+
+	waited := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		waited <- manager.Wait(ctx, template.ID)
+	}()
+
+	// Another miner wins the block first
+	if bc.AddBlock("found elsewhere") == nil {
+		t.Fatal("Failed to add block")
+	}
+	if err := <-waited; err != nil {
+		t.Errorf("Expected waiting miners to learn the template is stale, got %v", err)
+	}
+	if _, err := manager.Submit(solution); !errors.Is(err, mining.ErrStaleTemplate) {
+		t.Errorf("Expected ErrStaleTemplate, got %v", err)
+	}
+}
+
+func TestMiningMempoolTransactions(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+	pool := mempool.NewMempool(bc, mempool.DefaultOptions())
+	manager := mining.NewManager(bc, pool, mining.DefaultOptions())
+	alice, bob, carol := newWallet(t), newWallet(t), newWallet(t)
+
+	if bc.AddBlock([]*core.Transaction{newCoinbase(t, alice.Address, "fund alice", 100)}) == nil {
+		t.Fatal("Failed to add funding block")
+	}
+
+	// Alice pays bob with a low fee and bob spends the unconfirmed
+	// output with a higher one, so the child has the better fee rate
+	utxos, _ := bc.GetUTXOsByAddress(alice.Address)
+	parent, err := core.NewTransaction(alice.Address, bob.Address, 40, map[string][]core.UTXO{alice.Address: utxos})
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	parent.Outputs[len(parent.Outputs)-1].Value -= 1
+	if err := bc.SignTransaction(parent, alice.PrivateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+
+	unconfirmed := []core.UTXO{{TXID: parent.ID, Index: 0, Output: parent.Outputs[0]}}
+	child, err := core.NewTransaction(bob.Address, carol.Address, 20, map[string][]core.UTXO{bob.Address: unconfirmed})
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	child.Outputs[len(child.Outputs)-1].Value -= 10
+	if err := child.Sign(bob.PrivateKey, map[string]core.Transaction{parent.ID: *parent}); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+
+	for _, tx := range []*core.Transaction{parent, child} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatalf("Failed to add transaction to the pool: %v", err)
+		}
+	}
+	if pool.Entries()[0].Tx.ID != child.ID {
+		t.Fatal("Expected the child to have the higher fee rate")
+	}
+
+	template, err := manager.Template(carol.Address)
+	if err != nil {
+		t.Fatalf("Failed to build template: %v", err)
+	}
+	if len(template.Transactions) != 3 || template.Transactions[1].ID != parent.ID || template.Transactions[2].ID != child.ID {
+		t.Fatal("Expected the coinbase, then the parent, then the child")
+	}
+	if want := bc.Params().Subsidy(template.Height) + 11; template.CoinbaseValue != want {
+		t.Errorf("Expected the coinbase to claim the subsidy and fees of %d, got %d", want, template.CoinbaseValue)
+	}
+
+	if _, err := manager.Submit(solveTemplate(t, template)); err != nil {
+		t.Fatalf("Expected the solution to be accepted, got %v", err)
+	}
+	if pool.Count() != 0 {
+		t.Errorf("Expected the mined transactions to leave the pool, %d left", pool.Count())
+	}
+}
+
+func TestMiningAPI(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+	pool := mempool.NewMempool(bc, mempool.DefaultOptions())
+	server := api.NewServer(bc, nil, pool, 0)
+
+	rec := httptest.NewRecorder()
+	server.GetBlockTemplate(rec, httptest.NewRequest(http.MethodGet, "/api/v1/mining/template", nil))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("Expected 501 without a template manager, got %d", rec.Code)
+	}
+
+	server.SetMining(mining.NewManager(bc, pool, mining.DefaultOptions()))
+	rec = httptest.NewRecorder()
+	server.GetBlockTemplate(rec, httptest.NewRequest(http.MethodGet, "/api/v1/mining/template?address="+newWallet(t).Address, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected a template, got %d: %s", rec.Code, rec.Body)
+	}
+	var template mining.Template
+	if err := json.NewDecoder(rec.Body).Decode(&template); err != nil {
+		t.Fatalf("Failed to decode template: %v", err)
+	}
+
+	submit := func(solution mining.Solution) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(solution)
+		rec := httptest.NewRecorder()
+		server.SubmitBlock(rec, httptest.NewRequest(http.MethodPost, "/api/v1/mining/submit", bytes.NewReader(body)))
+		return rec
+	}
+
+	// The template survives the trip through JSON
+	solution := solveTemplate(t, &template)
+	if rec := submit(solution); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), bc.GetLatestBlock().Hash) {
+		t.Fatalf("Expected the solution to be accepted, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := submit(solution); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a stale template, got %d", rec.Code)
+	}
+	if rec := submit(mining.Solution{TemplateID: "no such template"}); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown template, got %d", rec.Code)
+	}
+}
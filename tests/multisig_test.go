@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+func TestMultiSig(t *testing.T) {
+	var signers []*wallet.Wallet
+	for i := 0; i < 3; i++ {
+		w, err := wallet.NewWallet()
+		if err != nil {
+			t.Fatalf("Failed to create wallet: %v", err)
+		}
+		signers = append(signers, w)
+	}
+	pubKeys := [][]byte{signers[0].PublicKey, signers[1].PublicKey, signers[2].PublicKey}
+
+	t.Run("ScriptShape", func(t *testing.T) {
+		out, err := wallet.NewMultiSigOutput(50, 2, pubKeys...)
+		if err != nil {
+			t.Fatalf("Failed to create multisig output: %v", err)
+		}
+
+		m, keys, ok := out.Script.MultiSig()
+		if !ok || m != 2 || len(keys) != 3 {
+			t.Errorf("Expected a 2-of-3 script, got %d-of-%d (%v)", m, len(keys), ok)
+		}
+
+		if _, err := wallet.NewMultiSigOutput(50, 4, pubKeys...); err == nil {
+			t.Error("Expected a threshold above the key count to be rejected")
+		}
+		if _, err := wallet.NewMultiSigOutput(50, 0, pubKeys...); err == nil {
+			t.Error("Expected a zero threshold to be rejected")
+		}
+	})
+
+	t.Run("TwoOfThreeTreasury", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		// Fund the treasury from a coinbase
+		funder, err := wallet.NewWallet()
+		if err != nil {
+			t.Fatalf("Failed to create wallet: %v", err)
+		}
+		bc.AddBlock([]*core.Transaction{newCoinbase(t, funder.Address, "reward", 100)})
+
+		utxos, err := bc.GetUTXOsByAddress(funder.Address)
+		if err != nil {
+			t.Fatalf("Failed to get UTXOs: %v", err)
+		}
+		funding, err := core.NewTransaction(funder.Address, testAddress("treasury"), 60, map[string][]core.UTXO{funder.Address: utxos})
+		if err != nil {
+			t.Fatalf("Failed to create transaction: %v", err)
+		}
+		if funding.Outputs[0], err = wallet.NewMultiSigOutput(60, 2, pubKeys...); err != nil {
+			t.Fatalf("Failed to create multisig output: %v", err)
+		}
+		if err := bc.SignTransaction(funding, funder.PrivateKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		if bc.AddBlock([]*core.Transaction{funding}) == nil {
+			t.Fatal("Expected treasury funding to be mined")
+		}
+
+		spend := &core.Transaction{
+			Inputs:  []core.TXInput{{TXID: funding.ID, Vout: 0}},
+			Outputs: []core.TXOutput{newOutput(t, 60, testAddress("payee"))},
+		}
+		prevOuts := []core.TXOutput{funding.Outputs[0]}
+
+		// The first signer signs and passes the transaction on
+		partial, err := core.NewPartialTransaction(spend, prevOuts)
+		if err != nil {
+			t.Fatalf("Failed to create partial transaction: %v", err)
+		}
+		if n, err := signers[0].SignPartial(partial); err != nil || n != 1 {
+			t.Fatalf("Expected one input signed, got %d, %v", n, err)
+		}
+		if partial.IsComplete() {
+			t.Error("Expected one of two signatures to be incomplete")
+		}
+		if _, err := partial.Finalize(); !errors.Is(err, core.ErrIncompleteTransaction) {
+			t.Errorf("Expected ErrIncompleteTransaction, got %v", err)
+		}
+
+		data, err := partial.Serialize()
+		if err != nil {
+			t.Fatalf("Failed to serialize partial transaction: %v", err)
+		}
+
+		// The third signer adds the second signature and finalizes
+		received, err := core.DeserializePartialTransaction(data)
+		if err != nil {
+			t.Fatalf("Failed to deserialize partial transaction: %v", err)
+		}
+		if n, err := signers[2].SignPartial(received); err != nil || n != 1 {
+			t.Fatalf("Expected one input signed, got %d, %v", n, err)
+		}
+		if !received.IsComplete() {
+			t.Fatal("Expected two of two signatures to be complete")
+		}
+		signed, err := received.Finalize()
+		if err != nil {
+			t.Fatalf("Failed to finalize: %v", err)
+		}
+
+		if !signed.Verify(map[string]core.Transaction{funding.ID: *funding}) {
+			t.Error("Expected the finalized transaction to verify")
+		}
+		if bc.AddBlock([]*core.Transaction{signed}) == nil {
+			t.Error("Expected the treasury spend to be mined")
+		}
+	})
+
+	t.Run("ThresholdEnforced", func(t *testing.T) {
+		lock, err := wallet.NewMultiSigOutput(10, 2, pubKeys...)
+		if err != nil {
+			t.Fatalf("Failed to create multisig output: %v", err)
+		}
+		tx := &core.Transaction{
+			Inputs:  []core.TXInput{{TXID: "treasury", Vout: 0}},
+			Outputs: []core.TXOutput{newOutput(t, 10, testAddress("payee"))},
+		}
+
+		sign := func(w *wallet.Wallet) []byte {
+			signature, err := core.SignHash(w.PrivateKey, tx.SigHash(0, lock))
+			if err != nil {
+				t.Fatalf("Failed to sign: %v", err)
+			}
+			return signature
+		}
+		first, second, third := sign(signers[0]), sign(signers[1]), sign(signers[2])
+
+		cases := []struct {
+			name       string
+			signatures [][]byte
+			valid      bool
+		}{
+			{"InKeyOrder", [][]byte{first, third}, true},
+			{"OutOfOrder", [][]byte{third, first}, false},
+			{"SameKeyTwice", [][]byte{second, second}, false},
+			{"TooFew", [][]byte{first}, false},
+		}
+		for _, c := range cases {
+			unlock := core.NewScript()
+			for _, signature := range c.signatures {
+				unlock = unlock.AddData(signature)
+			}
+			tx.Inputs[0].ScriptSig = unlock
+
+			if err := tx.VerifyScript(0, lock); (err == nil) != c.valid {
+				t.Errorf("%s: got %v", c.name, err)
+			}
+		}
+	})
+
+	t.Run("CombineIndependentSignatures", func(t *testing.T) {
+		lock, err := wallet.NewMultiSigOutput(10, 2, pubKeys...)
+		if err != nil {
+			t.Fatalf("Failed to create multisig output: %v", err)
+		}
+		tx := &core.Transaction{
+			Inputs:  []core.TXInput{{TXID: "treasury", Vout: 0}},
+			Outputs: []core.TXOutput{newOutput(t, 10, testAddress("payee"))},
+		}
+
+		a, _ := core.NewPartialTransaction(tx, []core.TXOutput{lock})
+		b, _ := core.NewPartialTransaction(tx, []core.TXOutput{lock})
+		signers[1].SignPartial(a)
+		signers[0].SignPartial(b)
+
+		if err := a.Combine(b); err != nil {
+			t.Fatalf("Failed to combine: %v", err)
+		}
+		if _, err := a.Finalize(); err != nil {
+			t.Errorf("Expected combined signatures to finalize, got %v", err)
+		}
+	})
+}
@@ -1,23 +1,22 @@
 package main
 
 import (
-	"os"
 	"testing"
 
-	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/mempool"
 	"github.com/antontuzov/coubcore/internal/blockchain/network"
 )
 
 func TestServerCreation(t *testing.T) {
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
-
 	// Create a new blockchain
-	blockchain := core.NewBlockchain()
+	blockchain := newTestBlockchain(t)
 	defer blockchain.Close()
 
+	// Create a transaction pool
+	pool := mempool.NewMempool(blockchain, mempool.DefaultOptions())
+
 	// Create a new server
-	server := network.NewServer("localhost", 8000, blockchain)
+	server := network.NewServer("localhost", 8000, blockchain, pool)
 
 	// Check that the server was created
 	if server == nil {
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/consensus"
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+// poaSlotTime is the slot time of the proof of authority test chains
+const poaSlotTime = 20 * time.Millisecond
+
+// newPoAFixture creates three validators, an engine for each and a chain run
+// by the first validator's engine
+func newPoAFixture(t *testing.T) (*core.Blockchain, []*wallet.Wallet, []*consensus.PoAEngine, consensus.PoAConfig) {
+	t.Helper()
+
+	validators := []*wallet.Wallet{newWallet(t), newWallet(t), newWallet(t)}
+	config := consensus.PoAConfig{SlotTime: poaSlotTime}
+	for _, v := range validators {
+		config.Validators = append(config.Validators, v.Address)
+	}
+
+	engines := make([]*consensus.PoAEngine, len(validators))
+	for i, v := range validators {
+		engine, err := consensus.NewPoAEngine(config, v)
+		if err != nil {
+			t.Fatalf("Failed to create engine: %v", err)
+		}
+		engines[i] = engine
+	}
+
+	opts := core.DefaultOptions()
+	opts.Backend = core.BackendMemory
+	opts.Engine = engines[0]
+	bc, err := core.NewBlockchain(opts)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	return bc, validators, engines, config
+}
+
+func TestPoARoundRobin(t *testing.T) {
+	bc, validators, engines, config := newPoAFixture(t)
+	defer bc.Close()
+
+	for _, i := range []int{1, 2, 0, 0, 2} {
+		block := produceBlock(t, bc, engines[i], nil)
+		if block.Validator != validators[i].Address {
+			t.Errorf("Expected block sealed by %s, got %s", validators[i].Address, block.Validator)
+		}
+
+		slot := uint64(block.Timestamp.Sub(core.GenesisTimestamp) / poaSlotTime)
+		if turn := config.Validators[slot%3]; turn != block.Validator {
+			t.Errorf("Expected slot %d to belong to %s, got %s", slot, turn, block.Validator)
+		}
+	}
+
+	// The chain's own engine seals with its validator key
+	block := bc.AddBlock("sealed by the node")
+	if block == nil || block.Validator != validators[0].Address {
+		t.Fatalf("Expected AddBlock to seal as the first validator, got %+v", block)
+	}
+	if bc.Length() != 7 {
+		t.Errorf("Expected 7 blocks, got %d", bc.Length())
+	}
+}
+
+func TestPoAOutOfTurn(t *testing.T) {
+	bc, _, engines, _ := newPoAFixture(t)
+	defer bc.Close()
+
+	block := core.NewBlock(1, bc.GetLatestBlock().Hash, "early")
+	if err := engines[1].Prepare(bc, block); err != nil {
+		t.Fatalf("Failed to prepare block: %v", err)
+	}
+
+	// Move the block into the next validator's slot
+	block.Timestamp = block.Timestamp.Add(poaSlotTime)
+	if err := engines[1].Seal(context.Background(), bc, block); err != nil {
+		t.Fatalf("Failed to seal block: %v", err)
+	}
+	if _, err := bc.ProcessBlock(block); !errors.Is(err, consensus.ErrOutOfTurn) || !errors.Is(err, core.ErrInvalidBlock) {
+		t.Errorf("Expected ErrOutOfTurn, got %v", err)
+	}
+}
+
+func TestPoAOutsider(t *testing.T) {
+	bc, _, engines, config := newPoAFixture(t)
+	defer bc.Close()
+
+	outsider := newWallet(t)
+	engine, err := consensus.NewPoAEngine(config, outsider)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	block := core.NewBlock(1, bc.GetLatestBlock().Hash, "outsider")
+	if err := engine.Prepare(bc, block); !errors.Is(err, consensus.ErrNotValidator) {
+		t.Errorf("Expected ErrNotValidator from Prepare, got %v", err)
+	}
+
+	// Take a validator's slot and sign it with the outsider's key
+	if err := engines[0].Prepare(bc, block); err != nil {
+		t.Fatalf("Failed to prepare block: %v", err)
+	}
+	block.Validator = outsider.Address
+	if err := engine.Seal(context.Background(), bc, block); err != nil {
+		t.Fatalf("Failed to seal block: %v", err)
+	}
+	if _, err := bc.ProcessBlock(block); !errors.Is(err, consensus.ErrNotValidator) {
+		t.Errorf("Expected ErrNotValidator, got %v", err)
+	}
+}
+
+func TestPoAForgedSignature(t *testing.T) {
+	bc, validators, engines, _ := newPoAFixture(t)
+	defer bc.Close()
+
+	block := prepareBlock(t, bc, engines[1], nil)
+	sealed := block.Signature
+
+	block.Signature = nil
+	if _, err := bc.ProcessBlock(block); !errors.Is(err, core.ErrInvalidBlock) {
+		t.Errorf("Expected an unsigned block to be rejected, got %v", err)
+	}
+
+	// Another validator signs a block that claims to be the first's
+	hash, _ := hex.DecodeString(block.Hash)
+	signature, err := core.SignHash(validators[2].PrivateKey, hash)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	block.Signature = append(signature, validators[2].PublicKey...)
+	if _, err := bc.ProcessBlock(block); !errors.Is(err, core.ErrInvalidBlock) {
+		t.Errorf("Expected a block signed by another validator to be rejected, got %v", err)
+	}
+
+	block.Signature = sealed
+	if _, err := bc.ProcessBlock(block); err != nil {
+		t.Errorf("Expected the validator's own signature to be accepted, got %v", err)
+	}
+}
+
+func TestPoAVotes(t *testing.T) {
+	bc, validators, engines, config := newPoAFixture(t)
+	defer bc.Close()
+
+	newcomer := newWallet(t)
+	newcomerEngine, err := consensus.NewPoAEngine(config, newcomer)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	current := func() []string {
+		t.Helper()
+
+		set, err := engines[0].Validators(bc, bc.GetLatestBlock().Hash)
+		if err != nil {
+			t.Fatalf("Failed to get validators: %v", err)
+		}
+		return set
+	}
+
+	// One vote of three is not a majority
+	engines[0].Propose(newcomer.Address, true)
+	engines[1].Propose(newcomer.Address, true)
+	produceBlock(t, bc, engines[0], nil)
+	if len(current()) != 3 {
+		t.Fatalf("Expected one vote to leave the set unchanged, got %v", current())
+	}
+
+	produceBlock(t, bc, engines[1], nil)
+	if set := current(); len(set) != 4 || set[3] != newcomer.Address {
+		t.Fatalf("Expected two votes to add the newcomer, got %v", set)
+	}
+	produceBlock(t, bc, newcomerEngine, nil)
+
+	// Removing a validator now takes three of the four
+	for _, engine := range []*consensus.PoAEngine{engines[0], engines[1], newcomerEngine} {
+		engine.Propose(validators[2].Address, false)
+	}
+	produceBlock(t, bc, engines[0], nil)
+	produceBlock(t, bc, engines[1], nil)
+	if len(current()) != 4 {
+		t.Fatalf("Expected two of four votes to leave the set unchanged, got %v", current())
+	}
+	produceBlock(t, bc, newcomerEngine, nil)
+	if set := current(); len(set) != 3 || set[2] != newcomer.Address {
+		t.Fatalf("Expected three votes to remove the validator, got %v", set)
+	}
+
+	removed, err := consensus.NewPoAEngine(config, validators[2])
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	block := core.NewBlock(bc.GetLatestBlock().Index+1, bc.GetLatestBlock().Hash, "removed")
+	if err := removed.Prepare(bc, block); !errors.Is(err, consensus.ErrNotValidator) {
+		t.Errorf("Expected the removed validator to be refused, got %v", err)
+	}
+}
+
+func TestPoAInvalidVote(t *testing.T) {
+	bc, _, engines, _ := newPoAFixture(t)
+	defer bc.Close()
+
+	if err := engines[0].Propose("not an address", true); err == nil {
+		t.Error("Expected a proposal for an invalid address to be refused")
+	}
+
+	// A vote slipped into the coinbase by hand is refused by the chain
+	tip := bc.GetLatestBlock()
+	block := core.NewBlock(tip.Index+1, tip.Hash, "invalid vote")
+	if err := engines[0].Prepare(bc, block); err != nil {
+		t.Fatalf("Failed to prepare block: %v", err)
+	}
+	coinbase := &block.Transactions[0]
+	coinbase.Outputs = append(coinbase.Outputs, core.NewDataOutput([]byte(`poa-vote:[{"address":"not an address","add":true}]`)))
+	coinbase.SetID()
+	block.MerkleRoot = block.ComputeMerkleRoot()
+	if err := engines[0].Seal(context.Background(), bc, block); err != nil {
+		t.Fatalf("Failed to seal block: %v", err)
+	}
+	if _, err := bc.ProcessBlock(block); !errors.Is(err, core.ErrInvalidBlock) {
+		t.Errorf("Expected a vote for an invalid address to be rejected, got %v", err)
+	}
+}
+
+func TestPoASealWaitsForTheChainClock(t *testing.T) {
+	setupChain, _, engines, _ := newPoAFixture(t)
+	setupChain.Close()
+
+	// The clock stands in the second validator's slot, so the first
+	// validator's next slot is two slots away
+	clock := core.NewManualClock(core.GenesisTimestamp.Add(poaSlotTime + poaSlotTime/2))
+	opts := core.DefaultOptions()
+	opts.Backend = core.BackendMemory
+	opts.Engine = engines[0]
+	opts.Clock = clock
+	bc, err := core.NewBlockchain(opts)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	defer bc.Close()
+
+	type result struct {
+		block *core.Block
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		block, err := bc.AddBlockContext(context.Background(), "on time")
+		done <- result{block, err}
+	}()
+
+	select {
+	case r := <-done:
+		t.Fatalf("Expected sealing to wait for the chain clock, got %+v, %v", r.block, r.err)
+	case <-time.After(5 * poaSlotTime):
+	}
+	if bc.Length() != 1 {
+		t.Error("Expected the chain to stay usable while the block waits for its slot")
+	}
+
+	clock.Advance(2 * poaSlotTime)
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("Expected block to be added once its slot began, got %v", r.err)
+		}
+		if want := core.GenesisTimestamp.Add(3 * poaSlotTime); !r.block.Timestamp.Equal(want) {
+			t.Errorf("Expected block at the start of slot 3, got %s", r.block.Timestamp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected sealing to finish once the chain clock reached the slot")
+	}
+}
+
+func TestPoASelectedByName(t *testing.T) {
+	signer := newWallet(t)
+	config, _ := json.Marshal(map[string]interface{}{
+		"validators": []string{signer.Address},
+		"slotTime":   poaSlotTime.String(),
+		"signerKey":  hex.EncodeToString(signer.PrivateKeyBytes()),
+	})
+
+	opts := core.DefaultOptions()
+	opts.Backend = core.BackendMemory
+	opts.Consensus = consensus.EnginePoA
+	opts.ConsensusConfig = config
+	bc, err := core.NewBlockchain(opts)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	defer bc.Close()
+
+	block := bc.AddBlock("configured")
+	if block == nil || block.Validator != signer.Address {
+		t.Fatalf("Expected the configured signer to seal the block, got %+v", block)
+	}
+}
+
+func TestPoAValidatorsDuringImport(t *testing.T) {
+	bc, _, engines, _ := newPoAFixture(t)
+	defer bc.Close()
+
+	parent := bc.GetLatestBlock().Hash
+	block := prepareBlock(t, bc, engines[1], nil)
+
+	// A reader walking the chain must not hold the engine's lock while the
+	// chain holds its own lock to verify a block with the same engine
+	reader := &pausingReader{Blockchain: bc, reading: make(chan struct{})}
+	read := make(chan error, 1)
+	go func() {
+		_, err := engines[0].Validators(reader, parent)
+		read <- err
+	}()
+	<-reader.reading
+
+	imported := make(chan error, 1)
+	go func() {
+		_, err := bc.ProcessBlock(block)
+		imported <- err
+	}()
+
+	for _, done := range []chan error{imported, read} {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Block import deadlocked with a validator set reader")
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/consensus"
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+const (
+	// posSlotTime is the slot time of the proof of stake test chains
+	posSlotTime = 5 * time.Millisecond
+
+	// posUnbonding is the unbonding period of their deposits
+	posUnbonding = 3
+)
+
+// newPoSEngine creates a proof of stake engine signing with signer
+func newPoSEngine(t *testing.T, config consensus.PoSConfig, signer *wallet.Wallet) *consensus.PoSEngine {
+	t.Helper()
+
+	engine, err := consensus.NewPoSEngine(config, signer)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	return engine
+}
+
+// newPoSFixture creates three validators staking 60, 30 and 10, an engine
+// for each and a chain run by the first validator's engine
+func newPoSFixture(t *testing.T) (*core.Blockchain, []*wallet.Wallet, []*consensus.PoSEngine, consensus.PoSConfig) {
+	t.Helper()
+
+	validators := []*wallet.Wallet{newWallet(t), newWallet(t), newWallet(t)}
+	config := consensus.PoSConfig{
+		Stakes: map[string]int{
+			validators[0].Address: 60,
+			validators[1].Address: 30,
+			validators[2].Address: 10,
+		},
+		SlotTime:  posSlotTime,
+		Unbonding: posUnbonding,
+	}
+
+	engines := make([]*consensus.PoSEngine, len(validators))
+	for i, v := range validators {
+		engines[i] = newPoSEngine(t, config, v)
+	}
+
+	opts := core.DefaultOptions()
+	opts.Backend = core.BackendMemory
+	opts.Params.CoinbaseMaturity = 0
+	opts.AddressIndex = true
+	opts.Engine = engines[0]
+	bc, err := core.NewBlockchain(opts)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	return bc, validators, engines, config
+}
+
+// stakeOf returns the stake of an address after the chain's tip
+func stakeOf(t *testing.T, bc *core.Blockchain, engine *consensus.PoSEngine, address string) int {
+	t.Helper()
+
+	stakes, err := engine.Stakes(bc, bc.GetLatestBlock().Hash)
+	if err != nil {
+		t.Fatalf("Failed to get stakes: %v", err)
+	}
+	return stakes[address]
+}
+
+func TestPoSProposerIsStakeWeighted(t *testing.T) {
+	bc, validators, engines, _ := newPoSFixture(t)
+	defer bc.Close()
+
+	genesis := bc.GetLatestBlock().Hash
+	chosen := make(map[string]int)
+	const slots = 3000
+	for slot := uint64(0); slot < slots; slot++ {
+		proposer, err := engines[0].Proposer(bc, genesis, slot)
+		if err != nil {
+			t.Fatalf("Failed to draw proposer: %v", err)
+		}
+		chosen[proposer]++
+
+		// Every node draws the same proposer
+		if again, _ := engines[2].Proposer(bc, genesis, slot); again != proposer {
+			t.Fatalf("Expected the draw for slot %d to be deterministic", slot)
+		}
+	}
+
+	for i, want := range []float64{0.6, 0.3, 0.1} {
+		share := float64(chosen[validators[i].Address]) / slots
+		if share < want-0.05 || share > want+0.05 {
+			t.Errorf("Expected validator %d to propose about %.0f%% of slots, got %.1f%%", i, want*100, share*100)
+		}
+	}
+}
+
+func TestPoSSeedCannotBeGround(t *testing.T) {
+	bc, _, engines, _ := newPoSFixture(t)
+	defer bc.Close()
+
+	// Two versions of the next block differ in hash but not in the
+	// proposers they leave to their children
+	first := prepareBlock(t, bc, engines[0], "one version")
+	second := prepareBlock(t, bc, engines[1], "another version")
+	for _, block := range []*core.Block{first, second} {
+		if _, err := bc.ProcessBlock(block); err != nil {
+			t.Fatalf("Expected block to be accepted, got %v", err)
+		}
+	}
+
+	for slot := uint64(0); slot < 1000; slot++ {
+		a, err := engines[0].Proposer(bc, first.Hash, slot)
+		if err != nil {
+			t.Fatalf("Failed to draw proposer: %v", err)
+		}
+		if b, _ := engines[0].Proposer(bc, second.Hash, slot); a != b {
+			t.Fatalf("Expected the draw for slot %d not to depend on the parent's hash, got %s and %s", slot, a, b)
+		}
+	}
+
+	// The genesis seed lasts two epochs of 32 blocks, then the last
+	// block of the first epoch takes over
+	draws := func(hash string) string {
+		t.Helper()
+
+		var proposers []string
+		for slot := uint64(0); slot < 100; slot++ {
+			proposer, err := engines[0].Proposer(bc, hash, slot)
+			if err != nil {
+				t.Fatalf("Failed to draw proposer: %v", err)
+			}
+			proposers = append(proposers, proposer)
+		}
+		return fmt.Sprint(proposers)
+	}
+	genesis := draws(bc.GetBlocks(0, 1)[0].Hash)
+	for bc.Length() < 63 {
+		produceBlock(t, bc, engines[0], fmt.Sprintf("filler %d", bc.Length()))
+	}
+	if draws(bc.GetLatestBlock().Hash) != genesis {
+		t.Error("Expected the second epoch to keep the genesis seed")
+	}
+	produceBlock(t, bc, engines[0], "last of the second epoch")
+	if draws(bc.GetLatestBlock().Hash) == genesis {
+		t.Error("Expected the third epoch to draw from a new seed")
+	}
+}
+
+func TestPoSTakingTurns(t *testing.T) {
+	bc, validators, engines, _ := newPoSFixture(t)
+	defer bc.Close()
+
+	for _, i := range []int{1, 2, 0, 2} {
+		parent := bc.GetLatestBlock().Hash
+		block := produceBlock(t, bc, engines[i], fmt.Sprintf("block by %d", i))
+		if block.Validator != validators[i].Address {
+			t.Errorf("Expected block sealed by %s, got %s", validators[i].Address, block.Validator)
+		}
+
+		slot := uint64(block.Timestamp.Sub(core.GenesisTimestamp) / posSlotTime)
+		if proposer, _ := engines[0].Proposer(bc, parent, slot); proposer != block.Validator {
+			t.Errorf("Expected slot %d to be proposed by %s, got %s", slot, proposer, block.Validator)
+		}
+	}
+
+	if block := bc.AddBlock("sealed by the node"); block == nil || block.Validator != validators[0].Address {
+		t.Fatalf("Expected AddBlock to seal as the first validator, got %+v", block)
+	}
+}
+
+func TestPoSOutOfTurn(t *testing.T) {
+	bc, _, engines, _ := newPoSFixture(t)
+	defer bc.Close()
+
+	block := core.NewBlock(1, bc.GetLatestBlock().Hash, "out of turn")
+	if err := engines[2].Prepare(bc, block); err != nil {
+		t.Fatalf("Failed to prepare block: %v", err)
+	}
+
+	// Move the block into a slot drawn for someone else
+	for {
+		block.Timestamp = block.Timestamp.Add(posSlotTime)
+		slot := uint64(block.Timestamp.Sub(core.GenesisTimestamp) / posSlotTime)
+		if proposer, _ := engines[0].Proposer(bc, block.PreviousHash, slot); proposer != block.Validator {
+			break
+		}
+	}
+	if err := engines[2].Seal(context.Background(), bc, block); err != nil {
+		t.Fatalf("Failed to seal block: %v", err)
+	}
+	if _, err := bc.ProcessBlock(block); !errors.Is(err, consensus.ErrOutOfTurn) {
+		t.Errorf("Expected ErrOutOfTurn, got %v", err)
+	}
+}
+
+func TestPoSDepositAndWithdraw(t *testing.T) {
+	bc, _, engines, config := newPoSFixture(t)
+	defer bc.Close()
+
+	staker := newWallet(t)
+	stakerEngine := newPoSEngine(t, config, staker)
+	if err := stakerEngine.Prepare(bc, core.NewBlock(1, bc.GetLatestBlock().Hash, "no stake")); !errors.Is(err, consensus.ErrNotValidator) {
+		t.Errorf("Expected ErrNotValidator without stake, got %v", err)
+	}
+
+	// Fund the staker and bond most of it
+	produceBlock(t, bc, engines[0], []*core.Transaction{newCoinbase(t, staker.Address, "fund staker", 80)})
+	utxos, err := bc.GetUTXOsByAddress(staker.Address)
+	if err != nil {
+		t.Fatalf("Failed to get UTXOs: %v", err)
+	}
+	bond, err := core.NewStakeTransaction(staker.Address, 50, posUnbonding, map[string][]core.UTXO{staker.Address: utxos})
+	if err != nil {
+		t.Fatalf("Failed to create stake transaction: %v", err)
+	}
+	if err := bc.SignTransaction(bond, staker.PrivateKey); err != nil {
+		t.Fatalf("Failed to sign stake transaction: %v", err)
+	}
+	produceBlock(t, bc, engines[0], []*core.Transaction{bond})
+
+	if stake := stakeOf(t, bc, engines[0], staker.Address); stake != 50 {
+		t.Fatalf("Expected a stake of 50, got %d", stake)
+	}
+	if balance, _ := bc.GetBalance(staker.Address); balance != 80 {
+		t.Errorf("Expected the bonded coins to stay in the balance of 80, got %d", balance)
+	}
+	if history, err := bc.GetAddressHistory(staker.Address, 0, 1); err != nil || len(history) != 1 || history[0].TxID != bond.ID {
+		t.Errorf("Expected the deposit at the top of the staker's history, got %+v (%v)", history, err)
+	}
+
+	// but a plain transfer can only spend the change
+	utxos, err = bc.GetUTXOsByAddress(staker.Address)
+	if err != nil {
+		t.Fatalf("Failed to get UTXOs: %v", err)
+	}
+	if _, err := core.NewTransaction(staker.Address, testAddress("elsewhere"), 31, map[string][]core.UTXO{staker.Address: utxos}); !errors.Is(err, core.ErrInsufficientFunds) {
+		t.Errorf("Expected the deposit to be left out of a transfer, got %v", err)
+	}
+	produceBlock(t, bc, stakerEngine, "proposed by the staker")
+
+	// The deposit stays bonded for the unbonding period
+	deposit, err := bc.GetUTXO(bond.ID, 0)
+	if err != nil || deposit == nil {
+		t.Fatalf("Failed to get deposit: %v", err)
+	}
+	withdrawal, err := core.NewWithdrawalTransaction([]core.UTXO{*deposit}, staker.Address)
+	if err != nil {
+		t.Fatalf("Failed to create withdrawal: %v", err)
+	}
+	if err := bc.SignTransaction(withdrawal, staker.PrivateKey); err != nil {
+		t.Fatalf("Failed to sign withdrawal: %v", err)
+	}
+	if _, err := bc.ProcessBlock(prepareBlock(t, bc, engines[0], []*core.Transaction{withdrawal})); !errors.Is(err, core.ErrTimeLocked) {
+		t.Errorf("Expected the withdrawal to be time locked, got %v", err)
+	}
+
+	for bc.Length() < int(deposit.Height)+posUnbonding {
+		produceBlock(t, bc, engines[0], fmt.Sprintf("filler %d", bc.Length()))
+	}
+	produceBlock(t, bc, engines[0], []*core.Transaction{withdrawal})
+	if stake := stakeOf(t, bc, engines[0], staker.Address); stake != 0 {
+		t.Errorf("Expected the withdrawal to end the stake, got %d", stake)
+	}
+	if balance, _ := bc.GetBalance(staker.Address); balance != 80 {
+		t.Errorf("Expected the staker to get the coins back, got a balance of %d", balance)
+	}
+}
+
+func TestPoSDoubleSignIsSlashed(t *testing.T) {
+	bc, validators, engines, _ := newPoSFixture(t)
+	defer bc.Close()
+
+	// The second validator signs two blocks on the genesis block
+	first := prepareBlock(t, bc, engines[1], "first")
+	second := prepareBlock(t, bc, engines[1], "second")
+	if _, err := bc.ProcessBlock(first); err != nil {
+		t.Fatalf("Expected the first block to be accepted, got %v", err)
+	}
+	if _, err := bc.ProcessBlock(second); err != nil {
+		t.Fatalf("Expected the second block to be stored as a fork, got %v", err)
+	}
+	if stake := stakeOf(t, bc, engines[0], validators[1].Address); stake != 30 {
+		t.Fatalf("Expected the stake to stand until evidence is mined, got %d", stake)
+	}
+
+	// The node's engine saw both and puts the evidence in its next block
+	if bc.AddBlock("evidence") == nil {
+		t.Fatal("Expected the block carrying the evidence to be added")
+	}
+	if stake := stakeOf(t, bc, engines[0], validators[1].Address); stake != 0 {
+		t.Errorf("Expected the double signer to lose its stake, got %d", stake)
+	}
+
+	block := core.NewBlock(bc.GetLatestBlock().Index+1, bc.GetLatestBlock().Hash, "slashed")
+	if err := engines[1].Prepare(bc, block); !errors.Is(err, consensus.ErrSlashed) {
+		t.Errorf("Expected ErrSlashed, got %v", err)
+	}
+
+	// Everyone else carries on
+	produceBlock(t, bc, engines[2], "after slashing")
+}
+
+func TestPoSSealWaitsForTheChainClock(t *testing.T) {
+	setupChain, _, engines, _ := newPoSFixture(t)
+	setupChain.Close()
+
+	// The clock is stopped at the genesis block, so no later slot begins
+	opts := core.DefaultOptions()
+	opts.Backend = core.BackendMemory
+	opts.Engine = engines[0]
+	opts.Clock = core.NewManualClock(core.GenesisTimestamp)
+	bc, err := core.NewBlockchain(opts)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	defer bc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*posSlotTime)
+	defer cancel()
+	if block, err := bc.AddBlockContext(ctx, "too early"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected sealing to wait for the chain clock until cancelled, got %+v, %v", block, err)
+	}
+	if bc.Length() != 1 {
+		t.Errorf("Expected no block to be added, got length %d", bc.Length())
+	}
+}
+
+func TestPoSSelectedByName(t *testing.T) {
+	signer := newWallet(t)
+	config, _ := json.Marshal(map[string]interface{}{
+		"stakes":    map[string]int{signer.Address: 1},
+		"slotTime":  posSlotTime.String(),
+		"unbonding": posUnbonding,
+		"signerKey": hex.EncodeToString(signer.PrivateKeyBytes()),
+	})
+
+	opts := core.DefaultOptions()
+	opts.Backend = core.BackendMemory
+	opts.Consensus = consensus.EnginePoS
+	opts.ConsensusConfig = config
+	bc, err := core.NewBlockchain(opts)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	defer bc.Close()
+
+	if block := bc.AddBlock("staked"); block == nil || block.Validator != signer.Address {
+		t.Fatalf("Expected the configured signer to seal the block, got %+v", block)
+	}
+}
+
+func TestPoSStakesDuringImport(t *testing.T) {
+	bc, _, engines, _ := newPoSFixture(t)
+	defer bc.Close()
+
+	parent := bc.GetLatestBlock().Hash
+	block := prepareBlock(t, bc, engines[1], "imported")
+
+	// A reader walking the chain must not hold the engine's lock while the
+	// chain holds its own lock to verify a block with the same engine
+	reader := &pausingReader{Blockchain: bc, reading: make(chan struct{})}
+	read := make(chan error, 1)
+	go func() {
+		_, err := engines[0].Stakes(reader, parent)
+		read <- err
+	}()
+	<-reader.reading
+
+	imported := make(chan error, 1)
+	go func() {
+		_, err := bc.ProcessBlock(block)
+		imported <- err
+	}()
+
+	for _, done := range []chan error{imported, read} {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Block import deadlocked with a stake reader")
+		}
+	}
+}
@@ -1,35 +1,43 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/antontuzov/coubcore/internal/blockchain/consensus"
 	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/metrics"
+	dto "github.com/prometheus/client_model/go"
 )
 
-func TestProofOfWork(t *testing.T) {
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
+// testMetrics registers the metrics once, however often the tests run
+var testMetrics = sync.OnceValue(metrics.NewMetrics)
 
+func TestProofOfWork(t *testing.T) {
 	// Create a new blockchain
-	blockchain := core.NewBlockchain()
+	blockchain := newTestBlockchain(t)
 	defer blockchain.Close()
 
 	// Create a new block
 	block := core.NewBlock(1, blockchain.GetLatestBlock().Hash, "Test data")
-	block.Difficulty = 1 // Low difficulty for testing
+	block.Bits = core.BitsFromLeadingZeros(1) // Low difficulty for testing
 
 	// Create proof of work
 	pow := consensus.NewProofOfWork(block)
 
-	// Run the proof of work
-	nonce, hash := pow.Run()
-
-	// Set the nonce and hash
-	block.Nonce = nonce
-	block.Hash = fmt.Sprintf("%x", hash)
+	// Run the proof of work, which sets the nonce and hash
+	nonce, hash, err := pow.Run(context.Background(), consensus.DefaultMinerOptions())
+	if err != nil {
+		t.Fatalf("Failed to run proof of work: %v", err)
+	}
+	if block.Nonce != nonce || block.Hash != fmt.Sprintf("%x", hash) {
+		t.Error("Expected Run to set the block's nonce and hash")
+	}
 
 	// Validate the proof of work
 	if !pow.Validate() {
@@ -41,11 +49,8 @@ func TestProofOfWork(t *testing.T) {
 }
 
 func TestMineBlock(t *testing.T) {
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
-
 	// Create a new blockchain
-	blockchain := core.NewBlockchain()
+	blockchain := newTestBlockchain(t)
 	defer blockchain.Close()
 
 	// Mine a new block
@@ -77,3 +82,125 @@ func TestMineBlock(t *testing.T) {
 
 	t.Logf("Mined block #%d with hash %s", minedBlock.Index, minedBlock.Hash)
 }
+
+func TestMiner(t *testing.T) {
+	t.Run("Workers", func(t *testing.T) {
+		block := core.NewBlock(1, core.NewGenesisBlock().Hash, "parallel")
+		block.Bits = core.BitsFromLeadingZeros(12)
+
+		pow := consensus.NewProofOfWork(block)
+		if _, _, err := pow.Run(context.Background(), consensus.MinerOptions{Workers: 4}); err != nil {
+			t.Fatalf("Failed to run proof of work: %v", err)
+		}
+		if !pow.Validate() {
+			t.Error("Expected the nonce found by the workers to be valid")
+		}
+	})
+
+	t.Run("ExtraNonceRolls", func(t *testing.T) {
+		// A chain whose easiest target needs ten zero bits
+		opts := core.DefaultOptions()
+		opts.Backend = core.BackendMemory
+		opts.Params.PowLimitBits = core.BitsFromLeadingZeros(10)
+		bc, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to create blockchain: %v", err)
+		}
+		defer bc.Close()
+
+		block := core.NewBlock(1, bc.GetLatestBlock().Hash, "small nonce space")
+		block.Bits = opts.Params.PowLimitBits
+		scriptSig := append(core.Script(nil), block.Transactions[0].Inputs[0].ScriptSig...)
+
+		// Sixteen nonces are rarely enough, so the coinbase has to change
+		pow := consensus.NewProofOfWork(block)
+		if _, _, err := pow.Run(context.Background(), consensus.MinerOptions{Workers: 2, MaxNonce: 15}); err != nil {
+			t.Fatalf("Failed to run proof of work: %v", err)
+		}
+		if block.Nonce > 15 {
+			t.Errorf("Expected a nonce of at most 15, got %d", block.Nonce)
+		}
+		if !pow.Validate() {
+			t.Fatal("Expected the block to be sealed")
+		}
+
+		rolled := block.Transactions[0].Inputs[0].ScriptSig
+		if len(rolled) == len(scriptSig) {
+			t.Skip("The first sixteen nonces happened to be enough")
+		}
+		if !bytes.HasPrefix(rolled, scriptSig) {
+			t.Errorf("Expected the extra nonce to follow the coinbase data, got %x", rolled)
+		}
+		if _, err := bc.ProcessBlock(block); err != nil {
+			t.Errorf("Expected the block with a rolled coinbase to be accepted, got %v", err)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		block := core.NewBlock(1, core.NewGenesisBlock().Hash, "never found")
+		block.Bits = core.BitsFromLeadingZeros(64)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		done := make(chan error, 1)
+		go func() {
+			_, _, err := consensus.NewProofOfWork(block).Run(ctx, consensus.MinerOptions{Workers: 2})
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Expected the search to stop at the deadline, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the search to stop when its context is done")
+		}
+	})
+
+	t.Run("MineBlockContext", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := consensus.MineBlockContext(ctx, bc, "cancelled"); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+		if bc.Length() != 1 {
+			t.Errorf("Expected no block to be added, got length %d", bc.Length())
+		}
+
+		block, err := consensus.MineBlockContext(context.Background(), bc, "mined")
+		if err != nil || bc.GetLatestBlock().Hash != block.Hash {
+			t.Errorf("Expected the mined block to become the tip, got %v", err)
+		}
+
+		// Other engines seal the block their own way
+		poa, validators, _, _ := newPoAFixture(t)
+		defer poa.Close()
+		block, err = consensus.MineBlockContext(context.Background(), poa, "sealed")
+		if err != nil || block.Validator != validators[0].Address {
+			t.Errorf("Expected the block to be sealed by the chain's validator, got %v", err)
+		}
+	})
+
+	t.Run("Hashrate", func(t *testing.T) {
+		opts := consensus.DefaultMinerOptions()
+		opts.Metrics = testMetrics()
+
+		block := core.NewBlock(1, core.NewGenesisBlock().Hash, "measured")
+		block.Bits = core.BitsFromLeadingZeros(12)
+		if _, _, err := consensus.NewProofOfWork(block).Run(context.Background(), opts); err != nil {
+			t.Fatalf("Failed to run proof of work: %v", err)
+		}
+		var hashrate dto.Metric
+		if err := opts.Metrics.Hashrate.Write(&hashrate); err != nil {
+			t.Fatalf("Failed to read hashrate: %v", err)
+		}
+		if hashrate.GetGauge().GetValue() <= 0 {
+			t.Errorf("Expected a positive hashrate, got %f", hashrate.GetGauge().GetValue())
+		}
+	})
+}
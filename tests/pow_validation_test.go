@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/consensus"
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+)
+
+// unworkedBlock builds a block on parent whose nonce misses its target
+func unworkedBlock(parent *core.Block, data interface{}) *core.Block {
+	block := core.NewBlock(parent.Index+1, parent.Hash, data)
+	for block.CheckProofOfWork(core.PowLimitBits) == nil {
+		block.Nonce++
+		block.Hash = block.CalculateHash()
+	}
+	return block
+}
+
+func TestProofOfWorkValidation(t *testing.T) {
+	t.Run("SealRejectsMissingWork", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		block := unworkedBlock(bc.GetLatestBlock(), "no work")
+		if err := bc.Engine().VerifySeal(bc, block); !errors.Is(err, core.ErrInsufficientWork) {
+			t.Errorf("Expected ErrInsufficientWork, got %v", err)
+		}
+		if _, err := bc.ProcessBlock(block); !errors.Is(err, core.ErrInvalidBlock) || !errors.Is(err, core.ErrInsufficientWork) {
+			t.Errorf("Expected ErrInvalidBlock and ErrInsufficientWork, got %v", err)
+		}
+
+		if _, err := bc.ProcessBlock(sealBlock(block)); err != nil {
+			t.Errorf("Expected the sealed block to pass, got %v", err)
+		}
+	})
+
+	t.Run("SealRejectsBadTarget", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		// Bits with the sign bit set and a target of 2^256, above the limit
+		for _, bits := range []uint32{0x04923456, core.BitsFromLeadingZeros(0)} {
+			block := core.NewBlock(1, bc.GetLatestBlock().Hash, "bad target")
+			block.Bits = bits
+			block.Hash = block.CalculateHash()
+			if err := bc.Engine().VerifySeal(bc, block); !errors.Is(err, core.ErrBadTarget) {
+				t.Errorf("Expected ErrBadTarget for bits %08x, got %v", bits, err)
+			}
+		}
+	})
+
+	t.Run("SealUsesTheChainLimit", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		opts.Backend = core.BackendMemory
+		opts.Params.PowLimitBits = core.BitsFromLeadingZeros(8)
+		bc, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to create blockchain: %v", err)
+		}
+		defer bc.Close()
+
+		// A block at the default limit is too easy for this chain
+		block := sealBlock(core.NewBlock(1, bc.GetLatestBlock().Hash, "easy"))
+		if _, err := bc.ProcessBlock(block); !errors.Is(err, core.ErrBadTarget) {
+			t.Errorf("Expected ErrBadTarget, got %v", err)
+		}
+	})
+
+	t.Run("ValidatorDoesNotSkipWork", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		// Naming a validator does not exempt a block from proof of work
+		block := core.NewBlock(1, bc.GetLatestBlock().Hash, "claims a validator")
+		block.Validator = "validator"
+		block.Hash = block.CalculateHash()
+		for block.CheckProofOfWork(core.PowLimitBits) == nil {
+			block.Nonce++
+			block.Hash = block.CalculateHash()
+		}
+
+		chain := []*core.Block{bc.GetLatestBlock(), block}
+		if err := bc.OfferChain(chain); !errors.Is(err, core.ErrInsufficientWork) {
+			t.Errorf("Expected ErrInsufficientWork, got %v", err)
+		}
+		if _, err := bc.ProcessBlock(block); !errors.Is(err, core.ErrInsufficientWork) {
+			t.Errorf("Expected ErrInsufficientWork, got %v", err)
+		}
+	})
+
+	t.Run("OfferChainRejectsMissingWork", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		tip := bc.GetLatestBlock()
+		worked := sealBlock(core.NewBlock(1, tip.Hash, "worked"))
+		chain := []*core.Block{tip, worked, unworkedBlock(worked, "no work")}
+		if err := bc.OfferChain(chain); !errors.Is(err, core.ErrInsufficientWork) {
+			t.Errorf("Expected ErrInsufficientWork, got %v", err)
+		}
+		if bc.ReplaceChain(chain) {
+			t.Error("Expected ReplaceChain to reject a chain without work")
+		}
+		if bc.Length() != 1 {
+			t.Errorf("Expected no block of the chain to be added, got length %d", bc.Length())
+		}
+	})
+
+	t.Run("OfferChainRejectsLighterChain", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		genesis := bc.GetLatestBlock()
+		for i := 0; i < 2; i++ {
+			if consensus.MineBlock(bc, "main") == nil {
+				t.Fatal("Failed to mine block")
+			}
+		}
+
+		chain := []*core.Block{genesis, sealBlock(core.NewBlock(1, genesis.Hash, "short"))}
+		if err := bc.OfferChain(chain); !errors.Is(err, core.ErrLighterChain) {
+			t.Errorf("Expected ErrLighterChain, got %v", err)
+		}
+	})
+
+	t.Run("VerifyChain", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		for i := 0; i < 3; i++ {
+			if consensus.MineBlock(bc, "mined") == nil {
+				t.Fatal("Failed to mine block")
+			}
+		}
+		if err := bc.VerifyChain(); err != nil {
+			t.Errorf("Expected the mined chain to verify, got %v", err)
+		}
+	})
+}
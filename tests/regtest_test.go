@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/api"
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/mempool"
+	"github.com/antontuzov/coubcore/internal/blockchain/mining"
+)
+
+// newRegtestBlockchain creates an in-memory regtest chain whose clock stands
+// still an hour after the genesis block
+func newRegtestBlockchain(t *testing.T) (*core.Blockchain, *core.ManualClock) {
+	t.Helper()
+
+	params, err := core.NetworkParams(core.NetworkRegtest)
+	if err != nil {
+		t.Fatalf("Failed to get regtest params: %v", err)
+	}
+
+	clock := core.NewManualClock(core.GenesisTimestamp.Add(time.Hour))
+	opts := core.DefaultOptions()
+	opts.Backend = core.BackendMemory
+	opts.Params = params
+	opts.Clock = clock
+
+	bc, err := core.NewBlockchain(opts)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	return bc, clock
+}
+
+func TestRegtestNetworkParams(t *testing.T) {
+	params, err := core.NetworkParams(core.NetworkRegtest)
+	if err != nil || params.RetargetWindow != 0 || params.PowLimitBits != core.PowLimitBits {
+		t.Errorf("Expected regtest to stay at the proof of work limit, got %+v, %v", params, err)
+	}
+	if params, _ := core.NetworkParams(core.NetworkMain); params != core.DefaultParams() {
+		t.Error("Expected the main network to use the default params")
+	}
+	if _, err := core.NetworkParams("testnet9"); !errors.Is(err, core.ErrUnknownNetwork) {
+		t.Errorf("Expected ErrUnknownNetwork, got %v", err)
+	}
+}
+
+func TestRegtestClockSetsTimestamps(t *testing.T) {
+	bc, clock := newRegtestBlockchain(t)
+	defer bc.Close()
+
+	block := bc.AddBlock("on the clock")
+	if block == nil || !block.Timestamp.Equal(clock.Now()) {
+		t.Fatalf("Expected the block to be stamped %v, got %+v", clock.Now(), block)
+	}
+
+	// A clock that does not move still gives every block a later timestamp
+	next := bc.AddBlock("clock stopped")
+	if next == nil || !next.Timestamp.Equal(block.Timestamp.Add(time.Nanosecond)) {
+		t.Fatalf("Expected the block to follow its parent by a nanosecond, got %+v", next)
+	}
+
+	clock.Advance(time.Minute)
+	if block := bc.AddBlock("a minute later"); block == nil || !block.Timestamp.Equal(clock.Now()) {
+		t.Errorf("Expected the block to be stamped %v, got %+v", clock.Now(), block)
+	}
+}
+
+func TestRegtestGeneratePaymentScenario(t *testing.T) {
+	bc, _ := newRegtestBlockchain(t)
+	defer bc.Close()
+	pool := mempool.NewMempool(bc, mempool.DefaultOptions())
+	manager := mining.NewManager(bc, pool, mining.DefaultOptions())
+	alice, bob := newWallet(t), newWallet(t)
+
+	// Mature the first coinbase
+	maturity := int(bc.Params().CoinbaseMaturity)
+	blocks, err := manager.Generate(context.Background(), maturity+1, alice.Address)
+	if err != nil || len(blocks) != maturity+1 {
+		t.Fatalf("Failed to generate blocks: %d, %v", len(blocks), err)
+	}
+	for _, block := range blocks {
+		if block.Bits != core.PowLimitBits {
+			t.Fatalf("Expected regtest blocks at the proof of work limit, got %08x", block.Bits)
+		}
+	}
+	if err := bc.VerifyChain(); err != nil {
+		t.Fatalf("Expected the generated chain to verify, got %v", err)
+	}
+
+	utxos, err := bc.GetUTXOsByAddress(alice.Address)
+	if err != nil {
+		t.Fatalf("Failed to get UTXOs: %v", err)
+	}
+	var first core.UTXO
+	for _, utxo := range utxos {
+		if utxo.Height == 1 {
+			first = utxo
+		}
+	}
+	tx, err := core.NewTransaction(alice.Address, bob.Address, 30, map[string][]core.UTXO{alice.Address: {first}})
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	if err := bc.SignTransaction(tx, alice.PrivateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	if err := pool.Add(tx); err != nil {
+		t.Fatalf("Expected the matured coinbase to be spendable, got %v", err)
+	}
+
+	if _, err := manager.Generate(context.Background(), 1, alice.Address); err != nil {
+		t.Fatalf("Failed to generate block: %v", err)
+	}
+	if balance, _ := bc.GetBalance(bob.Address); balance != 30 {
+		t.Errorf("Expected bob to be paid 30, got %d", balance)
+	}
+	if pool.Count() != 0 {
+		t.Errorf("Expected the payment to leave the pool, %d left", pool.Count())
+	}
+}
+
+func TestRegtestGenerateAPI(t *testing.T) {
+	bc, _ := newRegtestBlockchain(t)
+	defer bc.Close()
+	pool := mempool.NewMempool(bc, mempool.DefaultOptions())
+	server := api.NewServer(bc, nil, pool, 0)
+	server.SetMining(mining.NewManager(bc, pool, mining.DefaultOptions()))
+
+	generate := func(req api.GenerateRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		rec := httptest.NewRecorder()
+		server.Generate(rec, httptest.NewRequest(http.MethodPost, "/api/v1/generate", bytes.NewReader(body)))
+		return rec
+	}
+
+	// Generation is off unless the node runs regtest
+	if rec := generate(api.GenerateRequest{Blocks: 1, Address: newWallet(t).Address}); rec.Code != http.StatusNotImplemented {
+		t.Errorf("Expected 501 before generation is enabled, got %d", rec.Code)
+	}
+	if bc.Length() != 1 {
+		t.Fatalf("Expected no block to be generated, got length %d", bc.Length())
+	}
+	server.SetGenerate(true)
+
+	rec := generate(api.GenerateRequest{Blocks: 3, Address: newWallet(t).Address})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected blocks to be generated, got %d: %s", rec.Code, rec.Body)
+	}
+	var response struct {
+		Blocks []string `json:"blocks"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Blocks) != 3 || bc.GetLatestBlock().Hash != response.Blocks[2] {
+		t.Errorf("Expected three blocks ending at the tip, got %v", response.Blocks)
+	}
+
+	if rec := generate(api.GenerateRequest{Blocks: 0, Address: newWallet(t).Address}); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for no blocks, got %d", rec.Code)
+	}
+	if rec := generate(api.GenerateRequest{Blocks: 1, Address: "nobody"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid address, got %d", rec.Code)
+	}
+}
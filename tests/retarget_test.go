@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/consensus"
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+)
+
+// headerChain is a ChainReader over headers built by a test
+type headerChain struct {
+	params  core.Params
+	headers map[string]*core.ChainHeader
+}
+
+func (c *headerChain) Params() core.Params                  { return c.params }
+func (c *headerChain) Now() time.Time                       { return time.Now() }
+func (c *headerChain) Header(hash string) *core.ChainHeader { return c.headers[hash] }
+func (c *headerChain) Block(hash string) *core.Block        { return nil }
+
+// extend adds n headers spaced apart on top of the chain's highest header
+// and returns the new highest header
+func (c *headerChain) extend(tip *core.ChainHeader, n int, spacing time.Duration, bits uint32) *core.ChainHeader {
+	for i := 0; i < n; i++ {
+		header := &core.ChainHeader{
+			Hash:         fmt.Sprintf("block-%d", tip.Height+1),
+			PreviousHash: tip.Hash,
+			Height:       tip.Height + 1,
+			Timestamp:    tip.Timestamp.Add(spacing),
+			Bits:         bits,
+		}
+		c.headers[header.Hash] = header
+		tip = header
+	}
+	return tip
+}
+
+func TestRetarget(t *testing.T) {
+	t.Run("CompactEncoding", func(t *testing.T) {
+		for _, bits := range []uint32{0x1d00ffff, 0x1b0404cb, core.PowLimitBits} {
+			if got := core.BigToCompact(core.CompactToBig(bits)); got != bits {
+				t.Errorf("Expected %08x to round trip, got %08x", bits, got)
+			}
+		}
+
+		want := new(big.Int).Lsh(big.NewInt(1), 255)
+		if got := core.CompactToBig(core.BitsFromLeadingZeros(1)); got.Cmp(want) != 0 {
+			t.Errorf("Expected one leading zero to allow targets below 2^255, got %x", got)
+		}
+		if got := core.CompactToBig(0x04923456); got.Sign() != 0 {
+			t.Errorf("Expected a target with the sign bit set to be invalid, got %x", got)
+		}
+	})
+
+	t.Run("Work", func(t *testing.T) {
+		if work := core.Work(core.BitsFromLeadingZeros(8)); work.Cmp(big.NewInt(256)) != 0 {
+			t.Errorf("Expected eight leading zeros to take 256 hashes, got %s", work)
+		}
+		if work := core.Work(0); work.Sign() != 0 {
+			t.Errorf("Expected an invalid target to take no work, got %s", work)
+		}
+	})
+
+	t.Run("NextBits", func(t *testing.T) {
+		const spacing = 10 * time.Second
+		start := core.BitsFromLeadingZeros(8)
+
+		newChain := func() (*headerChain, *core.ChainHeader) {
+			params := core.DefaultParams()
+			params.TargetSpacing = spacing
+			params.RetargetWindow = 4
+			params.MaxRetarget = 4
+
+			genesis := &core.ChainHeader{Hash: "genesis", Timestamp: core.GenesisTimestamp, Bits: start}
+			return &headerChain{params: params, headers: map[string]*core.ChainHeader{genesis.Hash: genesis}}, genesis
+		}
+
+		tests := []struct {
+			name    string
+			spacing time.Duration
+			target  *big.Int
+		}{
+			{"OnSchedule", spacing, core.CompactToBig(start)},
+			{"TwiceAsFast", spacing / 2, new(big.Int).Rsh(core.CompactToBig(start), 1)},
+			{"ClampedFaster", spacing / 100, new(big.Int).Rsh(core.CompactToBig(start), 2)},
+			{"ClampedSlower", spacing * 100, new(big.Int).Lsh(core.CompactToBig(start), 2)},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				chain, genesis := newChain()
+				parent := chain.extend(genesis, 4, tt.spacing, start)
+
+				bits, err := consensus.NextBits(chain, parent)
+				if err != nil {
+					t.Fatalf("Failed to retarget: %v", err)
+				}
+				if got := core.CompactToBig(bits); got.Cmp(tt.target) != 0 {
+					t.Errorf("Expected target %x, got %x", tt.target, got)
+				}
+			})
+		}
+
+		t.Run("ShortChainUsesLimit", func(t *testing.T) {
+			chain, genesis := newChain()
+			parent := chain.extend(genesis, 3, spacing/100, start)
+			if bits, _ := consensus.NextBits(chain, parent); bits != chain.params.PowLimitBits {
+				t.Errorf("Expected the limit before a full window, got %08x", bits)
+			}
+		})
+
+		t.Run("UnclampedNeverReachesZero", func(t *testing.T) {
+			// Without MaxRetarget a window that took no time or went
+			// backwards still leaves a target blocks can meet
+			for _, spacing := range []time.Duration{0, -time.Second} {
+				chain, genesis := newChain()
+				chain.params.MaxRetarget = 0
+				parent := chain.extend(genesis, 4, spacing, start)
+
+				bits, err := consensus.NextBits(chain, parent)
+				if err != nil {
+					t.Fatalf("Failed to retarget: %v", err)
+				}
+				if core.CompactToBig(bits).Sign() <= 0 {
+					t.Errorf("Expected a positive target for spacing %s, got bits %08x", spacing, bits)
+				}
+			}
+		})
+
+		t.Run("CappedAtLimit", func(t *testing.T) {
+			chain, genesis := newChain()
+			parent := chain.extend(genesis, 4, spacing*100, core.PowLimitBits)
+			if bits, _ := consensus.NextBits(chain, parent); bits != chain.params.PowLimitBits {
+				t.Errorf("Expected slow blocks to stay at the limit, got %08x", bits)
+			}
+		})
+	})
+
+	t.Run("ChainVerifiesBits", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		opts.Backend = core.BackendMemory
+		opts.Params.RetargetWindow = 2
+		opts.Params.TargetSpacing = time.Hour
+		bc, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to create blockchain: %v", err)
+		}
+		defer bc.Close()
+
+		// Blocks mined far faster than an hour apart make the chain harder
+		// once the window no longer reaches back to the genesis block
+		for i := 0; i < 4; i++ {
+			if consensus.MineBlock(bc, fmt.Sprintf("block %d", i)) == nil {
+				t.Fatalf("Failed to mine block %d", i)
+			}
+		}
+		if bits := bc.GetLatestBlock().Bits; bits == core.PowLimitBits {
+			t.Errorf("Expected the target to drop below the limit, got %08x", bits)
+		}
+
+		tip := bc.GetLatestBlock()
+		block := core.NewBlock(tip.Index+1, tip.Hash, "at the limit")
+		block.Bits = core.PowLimitBits
+		sealBlock(block)
+		if _, err := bc.ProcessBlock(block); !errors.Is(err, core.ErrInvalidBlock) {
+			t.Errorf("Expected ErrInvalidBlock for bits that skip the retarget, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+func TestBlockReward(t *testing.T) {
+	t.Run("HalvingSchedule", func(t *testing.T) {
+		params := core.Params{InitialSubsidy: 100, HalvingInterval: 10}
+
+		cases := map[uint64]int{0: 100, 9: 100, 10: 50, 25: 25, 69: 1, 70: 0, 10000: 0}
+		for height, want := range cases {
+			if got := params.Subsidy(height); got != want {
+				t.Errorf("Subsidy(%d) = %d, want %d", height, got, want)
+			}
+		}
+
+		if got := (core.Params{InitialSubsidy: 100}).Subsidy(1000000); got != 100 {
+			t.Errorf("Expected constant subsidy without halving, got %d", got)
+		}
+	})
+
+	t.Run("CoinbaseLimitedToSubsidy", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		opts.Backend = core.BackendMemory
+		opts.Params = core.Params{InitialSubsidy: 100, HalvingInterval: 2}
+
+		bc, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to create blockchain: %v", err)
+		}
+		defer bc.Close()
+
+		if bc.AddBlock([]*core.Transaction{newCoinbase(t, testAddress("miner"), "greedy", 101)}) != nil {
+			t.Error("Expected coinbase above the subsidy to be rejected")
+		}
+		if bc.AddBlock([]*core.Transaction{newCoinbase(t, testAddress("miner"), "height 1", 100)}) == nil {
+			t.Fatal("Expected coinbase claiming the subsidy to be accepted")
+		}
+
+		// The subsidy halves at height 2
+		if bc.AddBlock([]*core.Transaction{newCoinbase(t, testAddress("miner"), "height 2", 100)}) != nil {
+			t.Error("Expected coinbase above the halved subsidy to be rejected")
+		}
+		if bc.AddBlock([]*core.Transaction{newCoinbase(t, testAddress("miner"), "height 2", 50)}) == nil {
+			t.Error("Expected coinbase claiming the halved subsidy to be accepted")
+		}
+	})
+
+	t.Run("CoinbaseCollectsFees", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		alice, err := wallet.NewWallet()
+		if err != nil {
+			t.Fatalf("Failed to create wallet: %v", err)
+		}
+		funding := newCoinbase(t, alice.Address, "funding", 100)
+		bc.AddBlock([]*core.Transaction{funding})
+
+		// Pay 60 to bob and 35 back in change, leaving a fee of 5
+		utxos, err := bc.GetUTXOsByAddress(alice.Address)
+		if err != nil {
+			t.Fatalf("Failed to get UTXOs: %v", err)
+		}
+		tx, err := core.NewTransaction(alice.Address, testAddress("bob"), 60, map[string][]core.UTXO{alice.Address: utxos})
+		if err != nil {
+			t.Fatalf("Failed to create transaction: %v", err)
+		}
+		tx.Outputs[1].Value -= 5
+		if err := bc.SignTransaction(tx, alice.PrivateKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+
+		if fee := tx.Fee([]core.TXOutput{funding.Outputs[0]}); fee != 5 {
+			t.Fatalf("Expected fee 5, got %d", fee)
+		}
+
+		greedy := newCoinbase(t, testAddress("miner"), "greedy", 106)
+		if bc.AddBlock([]*core.Transaction{greedy, tx}) != nil {
+			t.Error("Expected coinbase above subsidy plus fees to be rejected")
+		}
+
+		coinbase := newCoinbase(t, testAddress("miner"), "fees", 105)
+		if bc.AddBlock([]*core.Transaction{coinbase, tx}) == nil {
+			t.Error("Expected coinbase claiming subsidy plus fees to be accepted")
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+func TestScripts(t *testing.T) {
+	// spendWith builds a transaction whose only input is unlocked by scriptSig
+	spendWith := func(scriptSig core.Script) *core.Transaction {
+		tx := &core.Transaction{
+			Inputs:  []core.TXInput{{TXID: "prev", Vout: 0, ScriptSig: scriptSig}},
+			Outputs: []core.TXOutput{newOutput(t, 10, testAddress("recipient"))},
+		}
+		tx.SetID()
+		return tx
+	}
+
+	t.Run("PayToPubKeyHash", func(t *testing.T) {
+		hash := core.HashPubKey([]byte("public key"))
+		script := core.PayToPubKeyHashScript(hash)
+
+		want := "OP_DUP OP_PUBKEYHASH " + hex.EncodeToString(hash) + " OP_EQUALVERIFY OP_CHECKSIG"
+		if script.String() != want {
+			t.Errorf("Expected %q, got %q", want, script.String())
+		}
+		if got := script.PubKeyHash(); hex.EncodeToString(got) != hex.EncodeToString(hash) {
+			t.Errorf("Expected to extract the public key hash, got %x", got)
+		}
+		if core.HashLockScript(hash).PubKeyHash() != nil {
+			t.Error("Expected no public key hash for a hash lock")
+		}
+	})
+
+	t.Run("DataCarrierIsUnspendable", func(t *testing.T) {
+		out := core.NewDataOutput([]byte("payload"))
+		if !out.IsData() || string(out.Data()) != "payload" {
+			t.Fatalf("Expected a data output carrying the payload, got %s", out.Script)
+		}
+
+		tx := spendWith(core.NewScript().AddOp(core.OP_TRUE))
+		if err := tx.VerifyScript(0, out); !errors.Is(err, core.ErrScriptFailed) {
+			t.Errorf("Expected spending a data output to fail, got %v", err)
+		}
+	})
+
+	t.Run("Conditionals", func(t *testing.T) {
+		// Either branch compares the top element with a different constant
+		lock := core.TXOutput{Script: core.NewScript().
+			AddOp(core.OP_IF).AddData([]byte("a")).
+			AddOp(core.OP_ELSE).AddData([]byte("b")).
+			AddOp(core.OP_ENDIF).
+			AddOp(core.OP_EQUAL)}
+
+		cases := []struct {
+			value  string
+			branch core.Opcode
+			valid  bool
+		}{
+			{"a", core.OP_TRUE, true},
+			{"b", core.OP_FALSE, true},
+			{"a", core.OP_FALSE, false},
+		}
+		for _, c := range cases {
+			tx := spendWith(core.NewScript().AddData([]byte(c.value)).AddOp(c.branch))
+			if err := tx.VerifyScript(0, lock); (err == nil) != c.valid {
+				t.Errorf("Unlocking with %q and %s: got %v", c.value, c.branch, err)
+			}
+		}
+
+		unbalanced := core.TXOutput{Script: core.NewScript().AddOp(core.OP_IF).AddOp(core.OP_TRUE)}
+		if err := spendWith(core.NewScript().AddOp(core.OP_TRUE)).VerifyScript(0, unbalanced); !errors.Is(err, core.ErrScriptFailed) {
+			t.Errorf("Expected unbalanced OP_IF to fail, got %v", err)
+		}
+	})
+
+	t.Run("UnlockingScriptMustBePushOnly", func(t *testing.T) {
+		anyone := core.TXOutput{Script: core.NewScript().AddOp(core.OP_TRUE)}
+		tx := spendWith(core.NewScript().AddOp(core.OP_TRUE).AddOp(core.OP_DUP))
+		if err := tx.VerifyScript(0, anyone); !errors.Is(err, core.ErrScriptFailed) {
+			t.Errorf("Expected non-push unlocking script to fail, got %v", err)
+		}
+	})
+
+	t.Run("HashLockOnChain", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		alice, err := wallet.NewWallet()
+		if err != nil {
+			t.Fatalf("Failed to create wallet: %v", err)
+		}
+		bc.AddBlock([]*core.Transaction{newCoinbase(t, alice.Address, "reward", 100)})
+
+		// Alice locks 40 coins to the hash of a secret
+		secret := []byte("open sesame")
+		hash := sha256.Sum256(secret)
+
+		utxos, err := bc.GetUTXOsByAddress(alice.Address)
+		if err != nil {
+			t.Fatalf("Failed to get UTXOs: %v", err)
+		}
+		lock, err := core.NewTransaction(alice.Address, testAddress("recipient"), 40, map[string][]core.UTXO{alice.Address: utxos})
+		if err != nil {
+			t.Fatalf("Failed to create transaction: %v", err)
+		}
+		lock.Outputs[0].Script = core.HashLockScript(hash[:])
+		if err := bc.SignTransaction(lock, alice.PrivateKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		if bc.AddBlock([]*core.Transaction{lock}) == nil {
+			t.Fatal("Expected hash lock output to be mined")
+		}
+
+		claim := func(preimage []byte) *core.Transaction {
+			tx := &core.Transaction{
+				Inputs:  []core.TXInput{{TXID: lock.ID, Vout: 0, ScriptSig: core.NewScript().AddData(preimage)}},
+				Outputs: []core.TXOutput{newOutput(t, 40, testAddress("claimer"))},
+			}
+			tx.SetID()
+			return tx
+		}
+
+		if bc.AddBlock([]*core.Transaction{claim([]byte("wrong guess"))}) != nil {
+			t.Error("Expected a wrong preimage to be rejected")
+		}
+		if bc.AddBlock([]*core.Transaction{claim(secret)}) == nil {
+			t.Error("Expected the preimage to unlock the output")
+		}
+		if utxo, _ := bc.GetUTXO(lock.ID, 0); utxo != nil {
+			t.Error("Expected hash lock output to be spent")
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+func TestTransactionSignatures(t *testing.T) {
+	alice, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	mallory, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+
+	funding := newCoinbase(t, alice.Address, "funding", 100)
+	prevTXs := map[string]core.Transaction{funding.ID: *funding}
+	utxos := map[string][]core.UTXO{
+		alice.Address: {{TXID: funding.ID, Index: 0, Output: funding.Outputs[0]}},
+	}
+
+	newSpend := func(t *testing.T) *core.Transaction {
+		t.Helper()
+
+		tx, err := core.NewTransaction(alice.Address, mallory.Address, 40, utxos)
+		if err != nil {
+			t.Fatalf("Failed to create transaction: %v", err)
+		}
+		return tx
+	}
+
+	t.Run("SignAndVerify", func(t *testing.T) {
+		tx := newSpend(t)
+		if err := alice.SignTransaction(tx, prevTXs); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+
+		// The unlocking script pushes the signature and the public key
+		want := core.NewScript().AddData(make([]byte, core.SignatureLen)).AddData(make([]byte, core.PubKeyLen))
+		if got := tx.Inputs[0].ScriptSig; len(got) != len(want) || !got.IsPushOnly() {
+			t.Errorf("Expected <signature> <public key> unlocking script, got %s", got)
+		}
+		if !tx.Verify(prevTXs) {
+			t.Error("Expected signed transaction to verify")
+		}
+	})
+
+	t.Run("TamperedOutput", func(t *testing.T) {
+		tx := newSpend(t)
+		if err := alice.SignTransaction(tx, prevTXs); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+
+		tx.Outputs[0].Value = 100
+		if tx.Verify(prevTXs) {
+			t.Error("Expected tampered transaction to fail verification")
+		}
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		tx := newSpend(t)
+		if err := mallory.SignTransaction(tx, prevTXs); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+
+		// The signature is valid but the key does not own the spent output
+		if tx.Verify(prevTXs) {
+			t.Error("Expected signature by a different key to fail verification")
+		}
+	})
+
+	t.Run("MissingPreviousTransaction", func(t *testing.T) {
+		tx := newSpend(t)
+		if err := alice.SignTransaction(tx, map[string]core.Transaction{}); err == nil {
+			t.Error("Expected signing without the previous transaction to fail")
+		}
+	})
+
+	t.Run("BlockWithInvalidSignature", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		bc.AddBlock([]*core.Transaction{funding})
+
+		tx := newSpend(t)
+		if err := mallory.SignTransaction(tx, prevTXs); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		if bc.VerifyTransaction(tx) {
+			t.Error("Expected chain verification to reject the transaction")
+		}
+
+		block := sealBlock(core.NewBlock(2, bc.GetLatestBlock().Hash, []*core.Transaction{tx}))
+		if _, err := bc.ProcessBlock(block); !errors.Is(err, core.ErrInvalidBlock) {
+			t.Errorf("Expected block to be rejected, got %v", err)
+		}
+		if bc.Length() != 2 {
+			t.Errorf("Expected chain length to stay 2, got %d", bc.Length())
+		}
+
+		// The same spend signed by the owner is accepted
+		valid := newSpend(t)
+		if err := bc.SignTransaction(valid, alice.PrivateKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		if !bc.VerifyTransaction(valid) {
+			t.Error("Expected chain verification to accept the transaction")
+		}
+		if bc.AddBlock([]*core.Transaction{valid}) == nil {
+			t.Error("Expected block with a valid signature to be added")
+		}
+	})
+}
@@ -11,7 +11,11 @@ func main() {
 	fmt.Println("Testing blockchain...")
 
 	// Create a new blockchain
-	blockchain := core.NewBlockchain()
+	blockchain, err := core.NewBlockchain(core.DefaultOptions())
+	if err != nil {
+		fmt.Printf("Error opening blockchain: %v\n", err)
+		os.Exit(1)
+	}
 	defer blockchain.Close()
 
 	fmt.Printf("Blockchain length: %d\n", blockchain.Length())
@@ -39,7 +43,7 @@ func main() {
 	}
 
 	// List blocks
-	blocks := blockchain.GetBlocks()
+	blocks := blockchain.GetBlocks(0, 0)
 	fmt.Printf("Listing %d blocks:\n", len(blocks))
 	for _, b := range blocks {
 		fmt.Printf("Block #%d: %s\n", b.Index, b.Hash)
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/storage"
+)
+
+func TestStorageBackends(t *testing.T) {
+	backends := map[string]func(t *testing.T) storage.Store{
+		"Memory": func(t *testing.T) storage.Store {
+			return storage.NewMemory()
+		},
+		"Bolt": func(t *testing.T) storage.Store {
+			store, err := storage.OpenBolt(storage.BoltOptions{DataDir: t.TempDir()})
+			if err != nil {
+				t.Fatalf("Failed to open bolt store: %v", err)
+			}
+			return store
+		},
+	}
+
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			defer store.Close()
+
+			// Write a few keys out of order
+			err := store.Update(func(tx storage.Tx) error {
+				bucket, err := tx.CreateBucketIfNotExists([]byte("test"))
+				if err != nil {
+					return err
+				}
+				for _, key := range []string{"b", "c", "a"} {
+					if err := bucket.Put([]byte(key), []byte("value-"+key)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Failed to write keys: %v", err)
+			}
+
+			// A failed update must not leave partial writes behind
+			errAbort := errors.New("abort")
+			err = store.Update(func(tx storage.Tx) error {
+				if err := tx.Bucket([]byte("test")).Put([]byte("d"), []byte("value-d")); err != nil {
+					return err
+				}
+				return errAbort
+			})
+			if err != errAbort {
+				t.Fatalf("Expected abort error, got %v", err)
+			}
+
+			// Keys must come back in order and without the aborted write
+			var keys []string
+			err = store.View(func(tx storage.Tx) error {
+				return tx.Bucket([]byte("test")).ForEach(func(k, v []byte) error {
+					keys = append(keys, string(k))
+					return nil
+				})
+			})
+			if err != nil {
+				t.Fatalf("Failed to read keys: %v", err)
+			}
+			if len(keys) != 3 || keys[0] != "a" || keys[1] != "b" || keys[2] != "c" {
+				t.Errorf("Expected keys [a b c], got %v", keys)
+			}
+
+			// Writes are refused inside read-only transactions
+			err = store.View(func(tx storage.Tx) error {
+				return tx.Bucket([]byte("test")).Put([]byte("e"), nil)
+			})
+			if err != storage.ErrTxNotWritable {
+				t.Errorf("Expected ErrTxNotWritable, got %v", err)
+			}
+		})
+	}
+}
+
+func TestBlockchainDataDir(t *testing.T) {
+	// Two nodes in separate data directories must not interfere
+	opts1 := core.DefaultOptions()
+	opts1.DataDir = t.TempDir()
+	opts2 := core.DefaultOptions()
+	opts2.DataDir = t.TempDir()
+
+	bc1, err := core.NewBlockchain(opts1)
+	if err != nil {
+		t.Fatalf("Failed to open first blockchain: %v", err)
+	}
+	bc2, err := core.NewBlockchain(opts2)
+	if err != nil {
+		t.Fatalf("Failed to open second blockchain: %v", err)
+	}
+	defer bc2.Close()
+
+	bc1.AddBlock("Block 1")
+	if bc2.Length() != 1 {
+		t.Errorf("Expected second blockchain length to be 1, got %d", bc2.Length())
+	}
+
+	// Opening the same directory twice must fail instead of hanging
+	locked := opts1
+	locked.Timeout = 50 * time.Millisecond
+	if _, err := core.NewBlockchain(locked); err == nil {
+		t.Error("Expected opening a locked database to fail")
+	}
+
+	// The chain must survive a restart
+	bc1.Close()
+	reopened, err := core.NewBlockchain(opts1)
+	if err != nil {
+		t.Fatalf("Failed to reopen blockchain: %v", err)
+	}
+	defer reopened.Close()
+
+	if reopened.Length() != 2 {
+		t.Errorf("Expected reopened blockchain length to be 2, got %d", reopened.Length())
+	}
+
+	// Unknown backends are rejected
+	bad := core.DefaultOptions()
+	bad.Backend = "leveldb"
+	if _, err := core.NewBlockchain(bad); !errors.Is(err, core.ErrUnknownBackend) {
+		t.Errorf("Expected ErrUnknownBackend, got %v", err)
+	}
+}
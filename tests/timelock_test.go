@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/mempool"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+// mineFiller adds n blocks holding only a coinbase
+func mineFiller(t *testing.T, bc *core.Blockchain, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		coinbase := newCoinbase(t, testAddress("miner"), fmt.Sprintf("filler %d-%d", bc.Length(), i), 0)
+		if bc.AddBlock([]*core.Transaction{coinbase}) == nil {
+			t.Fatal("Failed to mine filler block")
+		}
+	}
+}
+
+// fundedTx returns an unsigned transaction paying amount from a freshly
+// funded wallet to an address
+func fundedTx(t *testing.T, bc *core.Blockchain, to string, amount int) (*core.Transaction, *wallet.Wallet) {
+	t.Helper()
+
+	from := newWallet(t)
+	if bc.AddBlock([]*core.Transaction{newCoinbase(t, from.Address, "fund "+from.Address, 100)}) == nil {
+		t.Fatal("Failed to add funding block")
+	}
+	utxos, err := bc.GetUTXOsByAddress(from.Address)
+	if err != nil {
+		t.Fatalf("Failed to get UTXOs: %v", err)
+	}
+	tx, err := core.NewTransaction(from.Address, to, amount, map[string][]core.UTXO{from.Address: utxos})
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %v", err)
+	}
+	return tx, from
+}
+
+func TestAbsoluteHeightLock(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+	pool := mempool.NewMempool(bc, mempool.DefaultOptions())
+
+	tx, alice := fundedTx(t, bc, testAddress("bob"), 40)
+	unlockHeight := uint32(bc.Length() + 2)
+	tx.LockTime = unlockHeight
+	if err := bc.SignTransaction(tx, alice.PrivateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+
+	if err := pool.Add(tx); !errors.Is(err, core.ErrTimeLocked) {
+		t.Errorf("Expected ErrTimeLocked from the mempool, got %v", err)
+	}
+	if bc.AddBlock([]*core.Transaction{tx}) != nil {
+		t.Fatal("Expected a block to reject the transaction before its lock time")
+	}
+
+	mineFiller(t, bc, 2)
+	if uint32(bc.Length()) != unlockHeight {
+		t.Fatalf("Expected the next block at height %d, got %d", unlockHeight, bc.Length())
+	}
+	if err := pool.Add(tx); err != nil {
+		t.Errorf("Expected the mempool to accept the transaction at its lock time, got %v", err)
+	}
+	if bc.AddBlock([]*core.Transaction{tx}) == nil {
+		t.Error("Expected the transaction to be mined at its lock time")
+	}
+}
+
+func TestAbsoluteTimeLock(t *testing.T) {
+	tx := &core.Transaction{LockTime: uint32(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC).Unix())}
+	if tx.IsFinal(1<<30, time.Date(2029, 12, 31, 23, 59, 59, 0, time.UTC)) {
+		t.Error("Expected a time lock to ignore the height")
+	}
+	if !tx.IsFinal(0, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Expected a time lock to expire at its timestamp")
+	}
+}
+
+func TestTimeLockUsesMedianTimePast(t *testing.T) {
+	opts := core.DefaultOptions()
+	opts.Backend = core.BackendMemory
+	opts.Params.CoinbaseMaturity = 0
+	clock := core.NewManualClock(core.GenesisTimestamp.Add(time.Hour))
+	opts.Clock = clock
+	bc, err := core.NewBlockchain(opts)
+	if err != nil {
+		t.Fatalf("Failed to create blockchain: %v", err)
+	}
+	defer bc.Close()
+	pool := mempool.NewMempool(bc, mempool.DefaultOptions())
+
+	tx, alice := fundedTx(t, bc, testAddress("bob"), 40)
+	funding := bc.GetLatestBlock()
+	utxos, err := bc.GetUTXOsByAddress(alice.Address)
+	if err != nil || len(utxos) != 1 {
+		t.Fatalf("Failed to get UTXOs: %v", err)
+	}
+	if want := bc.MedianTimePast(funding.PreviousHash); !utxos[0].Time.Equal(want) {
+		t.Errorf("Expected the output to be confirmed at the parent's median time past %v, got %v", want, utxos[0].Time)
+	}
+
+	unlock := clock.Now().Add(10 * time.Minute)
+	tx.LockTime = uint32(unlock.Unix())
+	if err := bc.SignTransaction(tx, alice.PrivateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+
+	// A block stamped past the lock time cannot include the transaction
+	// while the median of the blocks before it is still short of it
+	clock.Set(unlock)
+	if err := pool.Add(tx); !errors.Is(err, core.ErrTimeLocked) {
+		t.Errorf("Expected ErrTimeLocked from the mempool, got %v", err)
+	}
+	if bc.AddBlock([]*core.Transaction{tx}) != nil {
+		t.Fatal("Expected a block to reject the transaction before the median time past reaches its lock time")
+	}
+
+	mineFiller(t, bc, int(opts.Params.MedianTimeBlocks))
+	if err := pool.Add(tx); err != nil {
+		t.Errorf("Expected the mempool to accept the transaction, got %v", err)
+	}
+	if bc.AddBlock([]*core.Transaction{tx}) == nil {
+		t.Error("Expected the transaction to be mined once the median time past reaches its lock time")
+	}
+}
+
+func TestVestingOutput(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+
+	employee := newWallet(t)
+	grant, employer := fundedTx(t, bc, employee.Address, 50)
+	vestHeight := uint32(bc.Length() + 3)
+	vesting, err := core.NewTimeLockedOutput(50, employee.Address, vestHeight)
+	if err != nil {
+		t.Fatalf("Failed to create time-locked output: %v", err)
+	}
+	grant.Outputs[0] = vesting
+	if err := bc.SignTransaction(grant, employer.PrivateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	if bc.AddBlock([]*core.Transaction{grant}) == nil {
+		t.Fatal("Expected the grant to be mined")
+	}
+
+	lockTime, _, ok := grant.Outputs[0].Script.TimeLock()
+	if !ok || lockTime != vestHeight {
+		t.Fatalf("Expected a time lock at height %d, got %d (%v)", vestHeight, lockTime, ok)
+	}
+
+	claim := func(lockTime uint32) *core.Transaction {
+		tx := &core.Transaction{
+			Inputs:   []core.TXInput{{TXID: grant.ID, Vout: 0}},
+			Outputs:  []core.TXOutput{newOutput(t, 50, employee.Address)},
+			LockTime: lockTime,
+		}
+		if err := bc.SignTransaction(tx, employee.PrivateKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		return tx
+	}
+
+	// The script requires the claim to carry a lock time of at least the vesting height
+	unlocked := claim(0)
+	if err := unlocked.VerifyScript(0, grant.Outputs[0]); !errors.Is(err, core.ErrScriptFailed) {
+		t.Errorf("Expected a claim without lock time to fail the script, got %v", err)
+	}
+	if bc.AddBlock([]*core.Transaction{unlocked}) != nil {
+		t.Error("Expected a claim without lock time to be rejected")
+	}
+
+	// and consensus keeps such a claim out of blocks until then
+	vested := claim(vestHeight)
+	if bc.AddBlock([]*core.Transaction{vested}) != nil {
+		t.Error("Expected the claim to be rejected before the vesting height")
+	}
+	mineFiller(t, bc, int(vestHeight)-bc.Length())
+	if bc.AddBlock([]*core.Transaction{vested}) == nil {
+		t.Error("Expected the claim to be mined at the vesting height")
+	}
+}
+
+func TestRelativeBlockLock(t *testing.T) {
+	bc := newTestBlockchain(t)
+	defer bc.Close()
+	pool := mempool.NewMempool(bc, mempool.DefaultOptions())
+
+	tx, alice := fundedTx(t, bc, testAddress("bob"), 40)
+	utxo, err := bc.GetUTXO(tx.Inputs[0].TXID, tx.Inputs[0].Vout)
+	if err != nil || utxo == nil {
+		t.Fatalf("Failed to get spent output: %v", err)
+	}
+	tx.Inputs[0].Sequence = core.RelativeLockBlocks(3)
+	if err := bc.SignTransaction(tx, alice.PrivateKey); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+
+	if err := pool.Add(tx); !errors.Is(err, core.ErrTimeLocked) {
+		t.Errorf("Expected ErrTimeLocked from the mempool, got %v", err)
+	}
+	if bc.AddBlock([]*core.Transaction{tx}) != nil {
+		t.Fatal("Expected a block to reject the input before three confirmations")
+	}
+
+	mineFiller(t, bc, int(utxo.Height)+3-bc.Length())
+	if err := pool.Add(tx); err != nil {
+		t.Errorf("Expected the mempool to accept the transaction, got %v", err)
+	}
+	if bc.AddBlock([]*core.Transaction{tx}) == nil {
+		t.Error("Expected the input to be spendable after three confirmations")
+	}
+}
+
+func TestRelativeTimeLock(t *testing.T) {
+	confirmed := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	lock := core.SequenceLockScript(core.RelativeLockDuration(time.Hour), core.HashPubKey([]byte("refund key")))
+	if sequence, _, ok := lock.SequenceLock(); !ok || sequence&core.SequenceLockMask != 8 {
+		t.Fatalf("Expected an hour to round up to 8 units, got %#x (%v)", sequence, ok)
+	}
+
+	tx := &core.Transaction{
+		Inputs:  []core.TXInput{{TXID: "escrow", Vout: 0, Sequence: core.RelativeLockDuration(time.Hour)}},
+		Outputs: []core.TXOutput{newOutput(t, 10, testAddress("refund"))},
+	}
+	tx.SetID()
+	spent := []core.UTXO{{TXID: "escrow", Output: newOutput(t, 10, testAddress("escrow")), Height: 1, Time: confirmed}}
+
+	early := confirmed.Add(30 * time.Minute)
+	if _, err := core.ValidateTransaction(tx, spent, 100, early, core.DefaultParams()); !errors.Is(err, core.ErrTimeLocked) {
+		t.Errorf("Expected ErrTimeLocked half an hour after confirmation, got %v", err)
+	}
+}
+
+func TestCheckSequenceVerify(t *testing.T) {
+	lock := core.TXOutput{Script: core.NewScript().
+		AddInt(int64(core.RelativeLockBlocks(10))).
+		AddOp(core.OP_CHECKSEQUENCEVERIFY)}
+
+	cases := []struct {
+		name     string
+		sequence uint32
+		valid    bool
+	}{
+		{"Enough", core.RelativeLockBlocks(10), true},
+		{"TooShort", core.RelativeLockBlocks(9), false},
+		{"WrongKind", core.RelativeLockDuration(time.Hour), false},
+		{"Disabled", core.SequenceLockDisabled, false},
+	}
+	for _, c := range cases {
+		tx := &core.Transaction{
+			Inputs:  []core.TXInput{{TXID: "prev", Vout: 0, Sequence: c.sequence}},
+			Outputs: []core.TXOutput{newOutput(t, 10, testAddress("recipient"))},
+		}
+		if err := tx.VerifyScript(0, lock); (err == nil) != c.valid {
+			t.Errorf("%s: got %v", c.name, err)
+		}
+	}
+}
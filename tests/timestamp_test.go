@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+)
+
+func TestTimestampRules(t *testing.T) {
+	// timedBlock builds a sealed block on parent with the given timestamp
+	timedBlock := func(parent *core.Block, timestamp time.Time) *core.Block {
+		block := core.NewBlock(parent.Index+1, parent.Hash, timestamp.String())
+		block.Timestamp = timestamp
+		return sealBlock(block)
+	}
+
+	// extend adds blocks a minute apart after the genesis block
+	extend := func(t *testing.T, bc *core.Blockchain, n int) *core.Block {
+		t.Helper()
+
+		tip := bc.GetLatestBlock()
+		for i := 1; i <= n; i++ {
+			tip = timedBlock(tip, core.GenesisTimestamp.Add(time.Duration(i)*time.Minute))
+			if _, err := bc.ProcessBlock(tip); err != nil {
+				t.Fatalf("Failed to add block %d: %v", i, err)
+			}
+		}
+		return tip
+	}
+
+	t.Run("MedianTimePast", func(t *testing.T) {
+		bc, _ := newRegtestBlockchain(t)
+		defer bc.Close()
+		tip := extend(t, bc, 11)
+
+		// The median of blocks 1 to 11 is block 6
+		median := core.GenesisTimestamp.Add(6 * time.Minute)
+		if got := bc.MedianTimePast(tip.Hash); !got.Equal(median) {
+			t.Fatalf("Expected median time past %v, got %v", median, got)
+		}
+
+		if _, err := bc.ProcessBlock(timedBlock(tip, median)); !errors.Is(err, core.ErrTimeTooOld) {
+			t.Errorf("Expected ErrTimeTooOld for a block at the median, got %v", err)
+		}
+
+		// A block may be older than its parent as long as it is after the median
+		if _, err := bc.ProcessBlock(timedBlock(tip, median.Add(time.Second))); err != nil {
+			t.Errorf("Expected a block after the median to be accepted, got %v", err)
+		}
+	})
+
+	t.Run("FutureDrift", func(t *testing.T) {
+		bc, clock := newRegtestBlockchain(t)
+		defer bc.Close()
+		tip := bc.GetLatestBlock()
+
+		block := timedBlock(tip, clock.Now().Add(bc.Params().MaxFutureDrift+time.Second))
+		if _, err := bc.ProcessBlock(block); !errors.Is(err, core.ErrTimeTooNew) {
+			t.Fatalf("Expected ErrTimeTooNew, got %v", err)
+		}
+
+		// The block is not marked invalid and is accepted once its time comes
+		clock.Advance(time.Second)
+		if _, err := bc.ProcessBlock(block); err != nil {
+			t.Errorf("Expected the block to be accepted later, got %v", err)
+		}
+	})
+
+	t.Run("NextTimestampAfterMedian", func(t *testing.T) {
+		bc, clock := newRegtestBlockchain(t)
+		defer bc.Close()
+		tip := extend(t, bc, 11)
+
+		// A clock behind the tip, but within the drift, still gives a valid
+		// timestamp
+		clock.Set(tip.Timestamp.Add(-time.Minute))
+		if next := bc.NextTimestamp(tip); !next.After(bc.MedianTimePast(tip.Hash)) || !next.After(tip.Timestamp) {
+			t.Errorf("Expected the next timestamp after the median and the tip, got %v", next)
+		}
+		if block := bc.AddBlock("clock behind"); block == nil {
+			t.Error("Expected a block on a clock behind the tip to be accepted")
+		}
+	})
+
+	t.Run("NetworkClock", func(t *testing.T) {
+		base := core.NewManualClock(core.GenesisTimestamp)
+		clock := core.NewNetworkClock(base, core.DefaultMaxTimeOffset)
+
+		peers := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"}
+		for _, peer := range peers[:4] {
+			clock.AddSample(peer, base.Now().Add(10*time.Second))
+		}
+		if clock.Offset() != 0 {
+			t.Errorf("Expected no offset from too few peers, got %v", clock.Offset())
+		}
+
+		// A peer reconnecting does not count twice
+		clock.AddSample(peers[0], base.Now().Add(10*time.Second))
+		if clock.Offset() != 0 {
+			t.Errorf("Expected a repeated peer to be ignored, got %v", clock.Offset())
+		}
+
+		clock.AddSample(peers[4], base.Now().Add(20*time.Second))
+		if clock.Offset() != 10*time.Second || !clock.Now().Equal(base.Now().Add(10*time.Second)) {
+			t.Errorf("Expected the median offset of 10s, got %v", clock.Offset())
+		}
+
+		// Peers far off are not trusted
+		liars := core.NewNetworkClock(base, core.DefaultMaxTimeOffset)
+		for _, peer := range peers {
+			liars.AddSample(peer, base.Now().Add(time.Hour))
+		}
+		if liars.Offset() != 0 {
+			t.Errorf("Expected an offset beyond the limit to be ignored, got %v", liars.Offset())
+		}
+	})
+}
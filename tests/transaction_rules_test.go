@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/mempool"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+func TestTransactionRules(t *testing.T) {
+	input := core.TXInput{TXID: "funding", Vout: 0}
+	output := newOutput(t, 10, testAddress("recipient"))
+
+	cases := map[string]core.Transaction{
+		"NoInputs":        {Outputs: []core.TXOutput{output}},
+		"NoOutputs":       {Inputs: []core.TXInput{input}},
+		"NegativeValue":   {Inputs: []core.TXInput{input}, Outputs: []core.TXOutput{{Value: -1}}},
+		"ValueTooLarge":   {Inputs: []core.TXInput{input}, Outputs: []core.TXOutput{{Value: core.MaxMoney + 1}}},
+		"TotalTooLarge":   {Inputs: []core.TXInput{input}, Outputs: []core.TXOutput{{Value: core.MaxMoney}, {Value: 1}}},
+		"DuplicateInputs": {Inputs: []core.TXInput{input, input}, Outputs: []core.TXOutput{output}},
+	}
+	for name, tx := range cases {
+		t.Run(name, func(t *testing.T) {
+			if err := core.CheckTransaction(&tx); !errors.Is(err, core.ErrInvalidTransaction) {
+				t.Errorf("Expected ErrInvalidTransaction, got %v", err)
+			}
+		})
+	}
+
+	t.Run("ValidTransaction", func(t *testing.T) {
+		tx := core.Transaction{Inputs: []core.TXInput{input}, Outputs: []core.TXOutput{output}}
+		if err := core.CheckTransaction(&tx); err != nil {
+			t.Errorf("Expected transaction to pass, got %v", err)
+		}
+	})
+
+	t.Run("BlockWithInvalidTransaction", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		tx := core.Transaction{Inputs: []core.TXInput{input, input}, Outputs: []core.TXOutput{output}}
+		tx.SetID()
+
+		block := core.NewBlock(1, bc.GetLatestBlock().Hash, []core.Transaction{tx})
+		if _, err := bc.ProcessBlock(block); !errors.Is(err, core.ErrInvalidBlock) || !errors.Is(err, core.ErrInvalidTransaction) {
+			t.Errorf("Expected block to be rejected with ErrInvalidTransaction, got %v", err)
+		}
+	})
+
+	t.Run("CoinbaseMaturity", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		opts.Backend = core.BackendMemory
+		opts.Params.CoinbaseMaturity = 3
+
+		bc, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to create blockchain: %v", err)
+		}
+		defer bc.Close()
+		pool := mempool.NewMempool(bc, mempool.DefaultOptions())
+
+		alice, err := wallet.NewWallet()
+		if err != nil {
+			t.Fatalf("Failed to create wallet: %v", err)
+		}
+		bc.AddBlock([]*core.Transaction{newCoinbase(t, alice.Address, "reward", 100)})
+
+		utxos, err := bc.GetUTXOsByAddress(alice.Address)
+		if err != nil {
+			t.Fatalf("Failed to get UTXOs: %v", err)
+		}
+		tx, err := core.NewTransaction(alice.Address, testAddress("bob"), 40, map[string][]core.UTXO{alice.Address: utxos})
+		if err != nil {
+			t.Fatalf("Failed to create transaction: %v", err)
+		}
+		if err := bc.SignTransaction(tx, alice.PrivateKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+
+		// The coinbase at height 1 is spendable from height 4
+		block := sealBlock(core.NewBlock(2, bc.GetLatestBlock().Hash, []*core.Transaction{tx}))
+		if _, err := bc.ProcessBlock(block); !errors.Is(err, core.ErrImmatureCoinbase) {
+			t.Errorf("Expected ErrImmatureCoinbase from the block, got %v", err)
+		}
+		if err := pool.Add(tx); !errors.Is(err, core.ErrImmatureCoinbase) {
+			t.Errorf("Expected ErrImmatureCoinbase from the mempool, got %v", err)
+		}
+
+		bc.AddBlock("height 2")
+		bc.AddBlock("height 3")
+
+		if err := pool.Add(tx); err != nil {
+			t.Errorf("Expected mature spend to enter the mempool, got %v", err)
+		}
+		if bc.AddBlock([]*core.Transaction{tx}) == nil {
+			t.Error("Expected mature spend to be mined")
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/antontuzov/coubcore/internal/blockchain/core"
@@ -8,7 +9,7 @@ import (
 
 func TestTransactionCreation(t *testing.T) {
 	// Create a coinbase transaction
-	cbTx := core.NewCoinbaseTransaction("recipient", "test coinbase")
+	cbTx := newCoinbase(t, testAddress("recipient"), "test coinbase", 100)
 
 	// Check that the transaction was created
 	if cbTx == nil {
@@ -41,7 +42,8 @@ func TestTransactionCreation(t *testing.T) {
 func TestRegularTransactionCreation(t *testing.T) {
 	// Create a regular transaction
 	utxoSet := make(map[string][]core.UTXO)
-	tx, err := core.NewTransaction("sender", "recipient", 100, utxoSet)
+	utxoSet[testAddress("sender")] = []core.UTXO{{TXID: "funding", Index: 0, Output: core.TXOutput{Value: 100}}}
+	tx, err := core.NewTransaction(testAddress("sender"), testAddress("recipient"), 100, utxoSet)
 
 	// Check that the transaction was created without error
 	if err != nil {
@@ -65,9 +67,28 @@ func TestRegularTransactionCreation(t *testing.T) {
 	t.Logf("Regular transaction ID: %s", tx.ID)
 }
 
+func TestTransactionInvalidAddress(t *testing.T) {
+	// Paying a label instead of an address would lock the coins forever
+	if _, err := core.NewCoinbaseTransaction("recipient", "test coinbase", 100); !errors.Is(err, core.ErrInvalidAddress) {
+		t.Errorf("Expected ErrInvalidAddress for the coinbase, got %v", err)
+	}
+
+	sender, recipient := testAddress("sender"), testAddress("recipient")
+	utxoSet := map[string][]core.UTXO{sender: {{TXID: "funding", Index: 0, Output: core.TXOutput{Value: 100}}}}
+	if _, err := core.NewTransaction(sender, "recipient", 40, utxoSet); !errors.Is(err, core.ErrInvalidAddress) {
+		t.Errorf("Expected ErrInvalidAddress for the payee, got %v", err)
+	}
+	if _, err := core.NewTransaction("sender", recipient, 40, map[string][]core.UTXO{"sender": utxoSet[sender]}); !errors.Is(err, core.ErrInvalidAddress) {
+		t.Errorf("Expected ErrInvalidAddress for the change address, got %v", err)
+	}
+	if _, err := core.NewTXOutput(40, recipient[:len(recipient)-1]+"z"); !errors.Is(err, core.ErrInvalidAddress) {
+		t.Errorf("Expected ErrInvalidAddress for a non-hex address, got %v", err)
+	}
+}
+
 func TestTransactionHashing(t *testing.T) {
 	// Create a coinbase transaction
-	cbTx := core.NewCoinbaseTransaction("recipient", "test coinbase")
+	cbTx := newCoinbase(t, testAddress("recipient"), "test coinbase", 100)
 
 	// Get the hash
 	hash := cbTx.Hash()
@@ -82,7 +103,7 @@ func TestTransactionHashing(t *testing.T) {
 
 func TestTrimmedCopy(t *testing.T) {
 	// Create a coinbase transaction
-	cbTx := core.NewCoinbaseTransaction("recipient", "test coinbase")
+	cbTx := newCoinbase(t, testAddress("recipient"), "test coinbase", 100)
 
 	// Create a trimmed copy
 	trimmed := cbTx.TrimmedCopy()
@@ -103,11 +124,8 @@ func TestTrimmedCopy(t *testing.T) {
 
 	// Check that signatures and pubkeys are nil in the trimmed copy
 	for _, input := range trimmed.Inputs {
-		if input.Signature != nil {
-			t.Error("Expected signature to be nil in trimmed copy")
-		}
-		if input.PubKey != nil {
-			t.Error("Expected pubkey to be nil in trimmed copy")
+		if input.ScriptSig != nil {
+			t.Error("Expected unlocking script to be nil in trimmed copy")
 		}
 	}
 }
@@ -1,19 +1,15 @@
 package main
 
 import (
-	"os"
 	"testing"
 
 	"github.com/antontuzov/coubcore/internal/blockchain/core"
 )
 
 func TestTransactionValidation(t *testing.T) {
-	// Remove existing database for clean test
-	os.Remove("blockchain.db")
-
 	t.Run("CoinbaseTransaction", func(t *testing.T) {
 		// Create a coinbase transaction
-		tx := core.NewCoinbaseTransaction("recipient", "test coinbase")
+		tx := newCoinbase(t, testAddress("recipient"), "test coinbase", 100)
 
 		// Check that the transaction was created
 		if tx == nil {
@@ -34,9 +30,10 @@ func TestTransactionValidation(t *testing.T) {
 	t.Run("RegularTransaction", func(t *testing.T) {
 		// Create a UTXO set
 		utxoSet := make(map[string][]core.UTXO)
+		utxoSet[testAddress("sender")] = []core.UTXO{{TXID: "funding", Index: 0, Output: core.TXOutput{Value: 100}}}
 
 		// Create a regular transaction
-		tx, err := core.NewTransaction("sender", "recipient", 100, utxoSet)
+		tx, err := core.NewTransaction(testAddress("sender"), testAddress("recipient"), 100, utxoSet)
 
 		// Check that the transaction was created without error
 		if err != nil {
@@ -60,7 +57,7 @@ func TestTransactionValidation(t *testing.T) {
 
 	t.Run("TransactionHashing", func(t *testing.T) {
 		// Create a coinbase transaction
-		tx := core.NewCoinbaseTransaction("recipient", "test coinbase")
+		tx := newCoinbase(t, testAddress("recipient"), "test coinbase", 100)
 
 		// Get the hash
 		hash := tx.Hash()
@@ -79,7 +76,7 @@ func TestTransactionValidation(t *testing.T) {
 
 	t.Run("TrimmedCopy", func(t *testing.T) {
 		// Create a coinbase transaction
-		tx := core.NewCoinbaseTransaction("recipient", "test coinbase")
+		tx := newCoinbase(t, testAddress("recipient"), "test coinbase", 100)
 
 		// Create a trimmed copy
 		trimmed := tx.TrimmedCopy()
@@ -100,11 +97,8 @@ func TestTransactionValidation(t *testing.T) {
 
 		// Check that signatures and pubkeys are nil in the trimmed copy
 		for _, input := range trimmed.Inputs {
-			if input.Signature != nil {
-				t.Error("Expected signature to be nil in trimmed copy")
-			}
-			if input.PubKey != nil {
-				t.Error("Expected pubkey to be nil in trimmed copy")
+			if input.ScriptSig != nil {
+				t.Error("Expected unlocking script to be nil in trimmed copy")
 			}
 		}
 	})
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/wallet"
+)
+
+func TestUTXOSet(t *testing.T) {
+	alice, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+	bob, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %v", err)
+	}
+
+	// pay builds and signs a transaction from the sender's current unspent outputs
+	pay := func(t *testing.T, bc *core.Blockchain, from *wallet.Wallet, to string, amount int) *core.Transaction {
+		t.Helper()
+
+		utxos, err := bc.GetUTXOsByAddress(from.Address)
+		if err != nil {
+			t.Fatalf("Failed to get UTXOs: %v", err)
+		}
+		tx, err := core.NewTransaction(from.Address, to, amount, map[string][]core.UTXO{from.Address: utxos})
+		if err != nil {
+			t.Fatalf("Failed to create transaction: %v", err)
+		}
+		if err := bc.SignTransaction(tx, from.PrivateKey); err != nil {
+			t.Fatalf("Failed to sign transaction: %v", err)
+		}
+		return tx
+	}
+
+	balance := func(t *testing.T, bc *core.Blockchain, address string) int {
+		t.Helper()
+
+		value, err := bc.GetBalance(address)
+		if err != nil {
+			t.Fatalf("Failed to get balance: %v", err)
+		}
+		return value
+	}
+
+	t.Run("SpendAndChange", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		coinbase := newCoinbase(t, alice.Address, "reward", 100)
+		bc.AddBlock([]*core.Transaction{coinbase})
+
+		if got := balance(t, bc, alice.Address); got != 100 {
+			t.Fatalf("Expected alice to have 100, got %d", got)
+		}
+		utxo, err := bc.GetUTXO(coinbase.ID, 0)
+		if err != nil || utxo == nil {
+			t.Fatalf("Expected coinbase output to be unspent, got %v, %v", utxo, err)
+		}
+		if !utxo.Coinbase || utxo.Height != 1 {
+			t.Errorf("Expected coinbase output at height 1, got %+v", utxo)
+		}
+
+		tx := pay(t, bc, alice, bob.Address, 30)
+		if bc.AddBlock([]*core.Transaction{tx}) == nil {
+			t.Fatal("Expected spending block to be added")
+		}
+
+		if got := balance(t, bc, alice.Address); got != 70 {
+			t.Errorf("Expected alice to have 70 in change, got %d", got)
+		}
+		if got := balance(t, bc, bob.Address); got != 30 {
+			t.Errorf("Expected bob to have 30, got %d", got)
+		}
+		if utxo, _ := bc.GetUTXO(coinbase.ID, 0); utxo != nil {
+			t.Error("Expected spent output to leave the UTXO set")
+		}
+
+		// The same output cannot be spent twice
+		if bc.AddBlock([]*core.Transaction{tx}) != nil {
+			t.Error("Expected double spend to be rejected")
+		}
+	})
+
+	t.Run("InsufficientFunds", func(t *testing.T) {
+		utxos := []core.UTXO{{TXID: "funding", Index: 0, Output: core.TXOutput{Value: 10}}}
+		_, err := core.NewTransaction(testAddress("sender"), testAddress("recipient"), 11, map[string][]core.UTXO{testAddress("sender"): utxos})
+		if !errors.Is(err, core.ErrInsufficientFunds) {
+			t.Errorf("Expected ErrInsufficientFunds, got %v", err)
+		}
+	})
+
+	t.Run("ReorgRestoresSpentOutputs", func(t *testing.T) {
+		bc := newForkChoiceBlockchain(t)
+		defer bc.Close()
+
+		coinbase := newCoinbase(t, alice.Address, "reward", 100)
+		funded := bc.AddBlock([]*core.Transaction{coinbase})
+		tx := pay(t, bc, alice, bob.Address, 100)
+		bc.AddBlock([]*core.Transaction{tx})
+
+		if got := balance(t, bc, bob.Address); got != 100 {
+			t.Fatalf("Expected bob to have 100, got %d", got)
+		}
+
+		// A heavier branch from the funding block without the spend
+		fork := childBlock(funded, 4, "fork")
+		if status, err := bc.ProcessBlock(fork); err != nil || status != core.BlockMainChain {
+			t.Fatalf("Expected fork to become the main chain, got %v, %v", status, err)
+		}
+
+		if got := balance(t, bc, alice.Address); got != 100 {
+			t.Errorf("Expected alice's output to be restored, got %d", got)
+		}
+		if got := balance(t, bc, bob.Address); got != 0 {
+			t.Errorf("Expected bob's output to be removed, got %d", got)
+		}
+		if utxo, _ := bc.GetUTXO(coinbase.ID, 0); utxo == nil {
+			t.Error("Expected spent output to be unspent again")
+		}
+	})
+
+	t.Run("DuplicateTransactionRejected", func(t *testing.T) {
+		bc := newTestBlockchain(t)
+		defer bc.Close()
+
+		// The same coinbase mined twice would overwrite its own unspent output
+		coinbase := newCoinbase(t, alice.Address, "reward", 100)
+		if bc.AddBlock([]*core.Transaction{coinbase}) == nil {
+			t.Fatal("Failed to add block")
+		}
+		height := bc.Length()
+		if bc.AddBlock([]*core.Transaction{coinbase}) != nil {
+			t.Error("Expected a block repeating an unspent transaction to be rejected")
+		}
+		if bc.Length() != height {
+			t.Errorf("Expected the chain to stay at %d blocks, got %d", height, bc.Length())
+		}
+		if got := balance(t, bc, alice.Address); got != 100 {
+			t.Errorf("Expected alice to keep one output of 100, got %d", got)
+		}
+	})
+
+	t.Run("ChainstateSurvivesRestart", func(t *testing.T) {
+		opts := core.DefaultOptions()
+		opts.DataDir = t.TempDir()
+		opts.Params.CoinbaseMaturity = 0
+
+		bc, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to create blockchain: %v", err)
+		}
+		bc.AddBlock([]*core.Transaction{newCoinbase(t, alice.Address, "reward", 100)})
+		bc.AddBlock([]*core.Transaction{pay(t, bc, alice, bob.Address, 40)})
+		bc.Close()
+
+		reopened, err := core.NewBlockchain(opts)
+		if err != nil {
+			t.Fatalf("Failed to reopen blockchain: %v", err)
+		}
+		defer reopened.Close()
+
+		if got := balance(t, reopened, alice.Address); got != 60 {
+			t.Errorf("Expected alice to have 60 after restart, got %d", got)
+		}
+		if got := balance(t, reopened, bob.Address); got != 40 {
+			t.Errorf("Expected bob to have 40 after restart, got %d", got)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/antontuzov/coubcore/internal/blockchain/core"
+	"github.com/antontuzov/coubcore/internal/blockchain/network"
+)
+
+func TestWireProtocol(t *testing.T) {
+	encode := func(t *testing.T, msg network.Message) []byte {
+		t.Helper()
+
+		data, err := network.EncodeMessage(network.MagicMain, msg)
+		if err != nil {
+			t.Fatalf("Failed to encode message: %v", err)
+		}
+		return data
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		block := core.NewGenesisBlock()
+		handshake := network.Handshake{Version: 1, AddrFrom: "localhost:8000", Timestamp: 1700000000}
+
+		var buf bytes.Buffer
+		for _, msg := range []network.Message{
+			{Type: network.CmdBlock, Payload: block},
+			{Type: network.CmdHandshake, Payload: handshake},
+			{Type: network.CmdGetBlocks, Payload: network.GetBlocks{From: 5, Limit: 10}},
+		} {
+			if err := network.WriteMessage(&buf, network.MagicMain, msg); err != nil {
+				t.Fatalf("Failed to write %s message: %v", msg.Type, err)
+			}
+		}
+
+		msg, err := network.ReadMessage(&buf, network.MagicMain)
+		if got, ok := msg.Payload.(*core.Block); err != nil || !ok || got.Hash != block.Hash || got.CalculateHash() != block.Hash {
+			t.Errorf("Expected the block back, got %+v, %v", msg, err)
+		}
+		msg, err = network.ReadMessage(&buf, network.MagicMain)
+		if got, ok := msg.Payload.(*network.Handshake); err != nil || !ok || *got != handshake {
+			t.Errorf("Expected the handshake back, got %+v, %v", msg, err)
+		}
+		msg, err = network.ReadMessage(&buf, network.MagicMain)
+		if got, ok := msg.Payload.(*network.GetBlocks); err != nil || !ok || got.From != 5 || got.Limit != 10 {
+			t.Errorf("Expected the request back, got %+v, %v", msg, err)
+		}
+	})
+
+	t.Run("InvalidFrames", func(t *testing.T) {
+		valid := encode(t, network.Message{Type: network.CmdGetBlocks, Payload: network.GetBlocks{From: 1}})
+
+		corrupt := func(change func(frame []byte) []byte) []byte {
+			return change(append([]byte(nil), valid...))
+		}
+		frames := map[string][]byte{
+			"OtherNetwork": corrupt(func(f []byte) []byte { binary.BigEndian.PutUint32(f, network.MagicRegtest); return f }),
+			"OtherVersion": corrupt(func(f []byte) []byte { binary.BigEndian.PutUint32(f[4:], 99); return f }),
+			"EmptyCommand": corrupt(func(f []byte) []byte { copy(f[8:20], make([]byte, 12)); return f }),
+			"BadChecksum":  corrupt(func(f []byte) []byte { f[len(f)-2] ^= 1; return f }),
+			"Oversized": corrupt(func(f []byte) []byte {
+				binary.BigEndian.PutUint32(f[20:], network.MaxPayloadSize+1)
+				return f
+			}),
+			"WrongPayloadType": encode(t, network.Message{Type: network.CmdBlock, Payload: "not a block"}),
+		}
+		for name, frame := range frames {
+			if _, err := network.ReadMessage(bytes.NewReader(frame), network.MagicMain); !errors.Is(err, network.ErrInvalidFrame) {
+				t.Errorf("%s: expected ErrInvalidFrame, got %v", name, err)
+			}
+		}
+
+		if _, err := network.EncodeMessage(network.MagicMain, network.Message{Type: "test"}); !errors.Is(err, network.ErrUnknownCommand) {
+			t.Errorf("Expected unknown commands not to be sent, got %v", err)
+		}
+	})
+
+	t.Run("UnknownCommandSkipped", func(t *testing.T) {
+		frame := encode(t, network.Message{Type: network.CmdGetBlocks, Payload: network.GetBlocks{}})
+		copy(frame[8:20], "future\x00\x00\x00\x00\x00\x00")
+		stream := bytes.NewReader(append(frame, encode(t, network.Message{Type: network.CmdInventory, Payload: network.Inventory{}})...))
+
+		if _, err := network.ReadMessage(stream, network.MagicMain); !errors.Is(err, network.ErrUnknownCommand) {
+			t.Fatalf("Expected ErrUnknownCommand, got %v", err)
+		}
+		if msg, err := network.ReadMessage(stream, network.MagicMain); err != nil || msg.Type != network.CmdInventory {
+			t.Errorf("Expected the next message to be read, got %+v, %v", msg, err)
+		}
+	})
+
+	t.Run("PeerDisconnectedOnInvalidFrame", func(t *testing.T) {
+		local, remote := net.Pipe()
+		defer remote.Close()
+		messages := make(chan network.Message, 1)
+		dead := make(chan *network.Peer, 1)
+		peer := network.NewPeer(local, network.MagicMain, messages, dead)
+		go peer.ListenForMessages()
+
+		go remote.Write(encode(t, network.Message{Type: network.CmdInventory, Payload: network.Inventory{}}))
+		select {
+		case msg := <-messages:
+			if _, ok := msg.Payload.(*network.Inventory); !ok {
+				t.Errorf("Expected an inventory payload, got %T", msg.Payload)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the message to be delivered")
+		}
+
+		// A frame from another network
+		frame, _ := network.EncodeMessage(network.MagicRegtest, network.Message{Type: network.CmdInventory, Payload: network.Inventory{}})
+		go remote.Write(frame)
+		select {
+		case got := <-dead:
+			if got != peer {
+				t.Error("Expected the peer to be reported dead")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the peer to be disconnected")
+		}
+	})
+}